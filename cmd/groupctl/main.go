@@ -0,0 +1,324 @@
+// Command groupctl runs maintenance tasks on the groups stored in MongoDB.
+//
+// Usage:
+//
+//	groupctl <command> [flags]
+//
+// The commands are:
+//
+//	scan    reports the group documents that do not represent valid groups,
+//	        and optionally repairs them.
+//	export  writes all the groups as newline-delimited JSON, see the
+//	        transfer package.
+//	import  reads groups written by export and stores them, installing the
+//	        schema of the collection first.
+//
+// Run "groupctl <command> -h" for the flags of each command. The connection to
+// MongoDB is configured with an optional -config file and the GROUPS_*
+// environment variables, see the config package, which the -uri, -db and
+// -collection flags override.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/bootstrap"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/transfer"
+)
+
+const usage = `usage: groupctl <command> [flags]
+
+commands:
+  scan    report, and optionally repair, invalid group documents
+  export  write all the groups as newline-delimited JSON
+  import  store the groups written by export
+`
+
+// errUsage is returned when the command line is wrong, usage has already
+// been printed.
+var errUsage = errors.New("usage error")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "groupctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "scan":
+		return scan(ctx, args, stdout, stderr)
+	case "export":
+		return export(ctx, args, stdout, stderr)
+	case "import":
+		return importGroups(ctx, args, stdin, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd, usage)
+		return errUsage
+	}
+}
+
+// connection has the flags to connect to the group collection, they
+// override the configuration file and the environment variables.
+type connection struct {
+	configPath string
+	uri        string
+	database   string
+	collection string
+}
+
+func (c *connection) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.configPath, "config", "", "JSON configuration file, see the config package")
+	fs.StringVar(&c.uri, "uri", "", "MongoDB connection string, overrides GROUPS_MONGO_URI")
+	fs.StringVar(&c.database, "db", "", "database name, overrides GROUPS_MONGO_DATABASE")
+	fs.StringVar(&c.collection, "collection", "", "collection name, overrides GROUPS_MONGO_COLLECTION")
+}
+
+// config loads the configuration, giving precedence to the flags.
+func (c *connection) config() (*config.Config, error) {
+	flags := map[string]string{
+		"GROUPS_MONGO_URI":        c.uri,
+		"GROUPS_MONGO_DATABASE":   c.database,
+		"GROUPS_MONGO_COLLECTION": c.collection,
+	}
+
+	lookup := func(name string) (string, bool) {
+		if v := flags[name]; v != "" {
+			return v, true
+		}
+
+		return os.LookupEnv(name)
+	}
+
+	return config.LoadFrom(c.configPath, lookup)
+}
+
+// connect connects to MongoDB, call Close on the connection when done.
+func (c *connection) connect(ctx context.Context) (*bootstrap.Conn, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrap.Connect(ctx, cfg.Mongo)
+}
+
+// closeConn closes the connection, waiting a bit for the operations in
+// progress.
+func closeConn(conn *bootstrap.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_ = conn.Close(ctx)
+}
+
+func scan(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, "usage: groupctl scan [flags]\n\n"+
+			"Reports the group documents that do not represent valid groups.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var conn connection
+	conn.register(fs)
+	repair := fs.Bool("repair", false, "fix the invalid documents that can be fixed without losing members")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	mongoConn, err := conn.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn(mongoConn)
+
+	var opts []mongo.ScanOption
+	if *repair {
+		opts = append(opts, mongo.WithRepair())
+	}
+
+	report, err := mongo.Scan(ctx, mongoConn.Groups, opts...)
+	if err != nil {
+		return fmt.Errorf("scanning: %v", err)
+	}
+
+	repaired := 0
+
+	for _, f := range report.Findings {
+		status := "invalid"
+
+		switch {
+		case f.Repaired:
+			status = "repaired"
+			repaired++
+		case f.RepairErr != nil:
+			status = "repair failed"
+			fmt.Fprintf(stderr, "%s: %v\n", f.GroupID, f.RepairErr)
+		}
+
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", f.GroupID, status, strings.Join(f.Reasons, "; "))
+	}
+
+	fmt.Fprintf(stdout, "scanned %d documents, %d invalid, %d repaired\n",
+		report.Scanned, len(report.Findings), repaired)
+
+	return nil
+}
+
+func export(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, "usage: groupctl export [flags]\n\n"+
+			"Writes all the groups as newline-delimited JSON, sorted by id.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var conn connection
+	conn.register(fs)
+	output := fs.String("o", "", "file to write the groups to, instead of the standard output")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	mongoConn, err := conn.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn(mongoConn)
+
+	var file *os.File
+
+	w := stdout
+
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	n, err := transfer.Export(ctx, mongo.NewGroupRepo(mongoConn.Groups), w)
+	if err != nil {
+		return err
+	}
+
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stderr, "exported %d groups\n", n)
+
+	return nil
+}
+
+func importGroups(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, "usage: groupctl import [flags]\n\n"+
+			"Stores the groups written by groupctl export.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var conn connection
+	conn.register(fs)
+	input := fs.String("i", "", "file to read the groups from, instead of the standard input")
+	dryRun := fs.Bool("dry-run", false, "validate the groups and report what would be done, without storing anything")
+	onConflict := fs.String("on-conflict", string(transfer.Fail), "what to do with the existing groups: skip, overwrite or fail")
+	batchSize := fs.Int("batch-size", transfer.DefaultBatchSize, "how many groups to store at once")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	policy, err := transfer.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return errUsage
+	}
+
+	r := stdin
+
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	mongoConn, err := conn.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn(mongoConn)
+
+	// the unique indexes must be there before storing anything, or the
+	// imported groups could break them
+	if !*dryRun {
+		if err := mongo.EnsureGroupSchema(ctx, mongoConn.DB, mongoConn.Groups.Name()); err != nil {
+			return fmt.Errorf("installing the group schema: %v", err)
+		}
+	}
+
+	opts := []transfer.ImportOption{
+		transfer.WithConflictPolicy(policy),
+		transfer.WithBatchSize(*batchSize),
+	}
+
+	if *dryRun {
+		opts = append(opts, transfer.WithDryRun())
+	}
+
+	report, err := transfer.Import(ctx, mongo.NewGroupRepo(mongoConn.Groups), r, opts...)
+	if report == nil {
+		return err
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+
+	fmt.Fprintf(stdout, "read %d groups, %s %d new, %d overwritten, %d skipped\n",
+		report.Read, verb, report.Created, report.Overwritten, report.Skipped)
+
+	return err
+}
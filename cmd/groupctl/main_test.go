@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// the URI of the MongoDB Docker container
+	mongoURI string
+)
+
+// now is the time used by the tests that do not care about it.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// TestMain performs some setup/cleanup before/after running the tests in this package.
+//
+// Setup:
+//   - starts a MongoDB Docker container and fills mongoURI with its connection
+//     string.
+//
+// Cleanup:
+//   - terminate the MongoDB Docker container
+func TestMain(m *testing.M) {
+	timeout := 5 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	mongodbContainer, err := mongodb.RunContainer(ctx, testcontainers.WithImage("mongo:6.0.15"))
+	if err != nil {
+		log.Fatalf("starting MongoDB container: %v", err)
+	}
+
+	// clean up the mongo container
+	defer func() {
+		timeout := 2 * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := mongodbContainer.Terminate(ctx); err != nil {
+			log.Fatalf("terminating container: %v", err)
+		}
+	}()
+
+	mongoURI, err = mongodbContainer.ConnectionString(ctx)
+	if err != nil {
+		log.Fatalf("getting MongoDB connection string: %v", err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// output is what a command writes.
+type output struct {
+	stdout, stderr bytes.Buffer
+}
+
+// runCommand runs groupctl with the given arguments against the database,
+// reading stdin from the given string.
+func runCommand(ctx context.Context, db *mongodriver.Database, stdin string, args ...string) (*output, error) {
+	var out output
+
+	args = append(args, "-uri", mongoURI, "-db", db.Name())
+	err := run(ctx, args, bytes.NewBufferString(stdin), &out.stdout, &out.stderr)
+
+	return &out, err
+}
+
+// newContext returns a context for a test that talks to MongoDB.
+func newContext(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// Tests the wrong command lines print the usage and fail with errUsage,
+// without connecting to MongoDB.
+func TestRun_Usage(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		args []string
+		// want is the content we want in the standard error
+		want string
+	}{
+		"no command": {
+			want: "usage: groupctl <command>",
+		},
+		"unknown command": {
+			args: []string{"drop"},
+			want: `unknown command "drop"`,
+		},
+		"unknown flag": {
+			args: []string{"scan", "-force"},
+			want: "usage: groupctl scan",
+		},
+		"unknown conflict policy": {
+			args: []string{"import", "-on-conflict", "ignore"},
+			want: "unknown conflict policy",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// WHEN we run a wrong command line
+			var stdout, stderr bytes.Buffer
+			err := run(context.Background(), test.args, &bytes.Buffer{}, &stdout, &stderr)
+
+			// THEN it fails with errUsage, explaining why
+			require.ErrorIs(t, err, errUsage)
+			require.Contains(t, stderr.String(), test.want)
+			require.Empty(t, stdout.String())
+		})
+	}
+}
+
+// Tests the groups exported from a collection can be imported into a new
+// one, which gets the group schema.
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext(t)
+	db := testhelp.NewTestDatabase(t, mongoURI)
+
+	// GIVEN a collection with some groups
+	err := mongo.EnsureGroupSchema(ctx, db, "group")
+	require.NoError(t, err)
+
+	repo := mongo.NewGroupRepo(db.Collection("group"))
+
+	named := testhelp.NamedGroup(t, now, "b", "owner_id", "name", "tag")
+	require.NoError(t, named.AddMember("user_id", now))
+
+	groups := []*domain.Group{domain.NewGroup("a", "owner_id", now), named}
+	for _, g := range groups {
+		require.NoError(t, repo.Create(ctx, g))
+	}
+
+	// WHEN we export them to a file
+	path := filepath.Join(t.TempDir(), "groups.ndjson")
+
+	out, err := runCommand(ctx, db, "", "export", "-o", path)
+	require.NoError(t, err)
+
+	// THEN they are all exported
+	require.Equal(t, "exported 2 groups\n", out.stderr.String())
+
+	// WHEN we import the file into a new collection
+	out, err = runCommand(ctx, db, "", "import", "-i", path, "-collection", "copy")
+	require.NoError(t, err)
+
+	// THEN they are all imported as they were
+	require.Equal(t, "read 2 groups, imported 2 new, 0 overwritten, 0 skipped\n", out.stdout.String())
+
+	copied := mongo.NewGroupRepo(db.Collection("copy"))
+	for _, want := range groups {
+		got, err := copied.Load(ctx, want.ID())
+		require.NoError(t, err)
+		require.Equal(t, want.Snapshot(), got.Snapshot())
+	}
+
+	// THEN the new collection rejects invalid groups
+	_, err = db.Collection("copy").InsertOne(ctx, bson.M{"_id": "invalid", "owner_id": ""})
+	require.Error(t, err)
+
+	// WHEN we import the file again, from the standard input, skipping the
+	// existing groups
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	out, err = runCommand(ctx, db, string(data), "import", "-collection", "copy", "-on-conflict", "skip")
+	require.NoError(t, err)
+
+	// THEN all of them are skipped
+	require.Equal(t, "read 2 groups, imported 0 new, 0 overwritten, 2 skipped\n", out.stdout.String())
+}
+
+// Tests a dry run of an import reports what it would do without storing
+// anything, not even the schema.
+func TestImport_DryRun(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext(t)
+	db := testhelp.NewTestDatabase(t, mongoURI)
+
+	// GIVEN an export of a group
+	data := `{"version":1,"id":"a","owner_id":"owner_id","members":[{"id":"owner_id","joined_at":"2024-01-02T03:04:05Z"}]}` + "\n"
+
+	// WHEN we import it in a dry run
+	out, err := runCommand(ctx, db, data, "import", "-dry-run")
+	require.NoError(t, err)
+
+	// THEN we get what would be done
+	require.Equal(t, "read 1 groups, would import 1 new, 0 overwritten, 0 skipped\n", out.stdout.String())
+
+	// THEN nothing has been created
+	names, err := db.ListCollectionNames(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+// Tests the scan reports the invalid documents and repairs them if asked to.
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	ctx := newContext(t)
+	db := testhelp.NewTestDatabase(t, mongoURI)
+
+	// GIVEN a collection, without a schema, with a valid and a repairable
+	// document
+	docs := []any{
+		bson.M{"_id": "valid", "owner_id": "a", "members": bson.A{"a", "b"}},
+		bson.M{"_id": "duplicated", "owner_id": "a", "members": bson.A{"a", "b", "b"}},
+	}
+
+	_, err := db.Collection("group").InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	// WHEN we scan it
+	out, err := runCommand(ctx, db, "", "scan")
+	require.NoError(t, err)
+
+	// THEN we get the invalid document
+	require.Equal(t, "duplicated\tinvalid\tduplicated member (b)\n"+
+		"scanned 2 documents, 1 invalid, 0 repaired\n", out.stdout.String())
+
+	// WHEN we scan it with repair
+	out, err = runCommand(ctx, db, "", "scan", "-repair")
+	require.NoError(t, err)
+
+	// THEN the invalid document is repaired
+	require.Equal(t, "duplicated\trepaired\tduplicated member (b)\n"+
+		"scanned 2 documents, 1 invalid, 1 repaired\n", out.stdout.String())
+}
@@ -0,0 +1,181 @@
+// Command loadgen stresses the application with a configurable workload and
+// prints a summary with the latencies, the errors and the result of checking
+// the invariants of the groups afterwards.
+//
+// Usage:
+//
+//	loadgen [flags]
+//
+// For example, to see how transactions prevent lost updates on hot groups:
+//
+//	loadgen -db loadgen -mix add=1 -groups 10 -distribution zipf -transactions=false
+//	loadgen -db loadgen -mix add=1 -groups 10 -distribution zipf -transactions
+//
+// The mongo store is configured with an optional -config file and the
+// GROUPS_* environment variables, see the config package, which the -uri,
+// -db and -collection flags override.
+//
+// It exits with status 3 if any invariant is broken.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/bootstrap"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/loadgen"
+	"github.com/google/uuid"
+)
+
+var (
+	// errUsage is returned when the command line is wrong, usage has
+	// already been printed.
+	errUsage = errors.New("usage error")
+	// errViolations is returned when the workload breaks some invariant.
+	errViolations = errors.New("invariant violations")
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case errors.Is(err, errViolations):
+		os.Exit(3)
+	default:
+		fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		store        = fs.String("store", "mongo", "where to store the groups: mongo or memory")
+		configPath   = fs.String("config", "", "JSON configuration file for the mongo store, see the config package")
+		uri          = fs.String("uri", "", "MongoDB connection string, overrides GROUPS_MONGO_URI")
+		database     = fs.String("db", "", "MongoDB database name, overrides GROUPS_MONGO_DATABASE")
+		collection   = fs.String("collection", "", "MongoDB collection name, overrides GROUPS_MONGO_COLLECTION")
+		mix          = fs.String("mix", "create=1,get=4,add=5", "relative weight of each operation")
+		concurrency  = fs.Int("concurrency", 8, "number of concurrent callers")
+		rate         = fs.Float64("rate", 0, "target calls per second, 0 means as fast as possible")
+		duration     = fs.Duration("duration", 10*time.Second, "how long to run, 0 means until -ops calls are made")
+		operations   = fs.Int("ops", 0, "number of calls to make, 0 means no limit")
+		groups       = fs.Int("groups", 10, "number of groups created before starting")
+		distribution = fs.String("distribution", "uniform", "how calls choose their group: uniform or zipf")
+		zipfS        = fs.Float64("zipf-s", 1.2, "s parameter of the Zipf distribution, greater than 1")
+		seed         = fs.Int64("seed", time.Now().UnixNano(), "seed of the random choices")
+		transactions = fs.Bool("transactions", true, "add users to groups using transactions")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	parsedMix, err := loadgen.ParseMix(*mix)
+	if err != nil {
+		return fmt.Errorf("parsing -mix: %v", err)
+	}
+
+	cfg := loadgen.Config{
+		Mix:          parsedMix,
+		Concurrency:  *concurrency,
+		Rate:         *rate,
+		Duration:     *duration,
+		Operations:   *operations,
+		Groups:       *groups,
+		Distribution: loadgen.Distribution(*distribution),
+		ZipfS:        *zipfS,
+		Seed:         *seed,
+	}
+
+	if *transactions {
+		cfg.Options = append(cfg.Options, application.EnableTransactions{})
+	}
+
+	var app *application.App
+
+	switch *store {
+	case "memory":
+		app = application.New(uuider{}, memory.NewGroupRepo())
+	case "mongo":
+		cfg, err := config.LoadFrom(*configPath, lookupWithOverrides(map[string]string{
+			"GROUPS_MONGO_URI":        *uri,
+			"GROUPS_MONGO_DATABASE":   *database,
+			"GROUPS_MONGO_COLLECTION": *collection,
+		}))
+		if err != nil {
+			return err
+		}
+
+		svc, err := bootstrap.New(ctx, *cfg)
+		if err != nil {
+			return err
+		}
+		defer closeService(svc)
+
+		app = svc.App
+	default:
+		return fmt.Errorf("unknown store %q", *store)
+	}
+
+	fmt.Fprintf(stdout, "running workload with seed %d\n", *seed)
+
+	summary, err := loadgen.Run(ctx, app, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := summary.Write(stdout); err != nil {
+		return fmt.Errorf("writing summary: %v", err)
+	}
+
+	if len(summary.Violations) > 0 {
+		return errViolations
+	}
+
+	return nil
+}
+
+// lookupWithOverrides returns a function to look up environment variables
+// that gives precedence to the non empty overrides.
+func lookupWithOverrides(overrides map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if v := overrides[name]; v != "" {
+			return v, true
+		}
+
+		return os.LookupEnv(name)
+	}
+}
+
+// closeService closes the service, waiting a bit for the operations in
+// progress.
+func closeService(svc *bootstrap.Service) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_ = svc.Close(ctx)
+}
+
+type uuider struct{}
+
+func (uuider) NewString() string {
+	return uuid.NewString()
+}
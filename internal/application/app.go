@@ -2,17 +2,39 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// tracerName is the name of the tracer used to trace the use cases.
+const tracerName = "github.com/alcortesm/demo-mongodb-transactions/internal/application"
+
+// defaultTransactionRetries is the default number of times a transaction is
+// attempted before giving up.
+const defaultTransactionRetries = 10
+
 //go:generate mockgen -source=app.go -destination=mock_dependencies_test.go -package=application_test
 
 type App struct {
-	uuider Uuider
-	store  Store
+	uuider      Uuider
+	clock       Clock
+	store       Store
+	logger      *slog.Logger
+	metrics     Metrics
+	tracer      trace.Tracer
+	retries     uint
+	audit       AuditLog
+	locker      Locker
+	coalesce    bool
+	middlewares []Middleware
+	handler     Handler
 }
 
 type Store interface {
@@ -22,68 +44,525 @@ type Store interface {
 	WithTransaction(ctx context.Context, callback func(ctx context.Context) error, retries uint) error
 }
 
+// MemberAdder is an optional capability of a Store: adding a member to a
+// group atomically, without a transaction, while respecting the group
+// invariants.
+//
+// The App uses it to add users to groups with the EnableAtomicUpdates
+// option, as it gives the same guarantees as a transaction at a fraction of
+// the cost.
+type MemberAdder interface {
+	// AddMember adds userID as a member of the group with the given id,
+	// who joins it at the given time.
+	//
+	// Errors:
+	//   - domain.ErrNotFound if the group does not exist.
+	//   - domain.ErrGroupFull if the group is full.
+	AddMember(ctx context.Context, groupID, userID string, now time.Time) error
+}
+
+// DetailsUpdater is an optional capability of a Store: updating the details
+// of a group with optimistic concurrency control, without touching its
+// members.
+//
+// The App uses it to update the details of groups when transactions are not
+// enabled.
+type DetailsUpdater interface {
+	// UpdateDetails stores the details of the group, only if the stored
+	// details are still the previous ones.
+	//
+	// Errors:
+	//   - domain.ErrNotFound if the group does not exist.
+	//   - domain.ErrConflict if the stored details are not the previous ones.
+	//   - domain.ErrAlreadyExists if the owner has another group with the
+	//     same name.
+	UpdateDetails(ctx context.Context, group *domain.Group, previous domain.Details) error
+}
+
+// Locker is a distributed lock on the groups, whose leases expire after a
+// while, in case their holder dies, see EnableLocking.
+type Locker interface {
+	// Lock waits until the lock of the group is free, or its lease has
+	// expired, and takes it, until ctx is done. It returns the fencing
+	// token of the new lease, greater than the ones of all the previous
+	// leases of the group, and a function to release it.
+	Lock(ctx context.Context, groupID string) (token int64, unlock func(context.Context) error, err error)
+}
+
+// FencedUpdater is an optional capability of a Store, required by
+// EnableLocking: loading and overwriting a group with the fencing token of a
+// lease, so a holder whose lease has expired cannot overwrite the changes of
+// the next one, no matter the order of their calls.
+type FencedUpdater interface {
+	// LoadFenced returns the group, after storing the fencing token in it,
+	// so the writes with smaller tokens fail from then on.
+	//
+	// Errors:
+	//   - domain.ErrNotFound if the group does not exist.
+	//   - domain.ErrConflict if the group has a greater fencing token.
+	LoadFenced(ctx context.Context, id string, token int64) (*domain.Group, error)
+	// UpdateFenced overwrites the group, only if it does not have a greater
+	// fencing token.
+	//
+	// Errors:
+	//   - domain.ErrNotFound if the group does not exist.
+	//   - domain.ErrConflict if the group has a greater fencing token.
+	UpdateFenced(ctx context.Context, group *domain.Group, token int64) error
+}
+
+// AuditLog stores the audit trail of the changes in the members of the
+// groups, see WithAuditLog.
+type AuditLog interface {
+	// Record appends the entry to the history of its group. Inside a store
+	// transaction, the entry is only stored if the transaction commits.
+	Record(ctx context.Context, entry domain.AuditEntry) error
+	// History returns up to limit entries of the history of the group,
+	// sorted by key, starting right after the given key.
+	History(ctx context.Context, groupID string, after domain.AuditKey, limit int) ([]domain.AuditEntry, error)
+}
+
 // Uuider knows how to return V4 UUIDs.
 type Uuider interface {
 	NewString() string
 }
 
+// Clock tells the current time, see WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// Metrics receives measurements about the use cases run by the App.
+type Metrics interface {
+	// UseCaseFinished is called after each use case, with the error it
+	// returned and how long it took.
+	UseCaseFinished(useCase string, err error, elapsed time.Duration)
+}
+
 func New(
 	uuider Uuider,
 	store Store,
+	options ...AppOption,
 ) *App {
-	return &App{
-		uuider: uuider,
-		store:  store,
+	a := &App{
+		uuider:  uuider,
+		clock:   systemClock{},
+		store:   store,
+		logger:  logging.Discard(),
+		metrics: nopMetrics{},
+		tracer:  noop.NewTracerProvider().Tracer(tracerName),
+		retries: defaultTransactionRetries,
 	}
+
+	for _, o := range options {
+		o(a)
+	}
+
+	middlewares := []Middleware{
+		tracing(a.tracer),
+		logs(a.logger),
+		measure(a.metrics),
+		validation(),
+	}
+	middlewares = append(middlewares, a.middlewares...)
+	middlewares = append(middlewares, locking(a.locker, a.store, a.addUserToGroupFenced))
+	if a.coalesce {
+		middlewares = append(middlewares, coalescing(newCoalescer(a.now, a.addUsersToGroup)))
+	}
+	middlewares = append(middlewares, atomicUpdates(a.store, a.audit != nil, a.now))
+	middlewares = append(middlewares, transactions(a.store, a.retries))
+
+	a.handler = chain(a.handle, middlewares...)
+
+	return a
+}
+
+// Dispatch runs the command through the middlewares of the App and then
+// through the handler of its use case, returning its result.
+func (a *App) Dispatch(ctx context.Context, cmd Command) (any, error) {
+	return a.handler(ctx, cmd)
 }
 
-func (a *App) CreateGroup(ctx context.Context, ownerID string) (string, error) {
-	groupID := a.uuider.NewString()
-	group := domain.NewGroup(groupID, ownerID)
+// CreateGroup creates a new group owned by ownerID and returns its id.
+//
+// The id is a new UUID, unless the UseGroupID option is used.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if the group id is taken, see CreateIfAbsent.
+//   - domain.ErrInvalidArgument if an id is empty.
+func (a *App) CreateGroup(ctx context.Context, ownerID string, options ...Option) (string, error) {
+	groupID, ok := groupIDOption(options...)
+	if !ok {
+		groupID = a.uuider.NewString()
+	}
 
-	if err := a.store.Create(ctx, group); err != nil {
-		return "", fmt.Errorf("creating: %v", err)
+	cmd := CreateGroupCommand{
+		GroupID:     groupID,
+		OwnerID:     ownerID,
+		CallOptions: options,
 	}
 
-	return groupID, nil
+	if _, err := a.Dispatch(ctx, cmd); err != nil {
+		return "", err
+	}
 
+	return cmd.GroupID, nil
 }
 
 func (a *App) GetGroup(ctx context.Context, groupID string) (*domain.Group, error) {
-	group, err := a.store.Load(ctx, groupID)
+	result, err := a.Dispatch(ctx, GetGroupCommand{GroupID: groupID})
 	if err != nil {
-		return nil, fmt.Errorf("creating: %v", err)
+		return nil, err
 	}
 
-	return group, nil
+	return result.(*domain.Group), nil
 }
 
 func (a *App) AddUserToGroup(ctx context.Context, userID, groupID string, options ...Option) error {
-	do := func(ctx context.Context) error {
-		group, err := a.store.Load(ctx, groupID)
-		if err != nil {
-			return fmt.Errorf("creating: %v", err)
-		}
+	_, err := a.Dispatch(ctx, AddUserToGroupCommand{
+		UserID:      userID,
+		GroupID:     groupID,
+		CallOptions: options,
+	})
+
+	return err
+}
+
+// UpdateGroupDetails replaces the name, description and tags of a group.
+//
+// Concurrent updates are detected using a transaction, with the
+// EnableTransactions option, or else by checking the details have not
+// changed since they were loaded, if the Store implements DetailsUpdater.
+//
+// Errors:
+//   - domain.ErrNotFound if the group does not exist.
+//   - domain.ErrInvalidArgument if the details are not valid.
+//   - domain.ErrAlreadyExists if the owner has another group with the same
+//     name.
+//   - domain.ErrConflict if the details were changed concurrently, the call
+//     can be retried.
+func (a *App) UpdateGroupDetails(
+	ctx context.Context,
+	groupID string,
+	details domain.Details,
+	options ...Option,
+) error {
+	_, err := a.Dispatch(ctx, UpdateGroupDetailsCommand{
+		GroupID:     groupID,
+		Details:     details,
+		CallOptions: options,
+	})
+
+	return err
+}
+
+// GetGroupHistory returns a page of the audit trail of a group, oldest
+// entries first, with up to limit entries, or DefaultHistoryPageSize if limit
+// is 0.
+//
+// The first page is returned for an empty cursor, use the Next cursor of
+// each page to get the following one.
+//
+// Errors:
+//   - domain.ErrInvalidArgument if the cursor or the limit are not valid.
+//   - an error if the App has no AuditLog, see WithAuditLog.
+func (a *App) GetGroupHistory(ctx context.Context, groupID, cursor string, limit int) (*HistoryPage, error) {
+	result, err := a.Dispatch(ctx, GetGroupHistoryCommand{
+		GroupID: groupID,
+		Cursor:  cursor,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*HistoryPage), nil
+}
+
+// handle runs the command with the handler of its use case.
+func (a *App) handle(ctx context.Context, cmd Command) (any, error) {
+	switch cmd := cmd.(type) {
+	case CreateGroupCommand:
+		return nil, a.createGroup(ctx, cmd)
+	case GetGroupCommand:
+		return a.getGroup(ctx, cmd)
+	case AddUserToGroupCommand:
+		return nil, a.addUserToGroup(ctx, cmd)
+	case UpdateGroupDetailsCommand:
+		return nil, a.updateGroupDetails(ctx, cmd)
+	case GetGroupHistoryCommand:
+		return a.getGroupHistory(ctx, cmd)
+	default:
+		return nil, fmt.Errorf("unknown command %T", cmd)
+	}
+}
 
-		if err := group.AddMember(userID); err != nil {
-			return fmt.Errorf("adding: %w", err)
+func (a *App) createGroup(ctx context.Context, cmd CreateGroupCommand) error {
+	group := domain.NewGroup(cmd.GroupID, cmd.OwnerID, a.now())
+
+	if isCreateIfAbsent(cmd.Options()...) {
+		created, err := a.createGroupIfAbsent(ctx, group)
+		if err != nil || !created {
+			return err
 		}
+	} else if err := a.store.Create(ctx, group); err != nil {
+		return fmt.Errorf("creating: %w", err)
+	}
+
+	return a.record(ctx, domain.AuditEntry{
+		GroupID:       group.ID(),
+		Seq:           auditSeq(group),
+		Actor:         actorOption(cmd.Options()...),
+		Action:        domain.AuditGroupCreated,
+		UserID:        group.OwnerID(),
+		MembersBefore: 0,
+		MembersAfter:  len(group.Members()),
+	}, cmd.Options()...)
+}
+
+// createGroupIfAbsent creates the group unless there is already one with the
+// same id and owner, and returns if it has created it.
+//
+// The existing group is looked up before creating it, instead of only after
+// a failed creation, because inside a MongoDB transaction a duplicate key
+// error aborts the transaction; a concurrent creation is then detected as a
+// write conflict and the lookup happens again on the next attempt.
+func (a *App) createGroupIfAbsent(ctx context.Context, group *domain.Group) (bool, error) {
+	existing, err := a.store.Load(ctx, group.ID())
+	switch {
+	case err == nil:
+		return false, sameOwner(existing, group)
+	case !errors.Is(err, domain.ErrNotFound):
+		return false, fmt.Errorf("loading: %w", err)
+	}
+
+	err = a.store.Create(ctx, group)
+	switch {
+	case err == nil:
+		return true, nil
+	case !errors.Is(err, domain.ErrAlreadyExists):
+		return false, fmt.Errorf("creating: %w", err)
+	}
+
+	// a concurrent caller has just created it
+	existing, err = a.store.Load(ctx, group.ID())
+	if err != nil {
+		return false, fmt.Errorf("loading: %w", err)
+	}
+
+	return false, sameOwner(existing, group)
+}
+
+// sameOwner returns domain.ErrAlreadyExists if the existing group is not
+// owned by the owner of the group we wanted to create.
+func sameOwner(existing, wanted *domain.Group) error {
+	if existing.OwnerID() != wanted.OwnerID() {
+		return domain.Errorf(domain.ErrAlreadyExists, existing.ID(), "owned by a different user")
+	}
+
+	return nil
+}
+
+func (a *App) getGroup(ctx context.Context, cmd GetGroupCommand) (*domain.Group, error) {
+	group, err := a.store.Load(ctx, cmd.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("loading: %w", err)
+	}
+
+	return group, nil
+}
+
+func (a *App) addUserToGroup(ctx context.Context, cmd AddUserToGroupCommand) error {
+	return a.addUser(ctx, cmd, a.store.Load, a.store.Update)
+}
+
+// addUserToGroupFenced is like addUserToGroup, but it loads and stores the
+// group with the fencing token of the lease of the group, see EnableLocking.
+func (a *App) addUserToGroupFenced(ctx context.Context, cmd AddUserToGroupCommand, updater FencedUpdater, token int64) error {
+	load := func(ctx context.Context, id string) (*domain.Group, error) {
+		return updater.LoadFenced(ctx, id, token)
+	}
+
+	update := func(ctx context.Context, group *domain.Group) error {
+		return updater.UpdateFenced(ctx, group, token)
+	}
 
-		if d, ok := mustDelayBeforeUpdating(options...); ok {
-			time.Sleep(d)
+	return a.addUser(ctx, cmd, load, update)
+}
+
+// addUser loads the group with load, adds the user and stores the group with
+// update.
+func (a *App) addUser(
+	ctx context.Context,
+	cmd AddUserToGroupCommand,
+	load func(context.Context, string) (*domain.Group, error),
+	update func(context.Context, *domain.Group) error,
+) error {
+	group, err := load(ctx, cmd.GroupID)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+
+	before := len(group.Members())
+
+	if err := group.AddMember(cmd.UserID, a.now()); err != nil {
+		return fmt.Errorf("adding: %w", err)
+	}
+
+	if d, ok := mustDelayBeforeUpdating(cmd.Options()...); ok {
+		if err := sleep(ctx, d); err != nil {
+			return fmt.Errorf("delaying: %w", err)
 		}
+	}
+
+	if err := update(ctx, group); err != nil {
+		return fmt.Errorf("updating: %w", err)
+	}
 
-		if err := a.store.Update(ctx, group); err != nil {
-			return fmt.Errorf("updating: %w", err)
+	after := len(group.Members())
+	if after == before {
+		// the user was already a member
+		return nil
+	}
+
+	return a.record(ctx, domain.AuditEntry{
+		GroupID:       group.ID(),
+		Seq:           auditSeq(group),
+		Actor:         actorOption(cmd.Options()...),
+		Action:        domain.AuditMemberAdded,
+		UserID:        cmd.UserID,
+		MembersBefore: before,
+		MembersAfter:  after,
+	}, cmd.Options()...)
+}
+
+// sleep waits for d, or until the context is done, returning its error.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// updateGroupDetails loads the group, changes its details and stores them,
+// with a transaction, if enabled, or with optimistic concurrency control if
+// the store supports it.
+func (a *App) updateGroupDetails(ctx context.Context, cmd UpdateGroupDetailsCommand) error {
+	group, err := a.store.Load(ctx, cmd.GroupID)
+	if err != nil {
+		return fmt.Errorf("loading: %w", err)
+	}
+
+	previous := group.Details()
+
+	if err := group.SetDetails(cmd.Details, a.now()); err != nil {
+		return fmt.Errorf("setting details: %w", err)
+	}
+
+	if updater, ok := a.store.(DetailsUpdater); ok && !areTransactionsEnabled(cmd.Options()...) {
+		if err := updater.UpdateDetails(ctx, group, previous); err != nil {
+			return fmt.Errorf("updating details: %w", err)
 		}
 
 		return nil
 	}
 
-	if areTransactionsEnabled(options...) {
-		const retries = 10
-		return a.store.WithTransaction(ctx, do, retries)
+	if err := a.store.Update(ctx, group); err != nil {
+		return fmt.Errorf("updating: %w", err)
+	}
+
+	return nil
+}
+
+func (a *App) getGroupHistory(ctx context.Context, cmd GetGroupHistoryCommand) (*HistoryPage, error) {
+	if a.audit == nil {
+		return nil, domain.Errorf(domain.ErrFailedPrecondition, "", "the audit trail is not enabled")
+	}
+
+	after, err := decodeCursor(cmd.Cursor)
+	if err != nil {
+		return nil, domain.NewError(domain.ErrInvalidArgument, cmd.GroupID, err)
+	}
+
+	limit := cmd.Limit
+	if limit == 0 {
+		limit = DefaultHistoryPageSize
+	}
+
+	// ask for an extra entry to know if there is a next page
+	entries, err := a.audit.History(ctx, cmd.GroupID, after, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	page := &HistoryPage{Entries: entries}
+
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.Next = encodeCursor(page.Entries[limit-1].Key())
+	}
+
+	return page, nil
+}
+
+// record appends the entry to the audit trail, if enabled, with a new id and,
+// unless it has one, the current time, for the change made by a command with
+// the given options.
+//
+// If the change has been made in a transaction, failing to record the entry
+// fails the transaction. Otherwise, the change is already stored, so the
+// entry is lost, and the error is only logged, see WithAuditLog.
+func (a *App) record(ctx context.Context, entry domain.AuditEntry, options ...Option) error {
+	if a.audit == nil {
+		return nil
+	}
+
+	entry.ID = a.uuider.NewString()
+	if entry.Time.IsZero() {
+		entry.Time = a.now()
 	}
 
-	return do(ctx)
+	err := a.audit.Record(ctx, entry)
+	switch {
+	case err == nil:
+		return nil
+	case runsInTransaction(options...):
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+
+	a.logger.LogAttrs(ctx, slog.LevelError, "audit entry lost",
+		slog.String("group_id", entry.GroupID),
+		slog.Int("seq", entry.Seq),
+		slog.String("action", string(entry.Action)),
+		slog.String("user_id", entry.UserID),
+		slog.Any("error", err),
+	)
+
+	return nil
 }
+
+// auditSeq returns the position in the history of the group of its last
+// change: as members are only added, and each addition is a change, it is
+// the number of members.
+func auditSeq(group *domain.Group) int {
+	return group.NumMembers()
+}
+
+// now returns the current time of the clock in UTC, truncated to
+// milliseconds, the precision of MongoDB dates, so times do not change, nor
+// lose their order, once stored.
+func (a *App) now() time.Time {
+	return a.clock.Now().UTC().Truncate(time.Millisecond)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type nopMetrics struct{}
+
+func (nopMetrics) UseCaseFinished(string, error, time.Duration) {}
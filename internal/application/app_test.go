@@ -1,17 +1,31 @@
 package application_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/mock/gomock"
 )
 
+// now is the time of the clock of the apps in the tests.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
 type fixture struct {
 	app    *application.App
 	uuider *MockUuider
@@ -23,7 +37,7 @@ func newFixture(t *testing.T) *fixture {
 	uuider := NewMockUuider(ctrl)
 	store := NewMockStore(ctrl)
 
-	app := application.New(uuider, store)
+	app := application.New(uuider, store, application.WithClock(testhelp.NewClock(now)))
 
 	return &fixture{
 		app:    app,
@@ -53,7 +67,7 @@ func TestCreateGroup(t *testing.T) {
 
 		// GIVEN a groupRepo that fails the test if passed the wrong group
 		{
-			want := domain.NewGroup(fix.groupID, fix.ownerID)
+			want := domain.NewGroup(fix.groupID, fix.ownerID, now)
 			fix.store.EXPECT().
 				Create(gomock.Any(), want).
 				Return(nil)
@@ -88,10 +102,193 @@ func TestCreateGroup(t *testing.T) {
 		// WHEN we create a group
 		_, err := fix.app.CreateGroup(context.Background(), "irrelevant_owner_id")
 
-		// THEN we get the error we expect
-		require.Error(t, err)
-		require.ErrorContains(t, err, cause.Error())
+		// THEN we get the error we expect, wrapped
+		require.ErrorIs(t, err, cause)
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		fix := struct {
+			*fixture
+		}{
+			fixture: newFixture(t),
+		}
+
+		// GIVEN a uuider that returns the id of an existing group
+		fix.uuider.EXPECT().
+			NewString().
+			Return("existing_group_id")
+
+		// GIVEN a groupRepo that already has that group
+		fix.store.EXPECT().
+			Create(gomock.Any(), gomock.Any()).
+			Return(domain.NewError(domain.ErrAlreadyExists, "existing_group_id", nil))
+
+		// WHEN we create a group
+		_, err := fix.app.CreateGroup(context.Background(), "irrelevant_owner_id")
+
+		// THEN we get domain.ErrAlreadyExists with the group id
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+		var domainErr *domain.Error
+		require.ErrorAs(t, err, &domainErr)
+		require.Equal(t, "existing_group_id", domainErr.GroupID)
+	})
+}
+
+// Tests the callers can choose the id of the new group.
+func TestCreateGroup_UseGroupID(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a store expecting a group with the chosen id, and a uuider that
+	// fails the test if used
+	fix := newFixture(t)
+	fix.store.EXPECT().
+		Create(gomock.Any(), domain.NewGroup("chosen_id", "owner_id", now)).
+		Return(nil)
+
+	// WHEN we create a group choosing its id
+	id, err := fix.app.CreateGroup(context.Background(), "owner_id", application.UseGroupID("chosen_id"))
+	require.NoError(t, err)
+
+	// THEN we get the chosen id
+	require.Equal(t, "chosen_id", id)
+}
+
+func TestCreateGroup_IfAbsent(t *testing.T) {
+	t.Parallel()
+
+	options := []application.Option{
+		application.UseGroupID("group_id"),
+		application.CreateIfAbsent{},
+	}
+
+	notFound := domain.NewError(domain.ErrNotFound, "group_id", nil)
+	alreadyExists := domain.NewError(domain.ErrAlreadyExists, "group_id", nil)
+
+	t.Run("absent", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store without the group
+		fix := newFixture(t)
+		fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(nil, notFound)
+
+		// GIVEN-THEN a store expecting the creation of the group
+		fix.store.EXPECT().Create(gomock.Any(), domain.NewGroup("group_id", "owner_id", now)).Return(nil)
+
+		// WHEN we create the group if absent
+		id, err := fix.app.CreateGroup(context.Background(), "owner_id", options...)
+
+		// THEN it is created
+		require.NoError(t, err)
+		require.Equal(t, "group_id", id)
 	})
+
+	t.Run("present with the same owner", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store with the group, that fails the test if we create it
+		fix := newFixture(t)
+		fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(domain.NewGroup("group_id", "owner_id", now), nil)
+
+		// WHEN we create the group if absent
+		id, err := fix.app.CreateGroup(context.Background(), "owner_id", options...)
+
+		// THEN we get the existing group id
+		require.NoError(t, err)
+		require.Equal(t, "group_id", id)
+	})
+
+	t.Run("present with another owner", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store with the group owned by someone else
+		fix := newFixture(t)
+		fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(domain.NewGroup("group_id", "other_owner_id", now), nil)
+
+		// WHEN we create the group if absent
+		_, err := fix.app.CreateGroup(context.Background(), "owner_id", options...)
+
+		// THEN we get domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+
+	t.Run("created concurrently", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store where the group is created by someone else between
+		// our lookup and our creation
+		fix := newFixture(t)
+		gomock.InOrder(
+			fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(nil, notFound),
+			fix.store.EXPECT().Create(gomock.Any(), gomock.Any()).Return(alreadyExists),
+			fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(domain.NewGroup("group_id", "owner_id", now), nil),
+		)
+
+		// WHEN we create the group if absent
+		id, err := fix.app.CreateGroup(context.Background(), "owner_id", options...)
+
+		// THEN we get the group created by the other caller
+		require.NoError(t, err)
+		require.Equal(t, "group_id", id)
+	})
+
+	t.Run("load error", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store that cannot be reached
+		fix := newFixture(t)
+		unavailable := domain.NewError(domain.ErrUnavailable, "group_id", nil)
+		fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(nil, unavailable)
+
+		// WHEN we create the group if absent
+		_, err := fix.app.CreateGroup(context.Background(), "owner_id", options...)
+
+		// THEN we get the store error
+		require.ErrorIs(t, err, domain.ErrUnavailable)
+	})
+}
+
+// Tests many callers racing to create the same group all succeed, and only
+// one group is created.
+func TestCreateGroup_IfAbsentRace(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app over a real store
+	app := application.New(NewMockUuider(gomock.NewController(t)), memory.NewGroupRepo())
+
+	// WHEN many callers create the same group at the same time, some of
+	// them in transactions
+	const callers = 20
+
+	var wg sync.WaitGroup
+	errs := make([]error, callers)
+
+	wg.Add(callers)
+
+	for i := range callers {
+		go func() {
+			defer wg.Done()
+
+			options := []application.Option{application.UseGroupID("group_id"), application.CreateIfAbsent{}}
+			if i%2 == 0 {
+				options = append(options, application.EnableTransactions{})
+			}
+
+			_, errs[i] = app.CreateGroup(context.Background(), "owner_id", options...)
+		}()
+	}
+
+	wg.Wait()
+
+	// THEN they all succeed
+	for i, err := range errs {
+		require.NoErrorf(t, err, "caller %d", i)
+	}
+
+	// THEN the group has been created
+	group, err := app.GetGroup(context.Background(), "group_id")
+	require.NoError(t, err)
+	require.Equal(t, "owner_id", group.OwnerID())
 }
 
 func TestGetGroup(t *testing.T) {
@@ -108,7 +305,7 @@ func TestGetGroup(t *testing.T) {
 
 		// GIVEN a groupRepo that fails the test if passed the wrong group id
 		// and that returns a group
-		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id")
+		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id", now)
 		fix.store.EXPECT().
 			Load(gomock.Any(), fix.groupID).
 			Return(group, nil)
@@ -137,9 +334,27 @@ func TestGetGroup(t *testing.T) {
 		// WHEN we get a group
 		_, err := fix.app.GetGroup(context.Background(), "irrelevant_group_id")
 
-		// THEN we get the error we expect
-		require.Error(t, err)
-		require.ErrorContains(t, err, cause.Error())
+		// THEN we get the error we expect, wrapped
+		require.ErrorIs(t, err, cause)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		fix := struct {
+			*fixture
+		}{
+			fixture: newFixture(t),
+		}
+
+		// GIVEN a groupRepo that does not find the group
+		fix.store.EXPECT().
+			Load(gomock.Any(), gomock.Any()).
+			Return(nil, domain.ErrNotFound)
+
+		// WHEN we get the group
+		_, err := fix.app.GetGroup(context.Background(), "irrelevant_group_id")
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
 	})
 }
 
@@ -160,7 +375,7 @@ func TestAddUserToGroup(t *testing.T) {
 		}
 
 		// GIVEN a groupRepo expecting a Load with for the right group
-		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id")
+		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id", now)
 		fix.store.EXPECT().
 			Load(gomock.Any(), fix.groupID).
 			Return(group, nil)
@@ -199,9 +414,8 @@ func TestAddUserToGroup(t *testing.T) {
 		// WHEN we add a user to the group
 		err := fix.app.AddUserToGroup(context.Background(), "irrelevant_user_id", "irrelevant_group_id")
 
-		// THEN we get the error we expect
-		require.Error(t, err)
-		require.ErrorContains(t, err, cause.Error())
+		// THEN we get the error we expect, wrapped
+		require.ErrorIs(t, err, cause)
 	})
 
 	t.Run("groupRepo update error", func(t *testing.T) {
@@ -214,7 +428,7 @@ func TestAddUserToGroup(t *testing.T) {
 		}
 
 		// GIVEN a groupRepo that loads an irrelevant group
-		group := domain.NewGroup("irrelevant_group_id", "irrelevant_owner_id")
+		group := domain.NewGroup("irrelevant_group_id", "irrelevant_owner_id", now)
 		fix.store.EXPECT().
 			Load(gomock.Any(), gomock.Any()).
 			Return(group, nil)
@@ -228,9 +442,8 @@ func TestAddUserToGroup(t *testing.T) {
 		// WHEN we add a user to the group
 		err := fix.app.AddUserToGroup(context.Background(), "irrelevant_user_id", "irrelevant_group_id")
 
-		// THEN we get the error we expect
-		require.Error(t, err)
-		require.ErrorContains(t, err, cause.Error())
+		// THEN we get the error we expect, wrapped
+		require.ErrorIs(t, err, cause)
 	})
 
 	t.Run("full group", func(t *testing.T) {
@@ -252,9 +465,9 @@ func TestAddUserToGroup(t *testing.T) {
 		// GIVEN a groupRepo that loads a full group
 		var fullGroup *domain.Group
 		{
-			fullGroup = domain.NewGroup(fix.groupID, fix.ownerID)
+			fullGroup = domain.NewGroup(fix.groupID, fix.ownerID, now)
 			for i := range domain.MaxMembers - 1 {
-				err := fullGroup.AddMember(memberID(i))
+				err := fullGroup.AddMember(memberID(i), now)
 				require.NoErrorf(t, err, "adding member %d", i)
 			}
 		}
@@ -265,8 +478,628 @@ func TestAddUserToGroup(t *testing.T) {
 		// WHEN we add a user to the group
 		err := fix.app.AddUserToGroup(context.Background(), "new_user_id", fix.groupID)
 
-		// THEN we get the error ErrGroupFull
-		require.Error(t, err)
+		// THEN we get the error ErrGroupFull about the group
 		require.ErrorIs(t, err, domain.ErrGroupFull)
+
+		var domainErr *domain.Error
+		require.ErrorAs(t, err, &domainErr)
+		require.Equal(t, fix.groupID, domainErr.GroupID)
+	})
+}
+
+// detailsStore is a Store that also implements DetailsUpdater.
+type detailsStore struct {
+	*MockStore
+	*MockDetailsUpdater
+}
+
+func TestUpdateGroupDetails(t *testing.T) {
+	t.Parallel()
+
+	details := domain.Details{Name: "name", Description: "description", Tags: []string{"tag"}}
+
+	// groupWithDetails returns a group with the given details.
+	groupWithDetails := func(t *testing.T, d domain.Details) *domain.Group {
+		t.Helper()
+
+		g := domain.NewGroup("group_id", "owner_id", now)
+		require.NoError(t, g.SetDetails(d, now))
+
+		return g
+	}
+
+	t.Run("optimistic", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store with a group with some details
+		ctrl := gomock.NewController(t)
+		store := detailsStore{NewMockStore(ctrl), NewMockDetailsUpdater(ctrl)}
+		previous := domain.Details{Name: "old"}
+		store.MockStore.EXPECT().Load(gomock.Any(), "group_id").Return(groupWithDetails(t, previous), nil)
+
+		// GIVEN-THEN a store expecting the new details, conditioned to the
+		// previous ones, and no full update
+		store.MockDetailsUpdater.EXPECT().
+			UpdateDetails(gomock.Any(), gomock.Any(), previous).
+			DoAndReturn(func(_ context.Context, got *domain.Group, _ domain.Details) error {
+				require.Equal(t, details, got.Details())
+				return nil
+			})
+
+		app := application.New(NewMockUuider(ctrl), store)
+
+		// WHEN we update the details without transactions
+		err := app.UpdateGroupDetails(context.Background(), "group_id", details)
+
+		// THEN it succeeds (see the GIVEN-THEN above)
+		require.NoError(t, err)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store where the details are changed concurrently
+		ctrl := gomock.NewController(t)
+		store := detailsStore{NewMockStore(ctrl), NewMockDetailsUpdater(ctrl)}
+		store.MockStore.EXPECT().Load(gomock.Any(), "group_id").Return(groupWithDetails(t, domain.Details{}), nil)
+		store.MockDetailsUpdater.EXPECT().
+			UpdateDetails(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(domain.NewError(domain.ErrConflict, "group_id", nil))
+
+		app := application.New(NewMockUuider(ctrl), store)
+
+		// WHEN we update the details
+		err := app.UpdateGroupDetails(context.Background(), "group_id", details)
+
+		// THEN we get a retryable domain.ErrConflict
+		require.ErrorIs(t, err, domain.ErrConflict)
+		require.True(t, domain.IsRetryable(err))
+	})
+
+	t.Run("transactional", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store with a group, that runs the transactions
+		ctrl := gomock.NewController(t)
+		store := detailsStore{NewMockStore(ctrl), NewMockDetailsUpdater(ctrl)}
+		store.MockStore.EXPECT().
+			WithTransaction(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, f func(context.Context) error, _ uint) error {
+				return f(ctx)
+			})
+		store.MockStore.EXPECT().Load(gomock.Any(), "group_id").Return(groupWithDetails(t, domain.Details{}), nil)
+
+		// GIVEN-THEN a store expecting a full update in the transaction,
+		// instead of an optimistic one
+		store.MockStore.EXPECT().
+			Update(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, got *domain.Group) error {
+				require.Equal(t, details, got.Details())
+				return nil
+			})
+
+		app := application.New(NewMockUuider(ctrl), store)
+
+		// WHEN we update the details with transactions
+		err := app.UpdateGroupDetails(context.Background(), "group_id", details, application.EnableTransactions{})
+
+		// THEN it succeeds (see the GIVEN-THEN above)
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid details", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store with a group, that fails the test if updated
+		fix := newFixture(t)
+		fix.store.EXPECT().Load(gomock.Any(), "group_id").Return(groupWithDetails(t, domain.Details{}), nil)
+
+		// WHEN we update the details with an invalid tag
+		err := fix.app.UpdateGroupDetails(context.Background(), "group_id", domain.Details{Tags: []string{"NOPE"}})
+
+		// THEN we get domain.ErrInvalidArgument
+		require.ErrorIs(t, err, domain.ErrInvalidArgument)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store without the group
+		fix := newFixture(t)
+		fix.store.EXPECT().
+			Load(gomock.Any(), "group_id").
+			Return(nil, domain.NewError(domain.ErrNotFound, "group_id", nil))
+
+		// WHEN we update its details
+		err := fix.app.UpdateGroupDetails(context.Background(), "group_id", details)
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
+
+// Tests the groups record the times of the clock of the App.
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// GIVEN an app with a fake clock
+	clock := testhelp.NewClock(now)
+	app := application.New(sequentialUuider(t), memory.NewGroupRepo(), application.WithClock(clock))
+
+	// WHEN we create a group
+	groupID, err := app.CreateGroup(ctx, "owner_id")
+	require.NoError(t, err)
+
+	// WHEN we add a user later, in a transaction
+	clock.Advance(time.Minute)
+	err = app.AddUserToGroup(ctx, "user_id", groupID, application.EnableTransactions{})
+	require.NoError(t, err)
+
+	// WHEN we rename the group later
+	clock.Advance(time.Minute)
+	err = app.UpdateGroupDetails(ctx, groupID, domain.Details{Name: "name"})
+	require.NoError(t, err)
+
+	// THEN the group has the times of each change
+	group, err := app.GetGroup(ctx, groupID)
+	require.NoError(t, err)
+
+	require.Equal(t, now, group.CreatedAt())
+	require.Equal(t, now.Add(2*time.Minute), group.UpdatedAt())
+
+	joinedAt, ok := group.JoinedAt("user_id")
+	require.True(t, ok)
+	require.Equal(t, now.Add(time.Minute), joinedAt)
+}
+
+// Tests the calls stop as soon as their context is done, without writing
+// anything.
+func TestCancellation(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name    string
+		options []application.Option
+	}{
+		{
+			name:    "without transactions",
+			options: []application.Option{application.DelayBeforeUpdating(time.Hour)},
+		},
+		{
+			name: "with transactions",
+			options: []application.Option{
+				application.DelayBeforeUpdating(time.Hour),
+				application.EnableTransactions{},
+			},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a group
+			app := application.New(sequentialUuider(t), memory.NewGroupRepo())
+
+			groupID, err := app.CreateGroup(context.Background(), "owner_id")
+			require.NoError(t, err)
+
+			// WHEN we add a user with a long delay before updating the
+			// group and a short deadline
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			t.Cleanup(cancel)
+
+			start := time.Now()
+			err = app.AddUserToGroup(ctx, "user_id", groupID, test.options...)
+
+			// THEN the call fails with the error of the context as soon
+			// as the deadline is exceeded
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.NotErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+			require.Less(t, time.Since(start), time.Minute)
+
+			// THEN the user has not been added
+			group, err := app.GetGroup(context.Background(), groupID)
+			require.NoError(t, err)
+			require.Equal(t, []string{"owner_id"}, group.Members())
+		})
+	}
+}
+
+// sequentialUuider returns a uuider mock that returns "id_01", "id_02"...
+func sequentialUuider(t *testing.T) *MockUuider {
+	t.Helper()
+
+	var (
+		mu sync.Mutex
+		n  int
+	)
+
+	uuider := NewMockUuider(gomock.NewController(t))
+	uuider.EXPECT().NewString().DoAndReturn(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		n++
+
+		return fmt.Sprintf("id_%02d", n)
+	}).AnyTimes()
+
+	return uuider
+}
+
+// failingAuditLog is an audit log that fails to record entries.
+type failingAuditLog struct {
+	*memory.GroupRepo
+}
+
+func (failingAuditLog) Record(context.Context, domain.AuditEntry) error {
+	return errors.New("some error")
+}
+
+func TestAuditTrail(t *testing.T) {
+	t.Parallel()
+
+	// Tests the membership changes are recorded and can be read in pages.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		// GIVEN an app with an audit log
+		repo := memory.NewGroupRepo()
+		app := application.New(sequentialUuider(t), repo, application.WithAuditLog(repo))
+
+		// WHEN we create a group and add users to it, in and out of
+		// transactions, one of them twice
+		groupID, err := app.CreateGroup(ctx, "owner_id", application.Actor("admin"))
+		require.NoError(t, err)
+
+		err = app.AddUserToGroup(ctx, "user_1", groupID,
+			application.Actor("owner_id"), application.EnableTransactions{})
+		require.NoError(t, err)
+
+		err = app.AddUserToGroup(ctx, "user_1", groupID, application.Actor("owner_id"))
+		require.NoError(t, err)
+
+		err = app.AddUserToGroup(ctx, "user_2", groupID)
+		require.NoError(t, err)
+
+		// WHEN we read the first page of its history
+		first, err := app.GetGroupHistory(ctx, groupID, "", 2)
+		require.NoError(t, err)
+
+		// WHEN we read the next page
+		require.NotEmpty(t, first.Next)
+		second, err := app.GetGroupHistory(ctx, groupID, first.Next, 2)
+		require.NoError(t, err)
+
+		// THEN we get an entry per change, in order, without the repeated
+		// addition
+		require.Empty(t, second.Next)
+
+		entries := append(first.Entries, second.Entries...)
+		require.Len(t, entries, 3)
+
+		type summary struct {
+			actor, userID string
+			action        domain.AuditAction
+			before, after int
+		}
+
+		got := make([]summary, 0, len(entries))
+		for _, e := range entries {
+			require.Equal(t, groupID, e.GroupID)
+			require.NotEmpty(t, e.ID)
+			require.False(t, e.Time.IsZero())
+			got = append(got, summary{e.Actor, e.UserID, e.Action, e.MembersBefore, e.MembersAfter})
+		}
+
+		want := []summary{
+			{"admin", "owner_id", domain.AuditGroupCreated, 0, 1},
+			{"owner_id", "user_1", domain.AuditMemberAdded, 1, 2},
+			{"", "user_2", domain.AuditMemberAdded, 2, 3},
+		}
+		require.Equal(t, want, got)
+	})
+
+	// Tests a change is not stored if its entry cannot be recorded in its
+	// transaction.
+	t.Run("atomic", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		// GIVEN an app with an audit log that fails
+		repo := memory.NewGroupRepo()
+		app := application.New(sequentialUuider(t), repo,
+			application.WithAuditLog(failingAuditLog{repo}))
+
+		// GIVEN a group
+		err := repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we add a user in a transaction
+		err = app.AddUserToGroup(ctx, "user_id", "group_id", application.EnableTransactions{})
+
+		// THEN it fails
+		require.Error(t, err)
+
+		// THEN the user has not been added
+		group, err := app.GetGroup(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id"}, group.Members())
+	})
+
+	// Tests the changes made at the same time are read in the order they
+	// were made, whatever their ids.
+	t.Run("same time", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		// GIVEN an app with an audit log, a stopped clock and ids that
+		// decrease
+		var (
+			mu sync.Mutex
+			n  = 10
+		)
+
+		uuider := NewMockUuider(gomock.NewController(t))
+		uuider.EXPECT().NewString().DoAndReturn(func() string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			n--
+
+			return fmt.Sprintf("id_%02d", n)
+		}).AnyTimes()
+
+		repo := memory.NewGroupRepo()
+		app := application.New(uuider, repo,
+			application.WithAuditLog(repo), application.WithClock(testhelp.NewClock(now)))
+
+		// WHEN we create a group and add users to it
+		groupID, err := app.CreateGroup(ctx, "owner_id")
+		require.NoError(t, err)
+
+		for _, userID := range []string{"user_1", "user_2"} {
+			err = app.AddUserToGroup(ctx, userID, groupID)
+			require.NoError(t, err)
+		}
+
+		// WHEN we read its history one entry at a time
+		var got []string
+		cursor := ""
+		for {
+			page, err := app.GetGroupHistory(ctx, groupID, cursor, 1)
+			require.NoError(t, err)
+
+			for _, e := range page.Entries {
+				got = append(got, fmt.Sprintf("%d:%s", e.Seq, e.UserID))
+			}
+
+			if page.Next == "" {
+				break
+			}
+
+			cursor = page.Next
+		}
+
+		// THEN we get the entries in the order of the changes
+		require.Equal(t, []string{"1:owner_id", "2:user_1", "3:user_2"}, got)
+	})
+
+	// Tests a change outside a transaction is kept, and the loss of its
+	// entry logged, if the entry cannot be recorded.
+	t.Run("lost entry", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+
+		// GIVEN an app with an audit log that fails and a logger
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+		repo := memory.NewGroupRepo()
+		app := application.New(sequentialUuider(t), repo,
+			application.WithAuditLog(failingAuditLog{repo}), application.WithLogger(logger))
+
+		// GIVEN a group
+		err := repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we add a user outside a transaction
+		err = app.AddUserToGroup(ctx, "user_id", "group_id")
+
+		// THEN it succeeds and the user has been added
+		require.NoError(t, err)
+
+		group, err := app.GetGroup(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id", "user_id"}, group.Members())
+
+		// THEN the loss of the entry is logged
+		require.Contains(t, buf.String(), "audit entry lost")
+	})
+
+	// Tests the history cannot be read without an audit log.
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newFixture(t)
+
+		// WHEN we read the history of a group
+		_, err := fix.app.GetGroupHistory(context.Background(), "group_id", "", 0)
+
+		// THEN it fails with a precondition error, that cannot be retried
+		require.ErrorIs(t, err, domain.ErrFailedPrecondition)
+		require.False(t, domain.IsRetryable(err))
 	})
 }
+
+// Tests the use cases reject empty ids with domain.ErrInvalidArgument,
+// without reaching the store.
+func TestInvalidArgument(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(*application.App) error{
+		"create without owner": func(app *application.App) error {
+			_, err := app.CreateGroup(context.Background(), "")
+			return err
+		},
+		"get without group": func(app *application.App) error {
+			_, err := app.GetGroup(context.Background(), "")
+			return err
+		},
+		"add without group": func(app *application.App) error {
+			return app.AddUserToGroup(context.Background(), "user_id", "")
+		},
+		"add without user": func(app *application.App) error {
+			return app.AddUserToGroup(context.Background(), "", "group_id", application.EnableTransactions{})
+		},
+		"update details without group": func(app *application.App) error {
+			return app.UpdateGroupDetails(context.Background(), "", domain.Details{Name: "name"})
+		},
+		"history without group": func(app *application.App) error {
+			_, err := app.GetGroupHistory(context.Background(), "", "", 0)
+			return err
+		},
+		"history with malformed cursor": func(app *application.App) error {
+			_, err := app.GetGroupHistory(context.Background(), "group_id", "not a cursor", 0)
+			return err
+		},
+		"history with negative limit": func(app *application.App) error {
+			_, err := app.GetGroupHistory(context.Background(), "group_id", "", -1)
+			return err
+		},
+		"history with too big limit": func(app *application.App) error {
+			_, err := app.GetGroupHistory(context.Background(), "group_id", "", application.MaxHistoryPageSize+1)
+			return err
+		},
+	}
+
+	for name, call := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a store that fails the test if used
+			fix := newFixture(t)
+			fix.uuider.EXPECT().NewString().Return("group_id").AnyTimes()
+
+			// WHEN we call the use case with an empty id
+			err := call(fix.app)
+
+			// THEN we get a non retryable domain.ErrInvalidArgument
+			require.ErrorIs(t, err, domain.ErrInvalidArgument)
+			require.False(t, domain.IsRetryable(err))
+		})
+	}
+}
+
+// Tests the use cases log their outcome along with the request attributes.
+func TestLogging(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app with a logger writing JSON records to a buffer
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	app := application.New(NewMockUuider(ctrl), store, application.WithLogger(logger))
+
+	// GIVEN a store that fails to load the group
+	cause := errors.New("some_store_error")
+	store.EXPECT().
+		Load(gomock.Any(), gomock.Any()).
+		Return(nil, cause)
+
+	// WHEN we add a user to a group
+	err := app.AddUserToGroup(context.Background(), "some_user_id", "some_group_id")
+	require.Error(t, err)
+
+	// THEN we log the outcome with the request attributes
+	var record map[string]any
+	err = json.Unmarshal(buf.Bytes(), &record)
+	require.NoError(t, err)
+
+	require.Equal(t, "WARN", record["level"])
+	require.Equal(t, "AddUserToGroup", record["use_case"])
+	require.Equal(t, "some_group_id", record["group_id"])
+	require.Equal(t, "some_user_id", record["user_id"])
+	require.Contains(t, record["error"], cause.Error())
+	require.Contains(t, record, "duration")
+}
+
+// Tests the use cases report their outcome to the metrics.
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app with metrics
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	metrics := NewMockMetrics(ctrl)
+	app := application.New(NewMockUuider(ctrl), store, application.WithMetrics(metrics))
+
+	// GIVEN a store that loads a full group
+	fullGroup := domain.NewGroup("group_id", "owner_id", now)
+	for i := range domain.MaxMembers - 1 {
+		err := fullGroup.AddMember(fmt.Sprintf("member_id_%d", i), now)
+		require.NoError(t, err)
+	}
+
+	store.EXPECT().
+		Load(gomock.Any(), gomock.Any()).
+		Return(fullGroup, nil)
+
+	// GIVEN-THEN metrics expecting the use case to fail with ErrGroupFull
+	metrics.EXPECT().
+		UseCaseFinished("AddUserToGroup", gomock.Any(), gomock.Any()).
+		Do(func(_ string, err error, _ any) {
+			require.ErrorIs(t, err, domain.ErrGroupFull)
+		})
+
+	// WHEN we add a user to the group
+	err := app.AddUserToGroup(context.Background(), "new_user_id", "group_id")
+
+	// THEN we get ErrGroupFull, which has been reported (see the GIVEN-THEN above)
+	require.ErrorIs(t, err, domain.ErrGroupFull)
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app with a tracer provider that records the spans in memory
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	app := application.New(NewMockUuider(ctrl), store, application.WithTracerProvider(tp))
+
+	// GIVEN a store that fails to load
+	store.EXPECT().
+		Load(gomock.Any(), gomock.Any()).
+		Return(nil, domain.ErrNotFound)
+
+	// WHEN we add a user to a group
+	err := app.AddUserToGroup(context.Background(), "user_id", "group_id")
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	// THEN a span for the use case has been recorded
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	require.Equal(t, "application.AddUserToGroup", span.Name)
+
+	// THEN the span has the details of the request
+	require.Contains(t, span.Attributes, attribute.String("group_id", "group_id"))
+	require.Contains(t, span.Attributes, attribute.String("user_id", "user_id"))
+
+	// THEN the span has the error
+	require.Equal(t, codes.Error, span.Status.Code)
+	require.Contains(t, span.Status.Description, domain.ErrNotFound.Error())
+}
@@ -0,0 +1,286 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+)
+
+// maxCoalescedCalls is the maximum number of calls run in the same
+// transaction, to keep transactions short.
+const maxCoalescedCalls = 100
+
+// coalescer queues the calls that add users to the same group while a
+// transaction on the group is in progress, to run them together in the next
+// one, see WithCoalescing.
+type coalescer struct {
+	// run adds the users of the commands to the group in a single
+	// transaction, in their order of arrival, returning the result of
+	// each command.
+	run func(ctx context.Context, groupID string, arrivals []arrival) []error
+	// now returns the current time, to timestamp the arrivals.
+	now func() time.Time
+
+	mu sync.Mutex
+	// queues has the calls waiting for each group. There is a goroutine
+	// running the calls of each group in the map, see drain.
+	queues map[string][]*coalescedCall
+}
+
+// arrival is a command queued in the coalescer, with the time it was
+// queued, which is when its user joins the group.
+type arrival struct {
+	cmd AddUserToGroupCommand
+	at  time.Time
+}
+
+// coalescedCall is a call waiting for its result.
+type coalescedCall struct {
+	arrival
+
+	ctx  context.Context
+	done chan error
+}
+
+func newCoalescer(
+	now func() time.Time,
+	run func(ctx context.Context, groupID string, arrivals []arrival) []error,
+) *coalescer {
+	return &coalescer{
+		run:    run,
+		now:    now,
+		queues: map[string][]*coalescedCall{},
+	}
+}
+
+// coalescing returns a middleware that sends the AddUserToGroup commands
+// that can be coalesced to the coalescer, instead of running them one by one.
+func coalescing(c *coalescer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			add, ok := cmd.(AddUserToGroupCommand)
+			if !ok || !canCoalesce(add.Options()...) {
+				return next(ctx, cmd)
+			}
+
+			return nil, c.do(ctx, add)
+		}
+	}
+}
+
+// do queues the command and waits for its result, or until the context is
+// done. In the latter case, the user may still be added, if the command was
+// already running.
+func (c *coalescer) do(ctx context.Context, cmd AddUserToGroupCommand) error {
+	call := &coalescedCall{
+		arrival: arrival{cmd: cmd},
+		ctx:     ctx,
+		done:    make(chan error, 1),
+	}
+
+	c.mu.Lock()
+
+	// timestamped with the lock held, so the times of the calls in the
+	// queue follow their order
+	call.at = c.now()
+
+	queue, running := c.queues[cmd.GroupID]
+	c.queues[cmd.GroupID] = append(queue, call)
+
+	if !running {
+		go c.drain(cmd.GroupID)
+	}
+
+	c.mu.Unlock()
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain runs the calls queued for the group, in batches, until there are
+// no more.
+func (c *coalescer) drain(groupID string) {
+	for {
+		batch := c.next(groupID)
+		if batch == nil {
+			return
+		}
+
+		results := c.runBatch(groupID, batch)
+
+		for i, call := range batch {
+			call.done <- results[i]
+		}
+	}
+}
+
+// runBatch runs the batch of calls to the group, returning their results. If
+// running them panics, all of them fail with the panic, instead of waiting
+// forever.
+func (c *coalescer) runBatch(groupID string, batch []*coalescedCall) (results []error) {
+	ctx, cancel := batchContext(groupID, batch)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("running coalesced calls: panic: %v", r)
+
+			results = make([]error, len(batch))
+			for i := range results {
+				results[i] = err
+			}
+		}
+	}()
+
+	arrivals := make([]arrival, len(batch))
+	for i, call := range batch {
+		arrivals[i] = call.arrival
+	}
+
+	return c.run(ctx, groupID, arrivals)
+}
+
+// next removes the next batch of calls from the queue of the group, or
+// returns nil, and forgets the group, if there are none. The calls whose
+// context is already done are dropped.
+func (c *coalescer) next(groupID string) []*coalescedCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	queue := c.queues[groupID]
+
+	var batch []*coalescedCall
+
+	for len(queue) > 0 && len(batch) < maxCoalescedCalls {
+		call := queue[0]
+		queue = queue[1:]
+
+		if err := call.ctx.Err(); err != nil {
+			call.done <- err
+			continue
+		}
+
+		batch = append(batch, call)
+	}
+
+	if len(batch) == 0 {
+		delete(c.queues, groupID)
+		return nil
+	}
+
+	c.queues[groupID] = queue
+
+	return batch
+}
+
+// batchContext returns the context to run the batch with: it does not
+// belong to any of the calls, so it only has the group as logging attribute,
+// and it is cancelled once the contexts of all the calls are done, as nobody
+// waits for the batch anymore.
+func batchContext(groupID string, batch []*coalescedCall) (context.Context, context.CancelFunc) {
+	ctx := logging.WithAttrs(context.Background(), slog.String("group_id", groupID))
+	ctx, cancel := context.WithCancel(ctx)
+
+	var pending atomic.Int64
+	pending.Store(int64(len(batch)))
+
+	stops := make([]func() bool, len(batch))
+	for i, call := range batch {
+		stops[i] = context.AfterFunc(call.ctx, func() {
+			if pending.Add(-1) == 0 {
+				cancel()
+			}
+		})
+	}
+
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+
+		cancel()
+	}
+}
+
+// addUsersToGroup adds the users of the commands to the group in a single
+// transaction, in their order of arrival, returning the result of each
+// command: the commands fail on their own if their user cannot be added, like
+// when the group is full, or all together if the transaction fails.
+//
+// Each user joins the group, and its audit entry is timestamped, when its
+// command arrived, so the batch keeps the order of the calls.
+func (a *App) addUsersToGroup(ctx context.Context, groupID string, arrivals []arrival) []error {
+	a.logger.LogAttrs(ctx, slog.LevelDebug, "coalesced calls",
+		slog.Int("calls", len(arrivals)),
+	)
+
+	results := make([]error, len(arrivals))
+
+	err := a.store.WithTransaction(ctx, func(ctx context.Context) error {
+		clear(results)
+
+		group, err := a.store.Load(ctx, groupID)
+		if err != nil {
+			return fmt.Errorf("loading: %w", err)
+		}
+
+		var entries []domain.AuditEntry
+
+		for i, arrival := range arrivals {
+			cmd := arrival.cmd
+			before := len(group.Members())
+
+			if err := group.AddMember(cmd.UserID, arrival.at); err != nil {
+				results[i] = fmt.Errorf("adding: %w", err)
+				continue
+			}
+
+			if after := len(group.Members()); after != before {
+				entries = append(entries, domain.AuditEntry{
+					GroupID:       groupID,
+					Seq:           auditSeq(group),
+					Actor:         actorOption(cmd.Options()...),
+					Action:        domain.AuditMemberAdded,
+					UserID:        cmd.UserID,
+					Time:          arrival.at,
+					MembersBefore: before,
+					MembersAfter:  after,
+				})
+			}
+		}
+
+		if len(entries) == 0 {
+			// all the users were already members or could not be added
+			return nil
+		}
+
+		if err := a.store.Update(ctx, group); err != nil {
+			return fmt.Errorf("updating: %w", err)
+		}
+
+		for _, entry := range entries {
+			if err := a.record(ctx, entry, EnableTransactions{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, a.retries)
+	if err != nil {
+		for i := range results {
+			results[i] = err
+		}
+	}
+
+	return results
+}
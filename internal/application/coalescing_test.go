@@ -0,0 +1,554 @@
+package application_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+type coalescingFixture struct {
+	app   *application.App
+	repo  *memory.GroupRepo
+	clock *testhelp.Clock
+	// commits counts the transactions committed by the repo.
+	commits atomic.Int64
+	// armed makes the next load wait for release, see hold.
+	armed atomic.Bool
+	// panicking makes the loads panic.
+	panicking atomic.Bool
+	held      chan struct{}
+	release   chan struct{}
+	// entered receives a value when a call reaches the coalescer.
+	entered chan struct{}
+}
+
+// newCoalescingFixture returns an app with coalescing, audit trail and a
+// stopped clock, with the given extra options, and a group with "group_id" as
+// id, owned by "owner_id".
+func newCoalescingFixture(t *testing.T, options ...application.AppOption) *coalescingFixture {
+	t.Helper()
+
+	fix := &coalescingFixture{
+		clock:   testhelp.NewClock(now),
+		held:    make(chan struct{}),
+		release: make(chan struct{}),
+		entered: make(chan struct{}, 100),
+	}
+
+	fix.repo = memory.NewGroupRepo(memory.WithStepHook(func(_ context.Context, step memory.Step) {
+		switch step {
+		case memory.StepCommit:
+			fix.commits.Add(1)
+		case memory.StepLoad:
+			if fix.panicking.Load() {
+				panic("some panic")
+			}
+
+			if fix.armed.CompareAndSwap(true, false) {
+				close(fix.held)
+				<-fix.release
+			}
+		}
+	}))
+
+	// the last middleware runs right before the coalescer
+	enter := func(next application.Handler) application.Handler {
+		return func(ctx context.Context, cmd application.Command) (any, error) {
+			if _, ok := cmd.(application.AddUserToGroupCommand); ok {
+				fix.entered <- struct{}{}
+			}
+
+			return next(ctx, cmd)
+		}
+	}
+
+	options = append([]application.AppOption{
+		application.WithCoalescing(),
+		application.WithAuditLog(fix.repo),
+		application.WithClock(fix.clock),
+		application.WithMiddleware(enter),
+	}, options...)
+
+	fix.app = application.New(sequentialUuider(t), fix.repo, options...)
+
+	_, err := fix.app.CreateGroup(context.Background(), "owner_id", application.UseGroupID("group_id"))
+	require.NoError(t, err)
+
+	fix.commits.Store(0)
+
+	return fix
+}
+
+// hold adds the user to the group and holds its transaction until the
+// returned function is called, which returns the result of the call.
+func (fix *coalescingFixture) hold(t *testing.T, userID string) func() error {
+	t.Helper()
+
+	fix.armed.Store(true)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fix.app.AddUserToGroup(context.Background(), userID, "group_id", application.EnableTransactions{})
+	}()
+
+	<-fix.held
+	<-fix.entered
+
+	return func() error {
+		close(fix.release)
+		return <-done
+	}
+}
+
+// addUsers adds each user to the group concurrently, with the given
+// context, and waits until all the calls have reached the coalescer. Call
+// the returned function to get the result of each call.
+func (fix *coalescingFixture) addUsers(ctx context.Context, userIDs ...string) func() map[string]error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = map[string]error{}
+	)
+
+	wg.Add(len(userIDs))
+
+	for _, id := range userIDs {
+		go func() {
+			defer wg.Done()
+
+			err := fix.app.AddUserToGroup(ctx, id, "group_id", application.EnableTransactions{})
+
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}()
+	}
+
+	for range userIDs {
+		<-fix.entered
+	}
+
+	// give the calls time to go from the middleware to the queue
+	time.Sleep(10 * time.Millisecond)
+
+	return func() map[string]error {
+		wg.Wait()
+		return results
+	}
+}
+
+// Tests the calls to the same group that arrive during a transaction are
+// run together in the next one.
+func TestCoalescing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN other users are added meanwhile
+		wait := fix.addUsers(context.Background(), "user_2", "user_3", "user_4")
+
+		// THEN all of them are added
+		require.NoError(t, finish())
+
+		for id, err := range wait() {
+			require.NoError(t, err, id)
+		}
+
+		group, err := fix.app.GetGroup(context.Background(), "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id", "user_1", "user_2", "user_3", "user_4"}, group.Members())
+
+		// THEN only one more transaction has been committed
+		require.Equal(t, int64(2), fix.commits.Load())
+	})
+
+	t.Run("individual results", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN more users than fit in the group are added meanwhile
+		wait := fix.addUsers(context.Background(), "user_2", "user_3", "user_4", "user_5", "user_6")
+
+		require.NoError(t, finish())
+
+		// THEN the users that fit are added and the others get
+		// domain.ErrGroupFull
+		added, full := 0, 0
+
+		for id, err := range wait() {
+			switch {
+			case err == nil:
+				added++
+			case domain.KindOf(err) == domain.ErrGroupFull:
+				full++
+			default:
+				require.Failf(t, "unexpected error", "%s: %v", id, err)
+			}
+		}
+
+		require.Equal(t, domain.MaxMembers-2, added)
+		require.Equal(t, 2, full)
+
+		group, err := fix.app.GetGroup(context.Background(), "group_id")
+		require.NoError(t, err)
+		require.Len(t, group.Members(), domain.MaxMembers)
+	})
+
+	t.Run("shared errors", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// WHEN users are added concurrently to a group that does not exist
+		var wg sync.WaitGroup
+
+		errs := make([]error, 5)
+		for i := range errs {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				errs[i] = fix.app.AddUserToGroup(context.Background(), fmt.Sprintf("user_%d", i),
+					"missing_group_id", application.EnableTransactions{})
+			}()
+		}
+
+		wg.Wait()
+
+		// THEN all of them get domain.ErrNotFound
+		for _, err := range errs {
+			require.ErrorIs(t, err, domain.ErrNotFound)
+		}
+	})
+
+	t.Run("cancelled while queued", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN the context of a call is cancelled while it waits
+		ctx, cancel := context.WithCancel(context.Background())
+		wait := fix.addUsers(ctx, "user_2")
+		cancel()
+
+		// THEN it fails with the error of the context
+		require.ErrorIs(t, wait()["user_2"], context.Canceled)
+
+		// THEN the user is not added after the transaction in progress
+		require.NoError(t, finish())
+
+		group, err := fix.app.GetGroup(context.Background(), "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id", "user_1"}, group.Members())
+		require.Equal(t, int64(1), fix.commits.Load())
+	})
+
+	t.Run("order of arrival", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN other users are added meanwhile, one after the other, and
+		// not in alphabetical order
+		fix.clock.Advance(time.Second)
+		wait3 := fix.addUsers(context.Background(), "user_3")
+
+		fix.clock.Advance(time.Second)
+		wait2 := fix.addUsers(context.Background(), "user_2")
+
+		require.NoError(t, finish())
+		require.NoError(t, wait3()["user_3"])
+		require.NoError(t, wait2()["user_2"])
+
+		// THEN each user joins when its call arrived
+		group, err := fix.app.GetGroup(context.Background(), "group_id")
+		require.NoError(t, err)
+
+		for id, want := range map[string]time.Time{
+			"user_1": now,
+			"user_3": now.Add(time.Second),
+			"user_2": now.Add(2 * time.Second),
+		} {
+			got, ok := group.JoinedAt(id)
+			require.True(t, ok, id)
+			require.Equal(t, want, got, id)
+		}
+
+		// THEN the history has the additions in the order of the calls
+		page, err := fix.app.GetGroupHistory(context.Background(), "group_id", "", 0)
+		require.NoError(t, err)
+
+		var got []string
+		for _, e := range page.Entries {
+			got = append(got, fmt.Sprintf("%d:%s:%s", e.Seq, e.UserID, e.Time.Sub(now)))
+		}
+
+		require.Equal(t, []string{"1:owner_id:0s", "2:user_1:0s", "3:user_3:1s", "4:user_2:2s"}, got)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newCoalescingFixture(t)
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN other users are added meanwhile, and their batch panics
+		wait := fix.addUsers(context.Background(), "user_2", "user_3")
+		fix.panicking.Store(true)
+
+		require.NoError(t, finish())
+
+		// THEN all of them fail with the panic
+		results := wait()
+		require.Len(t, results, 2)
+
+		for id, err := range results {
+			require.ErrorContains(t, err, "some panic", id)
+		}
+
+		// THEN the next calls are still run
+		fix.panicking.Store(false)
+
+		err := fix.app.AddUserToGroup(context.Background(), "user_4", "group_id", application.EnableTransactions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("batch context", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN an app with coalescing logging everything
+		var buf syncBuffer
+		logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		fix := newCoalescingFixture(t, application.WithLogger(logger))
+
+		// GIVEN a transaction in progress on the group
+		finish := fix.hold(t, "user_1")
+
+		// WHEN other users are added meanwhile
+		wait := fix.addUsers(context.Background(), "user_2", "user_3")
+
+		require.NoError(t, finish())
+
+		for id, err := range wait() {
+			require.NoError(t, err, id)
+		}
+
+		// THEN their batch is logged with the group, but not with the
+		// attributes of any of the calls
+		var batches []map[string]any
+
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(line, &record))
+
+			if record["msg"] == "coalesced calls" && record["calls"] == float64(2) {
+				batches = append(batches, record)
+			}
+		}
+
+		require.Len(t, batches, 1)
+		require.Equal(t, "group_id", batches[0]["group_id"])
+		require.NotContains(t, batches[0], "user_id")
+	})
+
+	t.Run("audit trail", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN an app with coalescing and audit trail
+		repo := memory.NewGroupRepo()
+		app := application.New(sequentialUuider(t), repo,
+			application.WithCoalescing(),
+			application.WithAuditLog(repo),
+		)
+
+		groupID, err := app.CreateGroup(context.Background(), "owner_id")
+		require.NoError(t, err)
+
+		// WHEN we add a user, and then the same user again
+		for range 2 {
+			err = app.AddUserToGroup(context.Background(), "user_id", groupID,
+				application.EnableTransactions{}, application.Actor("actor_id"))
+			require.NoError(t, err)
+		}
+
+		// THEN the addition is recorded once, with its actor
+		page, err := app.GetGroupHistory(context.Background(), groupID, "", 0)
+		require.NoError(t, err)
+		require.Len(t, page.Entries, 2)
+		require.Equal(t, domain.AuditMemberAdded, page.Entries[1].Action)
+		require.Equal(t, "actor_id", page.Entries[1].Actor)
+		require.Equal(t, 1, page.Entries[1].MembersBefore)
+		require.Equal(t, 2, page.Entries[1].MembersAfter)
+	})
+}
+
+// syncBuffer is a bytes.Buffer safe to write from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the content of the buffer.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// countingStore counts the transactions run on a store and their attempts.
+type countingStore struct {
+	*memory.GroupRepo
+	transactions atomic.Int64
+	attempts     atomic.Int64
+}
+
+func (s *countingStore) WithTransaction(
+	ctx context.Context,
+	callback func(context.Context) error,
+	retries uint,
+) error {
+	s.transactions.Add(1)
+
+	return s.GroupRepo.WithTransaction(ctx, func(ctx context.Context) error {
+		s.attempts.Add(1)
+		return callback(ctx)
+	}, retries)
+}
+
+// Compares adding users to groups from many goroutines, each call in its own
+// transaction and coalescing them, under contention: the users are added
+// concurrently to the same groups, filling them one after the other.
+//
+// Besides the time per operation, it reports these custom metrics:
+//   - transactions/op: the transactions run per call.
+//   - retries/op: the attempts of the transactions that had to be retried.
+//   - errors/op: calls that failed, for instance, by running out of
+//     transaction retries.
+//
+// Run it with:
+//
+//	go test ./internal/application -run xxx -bench Coalescing
+func BenchmarkAddUserToGroup_Coalescing(b *testing.B) {
+	modes := []struct {
+		name    string
+		options []application.AppOption
+	}{
+		{
+			name: "transactions",
+		},
+		{
+			name:    "coalescing",
+			options: []application.AppOption{application.WithCoalescing()},
+		},
+	}
+
+	for _, mode := range modes {
+		for _, goroutines := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", mode.name, goroutines), func(b *testing.B) {
+				benchmarkCoalescing(b, goroutines, mode.options)
+			})
+		}
+	}
+}
+
+func benchmarkCoalescing(b *testing.B, goroutines int, options []application.AppOption) {
+	ctx := context.Background()
+
+	store := &countingStore{GroupRepo: memory.NewGroupRepo()}
+	app := application.New(NewMockUuider(gomock.NewController(b)), store, options...)
+
+	// GIVEN enough groups for all the users
+	const usersPerGroup = domain.MaxMembers - 1
+
+	groupID := func(n int64) string {
+		return fmt.Sprintf("group_id_%d", n/usersPerGroup)
+	}
+
+	for n := int64(0); n < int64(b.N); n += usersPerGroup {
+		if _, err := app.CreateGroup(ctx, "owner_id", application.UseGroupID(groupID(n))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var (
+		next     atomic.Int64
+		failures atomic.Int64
+		wg       sync.WaitGroup
+	)
+
+	b.ResetTimer()
+
+	// WHEN the goroutines add users until they run b.N calls between all of
+	// them
+	wg.Add(goroutines)
+
+	for range goroutines {
+		go func() {
+			defer wg.Done()
+
+			for {
+				n := next.Add(1) - 1
+				if n >= int64(b.N) {
+					return
+				}
+
+				userID := fmt.Sprintf("user_id_%d", n)
+
+				if err := app.AddUserToGroup(ctx, userID, groupID(n), application.EnableTransactions{}); err != nil {
+					failures.Add(1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	b.StopTimer()
+
+	// THEN we report the custom metrics
+	transactions := store.transactions.Load()
+
+	b.ReportMetric(float64(transactions)/float64(b.N), "transactions/op")
+	b.ReportMetric(float64(store.attempts.Load()-transactions)/float64(b.N), "retries/op")
+	b.ReportMetric(float64(failures.Load())/float64(b.N), "errors/op")
+}
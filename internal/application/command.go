@@ -0,0 +1,179 @@
+package application
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// Command is a request to run a use case. Commands are dispatched through the
+// middlewares of the App before reaching the handler of their use case.
+type Command interface {
+	// UseCase returns the name of the use case, like "AddUserToGroup".
+	UseCase() string
+	// Attrs returns the attributes that describe the command, used in logs
+	// and spans.
+	Attrs() []slog.Attr
+	// Options returns the per-call options of the command.
+	Options() []Option
+}
+
+// validator is implemented by the commands that can check their arguments
+// before being handled.
+type validator interface {
+	// Validate returns a domain.ErrInvalidArgument error if the command
+	// cannot be handled because of its arguments.
+	Validate() error
+}
+
+// CreateGroupCommand creates a new group owned by OwnerID with GroupID as its
+// id. Its handler returns nil.
+type CreateGroupCommand struct {
+	GroupID string
+	OwnerID string
+	// CallOptions are the per-call options, like CreateIfAbsent.
+	CallOptions []Option
+}
+
+func (CreateGroupCommand) UseCase() string { return "CreateGroup" }
+
+func (c CreateGroupCommand) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("group_id", c.GroupID),
+		slog.String("owner_id", c.OwnerID),
+	}
+}
+
+func (c CreateGroupCommand) Options() []Option { return c.CallOptions }
+
+func (c CreateGroupCommand) Validate() error {
+	switch {
+	case c.GroupID == "":
+		return domain.NewError(domain.ErrInvalidArgument, "", errors.New("empty group id"))
+	case c.OwnerID == "":
+		return domain.NewError(domain.ErrInvalidArgument, c.GroupID, errors.New("empty owner id"))
+	}
+
+	return nil
+}
+
+// GetGroupCommand gets the group with GroupID as its id. Its handler returns
+// a *domain.Group.
+type GetGroupCommand struct {
+	GroupID string
+}
+
+func (GetGroupCommand) UseCase() string { return "GetGroup" }
+
+func (c GetGroupCommand) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("group_id", c.GroupID),
+	}
+}
+
+func (GetGroupCommand) Options() []Option { return nil }
+
+func (c GetGroupCommand) Validate() error {
+	if c.GroupID == "" {
+		return domain.NewError(domain.ErrInvalidArgument, "", errors.New("empty group id"))
+	}
+
+	return nil
+}
+
+// AddUserToGroupCommand adds UserID as a member of the group with GroupID as
+// its id. Its handler returns nil.
+type AddUserToGroupCommand struct {
+	UserID  string
+	GroupID string
+	// CallOptions are the per-call options, like EnableTransactions.
+	CallOptions []Option
+}
+
+func (AddUserToGroupCommand) UseCase() string { return "AddUserToGroup" }
+
+func (c AddUserToGroupCommand) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("group_id", c.GroupID),
+		slog.String("user_id", c.UserID),
+	}
+}
+
+func (c AddUserToGroupCommand) Options() []Option { return c.CallOptions }
+
+func (c AddUserToGroupCommand) Validate() error {
+	switch {
+	case c.GroupID == "":
+		return domain.NewError(domain.ErrInvalidArgument, "", errors.New("empty group id"))
+	case c.UserID == "":
+		return domain.NewError(domain.ErrInvalidArgument, c.GroupID, errors.New("empty user id"))
+	}
+
+	return nil
+}
+
+// UpdateGroupDetailsCommand replaces the details of the group with GroupID as
+// its id. Its handler returns nil.
+type UpdateGroupDetailsCommand struct {
+	GroupID string
+	Details domain.Details
+	// CallOptions are the per-call options, like EnableTransactions.
+	CallOptions []Option
+}
+
+func (UpdateGroupDetailsCommand) UseCase() string { return "UpdateGroupDetails" }
+
+func (c UpdateGroupDetailsCommand) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("group_id", c.GroupID),
+		slog.String("name", c.Details.Name),
+	}
+}
+
+func (c UpdateGroupDetailsCommand) Options() []Option { return c.CallOptions }
+
+func (c UpdateGroupDetailsCommand) Validate() error {
+	if c.GroupID == "" {
+		return domain.NewError(domain.ErrInvalidArgument, "", errors.New("empty group id"))
+	}
+
+	return nil
+}
+
+// GetGroupHistoryCommand gets a page of the audit trail of the group with
+// GroupID as its id. Its handler returns a *HistoryPage.
+type GetGroupHistoryCommand struct {
+	GroupID string
+	// Cursor is the Next cursor of the previous page, empty for the first
+	// page.
+	Cursor string
+	// Limit is the maximum number of entries, DefaultHistoryPageSize if 0.
+	Limit int
+}
+
+func (GetGroupHistoryCommand) UseCase() string { return "GetGroupHistory" }
+
+func (c GetGroupHistoryCommand) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("group_id", c.GroupID),
+	}
+}
+
+func (GetGroupHistoryCommand) Options() []Option { return nil }
+
+func (c GetGroupHistoryCommand) Validate() error {
+	switch {
+	case c.GroupID == "":
+		return domain.NewError(domain.ErrInvalidArgument, "", errors.New("empty group id"))
+	case c.Limit < 0 || c.Limit > MaxHistoryPageSize:
+		return domain.Errorf(domain.ErrInvalidArgument, c.GroupID,
+			"limit must be between 0 and %d, got %d", MaxHistoryPageSize, c.Limit)
+	}
+
+	if _, err := decodeCursor(c.Cursor); err != nil {
+		return domain.NewError(domain.ErrInvalidArgument, c.GroupID, err)
+	}
+
+	return nil
+}
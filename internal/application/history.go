@@ -0,0 +1,65 @@
+package application
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+const (
+	// DefaultHistoryPageSize is the number of entries in a page of history
+	// when no limit is given.
+	DefaultHistoryPageSize = 50
+	// MaxHistoryPageSize is the maximum number of entries in a page of
+	// history.
+	MaxHistoryPageSize = 500
+)
+
+// HistoryPage is a page of the audit trail of a group.
+type HistoryPage struct {
+	// Entries sorted by key, oldest first.
+	Entries []domain.AuditEntry
+	// Next is the cursor of the next page, empty if this is the last one.
+	Next string
+}
+
+// encodeCursor returns an opaque cursor pointing right after the given key.
+func encodeCursor(k domain.AuditKey) string {
+	raw := strconv.FormatInt(k.Time.UnixMilli(), 10) + ":" + strconv.Itoa(k.Seq) + ":" + k.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns the key a cursor points to, the zero key for an empty
+// cursor.
+func decodeCursor(cursor string) (domain.AuditKey, error) {
+	if cursor == "" {
+		return domain.AuditKey{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return domain.AuditKey{}, errors.New("malformed cursor")
+	}
+
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return domain.AuditKey{}, errors.New("malformed cursor")
+	}
+
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return domain.AuditKey{}, fmt.Errorf("malformed cursor time: %v", err)
+	}
+
+	seq, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return domain.AuditKey{}, fmt.Errorf("malformed cursor sequence: %v", err)
+	}
+
+	return domain.AuditKey{Time: time.UnixMilli(millis).UTC(), Seq: seq, ID: parts[2]}, nil
+}
@@ -0,0 +1,214 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler runs a command and returns its result, see the documentation of
+// each command for the type of its result.
+type Handler func(ctx context.Context, cmd Command) (any, error)
+
+// Middleware wraps a Handler to add behaviour before or after it, like
+// logging or authorization, for all the use cases at once.
+type Middleware func(next Handler) Handler
+
+// chain returns a handler that runs the middlewares in order, the first one
+// being the outermost, before running h.
+func chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// tracing returns a middleware that creates a span for each command, with
+// its attributes and its error, if any.
+func tracing(tracer trace.Tracer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			attrs := cmd.Attrs()
+
+			spanAttrs := make([]attribute.KeyValue, 0, len(attrs))
+			for _, attr := range attrs {
+				spanAttrs = append(spanAttrs, attribute.String(attr.Key, attr.Value.String()))
+			}
+
+			ctx, span := tracer.Start(ctx, "application."+cmd.UseCase(), trace.WithAttributes(spanAttrs...))
+			defer span.End()
+
+			result, err := next(ctx, cmd)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+
+			return result, err
+		}
+	}
+}
+
+// logs returns a middleware that adds the attributes of each command to the
+// context, so they appear in every record logged while running it, and logs
+// its outcome.
+func logs(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			start := time.Now()
+			ctx = logging.WithAttrs(ctx, cmd.Attrs()...)
+
+			result, err := next(ctx, cmd)
+
+			level := slog.LevelDebug
+			if err != nil {
+				level = slog.LevelWarn
+			}
+
+			logger.LogAttrs(ctx, level, "use case finished",
+				slog.String("use_case", cmd.UseCase()),
+				slog.Duration("duration", time.Since(start)),
+				slog.Any("error", err),
+			)
+
+			return result, err
+		}
+	}
+}
+
+// measure returns a middleware that reports the outcome of each command.
+func measure(metrics Metrics) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			start := time.Now()
+
+			result, err := next(ctx, cmd)
+
+			metrics.UseCaseFinished(cmd.UseCase(), err, time.Since(start))
+
+			return result, err
+		}
+	}
+}
+
+// validation returns a middleware that rejects the commands with invalid
+// arguments, before they reach the store.
+func validation() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			if v, ok := cmd.(validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, err
+				}
+			}
+
+			return next(ctx, cmd)
+		}
+	}
+}
+
+// atomicUpdates returns a middleware that adds users to groups with
+// MemberAdder.AddMember instead of running the handler, when the store
+// supports it. The users join at the time returned by now.
+//
+// It only applies to the AddUserToGroup commands with the EnableAtomicUpdates
+// option and without the DelayBeforeUpdating option, as the latter only makes
+// sense for the load and update path. Those commands fail if there is an
+// audit log, as the atomic write cannot record the audit entry.
+func atomicUpdates(store Store, audited bool, now func() time.Time) Middleware {
+	adder, ok := store.(MemberAdder)
+	if !ok {
+		return func(next Handler) Handler { return next }
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			add, ok := cmd.(AddUserToGroupCommand)
+			if !ok || !canUseAtomicUpdates(add.Options()...) {
+				return next(ctx, cmd)
+			}
+
+			if audited {
+				return nil, domain.Errorf(domain.ErrFailedPrecondition, "", "atomic updates are not available with the audit log")
+			}
+
+			return nil, adder.AddMember(ctx, add.GroupID, add.UserID, now())
+		}
+	}
+}
+
+// locking returns a middleware that adds users to groups while holding the
+// lock of the group, with add, instead of running the handler, for the
+// AddUserToGroup commands with the EnableLocking option.
+//
+// Those commands fail if there is no locker or if the store does not
+// implement FencedUpdater.
+func locking(
+	locker Locker,
+	store Store,
+	add func(context.Context, AddUserToGroupCommand, FencedUpdater, int64) error,
+) Middleware {
+	updater, fenced := store.(FencedUpdater)
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			addCmd, ok := cmd.(AddUserToGroupCommand)
+			if !ok || !isLockingEnabled(addCmd.Options()...) {
+				return next(ctx, cmd)
+			}
+
+			switch {
+			case locker == nil:
+				return nil, domain.Errorf(domain.ErrFailedPrecondition, "", "locking is not enabled")
+			case !fenced:
+				return nil, domain.Errorf(domain.ErrFailedPrecondition, "", "the store does not support fenced updates")
+			}
+
+			token, unlock, err := locker.Lock(ctx, addCmd.GroupID)
+			if err != nil {
+				return nil, fmt.Errorf("locking: %w", err)
+			}
+
+			err = add(ctx, addCmd, updater, token)
+
+			// release the lock even if ctx is done, so others do not have
+			// to wait for the lease to expire
+			if unlockErr := unlock(context.WithoutCancel(ctx)); unlockErr != nil && err == nil {
+				err = fmt.Errorf("unlocking: %w", unlockErr)
+			}
+
+			return nil, err
+		}
+	}
+}
+
+// transactions returns a middleware that runs the commands with the
+// EnableTransactions option inside a store transaction, retrying them up to
+// retries times on transient failures.
+func transactions(store Store, retries uint) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Command) (any, error) {
+			if !areTransactionsEnabled(cmd.Options()...) {
+				return next(ctx, cmd)
+			}
+
+			var result any
+
+			err := store.WithTransaction(ctx, func(ctx context.Context) error {
+				var err error
+				result, err = next(ctx, cmd)
+				return err
+			}, retries)
+
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package application_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// Tests the middlewares added with WithMiddleware wrap every command, in
+// order.
+func TestWithMiddleware(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN two middlewares that record the commands they see
+	var calls []string
+
+	record := func(name string) application.Middleware {
+		return func(next application.Handler) application.Handler {
+			return func(ctx context.Context, cmd application.Command) (any, error) {
+				calls = append(calls, name+" before "+cmd.UseCase())
+				result, err := next(ctx, cmd)
+				calls = append(calls, name+" after "+cmd.UseCase())
+
+				return result, err
+			}
+		}
+	}
+
+	// GIVEN an app with the middlewares
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	app := application.New(NewMockUuider(ctrl), store,
+		application.WithMiddleware(record("first"), record("second")))
+
+	// GIVEN a store with a group
+	group := domain.NewGroup("group_id", "owner_id", now)
+	store.EXPECT().
+		Load(gomock.Any(), "group_id").
+		Return(group, nil)
+
+	// WHEN we get the group
+	got, err := app.GetGroup(context.Background(), "group_id")
+	require.NoError(t, err)
+	require.Equal(t, group, got)
+
+	// THEN the middlewares have seen the command, the first one being the
+	// outermost
+	want := []string{
+		"first before GetGroup",
+		"second before GetGroup",
+		"second after GetGroup",
+		"first after GetGroup",
+	}
+	require.Equal(t, want, calls)
+}
+
+// Tests a middleware can stop a command from reaching its handler.
+func TestWithMiddleware_ShortCircuit(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a middleware that rejects adding users to groups
+	errForbidden := errors.New("forbidden")
+
+	forbid := func(next application.Handler) application.Handler {
+		return func(ctx context.Context, cmd application.Command) (any, error) {
+			if _, ok := cmd.(application.AddUserToGroupCommand); ok {
+				return nil, errForbidden
+			}
+
+			return next(ctx, cmd)
+		}
+	}
+
+	// GIVEN an app with the middleware and a store expecting no calls
+	ctrl := gomock.NewController(t)
+	app := application.New(NewMockUuider(ctrl), NewMockStore(ctrl),
+		application.WithMiddleware(forbid))
+
+	// WHEN we add a user to a group
+	err := app.AddUserToGroup(context.Background(), "user_id", "group_id")
+
+	// THEN we get the error from the middleware
+	require.ErrorIs(t, err, errForbidden)
+}
+
+// Tests the commands with the EnableTransactions option run inside a store
+// transaction.
+func TestDispatch_EnableTransactions(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	app := application.New(NewMockUuider(ctrl), store)
+
+	// GIVEN-THEN a store expecting a transaction that loads and updates the
+	// group
+	group := domain.NewGroup("group_id", "owner_id", now)
+
+	store.EXPECT().
+		WithTransaction(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, callback func(context.Context) error, _ uint) error {
+			store.EXPECT().Load(gomock.Any(), "group_id").Return(group, nil)
+			store.EXPECT().Update(gomock.Any(), group).Return(nil)
+
+			return callback(ctx)
+		})
+
+	// WHEN we dispatch a command with the option
+	_, err := app.Dispatch(context.Background(), application.AddUserToGroupCommand{
+		UserID:      "user_id",
+		GroupID:     "group_id",
+		CallOptions: []application.Option{application.EnableTransactions{}},
+	})
+
+	// THEN the user is added in the transaction (see the GIVEN-THEN above)
+	require.NoError(t, err)
+	require.True(t, group.HasMember("user_id"))
+}
+
+type unknownCommand struct{}
+
+func (unknownCommand) UseCase() string               { return "Unknown" }
+func (unknownCommand) Attrs() []slog.Attr            { return nil }
+func (unknownCommand) Options() []application.Option { return nil }
+
+// Tests dispatching a command without a handler fails.
+func TestDispatch_UnknownCommand(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app
+	ctrl := gomock.NewController(t)
+	app := application.New(NewMockUuider(ctrl), NewMockStore(ctrl))
+
+	// WHEN we dispatch a command it does not know about
+	_, err := app.Dispatch(context.Background(), unknownCommand{})
+
+	// THEN we get an error
+	require.ErrorContains(t, err, "unknown command")
+}
+
+// atomicStore is a Store that also implements MemberAdder.
+type atomicStore struct {
+	*MockStore
+	*MockMemberAdder
+}
+
+// Tests users are added to groups atomically, without transactions, with the
+// EnableAtomicUpdates option, when the store supports it.
+func TestAddUserToGroup_AtomicUpdates(t *testing.T) {
+	t.Parallel()
+
+	for name, options := range map[string][]application.Option{
+		"atomic updates": {
+			application.EnableAtomicUpdates{},
+		},
+		"atomic updates and transactions": {
+			application.EnableAtomicUpdates{},
+			application.EnableTransactions{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN-THEN a store expecting an atomic add and no transaction
+			ctrl := gomock.NewController(t)
+			store := atomicStore{NewMockStore(ctrl), NewMockMemberAdder(ctrl)}
+
+			store.MockMemberAdder.EXPECT().
+				AddMember(gomock.Any(), "group_id", "user_id", now).
+				Return(fmt.Errorf("adding: %w", domain.ErrGroupFull))
+
+			app := application.New(NewMockUuider(ctrl), store, application.WithClock(testhelp.NewClock(now)))
+
+			// WHEN we add a user to a group
+			err := app.AddUserToGroup(context.Background(), "user_id", "group_id", options...)
+
+			// THEN we get the error from the store
+			require.ErrorIs(t, err, domain.ErrGroupFull)
+		})
+	}
+
+	for name, options := range map[string][]application.Option{
+		"transactions": {
+			application.EnableTransactions{},
+		},
+		"delay before updating": {
+			application.EnableTransactions{},
+			application.EnableAtomicUpdates{},
+			application.DelayBeforeUpdating(0),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN-THEN a store expecting a transaction and no atomic add
+			ctrl := gomock.NewController(t)
+			store := atomicStore{NewMockStore(ctrl), NewMockMemberAdder(ctrl)}
+
+			store.MockStore.EXPECT().
+				WithTransaction(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil)
+
+			app := application.New(NewMockUuider(ctrl), store)
+
+			// WHEN we add a user to a group
+			err := app.AddUserToGroup(context.Background(), "user_id", "group_id", options...)
+
+			// THEN the transaction is used (see the GIVEN-THEN above)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("with audit log", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN-THEN a store expecting no calls
+		ctrl := gomock.NewController(t)
+		store := atomicStore{NewMockStore(ctrl), NewMockMemberAdder(ctrl)}
+
+		// GIVEN an app with an audit log
+		app := application.New(NewMockUuider(ctrl), store, application.WithAuditLog(NewMockAuditLog(ctrl)))
+
+		// WHEN we add a user to a group atomically
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableAtomicUpdates{})
+
+		// THEN it fails, instead of ignoring the option
+		require.ErrorIs(t, err, domain.ErrFailedPrecondition)
+		require.ErrorContains(t, err, "atomic updates are not available with the audit log")
+	})
+
+	t.Run("without options", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN-THEN a store expecting a load and an update
+		ctrl := gomock.NewController(t)
+		store := atomicStore{NewMockStore(ctrl), NewMockMemberAdder(ctrl)}
+
+		store.MockStore.EXPECT().
+			Load(gomock.Any(), "group_id").
+			Return(domain.NewGroup("group_id", "owner_id", now), nil)
+		store.MockStore.EXPECT().
+			Update(gomock.Any(), gomock.Any()).
+			Return(nil)
+
+		app := application.New(NewMockUuider(ctrl), store)
+
+		// WHEN we add a user to a group without options
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id")
+
+		// THEN the load and update path is used (see the GIVEN-THEN above)
+		require.NoError(t, err)
+	})
+}
+
+// fencedStore is a Store that also implements FencedUpdater.
+type fencedStore struct {
+	*MockStore
+	*MockFencedUpdater
+}
+
+// Tests users are added to groups while holding their lock, with the
+// EnableLocking option, instead of in a transaction.
+func TestAddUserToGroup_Locking(t *testing.T) {
+	t.Parallel()
+
+	const token = int64(7)
+
+	// lockedOnce returns a locker expecting to lock the group once and to be
+	// unlocked afterwards.
+	lockedOnce := func(ctrl *gomock.Controller) (*MockLocker, *bool) {
+		unlocked := false
+		locker := NewMockLocker(ctrl)
+		locker.EXPECT().
+			Lock(gomock.Any(), "group_id").
+			Return(token, func(context.Context) error {
+				unlocked = true
+				return nil
+			}, nil)
+
+		return locker, &unlocked
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN-THEN a store expecting a fenced load and update with the
+		// token of the lease, and no transaction
+		ctrl := gomock.NewController(t)
+		store := fencedStore{NewMockStore(ctrl), NewMockFencedUpdater(ctrl)}
+
+		store.MockFencedUpdater.EXPECT().
+			LoadFenced(gomock.Any(), "group_id", token).
+			Return(domain.NewGroup("group_id", "owner_id", now), nil)
+		store.MockFencedUpdater.EXPECT().
+			UpdateFenced(gomock.Any(), gomock.Any(), token).
+			DoAndReturn(func(_ context.Context, got *domain.Group, _ int64) error {
+				require.Equal(t, []string{"owner_id", "user_id"}, got.Members())
+				return nil
+			})
+
+		locker, unlocked := lockedOnce(ctrl)
+		app := application.New(NewMockUuider(ctrl), store, application.WithLocker(locker))
+
+		// WHEN we add a user to a group with locks and transactions
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id",
+			application.EnableLocking{}, application.EnableTransactions{})
+
+		// THEN it succeeds and the lock is released
+		require.NoError(t, err)
+		require.True(t, *unlocked)
+	})
+
+	t.Run("expired lease", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store where the group has been written by a later lease
+		ctrl := gomock.NewController(t)
+		store := fencedStore{NewMockStore(ctrl), NewMockFencedUpdater(ctrl)}
+
+		store.MockFencedUpdater.EXPECT().
+			LoadFenced(gomock.Any(), "group_id", token).
+			Return(domain.NewGroup("group_id", "owner_id", now), nil)
+		store.MockFencedUpdater.EXPECT().
+			UpdateFenced(gomock.Any(), gomock.Any(), token).
+			Return(domain.NewError(domain.ErrConflict, "group_id", nil))
+
+		locker, unlocked := lockedOnce(ctrl)
+		app := application.New(NewMockUuider(ctrl), store, application.WithLocker(locker))
+
+		// WHEN we add a user to the group with locks
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableLocking{})
+
+		// THEN we get a retryable domain.ErrConflict and the lock is
+		// released
+		require.ErrorIs(t, err, domain.ErrConflict)
+		require.True(t, domain.IsRetryable(err))
+		require.True(t, *unlocked)
+	})
+
+	t.Run("fenced by a later lease", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store where the group has been loaded by a later lease
+		ctrl := gomock.NewController(t)
+		store := fencedStore{NewMockStore(ctrl), NewMockFencedUpdater(ctrl)}
+
+		store.MockFencedUpdater.EXPECT().
+			LoadFenced(gomock.Any(), "group_id", token).
+			Return(nil, domain.NewError(domain.ErrConflict, "group_id", nil))
+
+		locker, unlocked := lockedOnce(ctrl)
+		app := application.New(NewMockUuider(ctrl), store, application.WithLocker(locker))
+
+		// WHEN we add a user to the group with locks
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableLocking{})
+
+		// THEN we get domain.ErrConflict, without updating the group, and
+		// the lock is released
+		require.ErrorIs(t, err, domain.ErrConflict)
+		require.True(t, *unlocked)
+	})
+
+	t.Run("lock not acquired", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a locker that gives up waiting for the lock, and a store
+		// that fails the test if used
+		ctrl := gomock.NewController(t)
+		store := fencedStore{NewMockStore(ctrl), NewMockFencedUpdater(ctrl)}
+
+		locker := NewMockLocker(ctrl)
+		locker.EXPECT().
+			Lock(gomock.Any(), "group_id").
+			Return(int64(0), nil, context.DeadlineExceeded)
+
+		app := application.New(NewMockUuider(ctrl), store, application.WithLocker(locker))
+
+		// WHEN we add a user to the group with locks
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableLocking{})
+
+		// THEN we get the error from the locker
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("without locker", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN an app without a locker, and a store that fails the test if
+		// used
+		ctrl := gomock.NewController(t)
+		store := fencedStore{NewMockStore(ctrl), NewMockFencedUpdater(ctrl)}
+		app := application.New(NewMockUuider(ctrl), store)
+
+		// WHEN we add a user to a group with locks
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableLocking{})
+
+		// THEN we get an error
+		require.ErrorIs(t, err, domain.ErrFailedPrecondition)
+		require.ErrorContains(t, err, "locking is not enabled")
+	})
+
+	t.Run("without fenced updates", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a store that does not support fenced updates, and fails
+		// the test if used
+		fix := newFixture(t)
+		app := application.New(fix.uuider, fix.store, application.WithLocker(NewMockLocker(gomock.NewController(t))))
+
+		// WHEN we add a user to a group with locks
+		err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableLocking{})
+
+		// THEN we get an error
+		require.ErrorIs(t, err, domain.ErrFailedPrecondition)
+		require.ErrorContains(t, err, "does not support fenced updates")
+	})
+}
+
+// Tests the number of transaction retries can be configured.
+func TestWithTransactionRetries(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app with 3 transaction retries
+	ctrl := gomock.NewController(t)
+	store := NewMockStore(ctrl)
+	app := application.New(NewMockUuider(ctrl), store, application.WithTransactionRetries(3))
+
+	// GIVEN-THEN a store expecting a transaction with 3 retries
+	store.EXPECT().
+		WithTransaction(gomock.Any(), gomock.Any(), uint(3)).
+		Return(nil)
+
+	// WHEN we add a user to a group with transactions
+	err := app.AddUserToGroup(context.Background(), "user_id", "group_id", application.EnableTransactions{})
+
+	// THEN the configured retries are used (see the GIVEN-THEN above)
+	require.NoError(t, err)
+}
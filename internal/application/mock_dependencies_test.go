@@ -12,6 +12,7 @@ package application_test
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	domain "github.com/alcortesm/demo-mongodb-transactions/internal/domain"
 	gomock "go.uber.org/mock/gomock"
@@ -97,6 +98,223 @@ func (mr *MockStoreMockRecorder) WithTransaction(ctx, callback, retries any) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTransaction", reflect.TypeOf((*MockStore)(nil).WithTransaction), ctx, callback, retries)
 }
 
+// MockMemberAdder is a mock of MemberAdder interface.
+type MockMemberAdder struct {
+	ctrl     *gomock.Controller
+	recorder *MockMemberAdderMockRecorder
+}
+
+// MockMemberAdderMockRecorder is the mock recorder for MockMemberAdder.
+type MockMemberAdderMockRecorder struct {
+	mock *MockMemberAdder
+}
+
+// NewMockMemberAdder creates a new mock instance.
+func NewMockMemberAdder(ctrl *gomock.Controller) *MockMemberAdder {
+	mock := &MockMemberAdder{ctrl: ctrl}
+	mock.recorder = &MockMemberAdderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMemberAdder) EXPECT() *MockMemberAdderMockRecorder {
+	return m.recorder
+}
+
+// AddMember mocks base method.
+func (m *MockMemberAdder) AddMember(ctx context.Context, groupID, userID string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddMember", ctx, groupID, userID, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddMember indicates an expected call of AddMember.
+func (mr *MockMemberAdderMockRecorder) AddMember(ctx, groupID, userID, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMember", reflect.TypeOf((*MockMemberAdder)(nil).AddMember), ctx, groupID, userID, now)
+}
+
+// MockDetailsUpdater is a mock of DetailsUpdater interface.
+type MockDetailsUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *MockDetailsUpdaterMockRecorder
+}
+
+// MockDetailsUpdaterMockRecorder is the mock recorder for MockDetailsUpdater.
+type MockDetailsUpdaterMockRecorder struct {
+	mock *MockDetailsUpdater
+}
+
+// NewMockDetailsUpdater creates a new mock instance.
+func NewMockDetailsUpdater(ctrl *gomock.Controller) *MockDetailsUpdater {
+	mock := &MockDetailsUpdater{ctrl: ctrl}
+	mock.recorder = &MockDetailsUpdaterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDetailsUpdater) EXPECT() *MockDetailsUpdaterMockRecorder {
+	return m.recorder
+}
+
+// UpdateDetails mocks base method.
+func (m *MockDetailsUpdater) UpdateDetails(ctx context.Context, group *domain.Group, previous domain.Details) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateDetails", ctx, group, previous)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateDetails indicates an expected call of UpdateDetails.
+func (mr *MockDetailsUpdaterMockRecorder) UpdateDetails(ctx, group, previous any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDetails", reflect.TypeOf((*MockDetailsUpdater)(nil).UpdateDetails), ctx, group, previous)
+}
+
+// MockLocker is a mock of Locker interface.
+type MockLocker struct {
+	ctrl     *gomock.Controller
+	recorder *MockLockerMockRecorder
+}
+
+// MockLockerMockRecorder is the mock recorder for MockLocker.
+type MockLockerMockRecorder struct {
+	mock *MockLocker
+}
+
+// NewMockLocker creates a new mock instance.
+func NewMockLocker(ctrl *gomock.Controller) *MockLocker {
+	mock := &MockLocker{ctrl: ctrl}
+	mock.recorder = &MockLockerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLocker) EXPECT() *MockLockerMockRecorder {
+	return m.recorder
+}
+
+// Lock mocks base method.
+func (m *MockLocker) Lock(ctx context.Context, groupID string) (int64, func(context.Context) error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lock", ctx, groupID)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(func(context.Context) error)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Lock indicates an expected call of Lock.
+func (mr *MockLockerMockRecorder) Lock(ctx, groupID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lock", reflect.TypeOf((*MockLocker)(nil).Lock), ctx, groupID)
+}
+
+// MockFencedUpdater is a mock of FencedUpdater interface.
+type MockFencedUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *MockFencedUpdaterMockRecorder
+}
+
+// MockFencedUpdaterMockRecorder is the mock recorder for MockFencedUpdater.
+type MockFencedUpdaterMockRecorder struct {
+	mock *MockFencedUpdater
+}
+
+// NewMockFencedUpdater creates a new mock instance.
+func NewMockFencedUpdater(ctrl *gomock.Controller) *MockFencedUpdater {
+	mock := &MockFencedUpdater{ctrl: ctrl}
+	mock.recorder = &MockFencedUpdaterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFencedUpdater) EXPECT() *MockFencedUpdaterMockRecorder {
+	return m.recorder
+}
+
+// LoadFenced mocks base method.
+func (m *MockFencedUpdater) LoadFenced(ctx context.Context, id string, token int64) (*domain.Group, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadFenced", ctx, id, token)
+	ret0, _ := ret[0].(*domain.Group)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadFenced indicates an expected call of LoadFenced.
+func (mr *MockFencedUpdaterMockRecorder) LoadFenced(ctx, id, token any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadFenced", reflect.TypeOf((*MockFencedUpdater)(nil).LoadFenced), ctx, id, token)
+}
+
+// UpdateFenced mocks base method.
+func (m *MockFencedUpdater) UpdateFenced(ctx context.Context, group *domain.Group, token int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateFenced", ctx, group, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateFenced indicates an expected call of UpdateFenced.
+func (mr *MockFencedUpdaterMockRecorder) UpdateFenced(ctx, group, token any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateFenced", reflect.TypeOf((*MockFencedUpdater)(nil).UpdateFenced), ctx, group, token)
+}
+
+// MockAuditLog is a mock of AuditLog interface.
+type MockAuditLog struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuditLogMockRecorder
+}
+
+// MockAuditLogMockRecorder is the mock recorder for MockAuditLog.
+type MockAuditLogMockRecorder struct {
+	mock *MockAuditLog
+}
+
+// NewMockAuditLog creates a new mock instance.
+func NewMockAuditLog(ctrl *gomock.Controller) *MockAuditLog {
+	mock := &MockAuditLog{ctrl: ctrl}
+	mock.recorder = &MockAuditLogMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuditLog) EXPECT() *MockAuditLogMockRecorder {
+	return m.recorder
+}
+
+// History mocks base method.
+func (m *MockAuditLog) History(ctx context.Context, groupID string, after domain.AuditKey, limit int) ([]domain.AuditEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", ctx, groupID, after, limit)
+	ret0, _ := ret[0].([]domain.AuditEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockAuditLogMockRecorder) History(ctx, groupID, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockAuditLog)(nil).History), ctx, groupID, after, limit)
+}
+
+// Record mocks base method.
+func (m *MockAuditLog) Record(ctx context.Context, entry domain.AuditEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Record", ctx, entry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Record indicates an expected call of Record.
+func (mr *MockAuditLogMockRecorder) Record(ctx, entry any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockAuditLog)(nil).Record), ctx, entry)
+}
+
 // MockUuider is a mock of Uuider interface.
 type MockUuider struct {
 	ctrl     *gomock.Controller
@@ -133,3 +351,75 @@ func (mr *MockUuiderMockRecorder) NewString() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewString", reflect.TypeOf((*MockUuider)(nil).NewString))
 }
+
+// MockClock is a mock of Clock interface.
+type MockClock struct {
+	ctrl     *gomock.Controller
+	recorder *MockClockMockRecorder
+}
+
+// MockClockMockRecorder is the mock recorder for MockClock.
+type MockClockMockRecorder struct {
+	mock *MockClock
+}
+
+// NewMockClock creates a new mock instance.
+func NewMockClock(ctrl *gomock.Controller) *MockClock {
+	mock := &MockClock{ctrl: ctrl}
+	mock.recorder = &MockClockMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClock) EXPECT() *MockClockMockRecorder {
+	return m.recorder
+}
+
+// Now mocks base method.
+func (m *MockClock) Now() time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Now")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+// Now indicates an expected call of Now.
+func (mr *MockClockMockRecorder) Now() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Now", reflect.TypeOf((*MockClock)(nil).Now))
+}
+
+// MockMetrics is a mock of Metrics interface.
+type MockMetrics struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsMockRecorder
+}
+
+// MockMetricsMockRecorder is the mock recorder for MockMetrics.
+type MockMetricsMockRecorder struct {
+	mock *MockMetrics
+}
+
+// NewMockMetrics creates a new mock instance.
+func NewMockMetrics(ctrl *gomock.Controller) *MockMetrics {
+	mock := &MockMetrics{ctrl: ctrl}
+	mock.recorder = &MockMetricsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetrics) EXPECT() *MockMetricsMockRecorder {
+	return m.recorder
+}
+
+// UseCaseFinished mocks base method.
+func (m *MockMetrics) UseCaseFinished(useCase string, err error, elapsed time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UseCaseFinished", useCase, err, elapsed)
+}
+
+// UseCaseFinished indicates an expected call of UseCaseFinished.
+func (mr *MockMetricsMockRecorder) UseCaseFinished(useCase, err, elapsed any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseCaseFinished", reflect.TypeOf((*MockMetrics)(nil).UseCaseFinished), useCase, err, elapsed)
+}
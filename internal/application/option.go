@@ -1,7 +1,132 @@
 package application
 
-import "time"
+import (
+	"log/slog"
+	"time"
 
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AppOption configures an App when it is created.
+type AppOption func(*App)
+
+// WithLogger sets the logger used by the App. The attributes of each
+// request, like the group and user ids, are carried through the context and
+// added to every record.
+//
+// By default, the App does not log anything.
+func WithLogger(l *slog.Logger) AppOption {
+	return func(a *App) {
+		a.logger = logging.NewLogger(l)
+	}
+}
+
+// WithMetrics sets where the App reports the outcome of its use cases.
+//
+// By default, no metrics are reported.
+func WithMetrics(m Metrics) AppOption {
+	return func(a *App) {
+		a.metrics = m
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer used to create a span
+// for each use case.
+//
+// By default, no spans are created.
+func WithTracerProvider(tp trace.TracerProvider) AppOption {
+	return func(a *App) {
+		a.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithTransactionRetries sets how many times the use cases run with the
+// EnableTransactions option attempt their transaction before giving up with
+// domain.ErrTooManyTransactionRetries.
+//
+// It defaults to 10.
+func WithTransactionRetries(n uint) AppOption {
+	return func(a *App) {
+		a.retries = n
+	}
+}
+
+// WithClock sets the clock used to timestamp the groups, their members and
+// the audit trail.
+//
+// By default, the system clock is used.
+func WithClock(c Clock) AppOption {
+	return func(a *App) {
+		a.clock = c
+	}
+}
+
+// WithAuditLog makes the App record every change in the members of the
+// groups in the audit log: the creation of the groups and the users added to
+// them.
+//
+// The entries are recorded in the same transaction as the change for the
+// calls with the EnableTransactions option, so both are stored or none is.
+// Without it, the entry is recorded right after the change, and it is lost
+// if recording it fails: the error is logged, but the call succeeds, as the
+// change has been made.
+//
+// As a consequence, the calls with the EnableAtomicUpdates option fail, as
+// MemberAdder.AddMember cannot record the entry in the same write.
+//
+// By default, no audit trail is recorded.
+func WithAuditLog(l AuditLog) AppOption {
+	return func(a *App) {
+		a.audit = l
+	}
+}
+
+// WithLocker sets the distributed lock used by the calls with the
+// EnableLocking option.
+//
+// By default, there is none, and those calls fail.
+func WithLocker(l Locker) AppOption {
+	return func(a *App) {
+		a.locker = l
+	}
+}
+
+// WithCoalescing makes the App add the users to a group together, in a
+// single transaction, when the calls arrive while another transaction on the
+// same group is in progress, instead of having them fight each other and
+// retry. Each call still gets its own result, like domain.ErrGroupFull when
+// there is no room for its user.
+//
+// It only applies to the AddUserToGroup calls with the EnableTransactions
+// option and without the EnableAtomicUpdates or DelayBeforeUpdating options.
+// The calls to the same group run one transaction at a time, in the order
+// they arrive, within this App, and each user joins the group when its call
+// arrived. The transactions do not run with the context of any of the calls,
+// they are only logged with the group, and they are cancelled once all of
+// their calls are.
+//
+// By default, each call runs in its own transaction.
+func WithCoalescing() AppOption {
+	return func(a *App) {
+		a.coalesce = true
+	}
+}
+
+// WithMiddleware adds middlewares to the App, which wrap every command
+// dispatched to it, in the given order, the first one being the outermost.
+//
+// They run inside the logging, metrics, tracing and argument validation of
+// the App, and outside the transaction of the commands with the
+// EnableTransactions option.
+func WithMiddleware(m ...Middleware) AppOption {
+	return func(a *App) {
+		a.middlewares = append(a.middlewares, m...)
+	}
+}
+
+// Option is a per-call option of a use case. Options are carried by the
+// commands and acted upon by the middlewares and handlers of the App.
 type Option interface {
 	option()
 }
@@ -9,6 +134,9 @@ type Option interface {
 // DelayBeforeUpdating introduces an artificial delay before calling
 // Store.Update, which can be used to improve the chance concurrent application
 // calls will race against each other during testing.
+//
+// The call fails with the error of the context if it is done during the
+// delay.
 type DelayBeforeUpdating time.Duration
 
 func (DelayBeforeUpdating) option() {}
@@ -36,3 +164,134 @@ func areTransactionsEnabled(options ...Option) bool {
 
 	return false
 }
+
+// runsInTransaction returns if a command with the given options runs inside
+// a store transaction: with EnableTransactions, unless EnableLocking takes
+// precedence.
+func runsInTransaction(options ...Option) bool {
+	return areTransactionsEnabled(options...) && !isLockingEnabled(options...)
+}
+
+// EnableLocking makes AddUserToGroup load and update the group while holding
+// its lock, see WithLocker, instead of in a transaction. The group is loaded
+// and stored with the fencing token of the lease, see FencedUpdater, so if
+// the lease expires before the group is stored, and the next holder has
+// loaded it, the update fails with domain.ErrConflict instead of overwriting
+// the changes of the next holder.
+//
+// It takes precedence over EnableTransactions. The groups must only be
+// modified with locks, as the other writes ignore them.
+type EnableLocking struct{}
+
+func (EnableLocking) option() {}
+
+func isLockingEnabled(options ...Option) bool {
+	for _, o := range options {
+		if _, ok := o.(EnableLocking); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnableAtomicUpdates makes AddUserToGroup add the user with
+// MemberAdder.AddMember, in a single atomic write, instead of loading and
+// updating the group, when the Store implements MemberAdder. Otherwise, it is
+// ignored, so use it together with EnableTransactions to keep the group
+// invariants either way.
+//
+// The calls with it fail if there is an audit log, see WithAuditLog.
+//
+// It takes precedence over EnableTransactions, and it is ignored with
+// DelayBeforeUpdating, which only makes sense when loading and updating.
+type EnableAtomicUpdates struct{}
+
+func (EnableAtomicUpdates) option() {}
+
+// canUseAtomicUpdates returns if the options ask for an atomic update, see
+// EnableAtomicUpdates.
+func canUseAtomicUpdates(options ...Option) bool {
+	enabled := false
+
+	for _, o := range options {
+		switch o.(type) {
+		case EnableAtomicUpdates:
+			enabled = true
+		case DelayBeforeUpdating:
+			return false
+		}
+	}
+
+	return enabled
+}
+
+// canCoalesce returns if the options allow running the call together with
+// others, see WithCoalescing.
+func canCoalesce(options ...Option) bool {
+	if !areTransactionsEnabled(options...) || canUseAtomicUpdates(options...) {
+		return false
+	}
+
+	_, delayed := mustDelayBeforeUpdating(options...)
+
+	return !delayed
+}
+
+// UseGroupID makes CreateGroup use the given id for the new group, instead of
+// a new UUID from the Uuider. Callers can use it to make retries of the same
+// creation idempotent, or to derive the id from a natural key.
+//
+// If a group with that id already exists, CreateGroup fails with
+// domain.ErrAlreadyExists, unless CreateIfAbsent is also used.
+type UseGroupID string
+
+func (UseGroupID) option() {}
+
+// CreateIfAbsent makes CreateGroup succeed without creating anything if the
+// group already exists with the same owner, returning its id. It fails with
+// domain.ErrAlreadyExists if it exists with a different owner.
+//
+// It is safe when many callers race to create the same group: one of them
+// creates it and the rest find it.
+type CreateIfAbsent struct{}
+
+func (CreateIfAbsent) option() {}
+
+// groupIDOption returns the group id set by UseGroupID, if any.
+func groupIDOption(options ...Option) (string, bool) {
+	for _, o := range options {
+		if id, ok := o.(UseGroupID); ok {
+			return string(id), true
+		}
+	}
+
+	return "", false
+}
+
+func isCreateIfAbsent(options ...Option) bool {
+	for _, o := range options {
+		if _, ok := o.(CreateIfAbsent); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Actor identifies who makes a call, like the id of the authenticated user,
+// for the audit trail, see WithAuditLog.
+type Actor string
+
+func (Actor) option() {}
+
+// actorOption returns the actor set by the Actor option, or an empty string.
+func actorOption(options ...Option) string {
+	for _, o := range options {
+		if actor, ok := o.(Actor); ok {
+			return string(actor)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,264 @@
+// Package bootstrap builds a ready to use application from a configuration:
+// it connects to MongoDB, checks the connection, prepares the group and
+// audit collections and wires the application with its dependencies.
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/google/uuid"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Conn is a checked connection to the MongoDB database of the configuration.
+type Conn struct {
+	Client *mongodriver.Client
+	DB     *mongodriver.Database
+	// Groups is the group collection.
+	Groups *mongodriver.Collection
+	// Audit is the audit collection, nil if the audit trail is disabled.
+	Audit *mongodriver.Collection
+	// Locks is the collection with the locks of the groups, nil if locking
+	// is disabled.
+	Locks *mongodriver.Collection
+}
+
+// Connect connects to MongoDB and pings it, giving up after the connect
+// timeout of the configuration.
+//
+// The extra client options, like a command monitor, are applied after the
+// ones from the configuration.
+func Connect(ctx context.Context, cfg config.Mongo, extra ...*options.ClientOptions) (*Conn, error) {
+	clientOpts, err := clientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ConnectTimeout))
+	defer cancel()
+
+	client, err := mongodriver.Connect(ctx, append([]*options.ClientOptions{clientOpts}, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to MongoDB: %v", err)
+	}
+
+	db := client.Database(cfg.Database)
+
+	conn := &Conn{
+		Client: client,
+		DB:     db,
+		Groups: db.Collection(cfg.Collection),
+	}
+
+	if cfg.AuditCollection != "" {
+		conn.Audit = db.Collection(cfg.AuditCollection)
+	}
+
+	if cfg.LockCollection != "" {
+		conn.Locks = db.Collection(cfg.LockCollection)
+	}
+
+	if err := conn.Ping(ctx); err != nil {
+		_ = client.Disconnect(context.WithoutCancel(ctx))
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Ping checks MongoDB can be reached.
+func (c *Conn) Ping(ctx context.Context) error {
+	if err := c.Client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("pinging MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// Close disconnects from MongoDB, waiting for the operations in progress
+// until ctx is done.
+func (c *Conn) Close(ctx context.Context) error {
+	if err := c.Client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("disconnecting from MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+func clientOptions(cfg config.Mongo) (*options.ClientOptions, error) {
+	opts := options.Client().
+		ApplyURI(cfg.URI).
+		SetConnectTimeout(time.Duration(cfg.ConnectTimeout)).
+		SetServerSelectionTimeout(time.Duration(cfg.ServerSelectionTimeout))
+
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if cfg.TLS.CAFile != "" {
+			pem, err := os.ReadFile(cfg.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading TLS CA file: %v", err)
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in TLS CA file %s", cfg.TLS.CAFile)
+			}
+
+			tlsConfig.RootCAs = pool
+		}
+
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
+
+// Service is an application ready to be used, with the resources it needs.
+type Service struct {
+	App  *application.App
+	Repo *mongo.GroupRepo
+	// Audit is the audit log of the application, nil if disabled.
+	Audit *mongo.AuditLog
+	Conn  *Conn
+}
+
+// Option configures how New builds the Service.
+type Option func(*settings)
+
+type settings struct {
+	uuider        application.Uuider
+	appOptions    []application.AppOption
+	repoOptions   []mongo.Option
+	clientOptions []*options.ClientOptions
+}
+
+// WithUuider sets the uuider of the application, it defaults to random V4
+// UUIDs.
+func WithUuider(u application.Uuider) Option {
+	return func(s *settings) {
+		s.uuider = u
+	}
+}
+
+// WithAppOptions adds options to the application, they are applied after
+// the ones from the configuration.
+func WithAppOptions(opts ...application.AppOption) Option {
+	return func(s *settings) {
+		s.appOptions = append(s.appOptions, opts...)
+	}
+}
+
+// WithRepoOptions adds options to the group repository.
+func WithRepoOptions(opts ...mongo.Option) Option {
+	return func(s *settings) {
+		s.repoOptions = append(s.repoOptions, opts...)
+	}
+}
+
+// WithClientOptions adds options to the MongoDB client, see Connect.
+func WithClientOptions(opts ...*options.ClientOptions) Option {
+	return func(s *settings) {
+		s.clientOptions = append(s.clientOptions, opts...)
+	}
+}
+
+// New connects to MongoDB, prepares the group collection, by installing its
+// schema and migrating its documents to the current schema version, and the
+// audit collection, if configured, and returns the application using them.
+//
+// Call Close to release the connection.
+func New(ctx context.Context, cfg config.Config, opts ...Option) (*Service, error) {
+	s := &settings{uuider: uuider{}}
+	for _, o := range opts {
+		o(s)
+	}
+
+	conn, err := Connect(ctx, cfg.Mongo, s.clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := prepare(ctx, conn, cfg.Mongo); err != nil {
+		return nil, errors.Join(err, conn.Close(context.WithoutCancel(ctx)))
+	}
+
+	repo := mongo.NewGroupRepo(conn.Groups, s.repoOptions...)
+
+	appOptions := []application.AppOption{
+		application.WithTransactionRetries(cfg.Transactions.MaxRetries),
+	}
+
+	if cfg.Transactions.Coalesce {
+		appOptions = append(appOptions, application.WithCoalescing())
+	}
+
+	var audit *mongo.AuditLog
+	if conn.Audit != nil {
+		audit = mongo.NewAuditLog(conn.Audit)
+		appOptions = append(appOptions, application.WithAuditLog(audit))
+	}
+
+	if conn.Locks != nil {
+		appOptions = append(appOptions, application.WithLocker(mongo.NewLeaseLocker(conn.Locks)))
+	}
+
+	appOptions = append(appOptions, s.appOptions...)
+
+	return &Service{
+		App:   application.New(s.uuider, repo, appOptions...),
+		Repo:  repo,
+		Audit: audit,
+		Conn:  conn,
+	}, nil
+}
+
+// prepare installs the schema of the group collection, migrates its
+// documents and creates the indexes of the audit collection, giving up after
+// the connect timeout.
+func prepare(ctx context.Context, conn *Conn, cfg config.Mongo) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ConnectTimeout))
+	defer cancel()
+
+	if err := mongo.EnsureGroupSchema(ctx, conn.DB, cfg.Collection); err != nil {
+		return fmt.Errorf("installing the group schema: %v", err)
+	}
+
+	if _, err := mongo.Migrate(ctx, conn.DB, cfg.Collection); err != nil {
+		return fmt.Errorf("migrating the group collection: %v", err)
+	}
+
+	if cfg.AuditCollection != "" {
+		if err := mongo.EnsureAuditIndexes(ctx, conn.DB, cfg.AuditCollection); err != nil {
+			return fmt.Errorf("preparing the audit collection: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Close releases the resources of the service, waiting for the operations in
+// progress until ctx is done.
+func (s *Service) Close(ctx context.Context) error {
+	return s.Conn.Close(ctx)
+}
+
+type uuider struct{}
+
+func (uuider) NewString() string {
+	return uuid.NewString()
+}
@@ -0,0 +1,71 @@
+package bootstrap_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/bootstrap"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableConfig returns a configuration pointing to a port where no
+// MongoDB is listening, with short timeouts.
+func unreachableConfig() config.Config {
+	cfg := config.Default()
+	cfg.Mongo.URI = "mongodb://127.0.0.1:1/?directConnection=true"
+	cfg.Mongo.Database = "db"
+	cfg.Mongo.ConnectTimeout = config.Duration(time.Second)
+	cfg.Mongo.ServerSelectionTimeout = config.Duration(100 * time.Millisecond)
+
+	return cfg
+}
+
+// Tests the connection is checked before returning the service, the
+// bootstrap of a working MongoDB is covered by the e2e tests.
+func TestNew_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a configuration pointing to a port without MongoDB
+	cfg := unreachableConfig()
+
+	// WHEN we build the service
+	_, err := bootstrap.New(context.Background(), cfg)
+
+	// THEN it fails when pinging MongoDB
+	require.ErrorContains(t, err, "pinging MongoDB")
+}
+
+func TestNew_TLSCAFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := unreachableConfig()
+		cfg.Mongo.TLS = config.TLS{
+			Enabled: true,
+			CAFile:  filepath.Join(t.TempDir(), "missing.pem"),
+		}
+
+		_, err := bootstrap.New(context.Background(), cfg)
+		require.ErrorContains(t, err, "reading TLS CA file")
+	})
+
+	t.Run("without certificates", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		err := os.WriteFile(path, []byte("not a certificate"), 0o600)
+		require.NoError(t, err)
+
+		cfg := unreachableConfig()
+		cfg.Mongo.TLS = config.TLS{Enabled: true, CAFile: path}
+
+		_, err = bootstrap.New(context.Background(), cfg)
+		require.ErrorContains(t, err, "no certificates found")
+	})
+}
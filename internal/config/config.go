@@ -0,0 +1,261 @@
+// Package config loads the configuration of the programs in this module
+// from an optional JSON file and from environment variables, the later
+// taking precedence.
+//
+// An example file with all the fields:
+//
+//	{
+//	  "mongo": {
+//	    "uri": "mongodb://localhost:27017",
+//	    "database": "groups",
+//	    "collection": "group",
+//	    "audit_collection": "group_audit",
+//	    "lock_collection": "group_locks",
+//	    "connect_timeout": "10s",
+//	    "server_selection_timeout": "5s",
+//	    "max_pool_size": 100,
+//	    "tls": {
+//	      "enabled": true,
+//	      "ca_file": "/etc/ssl/mongo-ca.pem"
+//	    }
+//	  },
+//	  "transactions": {
+//	    "max_retries": 10,
+//	    "coalesce": false
+//	  }
+//	}
+//
+// Each field can be overridden by an environment variable, see the
+// documentation of each field.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config is the configuration of a program.
+type Config struct {
+	Mongo        Mongo        `json:"mongo"`
+	Transactions Transactions `json:"transactions"`
+}
+
+// Mongo configures the connection to MongoDB.
+type Mongo struct {
+	// URI is the connection string, GROUPS_MONGO_URI.
+	URI string `json:"uri"`
+	// Database is the name of the database, GROUPS_MONGO_DATABASE. It is
+	// required.
+	Database string `json:"database"`
+	// Collection is the name of the group collection,
+	// GROUPS_MONGO_COLLECTION.
+	Collection string `json:"collection"`
+	// AuditCollection is the name of the collection with the audit trail
+	// of the groups, GROUPS_MONGO_AUDIT_COLLECTION. The audit trail is not
+	// recorded if it is empty, the default, as recording it makes the calls
+	// with the application.EnableAtomicUpdates option fail.
+	AuditCollection string `json:"audit_collection"`
+	// LockCollection is the name of the collection with the locks of the
+	// groups, GROUPS_MONGO_LOCK_COLLECTION. The locks are used by the calls
+	// with the application.EnableLocking option, which fail if it is empty.
+	LockCollection string `json:"lock_collection"`
+	// ConnectTimeout bounds connecting, checking the connection and
+	// bootstrapping the collection, GROUPS_MONGO_CONNECT_TIMEOUT.
+	ConnectTimeout Duration `json:"connect_timeout"`
+	// ServerSelectionTimeout bounds how long an operation waits for a
+	// suitable server, GROUPS_MONGO_SERVER_SELECTION_TIMEOUT.
+	ServerSelectionTimeout Duration `json:"server_selection_timeout"`
+	// MaxPoolSize is the maximum number of connections per server, 0 means
+	// the driver default, GROUPS_MONGO_MAX_POOL_SIZE.
+	MaxPoolSize uint64 `json:"max_pool_size"`
+	TLS         TLS    `json:"tls"`
+}
+
+// TLS configures the encryption of the connection to MongoDB.
+type TLS struct {
+	// Enabled turns TLS on, GROUPS_MONGO_TLS_ENABLED.
+	Enabled bool `json:"enabled"`
+	// CAFile is a PEM file with the certificate authorities to trust,
+	// instead of the system ones, GROUPS_MONGO_TLS_CA_FILE.
+	CAFile string `json:"ca_file"`
+}
+
+// Transactions configures how the application runs transactions.
+type Transactions struct {
+	// MaxRetries is how many times a transaction is attempted before
+	// giving up, GROUPS_TRANSACTIONS_MAX_RETRIES.
+	MaxRetries uint `json:"max_retries"`
+	// Coalesce runs the concurrent additions of users to the same group
+	// together, in a single transaction, GROUPS_TRANSACTIONS_COALESCE. See
+	// application.WithCoalescing.
+	Coalesce bool `json:"coalesce"`
+}
+
+// Default returns the configuration used for the fields not set in the file
+// or the environment.
+func Default() Config {
+	return Config{
+		Mongo: Mongo{
+			URI:                    "mongodb://localhost:27017",
+			Collection:             "group",
+			LockCollection:         "group_locks",
+			ConnectTimeout:         Duration(10 * time.Second),
+			ServerSelectionTimeout: Duration(5 * time.Second),
+		},
+		Transactions: Transactions{
+			MaxRetries: 10,
+		},
+	}
+}
+
+// Load returns the default configuration, overridden by the JSON file at
+// path, if path is not empty, and by the environment variables.
+func Load(path string) (*Config, error) {
+	return LoadFrom(path, os.LookupEnv)
+}
+
+// LoadFrom is like Load, but it reads the environment variables with
+// lookup.
+func LoadFrom(path string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %v", err)
+		}
+
+		if err := json.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.loadEnv(lookup); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	vars := []struct {
+		name  string
+		parse func(string) error
+	}{
+		{"GROUPS_MONGO_URI", setString(&c.Mongo.URI)},
+		{"GROUPS_MONGO_DATABASE", setString(&c.Mongo.Database)},
+		{"GROUPS_MONGO_COLLECTION", setString(&c.Mongo.Collection)},
+		{"GROUPS_MONGO_AUDIT_COLLECTION", setString(&c.Mongo.AuditCollection)},
+		{"GROUPS_MONGO_LOCK_COLLECTION", setString(&c.Mongo.LockCollection)},
+		{"GROUPS_MONGO_CONNECT_TIMEOUT", c.Mongo.ConnectTimeout.Set},
+		{"GROUPS_MONGO_SERVER_SELECTION_TIMEOUT", c.Mongo.ServerSelectionTimeout.Set},
+		{"GROUPS_MONGO_MAX_POOL_SIZE", func(s string) error {
+			n, err := strconv.ParseUint(s, 10, 64)
+			c.Mongo.MaxPoolSize = n
+
+			return err
+		}},
+		{"GROUPS_MONGO_TLS_ENABLED", func(s string) error {
+			b, err := strconv.ParseBool(s)
+			c.Mongo.TLS.Enabled = b
+
+			return err
+		}},
+		{"GROUPS_MONGO_TLS_CA_FILE", setString(&c.Mongo.TLS.CAFile)},
+		{"GROUPS_TRANSACTIONS_MAX_RETRIES", func(s string) error {
+			n, err := strconv.ParseUint(s, 10, 0)
+			c.Transactions.MaxRetries = uint(n)
+
+			return err
+		}},
+		{"GROUPS_TRANSACTIONS_COALESCE", func(s string) error {
+			b, err := strconv.ParseBool(s)
+			c.Transactions.Coalesce = b
+
+			return err
+		}},
+	}
+
+	for _, v := range vars {
+		value, ok := lookup(v.name)
+		if !ok {
+			continue
+		}
+
+		if err := v.parse(value); err != nil {
+			return fmt.Errorf("parsing %s: %v", v.name, err)
+		}
+	}
+
+	return nil
+}
+
+func setString(dst *string) func(string) error {
+	return func(s string) error {
+		*dst = s
+		return nil
+	}
+}
+
+// Validate returns an error if the configuration cannot be used.
+func (c *Config) Validate() error {
+	switch {
+	case c.Mongo.URI == "":
+		return errors.New("missing mongo uri")
+	case c.Mongo.Database == "":
+		return errors.New("missing mongo database")
+	case c.Mongo.Collection == "":
+		return errors.New("missing mongo collection")
+	case c.Mongo.AuditCollection != "" && c.Mongo.AuditCollection == c.Mongo.Collection:
+		return errors.New("mongo audit collection must be different from the group collection")
+	case c.Mongo.LockCollection != "" &&
+		(c.Mongo.LockCollection == c.Mongo.Collection || c.Mongo.LockCollection == c.Mongo.AuditCollection):
+		return errors.New("mongo lock collection must be different from the group and audit collections")
+	case c.Mongo.ConnectTimeout <= 0:
+		return errors.New("mongo connect timeout must be positive")
+	case c.Mongo.ServerSelectionTimeout <= 0:
+		return errors.New("mongo server selection timeout must be positive")
+	case c.Mongo.TLS.CAFile != "" && !c.Mongo.TLS.Enabled:
+		return errors.New("mongo tls ca file set but tls is disabled")
+	case c.Transactions.MaxRetries == 0:
+		return errors.New("transactions max retries must be at least 1")
+	}
+
+	return nil
+}
+
+// Duration is a time.Duration written as a string in JSON, like "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %v", err)
+	}
+
+	return d.Set(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Set parses s as a time.Duration.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
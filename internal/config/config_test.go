@@ -0,0 +1,199 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// env returns a lookup function for the given environment variables.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+// writeFile writes content to a new file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	require.NoError(t, err)
+
+	return path
+}
+
+// Tests the defaults are used when there is no file and only the required
+// variables are set.
+func TestLoadFrom_Defaults(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN only the database in the environment
+	lookup := env(map[string]string{"GROUPS_MONGO_DATABASE": "groups"})
+
+	// WHEN we load the config without a file
+	got, err := config.LoadFrom("", lookup)
+	require.NoError(t, err)
+
+	// THEN we get the defaults with the database
+	want := config.Default()
+	want.Mongo.Database = "groups"
+	require.Equal(t, &want, got)
+	// THEN the audit trail is disabled, so atomic updates can be used
+	require.Empty(t, got.Mongo.AuditCollection)
+}
+
+// Tests the file overrides the defaults and the environment overrides the
+// file.
+func TestLoadFrom_Precedence(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a file with all the fields
+	path := writeFile(t, `{
+		"mongo": {
+			"uri": "mongodb://file:27017",
+			"database": "file_db",
+			"collection": "file_coll",
+			"audit_collection": "file_audit",
+			"lock_collection": "file_locks",
+			"connect_timeout": "3s",
+			"server_selection_timeout": "2s",
+			"max_pool_size": 7,
+			"tls": {"enabled": true, "ca_file": "/file/ca.pem"}
+		},
+		"transactions": {"max_retries": 4, "coalesce": false}
+	}`)
+
+	// GIVEN some of them overridden in the environment
+	lookup := env(map[string]string{
+		"GROUPS_MONGO_URI":                      "mongodb://env:27017",
+		"GROUPS_MONGO_AUDIT_COLLECTION":         "env_audit",
+		"GROUPS_MONGO_LOCK_COLLECTION":          "env_locks",
+		"GROUPS_MONGO_SERVER_SELECTION_TIMEOUT": "1m",
+		"GROUPS_MONGO_MAX_POOL_SIZE":            "20",
+		"GROUPS_TRANSACTIONS_MAX_RETRIES":       "2",
+		"GROUPS_TRANSACTIONS_COALESCE":          "true",
+	})
+
+	// WHEN we load the config
+	got, err := config.LoadFrom(path, lookup)
+	require.NoError(t, err)
+
+	// THEN the environment wins over the file
+	want := &config.Config{
+		Mongo: config.Mongo{
+			URI:                    "mongodb://env:27017",
+			Database:               "file_db",
+			Collection:             "file_coll",
+			AuditCollection:        "env_audit",
+			LockCollection:         "env_locks",
+			ConnectTimeout:         config.Duration(3 * time.Second),
+			ServerSelectionTimeout: config.Duration(time.Minute),
+			MaxPoolSize:            20,
+			TLS: config.TLS{
+				Enabled: true,
+				CAFile:  "/file/ca.pem",
+			},
+		},
+		Transactions: config.Transactions{MaxRetries: 2, Coalesce: true},
+	}
+	require.Equal(t, want, got)
+}
+
+func TestLoadFrom_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		file string
+		env  map[string]string
+		want string
+	}{
+		"missing database": {
+			want: "missing mongo database",
+		},
+		"malformed file": {
+			file: `{"mongo": `,
+			env:  map[string]string{"GROUPS_MONGO_DATABASE": "db"},
+			want: "parsing config file",
+		},
+		"duration as a number": {
+			file: `{"mongo": {"connect_timeout": 10}}`,
+			env:  map[string]string{"GROUPS_MONGO_DATABASE": "db"},
+			want: "duration must be a string",
+		},
+		"invalid env duration": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":        "db",
+				"GROUPS_MONGO_CONNECT_TIMEOUT": "soon",
+			},
+			want: "parsing GROUPS_MONGO_CONNECT_TIMEOUT",
+		},
+		"invalid env bool": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":    "db",
+				"GROUPS_MONGO_TLS_ENABLED": "maybe",
+			},
+			want: "parsing GROUPS_MONGO_TLS_ENABLED",
+		},
+		"same audit and group collections": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":         "db",
+				"GROUPS_MONGO_AUDIT_COLLECTION": "group",
+			},
+			want: "audit collection must be different",
+		},
+		"same lock and audit collections": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":         "db",
+				"GROUPS_MONGO_AUDIT_COLLECTION": "group_audit",
+				"GROUPS_MONGO_LOCK_COLLECTION":  "group_audit",
+			},
+			want: "lock collection must be different",
+		},
+		"zero retries": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":           "db",
+				"GROUPS_TRANSACTIONS_MAX_RETRIES": "0",
+			},
+			want: "max retries must be at least 1",
+		},
+		"ca file without tls": {
+			env: map[string]string{
+				"GROUPS_MONGO_DATABASE":    "db",
+				"GROUPS_MONGO_TLS_CA_FILE": "/ca.pem",
+			},
+			want: "tls is disabled",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a wrong config
+			path := ""
+			if test.file != "" {
+				path = writeFile(t, test.file)
+			}
+
+			// WHEN we load it
+			_, err := config.LoadFrom(path, env(test.env))
+
+			// THEN we get an error explaining what is wrong
+			require.ErrorContains(t, err, test.want)
+		})
+	}
+}
+
+func TestLoadFrom_MissingFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := config.LoadFrom(filepath.Join(t.TempDir(), "missing.json"), env(nil))
+	require.ErrorContains(t, err, "reading config file")
+}
@@ -0,0 +1,72 @@
+package domain
+
+import "time"
+
+// AuditAction is the kind of change recorded by an AuditEntry.
+type AuditAction string
+
+const (
+	// AuditGroupCreated records the creation of a group, with its owner as
+	// its first member.
+	AuditGroupCreated = AuditAction("group_created")
+	// AuditMemberAdded records a user joining a group.
+	AuditMemberAdded = AuditAction("member_added")
+)
+
+// AuditEntry records a change in the members of a group: who did it, when,
+// and how the number of members changed.
+//
+// The entries of a group are ordered by their Key.
+type AuditEntry struct {
+	// ID identifies the entry, it plays no part in its order.
+	ID      string
+	GroupID string
+	// Seq is the position of the change in the history of its group,
+	// starting at 1 with its creation, so it breaks the ties between
+	// entries recorded at the same time.
+	Seq int
+	// Actor is who requested the change, empty if unknown.
+	Actor  string
+	Action AuditAction
+	// UserID is the member the change is about.
+	UserID string
+	Time   time.Time
+	// MembersBefore and MembersAfter are the number of members of the
+	// group before and after the change.
+	MembersBefore int
+	MembersAfter  int
+}
+
+// Key returns the position of the entry in the history of its group.
+func (e AuditEntry) Key() AuditKey {
+	return AuditKey{Time: e.Time, Seq: e.Seq, ID: e.ID}
+}
+
+// AuditKey is the position of an entry in the history of its group: entries
+// are sorted by time and then by sequence, to break ties.
+//
+// The id only breaks the ties between entries with the same sequence, which
+// only happens when concurrent changes outside transactions overwrite each
+// other, so the order is total and pages never skip entries.
+type AuditKey struct {
+	Time time.Time
+	Seq  int
+	ID   string
+}
+
+// IsZero returns if k is the zero key, which comes before any entry.
+func (k AuditKey) IsZero() bool {
+	return k.Time.IsZero() && k.Seq == 0 && k.ID == ""
+}
+
+// Less returns if k comes before other.
+func (k AuditKey) Less(other AuditKey) bool {
+	switch {
+	case !k.Time.Equal(other.Time):
+		return k.Time.Before(other.Time)
+	case k.Seq != other.Seq:
+		return k.Seq < other.Seq
+	default:
+		return k.ID < other.ID
+	}
+}
@@ -0,0 +1,37 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditKey_Less(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Millisecond)
+
+	tests := map[string]struct {
+		a, b domain.AuditKey
+		want bool
+	}{
+		"earlier":               {a: domain.AuditKey{Time: t0, ID: "b"}, b: domain.AuditKey{Time: t1, ID: "a"}, want: true},
+		"later":                 {a: domain.AuditKey{Time: t1, ID: "a"}, b: domain.AuditKey{Time: t0, ID: "b"}, want: false},
+		"same time, lower seq":  {a: domain.AuditKey{Time: t0, Seq: 1, ID: "b"}, b: domain.AuditKey{Time: t0, Seq: 2, ID: "a"}, want: true},
+		"same time, higher seq": {a: domain.AuditKey{Time: t0, Seq: 2, ID: "a"}, b: domain.AuditKey{Time: t0, Seq: 1, ID: "b"}, want: false},
+		"same seq, lower id":    {a: domain.AuditKey{Time: t0, Seq: 1, ID: "a"}, b: domain.AuditKey{Time: t0, Seq: 1, ID: "b"}, want: true},
+		"equal":                 {a: domain.AuditKey{Time: t0, ID: "a"}, b: domain.AuditKey{Time: t0, ID: "a"}, want: false},
+		"zero goes first":       {a: domain.AuditKey{}, b: domain.AuditKey{Time: t0, ID: "a"}, want: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.want, test.a.Less(test.b))
+		})
+	}
+}
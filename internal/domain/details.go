@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxNameLength is the maximum number of characters in a group name.
+	MaxNameLength = 64
+	// MaxDescriptionLength is the maximum number of characters in a group
+	// description.
+	MaxDescriptionLength = 512
+	// MaxTags is the maximum number of tags of a group.
+	MaxTags = 10
+	// MaxTagLength is the maximum number of characters in a tag.
+	MaxTagLength = 32
+)
+
+// Details are the descriptive data of a group, shown to its users.
+//
+// Rules:
+//   - the name is optional, but the names of the groups of the same owner
+//     must be unique, which is enforced by the stores.
+//   - the name and the description have at most MaxNameLength and
+//     MaxDescriptionLength characters.
+//   - there are at most MaxTags tags, made of lowercase letters, digits and
+//     dashes, with at most MaxTagLength characters each.
+type Details struct {
+	Name        string
+	Description string
+	// Tags in alphabetical order, without duplicates.
+	Tags []string
+}
+
+// normalized returns a copy of d without surrounding spaces in its name and
+// description and with its tags sorted and without duplicates.
+func (d Details) normalized() Details {
+	tags := slices.Clone(d.Tags)
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	return Details{
+		Name:        strings.TrimSpace(d.Name),
+		Description: strings.TrimSpace(d.Description),
+		Tags:        tags,
+	}
+}
+
+// Equal returns if d and other have the same data.
+func (d Details) Equal(other Details) bool {
+	return d.Name == other.Name &&
+		d.Description == other.Description &&
+		slices.Equal(d.Tags, other.Tags)
+}
+
+// Violations returns all the reasons why d are not valid details, or nil if
+// they are.
+func (d Details) Violations() []error {
+	var result []error
+
+	if n := utf8.RuneCountInString(d.Name); n > MaxNameLength {
+		result = append(result, fmt.Errorf("name too long (%d characters)", n))
+	}
+
+	if n := utf8.RuneCountInString(d.Description); n > MaxDescriptionLength {
+		result = append(result, fmt.Errorf("description too long (%d characters)", n))
+	}
+
+	if len(d.Tags) > MaxTags {
+		result = append(result, fmt.Errorf("too many tags (%d)", len(d.Tags)))
+	}
+
+	for _, tag := range d.Tags {
+		if err := validateTag(tag); err != nil {
+			result = append(result, err)
+		}
+	}
+
+	if !slices.IsSorted(d.Tags) || len(slices.Compact(slices.Clone(d.Tags))) != len(d.Tags) {
+		result = append(result, errors.New("tags not sorted or duplicated"))
+	}
+
+	return result
+}
+
+func validateTag(tag string) error {
+	if tag == "" {
+		return errors.New("empty tag")
+	}
+
+	if len(tag) > MaxTagLength {
+		return fmt.Errorf("tag too long (%q)", tag)
+	}
+
+	for _, r := range tag {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return fmt.Errorf("invalid tag (%q), only lowercase letters, digits and dashes are allowed", tag)
+		}
+	}
+
+	return nil
+}
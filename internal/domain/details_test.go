@@ -0,0 +1,116 @@
+package domain_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroup_SetDetails(t *testing.T) {
+	t.Parallel()
+
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a group
+		group := domain.NewGroup("group_id", "owner_id", now)
+
+		// WHEN we set its details, with untidy values
+		err := group.SetDetails(domain.Details{
+			Name:        "  Book club ",
+			Description: "We read books\n",
+			Tags:        []string{"reading", "books", "reading"},
+		}, now)
+		require.NoError(t, err)
+
+		// THEN the group has the normalized details
+		want := domain.Details{
+			Name:        "Book club",
+			Description: "We read books",
+			Tags:        []string{"books", "reading"},
+		}
+		require.Equal(t, want, group.Details())
+	})
+
+	t.Run("details are copied", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a group with tags
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := group.SetDetails(domain.Details{Tags: []string{"a"}}, now)
+		require.NoError(t, err)
+
+		// WHEN we modify the returned tags
+		group.Details().Tags[0] = "b"
+
+		// THEN the group is not modified
+		require.Equal(t, []string{"a"}, group.Details().Tags)
+	})
+
+	tooManyTags := make([]string, domain.MaxTags+1)
+	for i := range tooManyTags {
+		tooManyTags[i] = strings.Repeat("a", i+1)
+	}
+
+	invalid := map[string]struct {
+		details domain.Details
+		want    string
+	}{
+		"long name": {
+			details: domain.Details{Name: strings.Repeat("ñ", domain.MaxNameLength+1)},
+			want:    "name too long",
+		},
+		"long description": {
+			details: domain.Details{Description: strings.Repeat("a", domain.MaxDescriptionLength+1)},
+			want:    "description too long",
+		},
+		"too many tags": {
+			details: domain.Details{Tags: tooManyTags},
+			want:    "too many tags",
+		},
+		"empty tag": {
+			details: domain.Details{Tags: []string{""}},
+			want:    "empty tag",
+		},
+		"long tag": {
+			details: domain.Details{Tags: []string{strings.Repeat("a", domain.MaxTagLength+1)}},
+			want:    "tag too long",
+		},
+		"uppercase tag": {
+			details: domain.Details{Tags: []string{"Books"}},
+			want:    "invalid tag",
+		},
+	}
+
+	for name, test := range invalid {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a group
+			group := domain.NewGroup("group_id", "owner_id", now)
+
+			// WHEN we set invalid details
+			err := group.SetDetails(test.details, now)
+
+			// THEN we get domain.ErrInvalidArgument explaining why
+			require.ErrorIs(t, err, domain.ErrInvalidArgument)
+			require.ErrorContains(t, err, test.want)
+
+			// THEN the group is not modified
+			require.Equal(t, domain.Details{}, group.Details())
+		})
+	}
+}
+
+func TestDetails_Equal(t *testing.T) {
+	t.Parallel()
+
+	a := domain.Details{Name: "n", Description: "d", Tags: []string{"x"}}
+
+	require.True(t, a.Equal(domain.Details{Name: "n", Description: "d", Tags: []string{"x"}}))
+	require.False(t, a.Equal(domain.Details{Name: "n", Description: "d"}))
+	require.False(t, a.Equal(domain.Details{Name: "m", Description: "d", Tags: []string{"x"}}))
+	require.True(t, domain.Details{}.Equal(domain.Details{Tags: []string{}}))
+}
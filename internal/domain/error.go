@@ -1,12 +1,132 @@
 package domain
 
-type errorString string
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind is the category of an error, it tells callers how to react to it
+// without knowing which layer produced it.
+//
+// The kinds are errors themselves, so callers can check the kind of any
+// error with errors.Is, for instance, errors.Is(err, domain.ErrNotFound).
+type Kind string
 
-func (e errorString) Error() string { return string(e) }
+func (k Kind) Error() string { return string(k) }
+
+// Retryable returns if an operation that failed with this kind of error
+// may succeed if tried again, without changing its arguments.
+func (k Kind) Retryable() bool {
+	switch k {
+	case ErrTransientTransaction, ErrConflict, ErrUnavailable:
+		return true
+	default:
+		return false
+	}
+}
 
 const (
-	ErrGroupFull                 = errorString("group is full")
-	ErrTransientTransaction      = errorString("transient transaction failure")
-	ErrNotFound                  = errorString("not found")
-	ErrTooManyTransactionRetries = errorString("too many transaction retries")
+	// ErrGroupFull means the group already has MaxMembers members.
+	ErrGroupFull = Kind("group is full")
+	// ErrTransientTransaction means a transaction failed and it can be
+	// retried from the start.
+	ErrTransientTransaction = Kind("transient transaction failure")
+	// ErrNotFound means the group does not exist.
+	ErrNotFound = Kind("not found")
+	// ErrTooManyTransactionRetries means a transaction has failed with
+	// transient errors more times than allowed.
+	ErrTooManyTransactionRetries = Kind("too many transaction retries")
+	// ErrAlreadyExists means a group with the same ID already exists.
+	ErrAlreadyExists = Kind("already exists")
+	// ErrConflict means the operation collided with a concurrent write to
+	// the same group, outside a transaction.
+	ErrConflict = Kind("conflict")
+	// ErrUnavailable means the store cannot be reached at the moment.
+	ErrUnavailable = Kind("unavailable")
+	// ErrInvalidArgument means the operation was called with wrong
+	// arguments, like an empty ID, or with data the store rejects.
+	ErrInvalidArgument = Kind("invalid argument")
+	// ErrFailedPrecondition means the operation cannot run with the
+	// current configuration, like reading the audit trail when it is not
+	// enabled, so it fails until the configuration changes.
+	ErrFailedPrecondition = Kind("failed precondition")
 )
+
+// Error is an error with the details callers need to handle it: its kind,
+// if it can be retried, the aggregate it refers to and what caused it.
+//
+// It matches both its kind and its cause with errors.Is and errors.As.
+type Error struct {
+	Kind Kind
+	// Retryable tells if the operation may succeed if tried again, it
+	// defaults to Kind.Retryable when created with NewError.
+	Retryable bool
+	// GroupID is the ID of the group the error refers to, if any.
+	GroupID string
+	// Err is the underlying cause, if any.
+	Err error
+}
+
+// NewError returns an error of the given kind about a group, with an
+// optional cause.
+func NewError(kind Kind, groupID string, cause error) *Error {
+	return &Error{
+		Kind:      kind,
+		Retryable: kind.Retryable(),
+		GroupID:   groupID,
+		Err:       cause,
+	}
+}
+
+// Errorf is like NewError, but its cause is built with fmt.Errorf.
+func Errorf(kind Kind, groupID string, format string, args ...any) *Error {
+	return NewError(kind, groupID, fmt.Errorf(format, args...))
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Kind)
+	if e.GroupID != "" {
+		msg = "group " + e.GroupID + ": " + msg
+	}
+
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+
+	return []error{e.Kind, e.Err}
+}
+
+// KindOf returns the kind of the error, or an empty kind if err has no known
+// kind, which should be treated as an internal error.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	var k Kind
+	if errors.As(err, &k) {
+		return k
+	}
+
+	return ""
+}
+
+// IsRetryable returns if the operation that returned err may succeed if
+// tried again.
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+
+	return KindOf(err).Retryable()
+}
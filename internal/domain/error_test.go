@@ -0,0 +1,116 @@
+package domain_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests an error matches its kind and its cause, even when wrapped.
+func TestError_Is(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a wrapped error with a kind and a cause
+	cause := errors.New("some cause")
+	err := fmt.Errorf("loading: %w", domain.NewError(domain.ErrUnavailable, "group_id", cause))
+
+	// THEN it matches both its kind and its cause
+	require.ErrorIs(t, err, domain.ErrUnavailable)
+	require.ErrorIs(t, err, cause)
+
+	// THEN it does not match other kinds
+	require.NotErrorIs(t, err, domain.ErrNotFound)
+
+	// THEN its details are available
+	var domainErr *domain.Error
+	require.ErrorAs(t, err, &domainErr)
+	require.Equal(t, domain.ErrUnavailable, domainErr.Kind)
+	require.Equal(t, "group_id", domainErr.GroupID)
+	require.True(t, domainErr.Retryable)
+}
+
+func TestError_Error(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want string
+	}{
+		"kind only": {
+			err:  domain.NewError(domain.ErrNotFound, "", nil),
+			want: "not found",
+		},
+		"with group": {
+			err:  domain.NewError(domain.ErrGroupFull, "group_id", nil),
+			want: "group group_id: group is full",
+		},
+		"with cause": {
+			err:  domain.Errorf(domain.ErrInvalidArgument, "group_id", "empty %s", "user id"),
+			want: "group group_id: invalid argument: empty user id",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.want, test.err.Error())
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want domain.Kind
+	}{
+		"structured":       {err: domain.NewError(domain.ErrConflict, "group_id", nil), want: domain.ErrConflict},
+		"wrapped kind":     {err: fmt.Errorf("loading: %w", domain.ErrNotFound), want: domain.ErrNotFound},
+		"plain error":      {err: errors.New("some error"), want: ""},
+		"nil":              {err: nil, want: ""},
+		"structured first": {err: fmt.Errorf("%w: %w", domain.NewError(domain.ErrAlreadyExists, "", nil), domain.ErrNotFound), want: domain.ErrAlreadyExists},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.want, domain.KindOf(test.err))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"transient transaction":    {err: domain.ErrTransientTransaction, want: true},
+		"conflict":                 {err: domain.NewError(domain.ErrConflict, "group_id", nil), want: true},
+		"unavailable":              {err: fmt.Errorf("x: %w", domain.NewError(domain.ErrUnavailable, "", nil)), want: true},
+		"not found":                {err: domain.NewError(domain.ErrNotFound, "group_id", nil), want: false},
+		"group full":               {err: domain.ErrGroupFull, want: false},
+		"already exists":           {err: domain.NewError(domain.ErrAlreadyExists, "group_id", nil), want: false},
+		"invalid argument":         {err: domain.NewError(domain.ErrInvalidArgument, "", nil), want: false},
+		"failed precondition":      {err: domain.NewError(domain.ErrFailedPrecondition, "", nil), want: false},
+		"too many retries":         {err: domain.ErrTooManyTransactionRetries, want: false},
+		"unknown":                  {err: errors.New("some error"), want: false},
+		"overridden retryable":     {err: &domain.Error{Kind: domain.ErrNotFound, Retryable: true}, want: true},
+		"overridden not retryable": {err: &domain.Error{Kind: domain.ErrUnavailable}, want: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.want, domain.IsRetryable(test.err))
+		})
+	}
+}
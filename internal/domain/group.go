@@ -1,21 +1,31 @@
 package domain
 
 import (
+	"errors"
+	"slices"
 	"sort"
+	"time"
 )
 
-type empty struct{}
-
 // Group represents a group of users.
 //
 // Invariants:
 //   - must have at least one member.
 //   - cannot have more than MaxMembers members.
 //   - must have an owner, which is one of its members.
+//   - its details must be valid, see Details.
+//
+// The group has no notion of the current time: the operations that change it
+// are given the time they happen at, so they can be recorded. Zero times mean
+// unknown, as groups created before times were recorded do not have them.
 type Group struct {
 	id      string
 	ownerID string
-	members map[string]empty
+	// members has when each member joined the group.
+	members   map[string]time.Time
+	details   Details
+	createdAt time.Time
+	updatedAt time.Time
 }
 
 // MaxMembers is maximum number of members in a group.
@@ -27,16 +37,19 @@ type Group struct {
 // MaxMembers must be greater than 0, so groups have at least one member.
 const MaxMembers = 5
 
-// NewGroup creates a new group owned by owner.
+// NewGroup creates a new group owned by owner, at the given time, which is
+// also when the owner joins it.
 //
 // The owner is required.
-func NewGroup(id string, ownerID string) *Group {
+func NewGroup(id string, ownerID string, now time.Time) *Group {
 	return &Group{
 		id:      id,
 		ownerID: ownerID,
-		members: map[string]empty{
-			ownerID: empty{},
+		members: map[string]time.Time{
+			ownerID: now,
 		},
+		createdAt: now,
+		updatedAt: now,
 	}
 }
 
@@ -50,18 +63,40 @@ func (g *Group) OwnerID() string {
 	return g.ownerID
 }
 
-// AddMember adds a user to the group.
+// CreatedAt returns when the group was created.
+func (g *Group) CreatedAt() time.Time {
+	return g.createdAt
+}
+
+// UpdatedAt returns when the members or the details of the group last
+// changed.
+func (g *Group) UpdatedAt() time.Time {
+	return g.updatedAt
+}
+
+// JoinedAt returns when the user joined the group and if it is a member.
+func (g *Group) JoinedAt(id string) (time.Time, bool) {
+	t, ok := g.members[id]
+	return t, ok
+}
+
+// AddMember adds a user to the group, who joins it at the given time.
 //
 // If the user was already a member, it is no-op and returns nil.
 //
 // Returns:
-// - ErrMaxMembers if the group is already full
-func (g *Group) AddMember(id string) error {
+// - ErrGroupFull if the group is already full
+func (g *Group) AddMember(id string, now time.Time) error {
 	if len(g.members) >= MaxMembers {
-		return ErrGroupFull
+		return NewError(ErrGroupFull, g.id, nil)
+	}
+
+	if _, ok := g.members[id]; ok {
+		return nil
 	}
 
-	g.members[id] = empty{}
+	g.members[id] = now
+	g.updatedAt = now
 
 	return nil
 }
@@ -84,12 +119,56 @@ func (g *Group) NumMembers() int {
 	return len(g.members)
 }
 
+// Details returns the name, description and tags of the group.
+func (g *Group) Details() Details {
+	d := g.details
+	d.Tags = slices.Clone(d.Tags)
+
+	return d
+}
+
+// SetDetails replaces the name, description and tags of the group, at the
+// given time. The name and description are trimmed and the tags are sorted
+// and deduplicated.
+//
+// Returns:
+// - ErrInvalidArgument if the details are not valid, see Details.
+func (g *Group) SetDetails(d Details, now time.Time) error {
+	d = d.normalized()
+
+	if violations := d.Violations(); len(violations) > 0 {
+		return NewError(ErrInvalidArgument, g.id, errors.Join(violations...))
+	}
+
+	if !d.Equal(g.details) {
+		g.details = d
+		g.updatedAt = now
+	}
+
+	return nil
+}
+
 // Snapshot returns a snapshot of the internal state of the group.
 func (g *Group) Snapshot() *GroupSnapshot {
+	d := g.Details()
+
+	members := g.Members()
+
+	joinedAt := make([]time.Time, len(members))
+	for i, id := range members {
+		joinedAt[i] = g.members[id]
+	}
+
 	return &GroupSnapshot{
-		ID:      g.ID(),
-		OwnerID: g.OwnerID(),
-		Members: g.Members(),
+		ID:          g.ID(),
+		OwnerID:     g.OwnerID(),
+		Members:     members,
+		JoinedAt:    joinedAt,
+		Name:        d.Name,
+		Description: d.Description,
+		Tags:        d.Tags,
+		CreatedAt:   g.createdAt,
+		UpdatedAt:   g.updatedAt,
 	}
 }
 
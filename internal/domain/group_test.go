@@ -3,18 +3,22 @@ package domain_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
 	"github.com/stretchr/testify/require"
 )
 
+// now is the time used by the tests that do not care about it.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
 func TestGroup_ID(t *testing.T) {
 	t.Parallel()
 
 	const id = "some_group_id"
 
 	// GIVEN a group with an specific id
-	group := domain.NewGroup(id, "irrelevant_owner_id")
+	group := domain.NewGroup(id, "irrelevant_owner_id", now)
 
 	// WHEN we ask the id of the group
 	got := group.ID()
@@ -29,7 +33,7 @@ func TestGroup_OwnerID(t *testing.T) {
 	const ownerID = "owner_id"
 
 	// GIVEN a group owned by the user
-	group := domain.NewGroup("irrelevant_group_id", ownerID)
+	group := domain.NewGroup("irrelevant_group_id", ownerID, now)
 
 	// WHEN we ask for the owner id of the group
 	got := group.OwnerID()
@@ -81,11 +85,11 @@ func TestGroup_AddMembers(t *testing.T) {
 				t.Parallel()
 
 				// GIVEN a group owned by ownerID
-				group := domain.NewGroup("irrelevant_group_id", ownerID)
+				group := domain.NewGroup("irrelevant_group_id", ownerID, now)
 
 				// WHEN we add test.usersToAdd to the group
 				for _, id := range test.usersToAdd {
-					err := group.AddMember(id)
+					err := group.AddMember(id, now)
 					require.NoErrorf(t, err, "adding user %s to group", id)
 				}
 
@@ -111,11 +115,11 @@ func TestGroup_AddMembers(t *testing.T) {
 		)
 
 		// GIVEN a group owned by ownerID
-		group := domain.NewGroup("irrelevant_group_id", ownerID)
+		group := domain.NewGroup("irrelevant_group_id", ownerID, now)
 		membersBefore := group.Members()
 
 		// WHEN we try to add an already existing member
-		err := group.AddMember(ownerID)
+		err := group.AddMember(ownerID, now)
 
 		// THEN we get success
 		require.NoError(t, err)
@@ -134,22 +138,75 @@ func TestGroup_AddMembers(t *testing.T) {
 		}
 
 		// GIVEN a full group
-		group := domain.NewGroup("irrelevant_group_id", userID(0))
+		group := domain.NewGroup("irrelevant_group_id", userID(0), now)
 		for i := 1; i < domain.MaxMembers; i++ {
-			err := group.AddMember(userID(i))
+			err := group.AddMember(userID(i), now)
 			require.NoErrorf(t, err, "adding user with index #%d", i)
 		}
 		require.Equal(t, domain.MaxMembers, group.NumMembers())
 
 		// WHEN we try to add one more user
-		err := group.AddMember("one_more_user_id")
+		err := group.AddMember("one_more_user_id", now)
 
-		// THEN we get ErrGroupFull
-		require.Error(t, err)
+		// THEN we get ErrGroupFull, which cannot be retried
 		require.ErrorIs(t, err, domain.ErrGroupFull)
+		require.False(t, domain.IsRetryable(err))
+		require.ErrorContains(t, err, group.ID())
 	})
 }
 
+// Tests the group records when it was created, updated and when each member
+// joined it.
+func TestGroup_Times(t *testing.T) {
+	t.Parallel()
+
+	created := now
+	joined := now.Add(time.Minute)
+	renamed := now.Add(time.Hour)
+
+	// GIVEN a group
+	group := domain.NewGroup("group_id", "owner_id", created)
+
+	// WHEN we add a member, then add it again later
+	err := group.AddMember("user_id", joined)
+	require.NoError(t, err)
+
+	err = group.AddMember("user_id", renamed)
+	require.NoError(t, err)
+
+	// THEN the member joined when it was first added
+	got, ok := group.JoinedAt("user_id")
+	require.True(t, ok)
+	require.Equal(t, joined, got)
+
+	got, ok = group.JoinedAt("owner_id")
+	require.True(t, ok)
+	require.Equal(t, created, got)
+
+	_, ok = group.JoinedAt("stranger_id")
+	require.False(t, ok)
+
+	// THEN the group was last updated when the member joined
+	require.Equal(t, created, group.CreatedAt())
+	require.Equal(t, joined, group.UpdatedAt())
+
+	// WHEN we change its details, then set the same ones later
+	err = group.SetDetails(domain.Details{Name: "name"}, renamed)
+	require.NoError(t, err)
+
+	err = group.SetDetails(domain.Details{Name: "name"}, renamed.Add(time.Hour))
+	require.NoError(t, err)
+
+	// THEN the group was last updated when its details changed
+	require.Equal(t, renamed, group.UpdatedAt())
+
+	// THEN the times survive a snapshot
+	regenerated, err := group.Snapshot().Regenerate()
+	require.NoError(t, err)
+	require.Equal(t, group.Snapshot(), regenerated.Snapshot())
+	require.Equal(t, created, regenerated.CreatedAt())
+}
+
 func TestHasMember(t *testing.T) {
 	t.Parallel()
 
@@ -187,8 +244,8 @@ func TestHasMember(t *testing.T) {
 			t.Parallel()
 
 			// GIVEN a group with ownerID and userID as members
-			group := domain.NewGroup("irrelevant_group_id", ownerID)
-			err := group.AddMember(userID)
+			group := domain.NewGroup("irrelevant_group_id", ownerID, now)
+			err := group.AddMember(userID, now)
 			require.NoError(t, err)
 
 			// WHEN you ask if the test.targetID user is in the group
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
 )
 
 // GroupSnapshot represent the internal state of a group.
@@ -15,42 +16,206 @@ type GroupSnapshot struct {
 	OwnerID string
 	// IDs of the members in alphabetical order.
 	Members []string
+	// JoinedAt has when each member joined the group, in the same order as
+	// Members. It can be nil if they are unknown.
+	JoinedAt    []time.Time
+	Name        string
+	Description string
+	// Tags in alphabetical order, without duplicates.
+	Tags      []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// details returns the details in s.
+func (s *GroupSnapshot) details() Details {
+	var tags []string
+	if len(s.Tags) > 0 {
+		tags = slices.Clone(s.Tags)
+	}
+
+	return Details{
+		Name:        s.Name,
+		Description: s.Description,
+		Tags:        tags,
+	}
+}
+
+// joinedAt returns when the i-th member joined the group, the zero time if
+// unknown.
+func (s *GroupSnapshot) joinedAt(i int) time.Time {
+	if i < len(s.JoinedAt) {
+		return s.JoinedAt[i]
+	}
+
+	return time.Time{}
 }
 
 // Regenerate creates a group from the internal state represented by s.
 //
-// Returns an error if the internal state represented by s would lead to an
-// invalid group.
+// Duplicated members are tolerated, as groups stored by older versions may
+// have them: they are merged, keeping the time they first joined at.
+//
+// Returns an error if the internal state represented by s, once merged the
+// duplicated members, would lead to an invalid group, see Violations.
 func (s *GroupSnapshot) Regenerate() (*Group, error) {
+	s = s.withoutDuplicatedMembers()
+
+	if violations := s.Violations(); len(violations) > 0 {
+		return nil, violations[0]
+	}
+
+	g := &Group{
+		id:        s.ID,
+		ownerID:   s.OwnerID,
+		members:   map[string]time.Time{},
+		details:   s.details(),
+		createdAt: s.CreatedAt,
+		updatedAt: s.UpdatedAt,
+	}
+
+	for i, id := range s.Members {
+		g.members[id] = s.joinedAt(i)
+	}
+
+	return g, nil
+}
+
+// withoutDuplicatedMembers returns s without the duplicated members, only
+// their first occurrence is kept, along with its joined at time. It returns s
+// itself if it has no duplicated members.
+func (s *GroupSnapshot) withoutDuplicatedMembers() *GroupSnapshot {
+	seen := make(map[string]bool, len(s.Members))
+	for _, id := range s.Members {
+		seen[id] = true
+	}
+
+	n := len(seen)
+	if n == len(s.Members) {
+		return s
+	}
+
+	result := *s
+	result.Members = make([]string, 0, n)
+	clear(seen)
+
+	// the joined at times are left alone if they do not match the members,
+	// so the mismatch is still reported
+	aligned := len(s.JoinedAt) == len(s.Members)
+	if aligned {
+		result.JoinedAt = make([]time.Time, 0, n)
+	}
+
+	for i, id := range s.Members {
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		result.Members = append(result.Members, id)
+
+		if aligned {
+			result.JoinedAt = append(result.JoinedAt, s.JoinedAt[i])
+		}
+	}
+
+	return &result
+}
+
+// Violations returns all the reasons why s does not represent a valid group,
+// or nil if it does.
+func (s *GroupSnapshot) Violations() []error {
+	var result []error
+
 	if s.ID == "" {
-		return nil, errors.New("empty id")
+		result = append(result, errors.New("empty id"))
 	}
 
 	if s.OwnerID == "" {
-		return nil, errors.New("empty owner id")
+		result = append(result, errors.New("empty owner id"))
 	}
 
 	if len(s.Members) == 0 {
-		return nil, errors.New("empty members")
+		result = append(result, errors.New("empty members"))
 	}
 
 	if len(s.Members) > MaxMembers {
-		return nil, fmt.Errorf("too many members (%d)", len(s.Members))
+		result = append(result, fmt.Errorf("too many members (%d)", len(s.Members)))
 	}
 
-	if !slices.Contains(s.Members, s.OwnerID) {
-		return nil, fmt.Errorf("owner (%s) is not member (%s)", s.OwnerID, s.Members)
+	if s.OwnerID != "" && len(s.Members) > 0 && !slices.Contains(s.Members, s.OwnerID) {
+		result = append(result, fmt.Errorf("owner (%s) is not member (%s)", s.OwnerID, s.Members))
 	}
 
-	g := &Group{
-		id:      s.ID,
-		ownerID: s.OwnerID,
-		members: map[string]empty{},
+	seen := map[string]int{}
+	for _, id := range s.Members {
+		seen[id]++
+		if seen[id] == 2 {
+			result = append(result, fmt.Errorf("duplicated member (%s)", id))
+		}
 	}
 
-	for _, id := range s.Members {
-		g.members[id] = empty{}
+	if s.JoinedAt != nil && len(s.JoinedAt) != len(s.Members) {
+		result = append(result, fmt.Errorf("%d joined at times for %d members", len(s.JoinedAt), len(s.Members)))
 	}
 
-	return g, nil
+	result = append(result, s.details().Violations()...)
+
+	return result
+}
+
+// Repaired returns a copy of s fixed to represent a valid group, if
+// possible, without losing members: duplicated members are removed and the
+// owner is added as a member if missing and there is room for it. Duplicated
+// members keep the time they first joined at and the added owner has an
+// unknown one.
+//
+// Returns false if s cannot be fixed, for instance, if it has no owner, if
+// it has too many different members or if its details are not valid.
+func (s *GroupSnapshot) Repaired() (*GroupSnapshot, bool) {
+	if s.ID == "" || s.OwnerID == "" || len(s.details().Violations()) > 0 {
+		return nil, false
+	}
+
+	joinedAt := map[string]time.Time{}
+	for i, id := range s.Members {
+		if _, ok := joinedAt[id]; !ok {
+			joinedAt[id] = s.joinedAt(i)
+		}
+	}
+
+	if _, ok := joinedAt[s.OwnerID]; !ok {
+		joinedAt[s.OwnerID] = time.Time{}
+	}
+
+	if len(joinedAt) > MaxMembers {
+		return nil, false
+	}
+
+	members := make([]string, 0, len(joinedAt))
+	for id := range joinedAt {
+		members = append(members, id)
+	}
+
+	slices.Sort(members)
+
+	var times []time.Time
+	if s.JoinedAt != nil {
+		times = make([]time.Time, len(members))
+		for i, id := range members {
+			times[i] = joinedAt[id]
+		}
+	}
+
+	return &GroupSnapshot{
+		ID:          s.ID,
+		OwnerID:     s.OwnerID,
+		Members:     members,
+		JoinedAt:    times,
+		Name:        s.Name,
+		Description: s.Description,
+		Tags:        slices.Clone(s.Tags),
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+	}, true
 }
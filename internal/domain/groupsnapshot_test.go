@@ -3,6 +3,7 @@ package domain_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
 	"github.com/stretchr/testify/require"
@@ -22,8 +23,8 @@ func TestGroupSnapshot_Regenerate(t *testing.T) {
 		)
 
 		// GIVEN a group with user1 and user2 as members
-		group := domain.NewGroup("irrelevant_group_id", user1)
-		err := group.AddMember(user2)
+		group := domain.NewGroup("irrelevant_group_id", user1, now)
+		err := group.AddMember(user2, now)
 		require.NoError(t, err)
 
 		// GIVEN a snapshot of the group
@@ -39,6 +40,50 @@ func TestGroupSnapshot_Regenerate(t *testing.T) {
 		require.Equal(t, group.Members(), group2.Members())
 	})
 
+	t.Run("with details", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot of a group with details
+		group := domain.NewGroup("irrelevant_group_id", "owner_id", now)
+		err := group.SetDetails(domain.Details{Name: "name", Description: "description", Tags: []string{"a"}}, now)
+		require.NoError(t, err)
+
+		snapshot := group.Snapshot()
+
+		// WHEN you recreate the group from the snapshot
+		group2, err := snapshot.Regenerate()
+		require.NoError(t, err)
+
+		// THEN the regenerated group has the same details
+		require.Equal(t, group.Details(), group2.Details())
+		require.Equal(t, snapshot, group2.Snapshot())
+	})
+
+	t.Run("duplicated members", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot with duplicated members, like the ones stored
+		// by older versions
+		snapshot := &domain.GroupSnapshot{
+			ID:       "irrelevant_group_id",
+			OwnerID:  "a",
+			Members:  []string{"a", "b", "b"},
+			JoinedAt: []time.Time{now, now.Add(1), now.Add(2)},
+		}
+
+		// WHEN you recreate the group from the snapshot
+		group, err := snapshot.Regenerate()
+		require.NoError(t, err)
+
+		// THEN the duplicated members are merged, keeping the time they
+		// first joined at
+		require.Equal(t, []string{"a", "b"}, group.Members())
+		require.Equal(t, []time.Time{now, now.Add(1)}, group.Snapshot().JoinedAt)
+
+		// THEN the snapshot is left untouched
+		require.Equal(t, []string{"a", "b", "b"}, snapshot.Members)
+	})
+
 	t.Run("invalid", func(t *testing.T) {
 		t.Parallel()
 
@@ -115,6 +160,15 @@ func TestGroupSnapshot_Regenerate(t *testing.T) {
 				},
 				errorContent: "empty owner id",
 			},
+			{
+				name: "too many members once merged the duplicated ones",
+				snapshot: &domain.GroupSnapshot{
+					ID:      "irrelevant_group_id",
+					OwnerID: "a",
+					Members: []string{"a", "b", "c", "d", "e", "f", "f"},
+				},
+				errorContent: "too many members (6)",
+			},
 		}
 
 		for _, test := range subtests {
@@ -131,3 +185,217 @@ func TestGroupSnapshot_Regenerate(t *testing.T) {
 		}
 	})
 }
+
+// Tests Violations reports all the reasons why a snapshot is invalid.
+func TestGroupSnapshot_Violations(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot of a valid group
+		snapshot := domain.NewGroup("group_id", "owner_id", now).Snapshot()
+
+		// WHEN we check its violations
+		violations := snapshot.Violations()
+
+		// THEN there are none
+		require.Empty(t, violations)
+	})
+
+	t.Run("several violations", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot with an owner that is not a member and
+		// duplicated members
+		snapshot := &domain.GroupSnapshot{
+			ID:      "group_id",
+			OwnerID: "owner_id",
+			Members: []string{"a", "a", "b", "b"},
+		}
+
+		// WHEN we check its violations
+		violations := snapshot.Violations()
+
+		// THEN we get all of them
+		require.Len(t, violations, 3)
+		require.ErrorContains(t, violations[0], "not member")
+		require.ErrorContains(t, violations[1], "duplicated member (a)")
+		require.ErrorContains(t, violations[2], "duplicated member (b)")
+	})
+
+	t.Run("joined at times mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot with more joined at times than members
+		snapshot := domain.NewGroup("group_id", "owner_id", now).Snapshot()
+		snapshot.JoinedAt = append(snapshot.JoinedAt, now)
+
+		// WHEN we check its violations
+		violations := snapshot.Violations()
+
+		// THEN we get the mismatch
+		require.Len(t, violations, 1)
+		require.ErrorContains(t, violations[0], "2 joined at times for 1 members")
+	})
+
+	t.Run("invalid details", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a snapshot with unsorted tags
+		snapshot := domain.NewGroup("group_id", "owner_id", now).Snapshot()
+		snapshot.Tags = []string{"b", "a"}
+
+		// WHEN we check its violations
+		violations := snapshot.Violations()
+
+		// THEN we get the details violation
+		require.Len(t, violations, 1)
+		require.ErrorContains(t, violations[0], "tags not sorted")
+	})
+}
+
+// Tests Repaired fixes the snapshots that can be fixed without losing
+// members.
+func TestGroupSnapshot_Repaired(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name     string
+		snapshot *domain.GroupSnapshot
+		want     *domain.GroupSnapshot // nil if it cannot be repaired
+	}{
+		{
+			name: "valid",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"b", "a"},
+			},
+			want: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a", "b"},
+			},
+		},
+		{
+			name: "duplicated members",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a", "b", "b"},
+			},
+			want: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a", "b"},
+			},
+		},
+		{
+			name: "owner is not a member",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"b"},
+			},
+			want: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a", "b"},
+			},
+		},
+		{
+			name: "no members",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+			},
+			want: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a"},
+			},
+		},
+		{
+			name: "empty owner",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				Members: []string{"a"},
+			},
+		},
+		{
+			name: "no room for the owner",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"b", "c", "d", "e", "f"},
+			},
+		},
+		{
+			name: "keeps the times",
+			snapshot: &domain.GroupSnapshot{
+				ID:        "group_id",
+				OwnerID:   "a",
+				Members:   []string{"c", "b", "c"},
+				JoinedAt:  []time.Time{now.Add(2), now.Add(1), now.Add(3)},
+				CreatedAt: now,
+				UpdatedAt: now.Add(3),
+			},
+			want: &domain.GroupSnapshot{
+				ID:        "group_id",
+				OwnerID:   "a",
+				Members:   []string{"a", "b", "c"},
+				JoinedAt:  []time.Time{{}, now.Add(1), now.Add(2)},
+				CreatedAt: now,
+				UpdatedAt: now.Add(3),
+			},
+		},
+		{
+			name: "keeps the details",
+			snapshot: &domain.GroupSnapshot{
+				ID:          "group_id",
+				OwnerID:     "a",
+				Members:     []string{"b"},
+				Name:        "name",
+				Description: "description",
+				Tags:        []string{"x", "y"},
+			},
+			want: &domain.GroupSnapshot{
+				ID:          "group_id",
+				OwnerID:     "a",
+				Members:     []string{"a", "b"},
+				Name:        "name",
+				Description: "description",
+				Tags:        []string{"x", "y"},
+			},
+		},
+		{
+			name: "invalid details",
+			snapshot: &domain.GroupSnapshot{
+				ID:      "group_id",
+				OwnerID: "a",
+				Members: []string{"a"},
+				Tags:    []string{"Not A Tag"},
+			},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// WHEN we repair the snapshot
+			got, ok := test.snapshot.Repaired()
+
+			// THEN we get the repaired snapshot, if it can be repaired
+			if test.want == nil {
+				require.False(t, ok)
+				return
+			}
+
+			require.True(t, ok)
+			require.Equal(t, test.want, got)
+			require.Empty(t, got.Violations())
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package domain_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// The property tests in this file generate their test cases from a slice of
+// bytes, which allows to use the same properties in two ways:
+//   - as regular tests, generating random slices of bytes and shrinking
+//     them when a property fails, to report a minimal failing case.
+//   - as Go native fuzz targets, letting the fuzzer come up with the bytes.
+
+// userPool are the user ids used by the generators, it is small on purpose
+// so the generated test cases have duplicated members, full groups...
+var userPool = []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+// byteSource reads values from a slice of bytes, returning zeros once the
+// bytes are exhausted.
+type byteSource struct {
+	data []byte
+}
+
+func (s *byteSource) next() byte {
+	if len(s.data) == 0 {
+		return 0
+	}
+
+	b := s.data[0]
+	s.data = s.data[1:]
+
+	return b
+}
+
+func (s *byteSource) exhausted() bool {
+	return len(s.data) == 0
+}
+
+// groupProperties creates a group and runs a sequence of operations on it,
+// both generated from data, and checks the group invariants after each
+// operation, comparing the group against a simple model of it.
+//
+// The first byte chooses the owner, each of the remaining bytes is an
+// operation: if its highest bit is set, the group is replaced by the one
+// regenerated from its snapshot, otherwise it adds a user to the group.
+func groupProperties(data []byte) error {
+	src := &byteSource{data: data}
+
+	ownerID := userPool[int(src.next())%len(userPool)]
+	group := domain.NewGroup("group_id", ownerID, now)
+	model := map[string]bool{ownerID: true}
+
+	if err := checkGroup(group, ownerID, model); err != nil {
+		return fmt.Errorf("after creation: %w", err)
+	}
+
+	for i := 0; !src.exhausted(); i++ {
+		b := src.next()
+
+		var desc string
+
+		if b&0x80 != 0 {
+			desc = "regenerate"
+
+			regenerated, err := group.Snapshot().Regenerate()
+			if err != nil {
+				return fmt.Errorf("op #%d (%s): %v", i, desc, err)
+			}
+
+			group = regenerated
+		} else {
+			userID := userPool[int(b)%len(userPool)]
+			desc = fmt.Sprintf("add %s", userID)
+
+			wantFull := len(model) >= domain.MaxMembers
+
+			err := group.AddMember(userID, now)
+			switch {
+			case wantFull && !errors.Is(err, domain.ErrGroupFull):
+				return fmt.Errorf("op #%d (%s): want ErrGroupFull, got %v", i, desc, err)
+			case !wantFull && err != nil:
+				return fmt.Errorf("op #%d (%s): unexpected error: %v", i, desc, err)
+			case !wantFull:
+				model[userID] = true
+			}
+		}
+
+		if err := checkGroup(group, ownerID, model); err != nil {
+			return fmt.Errorf("op #%d (%s): %w", i, desc, err)
+		}
+	}
+
+	return nil
+}
+
+// checkGroup checks the invariants of the group and that it has the same
+// members as the model.
+func checkGroup(group *domain.Group, ownerID string, model map[string]bool) error {
+	if group.OwnerID() != ownerID {
+		return fmt.Errorf("wrong owner: want %s, got %s", ownerID, group.OwnerID())
+	}
+
+	if !group.HasMember(ownerID) {
+		return fmt.Errorf("owner %s is not a member: %v", ownerID, group.Members())
+	}
+
+	if n := group.NumMembers(); n < 1 || n > domain.MaxMembers {
+		return fmt.Errorf("wrong number of members: %d", n)
+	}
+
+	want := make([]string, 0, len(model))
+	for id := range model {
+		want = append(want, id)
+	}
+	slices.Sort(want)
+
+	if got := group.Members(); !slices.Equal(want, got) {
+		return fmt.Errorf("wrong members: want %v, got %v", want, got)
+	}
+
+	if group.NumMembers() != len(want) {
+		return fmt.Errorf("wrong member count: want %d, got %d", len(want), group.NumMembers())
+	}
+
+	snapshot := group.Snapshot()
+
+	regenerated, err := snapshot.Regenerate()
+	if err != nil {
+		return fmt.Errorf("regenerating snapshot %+v: %v", snapshot, err)
+	}
+
+	if got := regenerated.Snapshot(); !snapshotsEqual(snapshot, got) {
+		return fmt.Errorf("snapshot does not round-trip: want %+v, got %+v", snapshot, got)
+	}
+
+	return nil
+}
+
+func snapshotsEqual(a, b *domain.GroupSnapshot) bool {
+	return a.ID == b.ID &&
+		a.OwnerID == b.OwnerID &&
+		slices.Equal(a.Members, b.Members)
+}
+
+// snapshotProperties generates a snapshot from data, which may or may not
+// be valid, and checks Violations reports it if and only if it is invalid,
+// that Regenerate rejects it if and only if it is invalid once merged its
+// duplicated members, and that repairing it leads to a valid snapshot.
+//
+// The first byte chooses the id, the second one the owner, the third one
+// the number of members, and each of the remaining bytes a member.
+func snapshotProperties(data []byte) error {
+	snapshot := genSnapshot(&byteSource{data: data})
+	wantValid := isValidSnapshot(snapshot)
+
+	if got := len(snapshot.Violations()) == 0; got != wantValid {
+		return fmt.Errorf("snapshot %+v: want valid %t, got violations %v", snapshot, wantValid, snapshot.Violations())
+	}
+
+	if repaired, ok := snapshot.Repaired(); ok && !isValidSnapshot(repaired) {
+		return fmt.Errorf("snapshot %+v: invalid repair %+v", snapshot, repaired)
+	}
+
+	merged := &domain.GroupSnapshot{
+		ID:      snapshot.ID,
+		OwnerID: snapshot.OwnerID,
+		Members: slices.Clone(snapshot.Members),
+	}
+	slices.Sort(merged.Members)
+	merged.Members = slices.Compact(merged.Members)
+	wantLoadable := isValidSnapshot(merged)
+
+	group, err := snapshot.Regenerate()
+
+	switch {
+	case wantLoadable && err != nil:
+		return fmt.Errorf("loadable snapshot %+v rejected: %v", snapshot, err)
+	case !wantLoadable && err == nil:
+		return fmt.Errorf("invalid snapshot %+v accepted", snapshot)
+	case !wantLoadable:
+		return nil
+	}
+
+	if got := group.Snapshot(); !snapshotsEqual(merged, got) {
+		return fmt.Errorf("regenerated group does not match snapshot: want %+v, got %+v", merged, got)
+	}
+
+	return nil
+}
+
+func genSnapshot(src *byteSource) *domain.GroupSnapshot {
+	// user returns an id from the pool, or an empty id for 0.
+	user := func(b byte) string {
+		n := int(b) % (len(userPool) + 1)
+		if n == 0 {
+			return ""
+		}
+
+		return userPool[n-1]
+	}
+
+	s := &domain.GroupSnapshot{}
+
+	if src.next()%4 != 0 {
+		s.ID = "group_id"
+	}
+
+	s.OwnerID = user(src.next())
+
+	count := int(src.next()) % (domain.MaxMembers + 3)
+	if count > 0 {
+		s.Members = make([]string, 0, count)
+	}
+
+	for range count {
+		// members are never empty, that is not something the domain
+		// cares about
+		s.Members = append(s.Members, userPool[int(src.next())%len(userPool)])
+	}
+
+	return s
+}
+
+// isValidSnapshot is the reference definition of a valid snapshot, written
+// in the most obvious way.
+func isValidSnapshot(s *domain.GroupSnapshot) bool {
+	if s.ID == "" || s.OwnerID == "" {
+		return false
+	}
+
+	if len(s.Members) == 0 || len(s.Members) > domain.MaxMembers {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for _, id := range s.Members {
+		if seen[id] {
+			return false
+		}
+
+		seen[id] = true
+	}
+
+	return seen[s.OwnerID]
+}
+
+// checkProperty runs the property against random inputs. On failure, it
+// shrinks the input to a minimal failing one and reports it.
+func checkProperty(t *testing.T, property func([]byte) error) {
+	t.Helper()
+
+	const (
+		runs   = 1000
+		maxLen = 32
+	)
+
+	//nolint:gosec // weak random generation is ok here
+	rnd := rand.New(rand.NewSource(rand.Int63()))
+
+	for range runs {
+		data := make([]byte, rnd.Intn(maxLen))
+		rnd.Read(data)
+
+		if property(data) == nil {
+			continue
+		}
+
+		fails := func(data []byte) bool { return property(data) != nil }
+		data = shrink(data, fails)
+
+		t.Fatalf("property failed for input %v: %v", data, property(data))
+	}
+}
+
+// shrink returns a smaller input that still fails: shorter and with smaller
+// bytes.
+func shrink(data []byte, fails func([]byte) bool) []byte {
+	for improved := true; improved; {
+		improved = false
+
+		// try to remove chunks of decreasing size
+		for size := len(data) / 2; size > 0; size /= 2 {
+			for i := 0; i+size <= len(data); {
+				candidate := append(data[:i:i], data[i+size:]...)
+				if fails(candidate) {
+					data = candidate
+					improved = true
+					continue
+				}
+
+				i++
+			}
+		}
+
+		// try to make each byte smaller
+		for i := range data {
+			for _, smaller := range []byte{0, data[i] / 2, data[i] - 1} {
+				if smaller >= data[i] {
+					continue
+				}
+
+				candidate := slices.Clone(data)
+				candidate[i] = smaller
+
+				if fails(candidate) {
+					data = candidate
+					improved = true
+					break
+				}
+			}
+		}
+	}
+
+	return data
+}
+
+func TestGroup_Properties(t *testing.T) {
+	t.Parallel()
+
+	checkProperty(t, groupProperties)
+}
+
+func TestGroupSnapshot_Properties(t *testing.T) {
+	t.Parallel()
+
+	checkProperty(t, snapshotProperties)
+}
+
+// Tests shrink finds minimal failing inputs.
+func TestShrink(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a property that fails when there are two bytes bigger than 10
+	fails := func(data []byte) bool {
+		count := 0
+		for _, b := range data {
+			if b > 10 {
+				count++
+			}
+		}
+
+		return count >= 2
+	}
+
+	// WHEN we shrink a big failing input
+	got := shrink([]byte{1, 200, 3, 4, 100, 6, 255, 8}, fails)
+
+	// THEN we get the minimal failing input
+	want := []byte{11, 11}
+	if !slices.Equal(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func FuzzGroup(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 1, 2, 3})
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7})
+	f.Add([]byte{3, 0x80, 1, 0x81, 2, 2, 4, 5, 6})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := groupProperties(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzGroupSnapshot(f *testing.F) {
+	f.Add([]byte{1, 1, 1, 0})
+	f.Add([]byte{1, 1, 2, 0, 1})
+	f.Add([]byte{1, 1, 2, 0, 0})
+	f.Add([]byte{0, 1, 1, 0})
+	f.Add([]byte{1, 0, 1, 0})
+	f.Add([]byte{1, 1, 6, 0, 1, 2, 3, 4, 5})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := snapshotProperties(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
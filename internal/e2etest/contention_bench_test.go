@@ -0,0 +1,217 @@
+package e2etest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+)
+
+// Measures how AddUserToGroup scales with the number of concurrent callers,
+// with and without transactions, with atomic updates, coalescing the
+// transactions to the same group, and with locks instead of transactions.
+//
+// The callers add users:
+//   - one-group: all of them to the same group, from a small pool of users,
+//     so it never gets full.
+//   - many-groups: each of them to its own group, from the same pool.
+//   - filling-groups: all of them to the same group until it is full, then
+//     to the next one, so they also race for the last seats.
+//
+// The audit trail is disabled, the default, as it makes atomic updates fail
+// and it would add the same extra writes to every mode.
+//
+// Besides the time per operation, it reports these custom metrics:
+//   - ops/s: the throughput of all the goroutines together.
+//   - p50-ms and p99-ms: the latency percentiles of the calls.
+//   - retries/op: transaction attempts that failed with a transient error
+//     and had to be retried.
+//   - errors/op: calls that failed, for instance, by running out of
+//     transaction retries.
+//
+// Run it with:
+//
+//	go test ./internal/e2etest -run xxx -bench Contention -benchtime 200x
+func BenchmarkContention(b *testing.B) {
+	modes := []struct {
+		name     string
+		options  []application.Option
+		coalesce bool
+	}{
+		{
+			name: "no-transactions",
+		},
+		{
+			name:    "transactions",
+			options: []application.Option{application.EnableTransactions{}},
+		},
+		{
+			name:    "atomic",
+			options: []application.Option{application.EnableAtomicUpdates{}, application.EnableTransactions{}},
+		},
+		{
+			name:     "coalescing",
+			options:  []application.Option{application.EnableTransactions{}},
+			coalesce: true,
+		},
+		{
+			name:    "locking",
+			options: []application.Option{application.EnableLocking{}},
+		},
+	}
+
+	for _, mode := range modes {
+		for _, groups := range []string{"one-group", "many-groups", "filling-groups"} {
+			for _, goroutines := range []int{1, 2, 4, 8, 16} {
+				name := fmt.Sprintf("%s/%s/goroutines=%d", mode.name, groups, goroutines)
+
+				b.Run(name, func(b *testing.B) {
+					benchmarkContention(b, goroutines, groups, mode.coalesce, mode.options)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkContention(b *testing.B, goroutines int, groups string, coalesce bool, options []application.Option) {
+	counters := &countingMetrics{}
+	fix := newConfiguredFixture(b, func(cfg *config.Config) {
+		cfg.Transactions.Coalesce = coalesce
+	}, mongo.WithMetrics(counters))
+
+	// the fixture context is too short for long benchmarks
+	ctx := context.Background()
+
+	// the filling groups get the users of the calls in order
+	const usersPerGroup = domain.MaxMembers - 1
+
+	// GIVEN a single group for all the goroutines, a group per goroutine, or
+	// enough groups for all the calls
+	var groupCount int
+
+	switch groups {
+	case "one-group":
+		groupCount = 1
+	case "many-groups":
+		groupCount = goroutines
+	case "filling-groups":
+		groupCount = b.N/usersPerGroup + 1
+	default:
+		b.Fatalf("unknown groups %q", groups)
+	}
+
+	groupIDs := make([]string, 0, groupCount)
+	for range groupCount {
+		id, err := fix.app.CreateGroup(ctx, "owner_id")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		groupIDs = append(groupIDs, id)
+	}
+
+	// target returns the user and the group of the n-th call of the
+	// goroutine g. Unless they are filling the groups, the users are taken
+	// from a small pool, so the groups, with their owner, never get full.
+	target := func(g, n int) (string, string) {
+		if groups == "filling-groups" {
+			return fmt.Sprintf("user_id_%d", n), groupIDs[n/usersPerGroup]
+		}
+
+		return fmt.Sprintf("user_id_%d", n%(domain.MaxMembers-2)), groupIDs[g%len(groupIDs)]
+	}
+
+	var (
+		next      atomic.Int64
+		failures  atomic.Int64
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, b.N)
+		wg        sync.WaitGroup
+	)
+
+	b.ResetTimer()
+	start := time.Now()
+
+	// WHEN the goroutines add users until they run b.N calls between all of
+	// them
+	wg.Add(goroutines)
+
+	for g := range goroutines {
+		go func() {
+			defer wg.Done()
+
+			local := make([]time.Duration, 0, b.N/goroutines+1)
+
+			for {
+				n := int(next.Add(1) - 1)
+				if n >= b.N {
+					break
+				}
+
+				userID, groupID := target(g, n)
+				callStart := time.Now()
+
+				if err := fix.app.AddUserToGroup(ctx, userID, groupID, options...); err != nil {
+					failures.Add(1)
+				}
+
+				local = append(local, time.Since(callStart))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	elapsed := time.Since(start)
+
+	b.StopTimer()
+
+	// THEN we report the custom metrics
+	slices.Sort(latencies)
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "ops/s")
+	b.ReportMetric(ms(percentile(latencies, 50)), "p50-ms")
+	b.ReportMetric(ms(percentile(latencies, 99)), "p99-ms")
+	b.ReportMetric(float64(counters.transientFailures.Load())/float64(b.N), "retries/op")
+	b.ReportMetric(float64(failures.Load())/float64(b.N), "errors/op")
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := (len(sorted)*p+99)/100 - 1
+
+	return sorted[max(i, 0)]
+}
+
+// countingMetrics is a mongo.Metrics that counts transaction attempts and
+// failures.
+type countingMetrics struct {
+	attempts          atomic.Int64
+	transientFailures atomic.Int64
+	tooManyRetries    atomic.Int64
+}
+
+var _ mongo.Metrics = (*countingMetrics)(nil)
+
+func (m *countingMetrics) TransactionAttempt()                { m.attempts.Add(1) }
+func (m *countingMetrics) TransientTransactionFailure()       { m.transientFailures.Add(1) }
+func (m *countingMetrics) TooManyTransactionRetries()         { m.tooManyRetries.Add(1) }
+func (m *countingMetrics) TransactionCommitted(time.Duration) {}
@@ -9,10 +9,11 @@ import (
 	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/bootstrap"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/config"
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
 	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
 	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,28 +24,47 @@ type fixture struct {
 	app *application.App
 }
 
-type googleUuider struct{}
+// newFixture returns an app using a new database, built the same way as the
+// programs build it, the options are used to create its GroupRepo.
+func newFixture(t testing.TB, options ...mongo.Option) *fixture {
+	t.Helper()
 
-func (googleUuider) NewString() string {
-	return uuid.NewString()
+	return newConfiguredFixture(t, func(*config.Config) {}, options...)
 }
 
-func newFixture(t *testing.T) *fixture {
+// newConfiguredFixture is like newFixture, but configure can change the
+// default configuration of the app.
+func newConfiguredFixture(t testing.TB, configure func(*config.Config), options ...mongo.Option) *fixture {
 	t.Helper()
 
 	const timeout = 10 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	t.Cleanup(cancel)
 
-	db := testhelp.NewTestDatabase(t, mongoURI)
-	coll := db.Collection("group")
-	groupRepo := mongo.NewGroupRepo(coll)
+	cfg := config.Default()
+	cfg.Mongo.URI = mongoURI
+	cfg.Mongo.Database = testhelp.DatabaseName(t)
+	configure(&cfg)
+
+	svc, err := bootstrap.New(ctx, cfg, bootstrap.WithRepoOptions(options...))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
 
-	app := application.New(googleUuider{}, groupRepo)
+		if err := svc.Conn.DB.Drop(ctx); err != nil {
+			t.Errorf("cleaning up database after test: %v", err)
+		}
+
+		if err := svc.Close(ctx); err != nil {
+			t.Errorf("closing the service: %v", err)
+		}
+	})
 
 	return &fixture{
 		ctx: ctx,
-		app: app,
+		app: svc.App,
 	}
 }
 
@@ -96,6 +116,80 @@ func Test_AddOneUserToGroup(t *testing.T) {
 	require.Equal(t, []string{fix.ownerID, fix.userID}, modifiedGroup.Members())
 }
 
+// Tests the membership changes are recorded in the audit trail.
+func Test_AuditTrail(t *testing.T) {
+	fix := newConfiguredFixture(t, func(cfg *config.Config) {
+		cfg.Mongo.AuditCollection = "group_audit"
+	})
+
+	// GIVEN a group
+	groupID, err := fix.app.CreateGroup(fix.ctx, "owner_id", application.Actor("admin"))
+	require.NoError(t, err)
+
+	// WHEN we add a user to it, in a transaction
+	err = fix.app.AddUserToGroup(fix.ctx, "user_id", groupID,
+		application.Actor("owner_id"), application.EnableTransactions{})
+	require.NoError(t, err)
+
+	// THEN both changes are in its history, in order
+	page, err := fix.app.GetGroupHistory(fix.ctx, groupID, "", 0)
+	require.NoError(t, err)
+	require.Empty(t, page.Next)
+	require.Len(t, page.Entries, 2)
+
+	created, added := page.Entries[0], page.Entries[1]
+
+	require.Equal(t, domain.AuditGroupCreated, created.Action)
+	require.Equal(t, "admin", created.Actor)
+	require.Equal(t, "owner_id", created.UserID)
+
+	require.Equal(t, domain.AuditMemberAdded, added.Action)
+	require.Equal(t, "owner_id", added.Actor)
+	require.Equal(t, "user_id", added.UserID)
+	require.Equal(t, 1, added.MembersBefore)
+	require.Equal(t, 2, added.MembersAfter)
+}
+
+// Tests many callers racing to create the same group with a client supplied
+// id all succeed, with and without transactions, and a duplicate creation
+// without CreateIfAbsent fails with domain.ErrAlreadyExists thanks to the
+// unique _id index.
+func Test_Concurrency_CreateGroupIfAbsent(t *testing.T) {
+	fix := newFixture(t)
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, callers)
+
+	// WHEN many callers create the same group at the same time
+	wg.Add(callers)
+
+	for i := range callers {
+		go func() {
+			defer wg.Done()
+
+			options := []application.Option{application.UseGroupID("group_id"), application.CreateIfAbsent{}}
+			if i%2 == 0 {
+				options = append(options, application.EnableTransactions{})
+			}
+
+			_, errs[i] = fix.app.CreateGroup(fix.ctx, "owner_id", options...)
+		}()
+	}
+
+	wg.Wait()
+
+	// THEN they all succeed
+	for i, err := range errs {
+		require.NoErrorf(t, err, "caller %d", i)
+	}
+
+	// THEN creating it again without CreateIfAbsent fails
+	_, err := fix.app.CreateGroup(fix.ctx, "owner_id", application.UseGroupID("group_id"))
+	require.ErrorIs(t, err, domain.ErrAlreadyExists)
+}
+
 // Test the app layer respects the Group invariants while adding users:
 //
 // Let's make many concurrent AddUserToGroup requests, more than the maximum
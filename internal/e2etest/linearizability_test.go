@@ -0,0 +1,137 @@
+package e2etest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/linearizability"
+	"github.com/stretchr/testify/require"
+)
+
+// Test a randomized concurrent workload against the Mongo store is
+// linearizable when transactions are enabled.
+//
+// Several clients concurrently add random users to a few groups and read
+// them back, all the calls are recorded and the resulting history is checked
+// against the sequential specification of a group.
+func Test_Linearizability_RandomWorkload(t *testing.T) {
+	const (
+		groupCount     = 3
+		clientCount    = 8
+		opsPerClient   = 15
+		candidateUsers = 2 * domain.MaxMembers
+	)
+
+	seed := time.Now().UnixNano()
+	t.Logf("using seed %d", seed)
+
+	fix := newFixture(t)
+	recorder := linearizability.NewRecorder(fix.app)
+
+	// GIVEN a few groups
+	groupIDs := make([]string, 0, groupCount)
+	for i := range groupCount {
+		id, err := recorder.CreateGroup(fix.ctx, fmt.Sprintf("owner_id_%d", i))
+		require.NoError(t, err)
+
+		groupIDs = append(groupIDs, id)
+	}
+
+	// WHEN several clients run random operations on them concurrently
+	var wg sync.WaitGroup
+	wg.Add(clientCount)
+
+	for c := range clientCount {
+		//nolint:gosec // weak random generation is ok here
+		rnd := rand.New(rand.NewSource(seed + int64(c)))
+
+		go func() {
+			defer wg.Done()
+
+			for range opsPerClient {
+				groupID := groupIDs[rnd.Intn(len(groupIDs))]
+
+				if rnd.Intn(2) == 0 {
+					_, _ = recorder.GetGroup(fix.ctx, groupID)
+					continue
+				}
+
+				userID := fmt.Sprintf("user_id_%02d", rnd.Intn(candidateUsers))
+				_ = recorder.AddUserToGroup(fix.ctx, userID, groupID, application.EnableTransactions{})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// THEN the history is linearizable
+	result := linearizability.Check(recorder.History())
+	require.Truef(t, result.Linearizable, "history is not linearizable:\n%s", describe(result))
+}
+
+// Test the linearizability checker detects the lost updates that happen when
+// adding users concurrently without transactions.
+func Test_Linearizability_LostUpdatesWithoutTransactions(t *testing.T) {
+	const userCount = 4
+
+	fix := newFixture(t)
+	recorder := linearizability.NewRecorder(fix.app)
+
+	// GIVEN a group
+	groupID, err := recorder.CreateGroup(fix.ctx, "some_owner_id")
+	require.NoError(t, err)
+
+	// GIVEN some users are added concurrently without transactions, with a
+	// delay that makes them race with each other
+	var wg sync.WaitGroup
+	wg.Add(userCount)
+
+	for i := range userCount {
+		go func() {
+			defer wg.Done()
+
+			_ = recorder.AddUserToGroup(
+				fix.ctx,
+				fmt.Sprintf("user_id_%02d", i),
+				groupID,
+				application.DelayBeforeUpdating(500*time.Millisecond),
+			)
+		}()
+	}
+
+	wg.Wait()
+
+	// GIVEN we read the group afterwards
+	_, err = recorder.GetGroup(fix.ctx, groupID)
+	require.NoError(t, err)
+
+	// WHEN we check the history
+	result := linearizability.Check(recorder.History())
+
+	// THEN it is not linearizable
+	require.False(t, result.Linearizable)
+	t.Logf("counterexample found:\n%s", describe(result))
+}
+
+// describe returns a human readable description of a check result.
+func describe(result linearizability.Result) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "group %s, counterexample:\n", result.GroupID)
+	for _, op := range result.Counterexample {
+		fmt.Fprintf(&b, "\t%s\n", op)
+	}
+
+	fmt.Fprintf(&b, "longest partial linearization:\n")
+	for _, op := range result.Partial {
+		fmt.Fprintf(&b, "\t%s\n", op)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,45 @@
+// Package codec encodes groups in stable, versioned formats, JSON and
+// protobuf, to export them or send them over messaging, independently of
+// how they are stored.
+//
+// Decoding is strict: unknown fields, unsupported versions and data that does
+// not represent a valid group are rejected with domain.ErrInvalidArgument.
+//
+// The formats are covered by golden files in testdata, any change in the
+// encoded data makes the tests fail: compatible changes require updating
+// the golden files, with go test -update, and incompatible ones require a
+// new version.
+package codec
+
+import (
+	"fmt"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+//go:generate buf generate
+
+// Version is the version of the formats written by this package, which is
+// also the only version it can read.
+const Version = 1
+
+// regenerate returns the group represented by s, or a
+// domain.ErrInvalidArgument error if it is not valid.
+func regenerate(s *domain.GroupSnapshot) (*domain.Group, error) {
+	group, err := s.Regenerate()
+	if err != nil {
+		return nil, domain.NewError(domain.ErrInvalidArgument, s.ID, fmt.Errorf("invalid group: %v", err))
+	}
+
+	return group, nil
+}
+
+// checkVersion returns a domain.ErrInvalidArgument error if v is not a
+// supported version.
+func checkVersion(v uint32) error {
+	if v != Version {
+		return domain.Errorf(domain.ErrInvalidArgument, "", "unsupported version %d, want %d", v, Version)
+	}
+
+	return nil
+}
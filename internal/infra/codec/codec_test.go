@@ -0,0 +1,63 @@
+package codec_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var now = time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+
+// fixtureGroup returns a group using every field of the formats.
+func fixtureGroup(t *testing.T) *domain.Group {
+	t.Helper()
+
+	group := domain.NewGroup("group_id", "owner_id", now)
+
+	err := group.AddMember("user_id", now.Add(time.Minute))
+	require.NoError(t, err)
+
+	details := domain.Details{
+		Name:        "name",
+		Description: "description",
+		Tags:        []string{"a", "b"},
+	}
+
+	err = group.SetDetails(details, now.Add(time.Hour))
+	require.NoError(t, err)
+
+	return group
+}
+
+// golden returns the contents of the golden file with the given name, after
+// overwriting it with got if the tests run with the -update flag.
+func golden(t *testing.T, name string, got []byte) []byte {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+
+	if *update {
+		err := os.WriteFile(path, got, 0o644)
+		require.NoError(t, err)
+	}
+
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return want
+}
+
+// requireInvalidArgument checks err is a domain.ErrInvalidArgument error.
+func requireInvalidArgument(t *testing.T, err error) {
+	t.Helper()
+
+	require.Error(t, err)
+	require.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
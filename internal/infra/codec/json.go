@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// jsonGroup is the JSON representation of a group.
+//
+// Times are written in RFC 3339 format, in UTC, and omitted if unknown.
+type jsonGroup struct {
+	Version     uint32       `json:"version"`
+	ID          string       `json:"id"`
+	OwnerID     string       `json:"owner_id"`
+	Members     []jsonMember `json:"members"`
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	CreatedAt   *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time   `json:"updated_at,omitempty"`
+}
+
+type jsonMember struct {
+	ID       string     `json:"id"`
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+}
+
+// MarshalJSON returns the JSON encoding of the group.
+func MarshalJSON(group *domain.Group) ([]byte, error) {
+	s := group.Snapshot()
+
+	j := jsonGroup{
+		Version:     Version,
+		ID:          s.ID,
+		OwnerID:     s.OwnerID,
+		Members:     make([]jsonMember, len(s.Members)),
+		Name:        s.Name,
+		Description: s.Description,
+		Tags:        s.Tags,
+		CreatedAt:   jsonTime(s.CreatedAt),
+		UpdatedAt:   jsonTime(s.UpdatedAt),
+	}
+
+	for i, id := range s.Members {
+		j.Members[i] = jsonMember{ID: id, JoinedAt: jsonTime(s.JoinedAt[i])}
+	}
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return nil, fmt.Errorf("encoding group %s: %v", s.ID, err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalJSON returns the group encoded in data by MarshalJSON.
+//
+// Errors:
+//   - domain.ErrInvalidArgument if data is not a single JSON object with
+//     the known fields of a supported version, or if it does not represent
+//     a valid group.
+func UnmarshalJSON(data []byte) (*domain.Group, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var j jsonGroup
+	if err := dec.Decode(&j); err != nil {
+		return nil, domain.Errorf(domain.ErrInvalidArgument, "", "decoding group: %v", err)
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, domain.Errorf(domain.ErrInvalidArgument, j.ID, "unexpected data after the group")
+	}
+
+	if err := checkVersion(j.Version); err != nil {
+		return nil, err
+	}
+
+	s := &domain.GroupSnapshot{
+		ID:          j.ID,
+		OwnerID:     j.OwnerID,
+		Members:     make([]string, len(j.Members)),
+		JoinedAt:    make([]time.Time, len(j.Members)),
+		Name:        j.Name,
+		Description: j.Description,
+		Tags:        j.Tags,
+		CreatedAt:   fromJSONTime(j.CreatedAt),
+		UpdatedAt:   fromJSONTime(j.UpdatedAt),
+	}
+
+	for i, m := range j.Members {
+		s.Members[i] = m.ID
+		s.JoinedAt[i] = fromJSONTime(m.JoinedAt)
+	}
+
+	return regenerate(s)
+}
+
+// jsonTime returns t in UTC, or nil if it is the zero time.
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	t = t.UTC()
+
+	return &t
+}
+
+// fromJSONTime returns the time pointed by t in UTC, the zero time if nil.
+func fromJSONTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.UTC()
+}
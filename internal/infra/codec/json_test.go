@@ -0,0 +1,123 @@
+package codec_test
+
+import (
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSON_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name  string
+		group func(*testing.T) *domain.Group
+	}{
+		{
+			name:  "all fields",
+			group: fixtureGroup,
+		},
+		{
+			name: "only the owner",
+			group: func(*testing.T) *domain.Group {
+				return domain.NewGroup("group_id", "owner_id", now)
+			},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a group
+			group := test.group(t)
+
+			// WHEN we encode and decode it
+			data, err := codec.MarshalJSON(group)
+			require.NoError(t, err)
+
+			got, err := codec.UnmarshalJSON(data)
+			require.NoError(t, err)
+
+			// THEN we get the same group
+			require.Equal(t, group.Snapshot(), got.Snapshot())
+		})
+	}
+}
+
+// Tests the JSON format does not change, which would break the consumers of
+// the groups already exported.
+func TestJSON_Golden(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN the encoding of a group
+	data, err := codec.MarshalJSON(fixtureGroup(t))
+	require.NoError(t, err)
+
+	// THEN it is the same as the golden file
+	want := golden(t, "group_v1.json", data)
+	require.JSONEq(t, string(want), string(data))
+
+	// WHEN we decode the golden file
+	got, err := codec.UnmarshalJSON(want)
+	require.NoError(t, err)
+
+	// THEN we get the group
+	require.Equal(t, fixtureGroup(t).Snapshot(), got.Snapshot())
+}
+
+func TestUnmarshalJSON_Invalid(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "not json",
+			data: `not json`,
+		},
+		{
+			name: "unknown field",
+			data: `{"version":1,"id":"g","owner_id":"o","members":[{"id":"o"}],"color":"red"}`,
+		},
+		{
+			name: "unknown member field",
+			data: `{"version":1,"id":"g","owner_id":"o","members":[{"id":"o","role":"admin"}]}`,
+		},
+		{
+			name: "trailing data",
+			data: `{"version":1,"id":"g","owner_id":"o","members":[{"id":"o"}]}{}`,
+		},
+		{
+			name: "missing version",
+			data: `{"id":"g","owner_id":"o","members":[{"id":"o"}]}`,
+		},
+		{
+			name: "unsupported version",
+			data: `{"version":2,"id":"g","owner_id":"o","members":[{"id":"o"}]}`,
+		},
+		{
+			name: "invalid group",
+			data: `{"version":1,"id":"g","owner_id":"o","members":[{"id":"a"}]}`,
+		},
+		{
+			name: "invalid time",
+			data: `{"version":1,"id":"g","owner_id":"o","members":[{"id":"o"}],"created_at":"yesterday"}`,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// WHEN we decode invalid data
+			_, err := codec.UnmarshalJSON([]byte(test.data))
+
+			// THEN we get an invalid argument error
+			requireInvalidArgument(t, err)
+		})
+	}
+}
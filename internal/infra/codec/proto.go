@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec/codecpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MarshalProto returns the protobuf encoding of the group, a
+// codecpb.GroupSnapshot message.
+//
+// The encoding is deterministic, so the same group is always encoded the
+// same way by the same binary.
+func MarshalProto(group *domain.Group) ([]byte, error) {
+	s := group.Snapshot()
+
+	msg := &codecpb.GroupSnapshot{
+		Version:     Version,
+		Id:          s.ID,
+		OwnerId:     s.OwnerID,
+		Members:     make([]*codecpb.Member, len(s.Members)),
+		Name:        s.Name,
+		Description: s.Description,
+		Tags:        s.Tags,
+		CreatedAt:   protoTime(s.CreatedAt),
+		UpdatedAt:   protoTime(s.UpdatedAt),
+	}
+
+	for i, id := range s.Members {
+		msg.Members[i] = &codecpb.Member{Id: id, JoinedAt: protoTime(s.JoinedAt[i])}
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encoding group %s: %v", s.ID, err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalProto returns the group encoded in data by MarshalProto.
+//
+// Errors:
+//   - domain.ErrInvalidArgument if data is not a codecpb.GroupSnapshot
+//     message of a supported version without unknown fields, or if it does
+//     not represent a valid group.
+func UnmarshalProto(data []byte) (*domain.Group, error) {
+	var msg codecpb.GroupSnapshot
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return nil, domain.Errorf(domain.ErrInvalidArgument, "", "decoding group: %v", err)
+	}
+
+	if err := checkVersion(msg.GetVersion()); err != nil {
+		return nil, err
+	}
+
+	if hasUnknownFields(&msg) {
+		return nil, domain.Errorf(domain.ErrInvalidArgument, msg.GetId(), "decoding group: unknown fields")
+	}
+
+	s := &domain.GroupSnapshot{
+		ID:          msg.GetId(),
+		OwnerID:     msg.GetOwnerId(),
+		Members:     make([]string, len(msg.GetMembers())),
+		JoinedAt:    make([]time.Time, len(msg.GetMembers())),
+		Name:        msg.GetName(),
+		Description: msg.GetDescription(),
+		Tags:        msg.GetTags(),
+		CreatedAt:   fromProtoTime(msg.GetCreatedAt()),
+		UpdatedAt:   fromProtoTime(msg.GetUpdatedAt()),
+	}
+
+	for i, m := range msg.GetMembers() {
+		s.Members[i] = m.GetId()
+		s.JoinedAt[i] = fromProtoTime(m.GetJoinedAt())
+	}
+
+	if len(s.Tags) == 0 {
+		s.Tags = nil
+	}
+
+	return regenerate(s)
+}
+
+// hasUnknownFields returns if the message, or any of its members, has fields
+// unknown to this version of the format.
+func hasUnknownFields(msg *codecpb.GroupSnapshot) bool {
+	if len(msg.ProtoReflect().GetUnknown()) > 0 {
+		return true
+	}
+
+	for _, m := range msg.GetMembers() {
+		if len(m.ProtoReflect().GetUnknown()) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// protoTime returns t as a timestamp, or nil if it is the zero time.
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+// fromProtoTime returns the timestamp as a time in UTC, the zero time if
+// nil.
+func fromProtoTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
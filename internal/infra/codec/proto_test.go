@@ -0,0 +1,171 @@
+package codec_test
+
+import (
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec/codecpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProto_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name  string
+		group func(*testing.T) *domain.Group
+	}{
+		{
+			name:  "all fields",
+			group: fixtureGroup,
+		},
+		{
+			name: "only the owner",
+			group: func(*testing.T) *domain.Group {
+				return domain.NewGroup("group_id", "owner_id", now)
+			},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a group
+			group := test.group(t)
+
+			// WHEN we encode and decode it
+			data, err := codec.MarshalProto(group)
+			require.NoError(t, err)
+
+			got, err := codec.UnmarshalProto(data)
+			require.NoError(t, err)
+
+			// THEN we get the same group
+			require.Equal(t, group.Snapshot(), got.Snapshot())
+		})
+	}
+}
+
+// Tests the protobuf format does not change, which would break the consumers
+// of the groups already sent.
+func TestProto_Golden(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN the encoding of a group
+	data, err := codec.MarshalProto(fixtureGroup(t))
+	require.NoError(t, err)
+
+	// THEN it is the same as the golden file
+	want := golden(t, "group_v1.binpb", data)
+	require.Equal(t, want, data)
+
+	// WHEN we decode the golden file
+	got, err := codec.UnmarshalProto(want)
+	require.NoError(t, err)
+
+	// THEN we get the group
+	require.Equal(t, fixtureGroup(t).Snapshot(), got.Snapshot())
+}
+
+func TestUnmarshalProto_Invalid(t *testing.T) {
+	t.Parallel()
+
+	valid := func() *codecpb.GroupSnapshot {
+		return &codecpb.GroupSnapshot{
+			Version: codec.Version,
+			Id:      "g",
+			OwnerId: "o",
+			Members: []*codecpb.Member{{Id: "o"}},
+		}
+	}
+
+	marshal := func(t *testing.T, msg *codecpb.GroupSnapshot) []byte {
+		t.Helper()
+
+		data, err := proto.Marshal(msg)
+		require.NoError(t, err)
+
+		return data
+	}
+
+	// unknownField adds a field that is not in the message.
+	unknownField := func(m proto.Message) {
+		var raw []byte
+		raw = protowire.AppendTag(raw, 99, protowire.VarintType)
+		raw = protowire.AppendVarint(raw, 1)
+		m.ProtoReflect().SetUnknown(raw)
+	}
+
+	subtests := []struct {
+		name string
+		data func(*testing.T) []byte
+	}{
+		{
+			name: "not protobuf",
+			data: func(*testing.T) []byte {
+				return []byte{0xff, 0xff, 0xff}
+			},
+		},
+		{
+			name: "unknown field",
+			data: func(t *testing.T) []byte {
+				msg := valid()
+				unknownField(msg)
+
+				return marshal(t, msg)
+			},
+		},
+		{
+			name: "unknown member field",
+			data: func(t *testing.T) []byte {
+				msg := valid()
+				unknownField(msg.Members[0])
+
+				return marshal(t, msg)
+			},
+		},
+		{
+			name: "missing version",
+			data: func(t *testing.T) []byte {
+				msg := valid()
+				msg.Version = 0
+
+				return marshal(t, msg)
+			},
+		},
+		{
+			name: "unsupported version",
+			data: func(t *testing.T) []byte {
+				msg := valid()
+				msg.Version = 2
+
+				return marshal(t, msg)
+			},
+		},
+		{
+			name: "invalid group",
+			data: func(t *testing.T) []byte {
+				msg := valid()
+				msg.Members = []*codecpb.Member{{Id: "a"}}
+
+				return marshal(t, msg)
+			},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// WHEN we decode invalid data
+			_, err := codec.UnmarshalProto(test.data(t))
+
+			// THEN we get an invalid argument error
+			requireInvalidArgument(t, err)
+		})
+	}
+}
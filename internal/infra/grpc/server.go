@@ -0,0 +1,207 @@
+// Package grpc exposes the application as a gRPC service, see
+// grouppb/group.proto.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/grpc/grouppb"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate buf generate
+
+// App is the subset of application.App served by the Server.
+type App interface {
+	CreateGroup(ctx context.Context, ownerID string, options ...application.Option) (string, error)
+	GetGroup(ctx context.Context, groupID string) (*domain.Group, error)
+	AddUserToGroup(ctx context.Context, userID, groupID string, options ...application.Option) error
+}
+
+// Server implements grouppb.GroupServiceServer over an App.
+//
+// Members are always added using transactions, so concurrent calls do not
+// lose updates.
+//
+// The errors returned to the clients only have a fixed message for each
+// status code, their details are logged instead, see WithLogger.
+type Server struct {
+	grouppb.UnimplementedGroupServiceServer
+	app    App
+	logger *slog.Logger
+}
+
+var _ grouppb.GroupServiceServer = (*Server)(nil)
+
+type Option func(*Server)
+
+// WithLogger sets the logger used by the Server to log the details of the
+// errors returned to the clients. The attributes carried by the context are
+// added to every record.
+//
+// By default, the Server does not log anything.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logging.NewLogger(l)
+	}
+}
+
+func NewServer(app App, options ...Option) *Server {
+	s := &Server{
+		app:    app,
+		logger: logging.Discard(),
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+func (s *Server) CreateGroup(
+	ctx context.Context,
+	req *grouppb.CreateGroupRequest,
+) (*grouppb.CreateGroupResponse, error) {
+	if req.GetOwnerId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing owner_id")
+	}
+
+	var options []application.Option
+
+	if req.GetGroupId() != "" {
+		options = append(options, application.UseGroupID(req.GetGroupId()))
+	}
+
+	if req.GetIfAbsent() {
+		if req.GetGroupId() == "" {
+			return nil, status.Error(codes.InvalidArgument, "if_absent requires a group_id")
+		}
+
+		options = append(options, application.CreateIfAbsent{})
+	}
+
+	groupID, err := s.app.CreateGroup(ctx, req.GetOwnerId(), options...)
+	if err != nil {
+		return nil, s.statusError(ctx, err)
+	}
+
+	return &grouppb.CreateGroupResponse{
+		GroupId: groupID,
+	}, nil
+}
+
+func (s *Server) GetGroup(
+	ctx context.Context,
+	req *grouppb.GetGroupRequest,
+) (*grouppb.GetGroupResponse, error) {
+	if req.GetGroupId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing group_id")
+	}
+
+	group, err := s.app.GetGroup(ctx, req.GetGroupId())
+	if err != nil {
+		return nil, s.statusError(ctx, err)
+	}
+
+	return &grouppb.GetGroupResponse{
+		Group: &grouppb.Group{
+			Id:      group.ID(),
+			OwnerId: group.OwnerID(),
+			Members: group.Members(),
+		},
+	}, nil
+}
+
+func (s *Server) AddMember(
+	ctx context.Context,
+	req *grouppb.AddMemberRequest,
+) (*grouppb.AddMemberResponse, error) {
+	switch {
+	case req.GetGroupId() == "":
+		return nil, status.Error(codes.InvalidArgument, "missing group_id")
+	case req.GetUserId() == "":
+		return nil, status.Error(codes.InvalidArgument, "missing user_id")
+	}
+
+	err := s.app.AddUserToGroup(ctx, req.GetUserId(), req.GetGroupId(), application.EnableTransactions{})
+	if err != nil {
+		return nil, s.statusError(ctx, err)
+	}
+
+	return &grouppb.AddMemberResponse{}, nil
+}
+
+// statusMessages are the messages returned to the clients for each status
+// code, so no details of the internal layers leak to them.
+var statusMessages = map[codes.Code]string{
+	codes.NotFound:           "group not found",
+	codes.FailedPrecondition: "group full",
+	codes.AlreadyExists:      "group already exists",
+	codes.InvalidArgument:    "invalid argument",
+	codes.Aborted:            "concurrent modification, try again",
+	codes.Unavailable:        "service unavailable, try again later",
+	codes.Canceled:           "request cancelled",
+	codes.DeadlineExceeded:   "deadline exceeded",
+	codes.Internal:           "internal error",
+}
+
+// preconditionMessage is the message for the errors of kind
+// domain.ErrFailedPrecondition, which share their code with the full groups.
+const preconditionMessage = "operation not available"
+
+// statusError returns the gRPC status error for the given application
+// error, with a fixed message for its code, and logs the error.
+func (s *Server) statusError(ctx context.Context, err error) error {
+	code := statusCode(err)
+
+	level := slog.LevelInfo
+	if code == codes.Internal {
+		level = slog.LevelError
+	}
+
+	s.logger.LogAttrs(ctx, level, "request failed",
+		slog.String("code", code.String()),
+		slog.Any("error", err),
+	)
+
+	msg := statusMessages[code]
+	if domain.KindOf(err) == domain.ErrFailedPrecondition {
+		msg = preconditionMessage
+	}
+
+	return status.Error(code, msg)
+}
+
+// statusCode returns the gRPC status code for the given application error.
+func statusCode(err error) codes.Code {
+	switch domain.KindOf(err) {
+	case domain.ErrNotFound:
+		return codes.NotFound
+	case domain.ErrGroupFull, domain.ErrFailedPrecondition:
+		return codes.FailedPrecondition
+	case domain.ErrAlreadyExists:
+		return codes.AlreadyExists
+	case domain.ErrInvalidArgument:
+		return codes.InvalidArgument
+	case domain.ErrTransientTransaction, domain.ErrConflict:
+		return codes.Aborted
+	case domain.ErrTooManyTransactionRetries, domain.ErrUnavailable:
+		return codes.Unavailable
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,331 @@
+package grpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	groupgrpc "github.com/alcortesm/demo-mongodb-transactions/internal/infra/grpc"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/grpc/grouppb"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fixture struct {
+	// a context with a timeout you can use in your tests
+	ctx    context.Context
+	client grouppb.GroupServiceClient
+}
+
+// newFixture serves app through an in-memory connection, with the given
+// options, and returns a client connected to it.
+func newFixture(t *testing.T, app groupgrpc.App, options ...groupgrpc.Option) *fixture {
+	t.Helper()
+
+	const timeout = 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+
+	const bufSize = 1024 * 1024
+	lis := bufconn.Listen(bufSize)
+
+	server := grpc.NewServer()
+	grouppb.RegisterGroupServiceServer(server, groupgrpc.NewServer(app, options...))
+
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return &fixture{
+		ctx:    ctx,
+		client: grouppb.NewGroupServiceClient(conn),
+	}
+}
+
+// newMemoryApp returns an app over an in-memory store.
+func newMemoryApp() *application.App {
+	return application.New(&sequentialUuider{}, memory.NewGroupRepo())
+}
+
+type sequentialUuider struct {
+	n int
+}
+
+func (u *sequentialUuider) NewString() string {
+	u.n++
+	return fmt.Sprintf("group_%d", u.n)
+}
+
+// Tests you can create a group, add members to it and get it.
+func TestServer_HappyPath(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t, newMemoryApp())
+
+	// GIVEN a group
+	created, err := fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{
+		OwnerId: "owner_id",
+	})
+	require.NoError(t, err)
+
+	// WHEN we add a member to it
+	_, err = fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{
+		GroupId: created.GetGroupId(),
+		UserId:  "user_id",
+	})
+	require.NoError(t, err)
+
+	// THEN we get the group with the new member
+	got, err := fix.client.GetGroup(fix.ctx, &grouppb.GetGroupRequest{
+		GroupId: created.GetGroupId(),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, created.GetGroupId(), got.GetGroup().GetId())
+	require.Equal(t, "owner_id", got.GetGroup().GetOwnerId())
+	require.Equal(t, []string{"owner_id", "user_id"}, got.GetGroup().GetMembers())
+}
+
+// Tests clients can choose the id of their groups and retry their creation.
+func TestServer_CreateGroupWithID(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t, newMemoryApp())
+
+	req := &grouppb.CreateGroupRequest{
+		OwnerId:  "owner_id",
+		GroupId:  "my_group_id",
+		IfAbsent: true,
+	}
+
+	// GIVEN a group created with a client supplied id
+	created, err := fix.client.CreateGroup(fix.ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "my_group_id", created.GetGroupId())
+
+	// WHEN we retry the creation
+	retried, err := fix.client.CreateGroup(fix.ctx, req)
+
+	// THEN it succeeds with the same group
+	require.NoError(t, err)
+	require.Equal(t, "my_group_id", retried.GetGroupId())
+
+	// WHEN we create it again without if_absent
+	_, err = fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{
+		OwnerId: "owner_id",
+		GroupId: "my_group_id",
+	})
+
+	// THEN we get AlreadyExists
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// WHEN we ask for if_absent without a group id
+	_, err = fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{
+		OwnerId:  "owner_id",
+		IfAbsent: true,
+	})
+
+	// THEN we get InvalidArgument
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// Tests adding a member to a full group fails with FailedPrecondition.
+func TestServer_FullGroup(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t, newMemoryApp())
+
+	// GIVEN a full group
+	created, err := fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{
+		OwnerId: "owner_id",
+	})
+	require.NoError(t, err)
+
+	for i := range domain.MaxMembers - 1 {
+		_, err = fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{
+			GroupId: created.GetGroupId(),
+			UserId:  fmt.Sprintf("member_id_%d", i),
+		})
+		require.NoErrorf(t, err, "adding member %d", i)
+	}
+
+	// WHEN we add another member
+	_, err = fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{
+		GroupId: created.GetGroupId(),
+		UserId:  "new_user_id",
+	})
+
+	// THEN we get FailedPrecondition
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// Tests the domain errors are mapped to their gRPC status codes.
+func TestServer_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want codes.Code
+	}{
+		"not found": {
+			err:  fmt.Errorf("loading: %w", domain.ErrNotFound),
+			want: codes.NotFound,
+		},
+		"group full": {
+			err:  fmt.Errorf("adding: %w", domain.ErrGroupFull),
+			want: codes.FailedPrecondition,
+		},
+		"failed precondition": {
+			err:  domain.Errorf(domain.ErrFailedPrecondition, "", "the audit trail is not enabled"),
+			want: codes.FailedPrecondition,
+		},
+		"transient transaction": {
+			err:  domain.ErrTransientTransaction,
+			want: codes.Aborted,
+		},
+		"too many transaction retries": {
+			err:  domain.ErrTooManyTransactionRetries,
+			want: codes.Unavailable,
+		},
+		"already exists": {
+			err:  fmt.Errorf("creating: %w", domain.NewError(domain.ErrAlreadyExists, "group_id", nil)),
+			want: codes.AlreadyExists,
+		},
+		"invalid argument": {
+			err:  domain.NewError(domain.ErrInvalidArgument, "group_id", errors.New("empty user id")),
+			want: codes.InvalidArgument,
+		},
+		"conflict": {
+			err:  fmt.Errorf("updating: %w", domain.NewError(domain.ErrConflict, "group_id", nil)),
+			want: codes.Aborted,
+		},
+		"unavailable": {
+			err:  fmt.Errorf("loading: %w", domain.NewError(domain.ErrUnavailable, "group_id", nil)),
+			want: codes.Unavailable,
+		},
+		"canceled": {
+			err:  fmt.Errorf("loading: %w", context.Canceled),
+			want: codes.Canceled,
+		},
+		"unknown": {
+			err:  fmt.Errorf("some error"),
+			want: codes.Internal,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN an app that fails with the error
+			fix := newFixture(t, failingApp{err: test.err})
+
+			// WHEN we call each method
+			_, createErr := fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{OwnerId: "owner_id"})
+			_, getErr := fix.client.GetGroup(fix.ctx, &grouppb.GetGroupRequest{GroupId: "group_id"})
+			_, addErr := fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{GroupId: "group_id", UserId: "user_id"})
+
+			// THEN they all fail with the expected code
+			require.Equal(t, test.want, status.Code(createErr), "CreateGroup")
+			require.Equal(t, test.want, status.Code(getErr), "GetGroup")
+			require.Equal(t, test.want, status.Code(addErr), "AddMember")
+		})
+	}
+}
+
+// Tests the errors returned to the clients do not leak the details of the
+// internal layers, which are logged instead.
+func TestServer_ErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app that fails with an error with internal details
+	const detail = "collection secret_groups: connection refused"
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	app := failingApp{err: domain.NewError(domain.ErrUnavailable, "group_id", errors.New(detail))}
+	fix := newFixture(t, app, groupgrpc.WithLogger(logger))
+
+	// WHEN we call it
+	_, err := fix.client.GetGroup(fix.ctx, &grouppb.GetGroupRequest{GroupId: "group_id"})
+
+	// THEN the client gets a fixed message for the code
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, "service unavailable, try again later", status.Convert(err).Message())
+
+	// THEN the details are logged
+	require.Contains(t, logs.String(), detail)
+}
+
+// Tests the failed preconditions are not reported as full groups, even if
+// they share the same code.
+func TestServer_FailedPrecondition(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app that fails with a precondition error
+	app := failingApp{err: domain.Errorf(domain.ErrFailedPrecondition, "", "locking is not enabled")}
+	fix := newFixture(t, app)
+
+	// WHEN we call it
+	_, err := fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{GroupId: "group_id", UserId: "user_id"})
+
+	// THEN the client gets the message for the failed preconditions
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Equal(t, "operation not available", status.Convert(err).Message())
+}
+
+// Tests requests with missing fields are rejected.
+func TestServer_InvalidArgument(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t, newMemoryApp())
+
+	_, err := fix.client.CreateGroup(fix.ctx, &grouppb.CreateGroupRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "CreateGroup")
+
+	_, err = fix.client.GetGroup(fix.ctx, &grouppb.GetGroupRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "GetGroup")
+
+	_, err = fix.client.AddMember(fix.ctx, &grouppb.AddMemberRequest{GroupId: "group_id"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "AddMember")
+}
+
+// failingApp is an App where every call fails with err.
+type failingApp struct {
+	err error
+}
+
+func (a failingApp) CreateGroup(context.Context, string, ...application.Option) (string, error) {
+	return "", a.err
+}
+
+func (a failingApp) GetGroup(context.Context, string) (*domain.Group, error) {
+	return nil, a.err
+}
+
+func (a failingApp) AddUserToGroup(context.Context, string, string, ...application.Option) error {
+	return a.err
+}
@@ -0,0 +1,654 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// Step identifies the kind of access to the repository that is about to
+// happen, as seen by a step hook.
+type Step string
+
+const (
+	StepCreate Step = "create"
+	StepLoad   Step = "load"
+	StepUpdate Step = "update"
+	StepCommit Step = "commit"
+)
+
+// GroupRepo is an in-process implementation of application.Store.
+//
+// It supports transactions with snapshot isolation: reads inside a
+// transaction see the groups as they were when the transaction started and
+// writes to a group modified by a concurrent transaction fail with
+// domain.ErrTransientTransaction, the same way write conflicts behave in
+// MongoDB. The names of the groups of the same owner are unique.
+//
+// It also implements application.AuditLog, recording the entries in the same
+// transactions as the groups.
+type GroupRepo struct {
+	hook func(context.Context, Step)
+
+	mu sync.Mutex
+	// clock is the version of the last committed write.
+	clock uint64
+	// groups has the committed versions of each group, oldest first.
+	groups map[string][]version
+	// active are the transactions in progress.
+	active map[*transaction]struct{}
+	// audit has the committed audit entries of each group, sorted by key.
+	audit map[string][]domain.AuditEntry
+}
+
+// version is a committed state of a group.
+type version struct {
+	number   uint64
+	snapshot *domain.GroupSnapshot
+}
+
+type Option func(*GroupRepo)
+
+// WithStepHook sets a function that is called right before every access to
+// the repository, which can be used to control the interleaving of
+// concurrent calls.
+func WithStepHook(hook func(ctx context.Context, step Step)) Option {
+	return func(r *GroupRepo) {
+		r.hook = hook
+	}
+}
+
+func NewGroupRepo(options ...Option) *GroupRepo {
+	r := &GroupRepo{
+		hook:   func(context.Context, Step) {},
+		groups: map[string][]version{},
+		active: map[*transaction]struct{}{},
+		audit:  map[string][]domain.AuditEntry{},
+	}
+
+	for _, o := range options {
+		o(r)
+	}
+
+	return r
+}
+
+// txKey is the context key of the current transaction.
+type txKey struct{}
+
+type transaction struct {
+	// start is the version of the repository when the transaction started.
+	start  uint64
+	writes map[string]*domain.GroupSnapshot
+	// entries are the audit entries recorded in the transaction.
+	entries []domain.AuditEntry
+}
+
+func currentTransaction(ctx context.Context) (*transaction, bool) {
+	tx, ok := ctx.Value(txKey{}).(*transaction)
+	return tx, ok
+}
+
+// Create stores the group as a new group.
+//
+// Error:
+//   - domain.ErrAlreadyExists if there is already a group with the same ID.
+//   - domain.ErrTransientTransaction if the operation failed during a transaction
+//     that can be retried.
+func (r *GroupRepo) Create(ctx context.Context, group *domain.Group) error {
+	r.hook(ctx, StepCreate)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tx, ok := currentTransaction(ctx); ok {
+		if err := r.checkConflict(tx, group.ID()); err != nil {
+			return err
+		}
+
+		if r.read(tx, group.ID()) != nil {
+			return domain.NewError(domain.ErrAlreadyExists, group.ID(), nil)
+		}
+
+		tx.writes[group.ID()] = group.Snapshot()
+
+		return nil
+	}
+
+	if r.latest(group.ID()) != nil {
+		return domain.NewError(domain.ErrAlreadyExists, group.ID(), nil)
+	}
+
+	writes := map[string]*domain.GroupSnapshot{group.ID(): group.Snapshot()}
+	if err := r.checkUniqueNames(writes); err != nil {
+		return err
+	}
+
+	r.commit(writes)
+
+	return nil
+}
+
+// Update overwrites the group.
+//
+// Error:
+//   - domain.ErrNotFound if the group is not found
+//   - domain.ErrAlreadyExists if the owner has another group with the same
+//     name.
+//   - domain.ErrTransientTransaction if the operation failed during a transaction
+//     that can be retried.
+func (r *GroupRepo) Update(ctx context.Context, group *domain.Group) error {
+	r.hook(ctx, StepUpdate)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tx, ok := currentTransaction(ctx); ok {
+		if err := r.checkConflict(tx, group.ID()); err != nil {
+			return err
+		}
+
+		if r.read(tx, group.ID()) == nil {
+			return domain.NewError(domain.ErrNotFound, group.ID(), nil)
+		}
+
+		tx.writes[group.ID()] = group.Snapshot()
+
+		return nil
+	}
+
+	if r.latest(group.ID()) == nil {
+		return domain.NewError(domain.ErrNotFound, group.ID(), nil)
+	}
+
+	writes := map[string]*domain.GroupSnapshot{group.ID(): group.Snapshot()}
+	if err := r.checkUniqueNames(writes); err != nil {
+		return err
+	}
+
+	r.commit(writes)
+
+	return nil
+}
+
+// UpdateDetails stores the details of the group, without modifying its
+// members, only if the stored details are still the previous ones.
+//
+// It implements application.DetailsUpdater.
+//
+// Errors:
+//   - domain.ErrNotFound if the group is not found.
+//   - domain.ErrConflict if the stored details are not the previous ones.
+//   - domain.ErrAlreadyExists if the owner has another group with the same
+//     name.
+//   - domain.ErrTransientTransaction if the operation failed during a
+//     transaction that can be retried.
+func (r *GroupRepo) UpdateDetails(ctx context.Context, group *domain.Group, previous domain.Details) error {
+	r.hook(ctx, StepUpdate)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, inTransaction := currentTransaction(ctx)
+
+	var current *domain.GroupSnapshot
+
+	if inTransaction {
+		if err := r.checkConflict(tx, group.ID()); err != nil {
+			return err
+		}
+
+		current = r.read(tx, group.ID())
+	} else {
+		current = r.latest(group.ID())
+	}
+
+	if current == nil {
+		return domain.NewError(domain.ErrNotFound, group.ID(), nil)
+	}
+
+	stored := domain.Details{Name: current.Name, Description: current.Description, Tags: current.Tags}
+	if !stored.Equal(previous) {
+		return domain.Errorf(domain.ErrConflict, group.ID(), "details changed concurrently")
+	}
+
+	details := group.Details()
+
+	updated := *current
+	updated.Name = details.Name
+	updated.Description = details.Description
+	updated.Tags = details.Tags
+	updated.UpdatedAt = group.UpdatedAt()
+
+	if inTransaction {
+		tx.writes[group.ID()] = &updated
+		return nil
+	}
+
+	writes := map[string]*domain.GroupSnapshot{group.ID(): &updated}
+	if err := r.checkUniqueNames(writes); err != nil {
+		return err
+	}
+
+	r.commit(writes)
+
+	return nil
+}
+
+// Load returns the group with the given id.
+//
+// Errors:
+//   - domain.ErrNotFound if there is no group with the given ID
+func (r *GroupRepo) Load(ctx context.Context, id string) (*domain.Group, error) {
+	r.hook(ctx, StepLoad)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var snapshot *domain.GroupSnapshot
+	if tx, ok := currentTransaction(ctx); ok {
+		snapshot = r.read(tx, id)
+	} else {
+		snapshot = r.latest(id)
+	}
+
+	if snapshot == nil {
+		return nil, domain.NewError(domain.ErrNotFound, id, nil)
+	}
+
+	return snapshot.Regenerate()
+}
+
+// Record appends the entry to the history of its group, when the current
+// transaction commits, if any.
+//
+// It implements application.AuditLog.
+func (r *GroupRepo) Record(ctx context.Context, entry domain.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tx, ok := currentTransaction(ctx); ok {
+		tx.entries = append(tx.entries, entry)
+		return nil
+	}
+
+	r.appendEntry(entry)
+
+	return nil
+}
+
+// History returns up to limit committed entries of the history of the
+// group, sorted by key, starting right after the given key.
+//
+// It implements application.AuditLog.
+func (r *GroupRepo) History(
+	_ context.Context,
+	groupID string,
+	after domain.AuditKey,
+	limit int,
+) ([]domain.AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []domain.AuditEntry
+
+	for _, entry := range r.audit[groupID] {
+		if len(result) == limit {
+			break
+		}
+
+		if after.Less(entry.Key()) {
+			result = append(result, entry)
+		}
+	}
+
+	return result, nil
+}
+
+// WithTransaction executes callback inside a transaction. If the callback
+// or the commit returns domain.ErrTransientTransaction it will be retried up
+// to maxRetries times.
+//
+// The transaction is not committed, and no more attempts are made, once the
+// context is done.
+//
+// Errors:
+//   - domain.ErrTooManyTransactionRetries if the transaction has failed
+//     more than maxRetries times.
+//   - the error of the context, wrapped, if it is done before the
+//     transaction commits.
+//   - whatever non-ErrTransientTransaction errors the callback returns.
+func (r *GroupRepo) WithTransaction(
+	ctx context.Context,
+	callback func(context.Context) error,
+	maxRetries uint,
+) error {
+	for i := range maxRetries {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("transaction cancelled after %d attempts: %w", i, err)
+		}
+
+		switch err := r.attempt(ctx, callback); {
+		case err == nil:
+			return nil
+		case errors.Is(err, domain.ErrTransientTransaction):
+			continue
+		default:
+			return err
+		}
+	}
+
+	return domain.ErrTooManyTransactionRetries
+}
+
+// attempt runs the callback in a new transaction and commits it.
+func (r *GroupRepo) attempt(ctx context.Context, callback func(context.Context) error) error {
+	tx := r.begin()
+	defer r.end(tx)
+
+	if err := callback(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	return r.commitTransaction(ctx, tx)
+}
+
+// GroupIDs returns the ids of all the committed groups in alphabetical order.
+func (r *GroupRepo) GroupIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]string, 0, len(r.groups))
+	for id := range r.groups {
+		result = append(result, id)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Each calls fn with every committed group, sorted by id, stopping at the
+// first error it returns.
+//
+// It implements transfer.Source.
+func (r *GroupRepo) Each(ctx context.Context, fn func(*domain.Group) error) error {
+	for _, id := range r.GroupIDs() {
+		r.mu.Lock()
+		snapshot := r.latest(id)
+		r.mu.Unlock()
+
+		group, err := snapshot.Regenerate()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Existing returns the ids, among the given ones, of the committed groups.
+//
+// It implements transfer.Destination.
+func (r *GroupRepo) Existing(ctx context.Context, ids []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []string
+
+	for _, id := range ids {
+		if r.latest(id) != nil {
+			result = append(result, id)
+		}
+	}
+
+	return result, nil
+}
+
+// Put stores the groups, creating the missing ones and overwriting the
+// existing ones, all at once, outside of any transaction.
+//
+// It implements transfer.Destination.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if two groups of the same owner would have the
+//     same name.
+func (r *GroupRepo) Put(ctx context.Context, groups []*domain.Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writes := make(map[string]*domain.GroupSnapshot, len(groups))
+	for _, g := range groups {
+		writes[g.ID()] = g.Snapshot()
+	}
+
+	if err := r.checkUniqueNames(writes); err != nil {
+		return err
+	}
+
+	r.commit(writes)
+
+	return nil
+}
+
+// PutMissing stores the groups that do not exist, all at once, outside of
+// any transaction, and returns how many it has created. The existing groups
+// are left as they are.
+//
+// It implements transfer.Destination.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if two groups of the same owner would have the
+//     same name.
+func (r *GroupRepo) PutMissing(ctx context.Context, groups []*domain.Group) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writes := make(map[string]*domain.GroupSnapshot, len(groups))
+	for _, g := range groups {
+		if r.latest(g.ID()) == nil {
+			writes[g.ID()] = g.Snapshot()
+		}
+	}
+
+	if err := r.checkUniqueNames(writes); err != nil {
+		return 0, err
+	}
+
+	r.commit(writes)
+
+	return len(writes), nil
+}
+
+func (r *GroupRepo) begin() *transaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := &transaction{
+		start:  r.clock,
+		writes: map[string]*domain.GroupSnapshot{},
+	}
+
+	r.active[tx] = struct{}{}
+
+	return tx
+}
+
+func (r *GroupRepo) end(tx *transaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.active, tx)
+}
+
+func (r *GroupRepo) commitTransaction(ctx context.Context, tx *transaction) error {
+	r.hook(ctx, StepCommit)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("committing: %w", err)
+	}
+
+	for id := range tx.writes {
+		if err := r.checkConflict(tx, id); err != nil {
+			return err
+		}
+	}
+
+	if err := r.checkUniqueNames(tx.writes); err != nil {
+		return err
+	}
+
+	r.commit(tx.writes)
+
+	for _, entry := range tx.entries {
+		r.appendEntry(entry)
+	}
+
+	return nil
+}
+
+// commit makes the writes visible as a new version of the repository.
+func (r *GroupRepo) commit(writes map[string]*domain.GroupSnapshot) {
+	if len(writes) == 0 {
+		return
+	}
+
+	r.clock++
+
+	for id, snapshot := range writes {
+		r.groups[id] = append(r.groups[id], version{
+			number:   r.clock,
+			snapshot: snapshot,
+		})
+
+		r.prune(id)
+	}
+}
+
+// appendEntry adds the entry to the history of its group, keeping it sorted.
+func (r *GroupRepo) appendEntry(entry domain.AuditEntry) {
+	entries := r.audit[entry.GroupID]
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entry.Key().Less(entries[i].Key())
+	})
+
+	r.audit[entry.GroupID] = slices.Insert(entries, i, entry)
+}
+
+// prune forgets the versions of a group that are no longer visible to any
+// transaction.
+func (r *GroupRepo) prune(id string) {
+	oldest := r.clock
+	for tx := range r.active {
+		oldest = min(oldest, tx.start)
+	}
+
+	versions := r.groups[id]
+
+	keep := 0
+	for i, v := range versions {
+		if v.number <= oldest {
+			keep = i
+		}
+	}
+
+	r.groups[id] = versions[keep:]
+}
+
+// checkUniqueNames returns domain.ErrAlreadyExists if, after the writes, two
+// groups of the same owner would have the same name, like the unique index
+// of the MongoDB store.
+func (r *GroupRepo) checkUniqueNames(writes map[string]*domain.GroupSnapshot) error {
+	type key struct{ ownerID, name string }
+
+	names := map[key]string{}
+
+	add := func(id string, s *domain.GroupSnapshot) error {
+		if s == nil || s.Name == "" {
+			return nil
+		}
+
+		k := key{s.OwnerID, s.Name}
+		if other, ok := names[k]; ok {
+			return domain.Errorf(domain.ErrAlreadyExists, id, "name %q is used by group %s", s.Name, other)
+		}
+
+		names[k] = id
+
+		return nil
+	}
+
+	for id := range r.groups {
+		if _, ok := writes[id]; ok {
+			continue
+		}
+
+		if err := add(id, r.latest(id)); err != nil {
+			return err
+		}
+	}
+
+	for id, s := range writes {
+		if err := add(id, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkConflict returns domain.ErrTransientTransaction if the group has been
+// modified since the transaction started.
+func (r *GroupRepo) checkConflict(tx *transaction, id string) error {
+	versions := r.groups[id]
+	if len(versions) == 0 {
+		return nil
+	}
+
+	if last := versions[len(versions)-1]; last.number > tx.start {
+		return domain.NewError(domain.ErrTransientTransaction, id, errors.New("write conflict"))
+	}
+
+	return nil
+}
+
+// read returns the group as seen from inside the transaction, or nil if it
+// does not exist.
+func (r *GroupRepo) read(tx *transaction, id string) *domain.GroupSnapshot {
+	if s, ok := tx.writes[id]; ok {
+		return s
+	}
+
+	versions := r.groups[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].number <= tx.start {
+			return versions[i].snapshot
+		}
+	}
+
+	return nil
+}
+
+// latest returns the last committed version of the group, or nil if it does
+// not exist.
+func (r *GroupRepo) latest(id string) *domain.GroupSnapshot {
+	versions := r.groups[id]
+	if len(versions) == 0 {
+		return nil
+	}
+
+	return versions[len(versions)-1].snapshot
+}
@@ -0,0 +1,705 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+// now is the time used by the tests that do not care about it.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestGroup_Create(t *testing.T) {
+	t.Parallel()
+
+	// tests that you can create a group, then load it later
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a group in the repo
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := repo.Create(ctx, group)
+		require.NoError(t, err)
+
+		// WHEN you load the group
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+
+		// THEN you get the same group that you saved
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// tests you cannot create a group if there is alreday a group with that same id
+	t.Run("already exists", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a group in the repo
+		group := domain.NewGroup("group_id", "irrelevant_owner_id", now)
+		err := repo.Create(ctx, group)
+		require.NoError(t, err)
+
+		// WHEN you try to create another group with the same id
+		err = repo.Create(ctx, group)
+
+		// THEN you get domain.ErrAlreadyExists about that group
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+		require.Equal(t, domain.ErrAlreadyExists, domain.KindOf(err))
+		require.ErrorContains(t, err, "group_id")
+	})
+}
+
+func TestGroup_Update(t *testing.T) {
+	t.Parallel()
+
+	// Tests that Update overwrites the group.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a group in the repo
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := repo.Create(ctx, group)
+		require.NoError(t, err)
+
+		// WHEN we update the group with a new member
+		err = group.AddMember("user_id", now)
+		require.NoError(t, err)
+
+		err = repo.Update(ctx, group)
+		require.NoError(t, err)
+
+		// THEN loading the group returns the new member
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// Tests that Update fails if there is no group for the given id.
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		repo := memory.NewGroupRepo()
+
+		// WHEN we update a group that is not in the repo
+		group := domain.NewGroup("irrelevant_group_id", "irrelevant_owner_id", now)
+		err := repo.Update(context.Background(), group)
+
+		// THEN we get domain.ErrNotFound, which cannot be retried
+		require.ErrorIs(t, err, domain.ErrNotFound)
+		require.False(t, domain.IsRetryable(err))
+	})
+}
+
+// Tests the names of the groups of an owner are unique.
+func TestGroup_UniqueNames(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a named group
+		repo := memory.NewGroupRepo()
+		require.NoError(t, repo.Create(ctx, testhelp.NamedGroup(t, now, "g1", "owner_id", "name")))
+
+		// WHEN we create another group of the same owner with the same name
+		err := repo.Create(ctx, testhelp.NamedGroup(t, now, "g2", "owner_id", "name"))
+
+		// THEN we get domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+		// THEN other owners and unnamed groups are not affected
+		require.NoError(t, repo.Create(ctx, testhelp.NamedGroup(t, now, "g3", "other_owner_id", "name")))
+		require.NoError(t, repo.Create(ctx, domain.NewGroup("g4", "owner_id", now)))
+		require.NoError(t, repo.Create(ctx, domain.NewGroup("g5", "owner_id", now)))
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a named group
+		repo := memory.NewGroupRepo()
+		require.NoError(t, repo.Create(ctx, testhelp.NamedGroup(t, now, "g1", "owner_id", "name")))
+		require.NoError(t, repo.Create(ctx, domain.NewGroup("g2", "owner_id", now)))
+
+		// WHEN a transaction renames another group of the owner to the
+		// same name
+		err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+			return repo.Update(ctx, testhelp.NamedGroup(t, now, "g2", "owner_id", "name"))
+		}, 1)
+
+		// THEN the commit fails with domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+		got, err := repo.Load(ctx, "g2")
+		require.NoError(t, err)
+		require.Empty(t, got.Details().Name)
+	})
+}
+
+func TestGroup_UpdateDetails(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a group
+		repo := memory.NewGroupRepo()
+		require.NoError(t, repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now)))
+
+		// GIVEN a concurrent addition of a member the caller has not seen
+		stale, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+
+		concurrent, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.NoError(t, concurrent.AddMember("user_id", now))
+		require.NoError(t, repo.Update(ctx, concurrent))
+
+		// WHEN we update the details of the stale group
+		require.NoError(t, stale.SetDetails(domain.Details{Name: "name"}, now))
+		err = repo.UpdateDetails(ctx, stale, domain.Details{})
+		require.NoError(t, err)
+
+		// THEN the details are updated and the member is not lost
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, "name", got.Details().Name)
+		require.True(t, got.HasMember("user_id"))
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a group whose details have changed
+		repo := memory.NewGroupRepo()
+		require.NoError(t, repo.Create(ctx, testhelp.NamedGroup(t, now, "group_id", "owner_id", "new")))
+
+		// WHEN we update its details expecting the old ones
+		err := repo.UpdateDetails(ctx, testhelp.NamedGroup(t, now, "group_id", "owner_id", "newer"), domain.Details{Name: "old"})
+
+		// THEN we get a retryable domain.ErrConflict
+		require.ErrorIs(t, err, domain.ErrConflict)
+		require.True(t, domain.IsRetryable(err))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		repo := memory.NewGroupRepo()
+
+		err := repo.UpdateDetails(ctx, testhelp.NamedGroup(t, now, "group_id", "owner_id", "name"), domain.Details{})
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	t.Run("duplicated name", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN two groups of the same owner
+		repo := memory.NewGroupRepo()
+		require.NoError(t, repo.Create(ctx, testhelp.NamedGroup(t, now, "g1", "owner_id", "name")))
+		require.NoError(t, repo.Create(ctx, domain.NewGroup("g2", "owner_id", now)))
+
+		// WHEN we give the second one the name of the first one
+		err := repo.UpdateDetails(ctx, testhelp.NamedGroup(t, now, "g2", "owner_id", "name"), domain.Details{})
+
+		// THEN we get domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+}
+
+func TestGroup_LoadNotFound(t *testing.T) {
+	t.Parallel()
+
+	repo := memory.NewGroupRepo()
+
+	// WHEN we load a non existing group id
+	_, err := repo.Load(context.Background(), "non_existing_group_id")
+
+	// THEN we get a domain.ErrNotFound error
+	require.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestGroup_WithTransaction(t *testing.T) {
+	t.Parallel()
+
+	// newRepo returns a repo with a group owned by "owner_id".
+	newRepo := func(t *testing.T) *memory.GroupRepo {
+		t.Helper()
+
+		repo := memory.NewGroupRepo()
+		err := repo.Create(context.Background(), domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		return repo
+	}
+
+	// addMember returns a transaction callback that adds a member to the group.
+	addMember := func(repo *memory.GroupRepo, userID string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			group, err := repo.Load(ctx, "group_id")
+			if err != nil {
+				return err
+			}
+
+			if err := group.AddMember(userID, now); err != nil {
+				return err
+			}
+
+			return repo.Update(ctx, group)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		// WHEN we add a member inside a transaction
+		err := repo.WithTransaction(ctx, addMember(repo, "user_id"), 1)
+		require.NoError(t, err)
+
+		// THEN the member has been added
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id", "user_id"}, got.Members())
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		// WHEN a transaction fails after updating the group
+		cause := errors.New("some_error")
+		err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := addMember(repo, "user_id")(ctx); err != nil {
+				return err
+			}
+
+			return cause
+		}, 1)
+
+		// THEN we get the error
+		require.ErrorIs(t, err, cause)
+
+		// THEN the group has not been modified
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id"}, got.Members())
+	})
+
+	t.Run("cancelled before starting", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newRepo(t)
+
+		// GIVEN a cancelled context
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// WHEN we run a transaction with it
+		called := false
+		err := repo.WithTransaction(ctx, func(context.Context) error {
+			called = true
+			return nil
+		}, 3)
+
+		// THEN it fails with the error of the context, without running
+		// the callback
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+		require.False(t, called)
+	})
+
+	t.Run("cancelled mid-transaction", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newRepo(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		// WHEN the context is cancelled after the transaction has
+		// updated the group and created another one
+		err := repo.WithTransaction(ctx, func(txCtx context.Context) error {
+			if err := addMember(repo, "user_id")(txCtx); err != nil {
+				return err
+			}
+
+			if err := repo.Create(txCtx, domain.NewGroup("other_group_id", "owner_id", now)); err != nil {
+				return err
+			}
+
+			cancel()
+
+			return nil
+		}, 3)
+
+		// THEN it fails with the error of the context
+		require.ErrorIs(t, err, context.Canceled)
+
+		// THEN nothing has been written
+		got, err := repo.Load(context.Background(), "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id"}, got.Members())
+		require.Equal(t, []string{"group_id"}, repo.GroupIDs())
+	})
+
+	t.Run("cancelled between retries", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newRepo(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		// WHEN every attempt fails with a transient error and the
+		// context is cancelled during the second one
+		attempts := 0
+
+		err := repo.WithTransaction(ctx, func(context.Context) error {
+			attempts++
+			if attempts == 2 {
+				cancel()
+			}
+
+			return domain.NewError(domain.ErrTransientTransaction, "group_id", nil)
+		}, 10)
+
+		// THEN no more attempts are made and we get the error of the
+		// context, not domain.ErrTooManyTransactionRetries
+		require.Equal(t, 2, attempts)
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+		require.ErrorContains(t, err, "after 2 attempts")
+	})
+
+	t.Run("write conflict is retried", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		// WHEN a transaction loads the group and, before updating it, a
+		// concurrent write modifies the group
+		attempts := 0
+		err := repo.WithTransaction(ctx, func(txCtx context.Context) error {
+			attempts++
+
+			group, err := repo.Load(txCtx, "group_id")
+			if err != nil {
+				return err
+			}
+
+			if attempts == 1 {
+				err := addMember(repo, "concurrent_user_id")(ctx)
+				require.NoError(t, err)
+			}
+
+			if err := group.AddMember("user_id", now); err != nil {
+				return err
+			}
+
+			return repo.Update(txCtx, group)
+		}, 2)
+
+		// THEN the transaction succeeds on the second attempt
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+
+		// THEN no update has been lost
+		got, err := repo.Load(ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"concurrent_user_id", "owner_id", "user_id"}, got.Members())
+	})
+
+	t.Run("snapshot isolation", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		// WHEN a transaction loads the group twice, with a concurrent
+		// modification in between
+		var first, second *domain.Group
+		err := repo.WithTransaction(ctx, func(txCtx context.Context) error {
+			var err error
+
+			first, err = repo.Load(txCtx, "group_id")
+			require.NoError(t, err)
+
+			err = addMember(repo, "concurrent_user_id")(ctx)
+			require.NoError(t, err)
+
+			second, err = repo.Load(txCtx, "group_id")
+			require.NoError(t, err)
+
+			return nil
+		}, 1)
+		require.NoError(t, err)
+
+		// THEN both loads see the same group
+		require.Equal(t, first.Snapshot(), second.Snapshot())
+	})
+
+	t.Run("too many retries", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newRepo(t)
+
+		// WHEN a transaction always fails with a transient error
+		attempts := 0
+		err := repo.WithTransaction(context.Background(), func(context.Context) error {
+			attempts++
+			return domain.ErrTransientTransaction
+		}, 3)
+
+		// THEN we get ErrTooManyTransactionRetries after all the attempts
+		require.ErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+		require.Equal(t, 3, attempts)
+	})
+}
+
+func TestGroup_Audit(t *testing.T) {
+	t.Parallel()
+
+	// Tests History returns the entries of the group sorted by key, in
+	// pages.
+	t.Run("history", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN some entries recorded out of order, in several groups
+		entries := []domain.AuditEntry{
+			testhelp.AuditEntry("group_id", "b", 2),
+			testhelp.AuditEntry("group_id", "a", 2),
+			testhelp.AuditEntry("other_group_id", "c", 1),
+			testhelp.AuditEntry("group_id", "d", 1),
+		}
+
+		for _, e := range entries {
+			err := repo.Record(ctx, e)
+			require.NoError(t, err)
+		}
+
+		// WHEN we read the first page
+		first, err := repo.History(ctx, "group_id", domain.AuditKey{}, 2)
+		require.NoError(t, err)
+
+		// WHEN we read the rest
+		rest, err := repo.History(ctx, "group_id", first[1].Key(), 10)
+		require.NoError(t, err)
+
+		// THEN we get the entries of the group sorted by time and id
+		require.Equal(t, []domain.AuditEntry{entries[3], entries[1]}, first)
+		require.Equal(t, []domain.AuditEntry{entries[0]}, rest)
+	})
+
+	// Tests the entries recorded in a transaction are only stored if it
+	// commits.
+	t.Run("transactions", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// WHEN we record entries in a failed and in a committed transaction
+		errAbort := errors.New("abort")
+		err := repo.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := repo.Record(ctx, testhelp.AuditEntry("group_id", "aborted", 1)); err != nil {
+				return err
+			}
+
+			return errAbort
+		}, 1)
+		require.ErrorIs(t, err, errAbort)
+
+		committed := testhelp.AuditEntry("group_id", "committed", 2)
+		err = repo.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := repo.Record(ctx, committed); err != nil {
+				return err
+			}
+
+			// THEN the entry is not visible before the commit
+			got, err := repo.History(ctx, "group_id", domain.AuditKey{}, 10)
+			require.NoError(t, err)
+			require.Empty(t, got)
+
+			return nil
+		}, 1)
+		require.NoError(t, err)
+
+		// THEN only the committed entry is stored
+		got, err := repo.History(ctx, "group_id", domain.AuditKey{}, 10)
+		require.NoError(t, err)
+		require.Equal(t, []domain.AuditEntry{committed}, got)
+	})
+}
+
+func TestGroup_Transfer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("each", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN some groups in the repo
+		for _, id := range []string{"b", "a", "c"} {
+			err := repo.Create(ctx, domain.NewGroup(id, "owner_id", now))
+			require.NoError(t, err)
+		}
+
+		// WHEN we iterate over them, stopping after the second one
+		var got []string
+		errStop := errors.New("stop")
+
+		err := repo.Each(ctx, func(g *domain.Group) error {
+			got = append(got, g.ID())
+			if len(got) == 2 {
+				return errStop
+			}
+
+			return nil
+		})
+
+		// THEN we get them sorted by id until we stop
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, []string{"a", "b"}, got)
+	})
+
+	t.Run("put", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a group in the repo
+		err := repo.Create(ctx, domain.NewGroup("old", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we put a new version of it and a new group
+		updated := domain.NewGroup("old", "owner_id", now)
+		err = updated.AddMember("user_id", now)
+		require.NoError(t, err)
+
+		err = repo.Put(ctx, []*domain.Group{updated, domain.NewGroup("new", "owner_id", now)})
+		require.NoError(t, err)
+
+		// THEN both exist
+		existing, err := repo.Existing(ctx, []string{"new", "missing", "old"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"new", "old"}, existing)
+
+		// THEN the existing group has been overwritten
+		got, err := repo.Load(ctx, "old")
+		require.NoError(t, err)
+		require.Equal(t, updated.Snapshot(), got.Snapshot())
+	})
+
+	t.Run("put with duplicated names", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a named group in the repo
+		err := repo.Create(ctx, testhelp.NamedGroup(t, now, "a", "owner_id", "name"))
+		require.NoError(t, err)
+
+		// WHEN we put another group of the same owner with the same name
+		err = repo.Put(ctx, []*domain.Group{
+			domain.NewGroup("b", "owner_id", now),
+			testhelp.NamedGroup(t, now, "c", "owner_id", "name"),
+		})
+
+		// THEN it fails and nothing is stored
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+		require.Equal(t, []string{"a"}, repo.GroupIDs())
+	})
+
+	t.Run("put missing", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		repo := memory.NewGroupRepo()
+
+		// GIVEN a group in the repo
+		old := domain.NewGroup("old", "owner_id", now)
+		err := repo.Create(ctx, old)
+		require.NoError(t, err)
+
+		// WHEN we put a new version of it and a new group, only if missing
+		updated := domain.NewGroup("old", "owner_id", now)
+		err = updated.AddMember("user_id", now)
+		require.NoError(t, err)
+
+		created, err := repo.PutMissing(ctx, []*domain.Group{updated, domain.NewGroup("new", "owner_id", now)})
+		require.NoError(t, err)
+
+		// THEN only the new group is created
+		require.Equal(t, 1, created)
+		require.Equal(t, []string{"new", "old"}, repo.GroupIDs())
+
+		// THEN the existing group is left as it was
+		got, err := repo.Load(ctx, "old")
+		require.NoError(t, err)
+		require.Equal(t, old.Snapshot(), got.Snapshot())
+	})
+}
+
+func TestGroup_StepHook(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a repo with a step hook that records the steps
+	var steps []memory.Step
+	repo := memory.NewGroupRepo(memory.WithStepHook(func(_ context.Context, step memory.Step) {
+		steps = append(steps, step)
+	}))
+
+	// WHEN we create a group and then update it in a transaction
+	ctx := context.Background()
+	group := domain.NewGroup("group_id", "owner_id", now)
+
+	err := repo.Create(ctx, group)
+	require.NoError(t, err)
+
+	err = repo.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := repo.Load(ctx, "group_id"); err != nil {
+			return err
+		}
+
+		return repo.Update(ctx, group)
+	}, 1)
+	require.NoError(t, err)
+
+	// THEN the hook has seen all the steps
+	want := []memory.Step{
+		memory.StepCreate,
+		memory.StepLoad,
+		memory.StepUpdate,
+		memory.StepCommit,
+	}
+	require.Equal(t, want, steps)
+}
@@ -0,0 +1,126 @@
+// Package metrics exposes Prometheus metrics about the transactions run by
+// the Mongo adapter and the use cases run by the application.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "groups"
+
+// Metrics collects measurements as Prometheus metrics.
+//
+// It implements both application.Metrics and mongo.Metrics.
+type Metrics struct {
+	transactionAttempts       prometheus.Counter
+	transientFailures         prometheus.Counter
+	tooManyTransactionRetries prometheus.Counter
+	transactionCommitDuration prometheus.Histogram
+	useCases                  *prometheus.CounterVec
+	useCaseDuration           *prometheus.HistogramVec
+}
+
+// New creates the metrics and registers them in reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		transactionAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transaction_attempts_total",
+			Help:      "Number of transaction attempts, including retries.",
+		}),
+		transientFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transaction_transient_failures_total",
+			Help:      "Number of transaction attempts that failed with a transient error and were retried.",
+		}),
+		tooManyTransactionRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transaction_too_many_retries_total",
+			Help:      "Number of transactions that gave up after running out of retries.",
+		}),
+		transactionCommitDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transaction_commit_duration_seconds",
+			Help:      "Time from the start of the successful transaction attempt until it is committed.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		useCases: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "use_cases_total",
+			Help:      "Number of use cases run, by use case and outcome.",
+		}, []string{"use_case", "outcome"}),
+		useCaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "use_case_duration_seconds",
+			Help:      "Time spent running use cases, by use case.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"use_case"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.transactionAttempts,
+		m.transientFailures,
+		m.tooManyTransactionRetries,
+		m.transactionCommitDuration,
+		m.useCases,
+		m.useCaseDuration,
+	}
+
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("registering metrics: %v", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Handler returns an HTTP handler that exposes the metrics gathered by g, to
+// be served at /metrics.
+func Handler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) TransactionAttempt() {
+	m.transactionAttempts.Inc()
+}
+
+func (m *Metrics) TransientTransactionFailure() {
+	m.transientFailures.Inc()
+}
+
+func (m *Metrics) TooManyTransactionRetries() {
+	m.tooManyTransactionRetries.Inc()
+}
+
+func (m *Metrics) TransactionCommitted(elapsed time.Duration) {
+	m.transactionCommitDuration.Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) UseCaseFinished(useCase string, err error, elapsed time.Duration) {
+	m.useCases.WithLabelValues(useCase, outcome(err)).Inc()
+	m.useCaseDuration.WithLabelValues(useCase).Observe(elapsed.Seconds())
+}
+
+// outcome returns the label for the outcome of a use case that returned err.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, domain.ErrGroupFull):
+		return "group_full"
+	case errors.Is(err, domain.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, domain.ErrTooManyTransactionRetries):
+		return "too_many_transaction_retries"
+	default:
+		return "error"
+	}
+}
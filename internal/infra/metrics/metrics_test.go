@@ -0,0 +1,166 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/metrics"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ application.Metrics = (*metrics.Metrics)(nil)
+	_ mongo.Metrics       = (*metrics.Metrics)(nil)
+)
+
+type fixture struct {
+	registry *prometheus.Registry
+	metrics  *metrics.Metrics
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+
+	return &fixture{
+		registry: registry,
+		metrics:  m,
+	}
+}
+
+func TestNew_AlreadyRegistered(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a registry with the metrics already registered
+	fix := newFixture(t)
+
+	// WHEN we register them again
+	_, err := metrics.New(fix.registry)
+
+	// THEN we get an error
+	require.Error(t, err)
+}
+
+func TestMetrics_Transactions(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t)
+
+	// GIVEN a transaction that succeeds after a transient failure
+	fix.metrics.TransactionAttempt()
+	fix.metrics.TransientTransactionFailure()
+	fix.metrics.TransactionAttempt()
+	fix.metrics.TransactionCommitted(20 * time.Millisecond)
+
+	// GIVEN a transaction that runs out of retries
+	fix.metrics.TransactionAttempt()
+	fix.metrics.TransientTransactionFailure()
+	fix.metrics.TooManyTransactionRetries()
+
+	// WHEN we gather the metrics
+	// THEN we get the counts we expect
+	want := `
+# HELP groups_transaction_attempts_total Number of transaction attempts, including retries.
+# TYPE groups_transaction_attempts_total counter
+groups_transaction_attempts_total 3
+# HELP groups_transaction_transient_failures_total Number of transaction attempts that failed with a transient error and were retried.
+# TYPE groups_transaction_transient_failures_total counter
+groups_transaction_transient_failures_total 2
+# HELP groups_transaction_too_many_retries_total Number of transactions that gave up after running out of retries.
+# TYPE groups_transaction_too_many_retries_total counter
+groups_transaction_too_many_retries_total 1
+`
+	err := testutil.GatherAndCompare(fix.registry, strings.NewReader(want),
+		"groups_transaction_attempts_total",
+		"groups_transaction_transient_failures_total",
+		"groups_transaction_too_many_retries_total",
+	)
+	require.NoError(t, err)
+
+	// THEN the commit latency has been observed
+	count, err := testutil.GatherAndCount(fix.registry, "groups_transaction_commit_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestMetrics_UseCases(t *testing.T) {
+	t.Parallel()
+
+	fix := newFixture(t)
+
+	// GIVEN use cases finishing with different outcomes, including wrapped
+	// domain errors
+	outcomes := []struct {
+		useCase string
+		err     error
+	}{
+		{useCase: "AddUserToGroup", err: nil},
+		{useCase: "AddUserToGroup", err: fmt.Errorf("adding: %w", domain.ErrGroupFull)},
+		{useCase: "AddUserToGroup", err: domain.ErrGroupFull},
+		{useCase: "AddUserToGroup", err: domain.ErrTooManyTransactionRetries},
+		{useCase: "GetGroup", err: fmt.Errorf("loading: %w", domain.ErrNotFound)},
+		{useCase: "GetGroup", err: errors.New("some_error")},
+	}
+
+	for _, o := range outcomes {
+		fix.metrics.UseCaseFinished(o.useCase, o.err, time.Millisecond)
+	}
+
+	// WHEN we gather the metrics
+	// THEN we get the counts by use case and outcome
+	want := `
+# HELP groups_use_cases_total Number of use cases run, by use case and outcome.
+# TYPE groups_use_cases_total counter
+groups_use_cases_total{outcome="error",use_case="GetGroup"} 1
+groups_use_cases_total{outcome="group_full",use_case="AddUserToGroup"} 2
+groups_use_cases_total{outcome="not_found",use_case="GetGroup"} 1
+groups_use_cases_total{outcome="ok",use_case="AddUserToGroup"} 1
+groups_use_cases_total{outcome="too_many_transaction_retries",use_case="AddUserToGroup"} 1
+`
+	err := testutil.GatherAndCompare(fix.registry, strings.NewReader(want), "groups_use_cases_total")
+	require.NoError(t, err)
+
+	// THEN the durations have been observed by use case
+	count, err := testutil.GatherAndCount(fix.registry, "groups_use_case_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN some metrics
+	fix := newFixture(t)
+	fix.metrics.UseCaseFinished("CreateGroup", nil, time.Millisecond)
+
+	// GIVEN a server exposing them
+	server := httptest.NewServer(metrics.Handler(fix.registry))
+	t.Cleanup(server.Close)
+
+	// WHEN we scrape the server
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	// THEN we get the metrics in the Prometheus text format
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `groups_use_cases_total{outcome="ok",use_case="CreateGroup"} 1`)
+}
@@ -0,0 +1,156 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditLog stores the audit trail of the groups in its own collection, one
+// document per entry. It implements application.AuditLog.
+//
+// Entries recorded inside a transaction of a GroupRepo, that is, with its
+// context, are part of the transaction, as long as both collections use the
+// same client.
+type AuditLog struct {
+	coll *mongo.Collection
+}
+
+func NewAuditLog(coll *mongo.Collection) *AuditLog {
+	return &AuditLog{coll: coll}
+}
+
+// auditDoc is a Mongo document representing an audit entry.
+type auditDoc struct {
+	ID            string    `bson:"_id"`
+	GroupID       string    `bson:"group_id"`
+	Seq           int       `bson:"seq"`
+	Actor         string    `bson:"actor,omitempty"`
+	Action        string    `bson:"action"`
+	UserID        string    `bson:"user_id"`
+	Time          time.Time `bson:"time"`
+	MembersBefore int       `bson:"members_before"`
+	MembersAfter  int       `bson:"members_after"`
+}
+
+func newAuditDoc(e domain.AuditEntry) *auditDoc {
+	return &auditDoc{
+		ID:            e.ID,
+		GroupID:       e.GroupID,
+		Seq:           e.Seq,
+		Actor:         e.Actor,
+		Action:        string(e.Action),
+		UserID:        e.UserID,
+		Time:          e.Time,
+		MembersBefore: e.MembersBefore,
+		MembersAfter:  e.MembersAfter,
+	}
+}
+
+func (d *auditDoc) entry() domain.AuditEntry {
+	return domain.AuditEntry{
+		ID:            d.ID,
+		GroupID:       d.GroupID,
+		Seq:           d.Seq,
+		Actor:         d.Actor,
+		Action:        domain.AuditAction(d.Action),
+		UserID:        d.UserID,
+		Time:          d.Time.UTC(),
+		MembersBefore: d.MembersBefore,
+		MembersAfter:  d.MembersAfter,
+	}
+}
+
+// EnsureAuditIndexes creates the indexes of the audit collection with the
+// given name, used to page through the history of each group.
+//
+// It is meant to be called when bootstrapping the application and it is safe
+// to call it several times. The index of previous versions, without the
+// sequence, is left alone, it can be dropped once they no longer run.
+func EnsureAuditIndexes(ctx context.Context, db *mongo.Database, collName string) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "group_id", Value: 1},
+			{Key: "time", Value: 1},
+			{Key: "seq", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+		Options: options.Index().SetName("group_id_time_seq"),
+	}
+
+	if _, err := db.Collection(collName).Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("creating indexes of collection %s: %v", collName, err)
+	}
+
+	return nil
+}
+
+// Record inserts the entry in the audit collection.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if there is already an entry with the same ID.
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+//   - domain.ErrTransientTransaction if the operation failed during a
+//     transaction that can be retried.
+func (l *AuditLog) Record(ctx context.Context, entry domain.AuditEntry) error {
+	if _, err := l.coll.InsertOne(ctx, newAuditDoc(entry)); err != nil {
+		return fmt.Errorf("inserting audit entry: %w", domainError(err, entry.GroupID))
+	}
+
+	return nil
+}
+
+// History returns up to limit entries of the history of the group, sorted by
+// key, starting right after the given key.
+//
+// Errors:
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+func (l *AuditLog) History(
+	ctx context.Context,
+	groupID string,
+	after domain.AuditKey,
+	limit int,
+) ([]domain.AuditEntry, error) {
+	filter := bson.M{"group_id": groupID}
+
+	if !after.IsZero() {
+		// the entries recorded by previous versions have no sequence,
+		// they sort as if it was 0
+		var seq any = after.Seq
+		if after.Seq == 0 {
+			seq = bson.M{"$in": bson.A{0, nil}}
+		}
+
+		filter["$or"] = bson.A{
+			bson.M{"time": bson.M{"$gt": after.Time}},
+			bson.M{"time": after.Time, "seq": bson.M{"$gt": after.Seq}},
+			bson.M{"time": after.Time, "seq": seq, "_id": bson.M{"$gt": after.ID}},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: 1}, {Key: "seq", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := l.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("finding audit entries: %w", domainError(err, groupID))
+	}
+
+	var docs []auditDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("decoding audit entries: %w", domainError(err, groupID))
+	}
+
+	result := make([]domain.AuditEntry, 0, len(docs))
+	for _, d := range docs {
+		result = append(result, d.entry())
+	}
+
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package mongo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type auditLogFixture struct {
+	*groupRepoFixture
+	audit *mongo.AuditLog
+}
+
+func newAuditLogFixture(t *testing.T) *auditLogFixture {
+	t.Helper()
+
+	fix := newGroupRepoFixture(t)
+
+	err := mongo.EnsureAuditIndexes(fix.ctx, fix.db, "group_audit")
+	require.NoError(t, err)
+
+	return &auditLogFixture{
+		groupRepoFixture: fix,
+		audit:            mongo.NewAuditLog(fix.db.Collection("group_audit")),
+	}
+}
+
+// Tests History returns the entries of the group sorted by key, in pages.
+func TestAuditLog_History(t *testing.T) {
+	t.Parallel()
+
+	fix := newAuditLogFixture(t)
+
+	// GIVEN some entries recorded out of order, in several groups
+	entries := []domain.AuditEntry{
+		testhelp.AuditEntry("group_id", "b", 2),
+		testhelp.AuditEntry("group_id", "a", 2),
+		testhelp.AuditEntry("other_group_id", "c", 1),
+		testhelp.AuditEntry("group_id", "d", 1),
+	}
+
+	for _, e := range entries {
+		err := fix.audit.Record(fix.ctx, e)
+		require.NoError(t, err)
+	}
+
+	// WHEN we read the first page
+	first, err := fix.audit.History(fix.ctx, "group_id", domain.AuditKey{}, 2)
+	require.NoError(t, err)
+
+	// WHEN we read the rest
+	rest, err := fix.audit.History(fix.ctx, "group_id", first[1].Key(), 10)
+	require.NoError(t, err)
+
+	// THEN we get the entries of the group sorted by time and id
+	require.Equal(t, []domain.AuditEntry{entries[3], entries[1]}, first)
+	require.Equal(t, []domain.AuditEntry{entries[0]}, rest)
+}
+
+// Tests the entries recorded at the same time are sorted by sequence before
+// id, including those of previous versions, without a sequence.
+func TestAuditLog_HistorySameTime(t *testing.T) {
+	t.Parallel()
+
+	fix := newAuditLogFixture(t)
+
+	// GIVEN some entries recorded at the same time, whose ids are not in
+	// the order of their sequences
+	legacy := testhelp.AuditEntry("group_id", "z", 1)
+	second := testhelp.AuditEntry("group_id", "a", 1)
+	second.Seq = 2
+	first := testhelp.AuditEntry("group_id", "b", 1)
+	first.Seq = 1
+
+	// GIVEN the oldest one has no sequence, as recorded by previous versions
+	_, err := fix.db.Collection("group_audit").InsertOne(fix.ctx, bson.M{
+		"_id":            legacy.ID,
+		"group_id":       legacy.GroupID,
+		"actor":          legacy.Actor,
+		"action":         string(legacy.Action),
+		"user_id":        legacy.UserID,
+		"time":           legacy.Time,
+		"members_before": legacy.MembersBefore,
+		"members_after":  legacy.MembersAfter,
+	})
+	require.NoError(t, err)
+
+	for _, e := range []domain.AuditEntry{second, first} {
+		err := fix.audit.Record(fix.ctx, e)
+		require.NoError(t, err)
+	}
+
+	// WHEN we read them one by one
+	var got []domain.AuditEntry
+	after := domain.AuditKey{}
+	for {
+		page, err := fix.audit.History(fix.ctx, "group_id", after, 1)
+		require.NoError(t, err)
+
+		if len(page) == 0 {
+			break
+		}
+
+		got = append(got, page...)
+		after = page[0].Key()
+	}
+
+	// THEN we get them sorted by sequence, the one without it first
+	require.Equal(t, []domain.AuditEntry{legacy, first, second}, got)
+}
+
+// Tests the entries recorded in a transaction of the GroupRepo are only
+// stored if it commits, along with the changes to the groups.
+func TestAuditLog_Transactions(t *testing.T) {
+	t.Parallel()
+
+	fix := newAuditLogFixture(t)
+
+	// WHEN we create a group and record an entry in a failed transaction
+	errAbort := errors.New("abort")
+	err := fix.repo.WithTransaction(fix.ctx, func(ctx context.Context) error {
+		if err := fix.repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now)); err != nil {
+			return err
+		}
+
+		if err := fix.audit.Record(ctx, testhelp.AuditEntry("group_id", "aborted", 1)); err != nil {
+			return err
+		}
+
+		return errAbort
+	}, 1)
+	require.ErrorIs(t, err, errAbort)
+
+	// THEN neither the group nor the entry are stored
+	_, err = fix.repo.Load(fix.ctx, "group_id")
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	got, err := fix.audit.History(fix.ctx, "group_id", domain.AuditKey{}, 10)
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	// WHEN we do the same in a committed transaction
+	committed := testhelp.AuditEntry("group_id", "committed", 2)
+	err = fix.repo.WithTransaction(fix.ctx, func(ctx context.Context) error {
+		if err := fix.repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now)); err != nil {
+			return err
+		}
+
+		return fix.audit.Record(ctx, committed)
+	}, 1)
+	require.NoError(t, err)
+
+	// THEN both are stored
+	_, err = fix.repo.Load(fix.ctx, "group_id")
+	require.NoError(t, err)
+
+	got, err = fix.audit.History(fix.ctx, "group_id", domain.AuditKey{}, 10)
+	require.NoError(t, err)
+	require.Equal(t, []domain.AuditEntry{committed}, got)
+}
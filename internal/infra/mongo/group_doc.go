@@ -1,21 +1,61 @@
 package mongo
 
-import "github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+import (
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
 
 // groupDoc is a Mongo document representing a group
 type groupDoc struct {
 	ID      string   `bson:"_id"`
 	OwnerID string   `bson:"owner_id"`
 	Members []string `bson:"members"`
+	// Name, Description and Tags are omitted when empty, so the unique index
+	// on the owner and the name only applies to named groups.
+	Name        string   `bson:"name,omitempty"`
+	Description string   `bson:"description,omitempty"`
+	Tags        []string `bson:"tags,omitempty"`
+	// JoinedAt has when each member joined the group, the members without
+	// it joined at an unknown time. It is a list instead of a map from ids
+	// to times, as ids can have characters not allowed in field names.
+	JoinedAt  []joinedAtDoc `bson:"joined_at,omitempty"`
+	CreatedAt time.Time     `bson:"created_at,omitempty"`
+	UpdatedAt time.Time     `bson:"updated_at,omitempty"`
+	// SchemaVersion is the version of the format of the document, see
+	// migrations.
+	SchemaVersion int `bson:"schema_version"`
+	// Fence is the greatest fencing token the group has been loaded or
+	// written with, see GroupRepo.LoadFenced. It is omitted in the groups
+	// never used with a lock.
+	Fence int64 `bson:"fence,omitempty"`
+}
+
+// joinedAtDoc is when a member joined a group.
+type joinedAtDoc struct {
+	UserID string    `bson:"user_id"`
+	At     time.Time `bson:"at"`
 }
 
 func newGroupDoc(group *domain.Group) *groupDoc {
 	s := group.Snapshot()
 
 	doc := &groupDoc{
-		ID:      s.ID,
-		OwnerID: s.OwnerID,
-		Members: s.Members,
+		ID:            s.ID,
+		OwnerID:       s.OwnerID,
+		Members:       s.Members,
+		Name:          s.Name,
+		Description:   s.Description,
+		Tags:          s.Tags,
+		CreatedAt:     s.CreatedAt,
+		UpdatedAt:     s.UpdatedAt,
+		SchemaVersion: currentSchemaVersion,
+	}
+
+	for i, id := range s.Members {
+		if i < len(s.JoinedAt) && !s.JoinedAt[i].IsZero() {
+			doc.JoinedAt = append(doc.JoinedAt, joinedAtDoc{UserID: id, At: s.JoinedAt[i]})
+		}
 	}
 
 	return doc
@@ -23,6 +63,34 @@ func newGroupDoc(group *domain.Group) *groupDoc {
 
 // group returns the domain.Group represented by docGroup.
 func (d *groupDoc) group() (*domain.Group, error) {
-	s := domain.GroupSnapshot(*d)
-	return s.Regenerate()
+	return d.snapshot().Regenerate()
+}
+
+// snapshot returns the domain.GroupSnapshot represented by docGroup, which
+// may not be a valid group.
+func (d *groupDoc) snapshot() *domain.GroupSnapshot {
+	s := &domain.GroupSnapshot{
+		ID:          d.ID,
+		OwnerID:     d.OwnerID,
+		Members:     d.Members,
+		Name:        d.Name,
+		Description: d.Description,
+		Tags:        d.Tags,
+		CreatedAt:   d.CreatedAt,
+		UpdatedAt:   d.UpdatedAt,
+	}
+
+	if len(d.JoinedAt) > 0 {
+		joinedAt := make(map[string]time.Time, len(d.JoinedAt))
+		for _, j := range d.JoinedAt {
+			joinedAt[j.UserID] = j.At
+		}
+
+		s.JoinedAt = make([]time.Time, len(d.Members))
+		for i, id := range d.Members {
+			s.JoinedAt[i] = joinedAt[id]
+		}
+	}
+
+	return s
 }
@@ -4,38 +4,111 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type GroupRepo struct {
-	coll *mongo.Collection
+	coll    *mongo.Collection
+	logger  *slog.Logger
+	metrics Metrics
+	tracer  trace.Tracer
 }
 
-func NewGroupRepo(coll *mongo.Collection) *GroupRepo {
-	return &GroupRepo{
-		coll: coll,
+// Metrics receives measurements about the transactions run by the GroupRepo.
+type Metrics interface {
+	// TransactionAttempt is called every time a transaction is attempted,
+	// including retries.
+	TransactionAttempt()
+	// TransientTransactionFailure is called every time a transaction attempt
+	// fails with a transient error.
+	TransientTransactionFailure()
+	// TooManyTransactionRetries is called every time a transaction gives up
+	// after running out of retries.
+	TooManyTransactionRetries()
+	// TransactionCommitted is called with the time it took to run the
+	// successful attempt of a transaction.
+	TransactionCommitted(elapsed time.Duration)
+}
+
+type Option func(*GroupRepo)
+
+// WithLogger sets the logger used by the GroupRepo. The attributes carried by
+// the context are added to every record.
+//
+// By default, the GroupRepo does not log anything.
+func WithLogger(l *slog.Logger) Option {
+	return func(r *GroupRepo) {
+		r.logger = logging.NewLogger(l)
+	}
+}
+
+// WithMetrics sets where the GroupRepo reports measurements about its
+// transactions.
+//
+// By default, no metrics are reported.
+func WithMetrics(m Metrics) Option {
+	return func(r *GroupRepo) {
+		r.metrics = m
 	}
 }
 
+// WithTracerProvider sets the provider of the tracer used to create spans
+// for the operations of the GroupRepo and for each transaction attempt.
+//
+// Use NewCommandMonitor to also trace the commands sent to MongoDB.
+//
+// By default, no spans are created.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(r *GroupRepo) {
+		r.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func NewGroupRepo(coll *mongo.Collection, options ...Option) *GroupRepo {
+	r := &GroupRepo{
+		coll:    coll,
+		logger:  logging.Discard(),
+		metrics: nopMetrics{},
+		tracer:  noop.NewTracerProvider().Tracer(tracerName),
+	}
+
+	for _, o := range options {
+		o(r)
+	}
+
+	return r
+}
+
 // Create stores the group in the database as a new document.
 //
 // Error:
+//   - domain.ErrAlreadyExists if there is already a group with the same ID.
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
 //   - domain.ErrTransientTransaction if the operation failed during a transaction
 //     that can be retried.
-func (r *GroupRepo) Create(ctx context.Context, group *domain.Group) error {
+func (r *GroupRepo) Create(ctx context.Context, group *domain.Group) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Create", attribute.String("group_id", group.ID()))
+	defer end(&err)
+
 	doc := newGroupDoc(group)
 
-	_, err := r.coll.InsertOne(ctx, doc)
+	_, err = r.coll.InsertOne(ctx, doc)
 	if err != nil {
-		return fmt.Errorf("replacing: %w", domainError(err))
+		return fmt.Errorf("inserting: %w", domainError(err, group.ID()))
 	}
 
 	return nil
@@ -43,11 +116,25 @@ func (r *GroupRepo) Create(ctx context.Context, group *domain.Group) error {
 
 // Update overwrites the group document in the database.
 //
+// It does not check the fencing token of the group, if any, and it drops it,
+// so the groups written by the holders of leases must be written with
+// UpdateFenced instead, see LoadFenced.
+//
 // Error:
 //   - domain.ErrNotFound if the group is not found
+//   - domain.ErrAlreadyExists if the owner has another group with the same
+//     name.
+//   - domain.ErrInvalidArgument if the document is rejected by the schema
+//     validator of the collection.
+//   - domain.ErrConflict if it collides with a concurrent write to the group,
+//     outside a transaction.
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
 //   - domain.ErrTransientTransaction if the operation failed during a transaction
 //     that can be retried.
-func (r *GroupRepo) Update(ctx context.Context, group *domain.Group) error {
+func (r *GroupRepo) Update(ctx context.Context, group *domain.Group) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Update", attribute.String("group_id", group.ID()))
+	defer end(&err)
+
 	filter := bson.M{
 		"_id": group.ID(),
 	}
@@ -56,37 +143,553 @@ func (r *GroupRepo) Update(ctx context.Context, group *domain.Group) error {
 
 	result, err := r.coll.ReplaceOne(ctx, filter, doc)
 	if err != nil {
-		return fmt.Errorf("replacing: %w", domainError(err))
+		return fmt.Errorf("replacing: %w", domainError(err, group.ID()))
+	}
+
+	if result.MatchedCount == 0 {
+		return domain.NewError(domain.ErrNotFound, group.ID(), nil)
+	}
+
+	return nil
+}
+
+// AddMember adds userID as a member of the group with the given id, who
+// joins it at the given time, in a single atomic update, without a
+// transaction: the update only matches the group if it is not full and the
+// user is not a member yet.
+//
+// It implements application.MemberAdder.
+//
+// Errors:
+//   - domain.ErrNotFound if the group is not found
+//   - domain.ErrGroupFull if the group is full, even if the user is already a
+//     member, like domain.Group.AddMember.
+func (r *GroupRepo) AddMember(ctx context.Context, groupID, userID string, now time.Time) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.AddMember",
+		attribute.String("group_id", groupID),
+		attribute.String("user_id", userID),
+	)
+	defer end(&err)
+
+	filter := bson.M{
+		"_id":     groupID,
+		"members": bson.M{"$ne": userID},
+		"$expr": bson.M{
+			"$lt": bson.A{bson.M{"$size": "$members"}, domain.MaxMembers},
+		},
+	}
+
+	// $push with a filter on the user instead of $addToSet: $addToSet would
+	// also make the write idempotent, but it would still add the joined at
+	// time of a user already member, and cannot keep the members sorted, as
+	// they are in the documents written by Update, see migrations.
+	update := bson.M{
+		"$push": bson.M{
+			"members": bson.M{
+				"$each": bson.A{userID},
+				"$sort": 1,
+			},
+			"joined_at": joinedAtDoc{UserID: userID, At: now},
+		},
+		"$set": bson.M{"updated_at": now},
+	}
+
+	result, err := r.coll.UpdateOne(ctx, filter, update)
+	switch {
+	case err != nil:
+		return fmt.Errorf("updating: %w", domainError(err, groupID))
+	case result.MatchedCount == 1:
+		return nil
+	}
+
+	// the group does not exist, it is full or the user is already a member
+	var doc struct {
+		Members []string `bson:"members"`
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"members": 1})
+
+	if err := r.coll.FindOne(ctx, bson.M{"_id": groupID}, opts).Decode(&doc); err != nil {
+		return fmt.Errorf("finding: %w", domainError(err, groupID))
+	}
+
+	switch {
+	case len(doc.Members) >= domain.MaxMembers:
+		return fmt.Errorf("adding: %w", domain.NewError(domain.ErrGroupFull, groupID, nil))
+	case slices.Contains(doc.Members, userID):
+		return nil
+	}
+
+	// the group has changed since the update, there are no removals, so
+	// this should not happen
+	return domain.Errorf(domain.ErrConflict, groupID, "group changed concurrently")
+}
+
+// UpdateDetails stores the details of the group, without modifying its
+// members, in a single atomic update: the update only matches the group if
+// its stored details are still the previous ones.
+//
+// It implements application.DetailsUpdater.
+//
+// Errors:
+//   - domain.ErrNotFound if the group is not found.
+//   - domain.ErrConflict if the stored details are not the previous ones.
+//   - domain.ErrAlreadyExists if the owner has another group with the same
+//     name.
+//   - domain.ErrTransientTransaction if the operation failed during a
+//     transaction that can be retried.
+func (r *GroupRepo) UpdateDetails(ctx context.Context, group *domain.Group, previous domain.Details) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.UpdateDetails", attribute.String("group_id", group.ID()))
+	defer end(&err)
+
+	filter := detailsFilter(previous)
+	filter["_id"] = group.ID()
+
+	result, err := r.coll.UpdateOne(ctx, filter, detailsUpdate(group.Details(), group.UpdatedAt()))
+	if err != nil {
+		return fmt.Errorf("updating: %w", domainError(err, group.ID()))
+	}
+
+	if result.MatchedCount != 0 {
+		return nil
+	}
+
+	// the group does not exist or its details have changed
+	n, err := r.coll.CountDocuments(ctx, bson.M{"_id": group.ID()})
+	if err != nil {
+		return fmt.Errorf("counting: %w", domainError(err, group.ID()))
+	}
+
+	if n == 0 {
+		return domain.NewError(domain.ErrNotFound, group.ID(), nil)
+	}
+
+	return domain.Errorf(domain.ErrConflict, group.ID(), "details changed concurrently")
+}
+
+// LoadFenced returns the group with the given id, like Load, after storing
+// the fencing token in its document, see LeaseLocker, if it does not have a
+// greater one. From then on, the holders of older leases cannot overwrite
+// it, even if they loaded it before, see UpdateFenced.
+//
+// It implements application.FencedUpdater.
+//
+// Errors:
+//   - domain.ErrNotFound if the group is not found.
+//   - domain.ErrConflict if the group has a greater fencing token.
+//   - domain.ErrTransientTransaction if the operation failed during a
+//     transaction that can be retried.
+func (r *GroupRepo) LoadFenced(ctx context.Context, id string, token int64) (_ *domain.Group, err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.LoadFenced",
+		attribute.String("group_id", id),
+		attribute.Int64("fence", token),
+	)
+	defer end(&err)
+
+	update := bson.M{
+		"$set": bson.M{"fence": token},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	raw, err := r.coll.FindOneAndUpdate(ctx, fenceFilter(id, token), update, opts).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.fenceError(ctx, id)
+	}
+
+	if err != nil {
+		return nil, domainError(err, id)
+	}
+
+	group, err := decodeGroup(raw)
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %v", id, err)
+	}
+
+	return group, nil
+}
+
+// UpdateFenced overwrites the group document in the database, like Update,
+// but only if it does not have a fencing token greater than token, see
+// LoadFenced.
+//
+// It implements application.FencedUpdater. The groups written with it must
+// not be written with Update, as it drops the token.
+//
+// Errors:
+//   - domain.ErrNotFound if the group is not found.
+//   - domain.ErrConflict if the group has a greater fencing token.
+//   - domain.ErrTransientTransaction if the operation failed during a
+//     transaction that can be retried.
+func (r *GroupRepo) UpdateFenced(ctx context.Context, group *domain.Group, token int64) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.UpdateFenced",
+		attribute.String("group_id", group.ID()),
+		attribute.Int64("fence", token),
+	)
+	defer end(&err)
+
+	doc := newGroupDoc(group)
+	doc.Fence = token
+
+	result, err := r.coll.ReplaceOne(ctx, fenceFilter(group.ID(), token), doc)
+	if err != nil {
+		return fmt.Errorf("replacing: %w", domainError(err, group.ID()))
 	}
 
 	if result.MatchedCount == 0 {
-		return domain.ErrNotFound
+		return r.fenceError(ctx, group.ID())
 	}
 
 	return nil
 }
 
+// fenceFilter returns a filter matching the group with the given id, if it
+// does not have a fencing token greater than token.
+func fenceFilter(id string, token int64) bson.M {
+	return bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"fence": bson.M{"$exists": false}},
+			bson.M{"fence": bson.M{"$lte": token}},
+		},
+	}
+}
+
+// fenceError returns why a fenceFilter did not match the group: it does not
+// exist or it has a greater token.
+func (r *GroupRepo) fenceError(ctx context.Context, id string) error {
+	n, err := r.coll.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("counting: %w", domainError(err, id))
+	}
+
+	if n == 0 {
+		return domain.NewError(domain.ErrNotFound, id, nil)
+	}
+
+	return domain.Errorf(domain.ErrConflict, id, "fenced with a greater token")
+}
+
+// detailsFilter returns a filter matching the documents with the given
+// details. Empty details are not stored, see groupDoc.
+func detailsFilter(d domain.Details) bson.M {
+	filter := bson.M{}
+
+	for field, value := range detailsFields(d) {
+		if value == nil {
+			filter[field] = bson.M{"$exists": false}
+		} else {
+			filter[field] = value
+		}
+	}
+
+	return filter
+}
+
+// detailsUpdate returns an update that sets the given details, removing the
+// empty ones, like groupDoc does, and the update time of the group.
+func detailsUpdate(d domain.Details, updatedAt time.Time) bson.M {
+	set := bson.M{"updated_at": updatedAt}
+	unset := bson.M{}
+
+	for field, value := range detailsFields(d) {
+		if value == nil {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+
+	update := bson.M{"$set": set}
+
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update
+}
+
+// detailsFields returns the value of each details field in a group document,
+// nil for the empty ones.
+func detailsFields(d domain.Details) map[string]any {
+	fields := map[string]any{
+		"name":        nil,
+		"description": nil,
+		"tags":        nil,
+	}
+
+	if d.Name != "" {
+		fields["name"] = d.Name
+	}
+
+	if d.Description != "" {
+		fields["description"] = d.Description
+	}
+
+	if len(d.Tags) > 0 {
+		fields["tags"] = d.Tags
+	}
+
+	return fields
+}
+
 // Load returns a group with the give id from the database.
 //
+// Documents in older schema versions are upgraded in memory, see Migrate.
+//
 // Errors:
 //   - domain.ErrNotFound if there is no group with the given ID
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
 //   - domain.ErrTransientTransaction if the operation failed during a transaction
 //     that can be retried.
-func (r *GroupRepo) Load(ctx context.Context, id string) (*domain.Group, error) {
+func (r *GroupRepo) Load(ctx context.Context, id string) (_ *domain.Group, err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Load", attribute.String("group_id", id))
+	defer end(&err)
+
 	filter := bson.M{
 		"_id": id,
 	}
 
+	raw, err := r.coll.FindOne(ctx, filter).Raw()
+	if err != nil {
+		return nil, domainError(err, id)
+	}
+
+	raw, err = upgradeRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading group %s: %v", id, err)
+	}
+
 	doc := new(groupDoc)
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, fmt.Errorf("decoding group %s: %v", id, err)
+	}
+
+	return doc.group()
+}
+
+// FindByTag returns the groups with the given tag, sorted by id.
+//
+// Documents in older schema versions are upgraded in memory, see Migrate.
+//
+// Errors:
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+//   - domain.ErrTransientTransaction if the operation failed during a transaction
+//     that can be retried.
+func (r *GroupRepo) FindByTag(ctx context.Context, tag string) (_ []*domain.Group, err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.FindByTag", attribute.String("tag", tag))
+	defer end(&err)
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := r.coll.Find(ctx, bson.M{"tags": tag}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("finding: %w", domainError(err, ""))
+	}
+	defer cursor.Close(ctx)
+
+	var result []*domain.Group
+
+	for cursor.Next(ctx) {
+		group, err := decodeGroup(cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, group)
+	}
 
-	err := r.coll.FindOne(ctx, filter).Decode(doc)
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("iterating: %w", domainError(err, ""))
+	}
+
+	return result, nil
+}
+
+// Each calls fn with every group, sorted by id, stopping at the first error
+// it returns. It fails on the first document that does not represent a valid
+// group, see Scan.
+//
+// Documents in older schema versions are upgraded in memory, see Migrate.
+//
+// It implements transfer.Source.
+//
+// Errors:
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+func (r *GroupRepo) Each(ctx context.Context, fn func(*domain.Group) error) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Each")
+	defer end(&err)
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := r.coll.Find(ctx, bson.M{}, opts)
 	if err != nil {
-		return nil, domainError(err)
+		return fmt.Errorf("finding: %w", domainError(err, ""))
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		group, err := decodeGroup(cursor.Current)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(group); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("iterating: %w", domainError(err, ""))
+	}
+
+	return nil
+}
+
+// decodeGroup returns the group in the document, upgrading it first if it
+// is in an older schema version.
+func decodeGroup(raw bson.Raw) (*domain.Group, error) {
+	doc, err := decodeGroupDoc(raw)
+	if err != nil {
+		return nil, err
 	}
 
 	return doc.group()
 }
 
+// decodeGroupDoc returns the group document in raw, upgraded to the current
+// schema version.
+func decodeGroupDoc(raw bson.Raw) (*groupDoc, error) {
+	raw, err := upgradeRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading group: %v", err)
+	}
+
+	doc := new(groupDoc)
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, fmt.Errorf("decoding group: %v", err)
+	}
+
+	return doc, nil
+}
+
+// Existing returns the ids, among the given ones, of the groups in the
+// collection, sorted.
+//
+// It implements transfer.Destination.
+//
+// Errors:
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+func (r *GroupRepo) Existing(ctx context.Context, ids []string) (_ []string, err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Existing")
+	defer end(&err)
+
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := r.coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("finding: %w", domainError(err, ""))
+	}
+
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("decoding: %w", domainError(err, ""))
+	}
+
+	result := make([]string, len(docs))
+	for i, d := range docs {
+		result[i] = d.ID
+	}
+
+	return result, nil
+}
+
+// Put stores the groups in a single bulk write, inserting the missing ones
+// and replacing the existing ones. The write is not atomic: if it fails, some
+// of the groups may have been stored.
+//
+// It does not check the fencing tokens of the existing groups, but it keeps
+// them, so the holders of older leases cannot overwrite the groups it
+// replaces either, see LoadFenced.
+//
+// It implements transfer.Destination.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if two groups of the same owner would have the
+//     same name.
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+func (r *GroupRepo) Put(ctx context.Context, groups []*domain.Group) (err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.Put", attribute.Int("groups", len(groups)))
+	defer end(&err)
+
+	models := make([]mongo.WriteModel, len(groups))
+	for i, g := range groups {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": g.ID()}).
+			SetUpdate(replaceKeepingFence(newGroupDoc(g))).
+			SetUpsert(true)
+	}
+
+	if _, err := r.coll.BulkWrite(ctx, models); err != nil {
+		return fmt.Errorf("writing: %w", domainError(err, ""))
+	}
+
+	return nil
+}
+
+// replaceKeepingFence returns an update pipeline that replaces the group
+// document by doc, keeping its fencing token, if any, so the holders of older
+// leases still cannot overwrite it, see LoadFenced.
+func replaceKeepingFence(doc *groupDoc) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$replaceWith", Value: bson.M{
+			// the document is a literal, so the strings starting with
+			// $ in it are not taken as field paths
+			"$mergeObjects": bson.A{
+				bson.M{"$literal": doc},
+				// a missing fence stays missing
+				bson.M{"fence": "$fence"},
+			},
+		}}},
+	}
+}
+
+// PutMissing stores the groups that do not exist in a single bulk write of
+// insert-only upserts, so the groups created concurrently by someone else are
+// left as they are, and returns how many it has created. The write is not
+// atomic: if it fails, some of the groups may have been stored.
+//
+// It implements transfer.Destination.
+//
+// Errors:
+//   - domain.ErrAlreadyExists if two groups of the same owner would have the
+//     same name.
+//   - domain.ErrUnavailable if MongoDB cannot be reached.
+func (r *GroupRepo) PutMissing(ctx context.Context, groups []*domain.Group) (_ int, err error) {
+	ctx, end := r.startSpan(ctx, "GroupRepo.PutMissing", attribute.Int("groups", len(groups)))
+	defer end(&err)
+
+	models := make([]mongo.WriteModel, len(groups))
+	for i, g := range groups {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": g.ID()}).
+			SetUpdate(bson.M{"$setOnInsert": newGroupDoc(g)}).
+			SetUpsert(true)
+	}
+
+	result, err := r.coll.BulkWrite(ctx, models)
+	if err != nil {
+		return 0, fmt.Errorf("writing: %w", domainError(err, ""))
+	}
+
+	return int(result.UpsertedCount), nil
+}
+
 // WithTransaction executes callback inside a transaction. If the callback
 // returns domain.ErrTransientTransaction it will be retried up to
 // maxRetries times.
@@ -98,12 +701,18 @@ func (r *GroupRepo) Load(ctx context.Context, id string) (*domain.Group, error)
 // Errors:
 //   - domain.ErrTooManyTransactionRetries if the transaction has failed
 //     more than maxRetries times.
+//   - the error of the context, wrapped, if it is done before an attempt or
+//     during a failed one. An attempt in progress is aborted by MongoDB when
+//     the context is done.
 //   - whatever non-ErrTransientTransaction errors the callback returns.
 func (s *GroupRepo) WithTransaction(
 	ctx context.Context,
 	callback func(context.Context) error,
 	maxRetries uint,
-) error {
+) (err error) {
+	ctx, end := s.startSpan(ctx, "GroupRepo.WithTransaction", attribute.Int("max_retries", int(maxRetries)))
+	defer end(&err)
+
 	session, err := s.transactionSession()
 	if err != nil {
 		return err
@@ -114,27 +723,95 @@ func (s *GroupRepo) WithTransaction(
 		return nil, callback(txCtx)
 	}
 
+	start := time.Now()
+
 	for i := range maxRetries {
-		if i > 0 {
-			log.Printf("retrying transaction, retry %d\n", i)
+		if err := ctx.Err(); err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelInfo, "transaction cancelled",
+				slog.Uint64("attempts", uint64(i)),
+				slog.Duration("duration", time.Since(start)),
+			)
+
+			return fmt.Errorf("transaction cancelled after %d attempts: %w", i, err)
 		}
 
-		switch _, err := session.WithTransaction(ctx, sessionCallback); {
+		switch err := s.attempt(ctx, session, sessionCallback, i); {
 		case err == nil:
-			log.Printf("transaction success, attempt: %d\n", i)
 			return nil // success
+		case ctx.Err() != nil:
+			// the error may not say the attempt failed because of the
+			// context, hide its kind so the error of the context prevails
+			return fmt.Errorf("transaction cancelled after %d attempts: %w: %v", i+1, ctx.Err(), err)
 		case errors.Is(err, domain.ErrTransientTransaction):
-			log.Printf("transaction failed with transient error, attempt: %d\n", i)
 			continue
 		default:
-			log.Printf("transaction failed with permanent error, attempt: %d", i)
 			return err
 		}
 	}
 
+	s.metrics.TooManyTransactionRetries()
+	s.logger.LogAttrs(ctx, slog.LevelWarn, "too many transaction retries",
+		slog.Uint64("attempts", uint64(maxRetries)),
+		slog.Duration("duration", time.Since(start)),
+	)
+
 	return domain.ErrTooManyTransactionRetries
 }
 
+// attempt runs the callback in a transaction on the session, logging,
+// measuring and tracing how it goes.
+func (s *GroupRepo) attempt(
+	ctx context.Context,
+	session mongo.Session,
+	callback func(mongo.SessionContext) (interface{}, error),
+	attempt uint,
+) (err error) {
+	ctx = logging.WithAttrs(ctx, slog.Uint64("attempt", uint64(attempt)))
+	ctx, end := s.startSpan(ctx, "transaction attempt", attribute.Int("attempt", int(attempt)))
+	defer end(&err)
+
+	start := time.Now()
+
+	s.metrics.TransactionAttempt()
+
+	switch _, err := session.WithTransaction(ctx, callback); {
+	case err == nil:
+		s.metrics.TransactionCommitted(time.Since(start))
+		s.logAttempt(ctx, slog.LevelDebug, "committed", start, nil)
+		return nil
+	case errors.Is(err, domain.ErrTransientTransaction):
+		s.metrics.TransientTransactionFailure()
+		s.logAttempt(ctx, slog.LevelInfo, "transient_failure", start, err)
+		return err
+	default:
+		s.logAttempt(ctx, slog.LevelWarn, "permanent_failure", start, err)
+		return err
+	}
+}
+
+// logAttempt logs the outcome of a transaction attempt that started at the
+// given time.
+func (r *GroupRepo) logAttempt(
+	ctx context.Context,
+	level slog.Level,
+	outcome string,
+	start time.Time,
+	err error,
+) {
+	r.logger.LogAttrs(ctx, level, "transaction attempt finished",
+		slog.String("outcome", outcome),
+		slog.Duration("duration", time.Since(start)),
+		slog.Any("error", err),
+	)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) TransactionAttempt()                {}
+func (nopMetrics) TransientTransactionFailure()       {}
+func (nopMetrics) TooManyTransactionRetries()         {}
+func (nopMetrics) TransactionCommitted(time.Duration) {}
+
 // transactionSession creates a session from the client associated with the db
 // in s. This session can be used to run transactions on it.
 //
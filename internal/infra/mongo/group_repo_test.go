@@ -2,6 +2,9 @@ package mongo_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,28 +12,77 @@ import (
 	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
 	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// now is the time used by the tests that do not care about it.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
 type groupRepoFixture struct {
 	// a context with a timeout you can use in your tests
 	ctx  context.Context
+	db   *mongodriver.Database
+	coll *mongodriver.Collection
 	repo *mongo.GroupRepo
 }
 
-func newGroupRepoFixture(t *testing.T) *groupRepoFixture {
+// fixtureOption configures a groupRepoFixture.
+type fixtureOption func(*fixtureConfig)
+
+type fixtureConfig struct {
+	timeout  time.Duration
+	noSchema bool
+}
+
+// withTimeout sets the timeout of the context of the fixture, 2 seconds by
+// default.
+func withTimeout(d time.Duration) fixtureOption {
+	return func(c *fixtureConfig) {
+		c.timeout = d
+	}
+}
+
+// withoutSchema leaves the group collection without a schema, so tests can
+// insert invalid documents in it.
+func withoutSchema() fixtureOption {
+	return func(c *fixtureConfig) {
+		c.noSchema = true
+	}
+}
+
+// newGroupRepoFixture returns a repo on the "group" collection of a new
+// database, with the group schema installed.
+func newGroupRepoFixture(t *testing.T, options ...fixtureOption) *groupRepoFixture {
 	t.Helper()
 
-	const timeout = 2 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cfg := fixtureConfig{
+		timeout: 2 * time.Second,
+	}
+
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
 	t.Cleanup(cancel)
 
 	db := testhelp.NewTestDatabase(t, mongoURI)
-	coll := db.Collection("group")
-	repo := mongo.NewGroupRepo(coll)
+
+	const collName = "group"
+	if !cfg.noSchema {
+		err := mongo.EnsureGroupSchema(ctx, db, collName)
+		require.NoError(t, err)
+	}
+
+	coll := db.Collection(collName)
 
 	return &groupRepoFixture{
 		ctx:  ctx,
-		repo: repo,
+		db:   db,
+		coll: coll,
+		repo: mongo.NewGroupRepo(coll),
 	}
 }
 
@@ -53,7 +105,7 @@ func TestGroup_Create(t *testing.T) {
 		}
 
 		// GIVEN a group with owned by ownerID in the repo
-		group := domain.NewGroup(fix.groupID, fix.ownerID)
+		group := domain.NewGroup(fix.groupID, fix.ownerID, now)
 		err := fix.repo.Create(fix.ctx, group)
 		require.NoError(t, err)
 
@@ -78,18 +130,80 @@ func TestGroup_Create(t *testing.T) {
 		}
 
 		// GIVEN a group with id fix.groupID in the store
-		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id")
+		group := domain.NewGroup(fix.groupID, "irrelevant_owner_id", now)
 		err := fix.repo.Create(fix.ctx, group)
 		require.NoError(t, err)
 
 		// WHEN you try to create another group with the same id
 		err = fix.repo.Create(fix.ctx, group)
 
-		// THEN you get an error
-		require.Error(t, err)
+		// THEN you get domain.ErrAlreadyExists about that group
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+		var domainErr *domain.Error
+		require.ErrorAs(t, err, &domainErr)
+		require.Equal(t, fix.groupID, domainErr.GroupID)
+		require.False(t, domainErr.Retryable)
 	})
 }
 
+// Tests the operations fail with domain.ErrUnavailable when MongoDB cannot be
+// reached.
+func TestGroup_Unavailable(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a repo using a server that does not exist
+	client, err := mongodriver.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1/?directConnection=true").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+
+	repo := mongo.NewGroupRepo(client.Database("db").Collection("group"))
+	ctx := context.Background()
+
+	// WHEN we use the repo
+	errs := map[string]error{
+		"create": repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now)),
+		"update": repo.Update(ctx, domain.NewGroup("group_id", "owner_id", now)),
+		"add":    repo.AddMember(ctx, "group_id", "user_id", now),
+	}
+	_, errs["load"] = repo.Load(ctx, "group_id")
+
+	// THEN every operation fails with a retryable domain.ErrUnavailable
+	for op, err := range errs {
+		require.ErrorIsf(t, err, domain.ErrUnavailable, "op %s", op)
+		require.Truef(t, domain.IsRetryable(err), "op %s", op)
+	}
+}
+
+// Tests the operations interrupted because their context is done fail with
+// the error of the context, instead of domain.ErrUnavailable.
+func TestGroup_ContextDone(t *testing.T) {
+	t.Parallel()
+
+	fix := newGroupRepoFixture(t)
+
+	// GIVEN a cancelled context and an expired one
+	cancelled, cancel := context.WithCancel(fix.ctx)
+	cancel()
+
+	expired, cancel := context.WithTimeout(fix.ctx, -time.Second)
+	t.Cleanup(cancel)
+
+	// WHEN we use the repo with them
+	_, cancelledErr := fix.repo.Load(cancelled, "group_id")
+	expiredErr := fix.repo.Create(expired, domain.NewGroup("group_id", "owner_id", now))
+
+	// THEN they fail with the error of their context, without a kind
+	require.ErrorIs(t, cancelledErr, context.Canceled)
+	require.ErrorIs(t, expiredErr, context.DeadlineExceeded)
+
+	for _, err := range []error{cancelledErr, expiredErr} {
+		require.Equal(t, domain.Kind(""), domain.KindOf(err))
+	}
+}
+
 func TestGroup_Update(t *testing.T) {
 	t.Parallel()
 
@@ -106,8 +220,8 @@ func TestGroup_Update(t *testing.T) {
 		}
 
 		// GIVEN two groups with the same id
-		group1 := domain.NewGroup(fix.groupID, "owner_id_1")
-		group2 := domain.NewGroup(fix.groupID, "owner_id_2")
+		group1 := domain.NewGroup(fix.groupID, "owner_id_1", now)
+		group2 := domain.NewGroup(fix.groupID, "owner_id_2", now)
 		require.Equal(t, group1.ID(), group2.ID())
 		require.NotEqual(t, group1.Snapshot(), group2.Snapshot())
 
@@ -138,7 +252,7 @@ func TestGroup_Update(t *testing.T) {
 		}
 
 		// GIVEN a group
-		group := domain.NewGroup("irrelevant_group_id", "irrelevant_group_id")
+		group := domain.NewGroup("irrelevant_group_id", "irrelevant_group_id", now)
 
 		// WHEN we update the group
 		err := fix.repo.Update(fix.ctx, group)
@@ -163,3 +277,675 @@ func TestGroup_LoadNotFound(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorIs(t, err, domain.ErrNotFound)
 }
+
+func TestGroup_AddMember(t *testing.T) {
+	t.Parallel()
+
+	// fullGroup returns a full group owned by "owner_id".
+	fullGroup := func(t *testing.T) *domain.Group {
+		t.Helper()
+
+		group := domain.NewGroup("group_id", "owner_id", now)
+		for i := range domain.MaxMembers - 1 {
+			err := group.AddMember(fmt.Sprintf("member_id_%d", i), now)
+			require.NoError(t, err)
+		}
+
+		return group
+	}
+
+	// Tests AddMember adds the user to the group.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we add a member
+		joined := now.Add(time.Minute)
+		err = fix.repo.AddMember(fix.ctx, "group_id", "user_id", joined)
+		require.NoError(t, err)
+
+		// WHEN we add it again
+		err = fix.repo.AddMember(fix.ctx, "group_id", "user_id", joined.Add(time.Minute))
+		require.NoError(t, err)
+
+		// THEN the group has the new member, only once
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id", "user_id"}, got.Members())
+
+		// THEN the member joined when it was first added, which is the
+		// last update of the group
+		joinedAt, ok := got.JoinedAt("user_id")
+		require.True(t, ok)
+		require.Equal(t, joined, joinedAt)
+		require.Equal(t, joined, got.UpdatedAt())
+		require.Equal(t, now, got.CreatedAt())
+	})
+
+	// Tests AddMember keeps the stored members sorted, like Update.
+	t.Run("sorted members", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "m_owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we add members that sort before and after the owner
+		err = fix.repo.AddMember(fix.ctx, "group_id", "z_user_id", now)
+		require.NoError(t, err)
+
+		err = fix.repo.AddMember(fix.ctx, "group_id", "a_user_id", now)
+		require.NoError(t, err)
+
+		// THEN the stored members are sorted
+		var doc struct {
+			Members []string `bson:"members"`
+		}
+
+		err = fix.coll.FindOne(fix.ctx, bson.M{"_id": "group_id"}).Decode(&doc)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a_user_id", "m_owner_id", "z_user_id"}, doc.Members)
+	})
+
+	// Tests AddMember fails on full groups, like domain.Group.AddMember.
+	t.Run("full group", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a full group in the db
+		group := fullGroup(t)
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		// WHEN we add a new member and an existing one
+		errNew := fix.repo.AddMember(fix.ctx, "group_id", "user_id", now)
+		errExisting := fix.repo.AddMember(fix.ctx, "group_id", "owner_id", now)
+
+		// THEN both fail with domain.ErrGroupFull, like the domain does
+		require.ErrorIs(t, errNew, domain.ErrGroupFull)
+		require.ErrorIs(t, errExisting, domain.ErrGroupFull)
+		require.ErrorIs(t, group.AddMember("owner_id", now), domain.ErrGroupFull)
+
+		// THEN the group has not changed
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// Tests AddMember fails if the group does not exist.
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// WHEN we add a member to a non existing group
+		err := fix.repo.AddMember(fix.ctx, "non_existing_group_id", "user_id", now)
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	// Tests concurrent calls respect the maximum number of members, without
+	// transactions.
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we add more users than fit in the group at the same time
+		const userCount = 4 * domain.MaxMembers
+
+		var wg sync.WaitGroup
+		wg.Add(userCount)
+
+		results := make([]error, userCount)
+		for i := range userCount {
+			go func() {
+				defer wg.Done()
+				results[i] = fix.repo.AddMember(fix.ctx, "group_id", fmt.Sprintf("user_id_%02d", i), now)
+			}()
+		}
+
+		wg.Wait()
+
+		// THEN only the users that fit are added, the rest get ErrGroupFull
+		successCount := 0
+		for i, err := range results {
+			switch {
+			case err == nil:
+				successCount++
+			case errors.Is(err, domain.ErrGroupFull):
+			default:
+				t.Fatalf("user %d: unexpected error: %v", i, err)
+			}
+		}
+
+		require.Equal(t, domain.MaxMembers-1, successCount)
+
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, domain.MaxMembers, got.NumMembers())
+	})
+}
+
+// Tests the names of the groups of the same owner are unique.
+func TestGroup_UniqueNames(t *testing.T) {
+	t.Parallel()
+
+	fix := newGroupRepoFixture(t)
+
+	// GIVEN a named group and an unnamed group in the db
+	err := fix.repo.Create(fix.ctx, testhelp.NamedGroup(t, now, "group_id_1", "owner_id", "name"))
+	require.NoError(t, err)
+
+	err = fix.repo.Create(fix.ctx, domain.NewGroup("group_id_2", "owner_id", now))
+	require.NoError(t, err)
+
+	// WHEN the same owner creates another group with the same name
+	err = fix.repo.Create(fix.ctx, testhelp.NamedGroup(t, now, "group_id_3", "owner_id", "name"))
+
+	// THEN we get domain.ErrAlreadyExists
+	require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+	// THEN other owners can use the name
+	err = fix.repo.Create(fix.ctx, testhelp.NamedGroup(t, now, "group_id_4", "other_owner_id", "name"))
+	require.NoError(t, err)
+
+	// THEN the same owner can have several unnamed groups
+	err = fix.repo.Create(fix.ctx, domain.NewGroup("group_id_5", "owner_id", now))
+	require.NoError(t, err)
+}
+
+func TestGroup_UpdateDetails(t *testing.T) {
+	t.Parallel()
+
+	// Tests UpdateDetails stores the new details when the stored ones have
+	// not changed, including removing them.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a named group in the db
+		group := testhelp.NamedGroup(t, now, "group_id", "owner_id", "name", "tag")
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		// WHEN we change its details
+		previous := group.Details()
+		err = group.SetDetails(domain.Details{Description: "description", Tags: []string{"other-tag"}}, now)
+		require.NoError(t, err)
+
+		err = fix.repo.UpdateDetails(fix.ctx, group, previous)
+		require.NoError(t, err)
+
+		// THEN loading the group returns the new details
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// Tests UpdateDetails fails if the stored details have changed.
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db
+		group := testhelp.NamedGroup(t, now, "group_id", "owner_id", "name")
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		// GIVEN its details are changed concurrently
+		concurrent := testhelp.NamedGroup(t, now, "group_id", "owner_id", "concurrent")
+		err = fix.repo.UpdateDetails(fix.ctx, concurrent, group.Details())
+		require.NoError(t, err)
+
+		// WHEN we update the details loaded before
+		previous := group.Details()
+		err = group.SetDetails(domain.Details{Name: "new"}, now)
+		require.NoError(t, err)
+
+		err = fix.repo.UpdateDetails(fix.ctx, group, previous)
+
+		// THEN we get domain.ErrConflict
+		require.ErrorIs(t, err, domain.ErrConflict)
+
+		// THEN the concurrent details are kept
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, "concurrent", got.Details().Name)
+	})
+
+	// Tests UpdateDetails keeps the names of the groups of the same owner
+	// unique.
+	t.Run("duplicated name", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN two groups of the same owner
+		err := fix.repo.Create(fix.ctx, testhelp.NamedGroup(t, now, "group_id_1", "owner_id", "name"))
+		require.NoError(t, err)
+
+		group := domain.NewGroup("group_id_2", "owner_id", now)
+		err = fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		// WHEN we rename the second one to the name of the first one
+		previous := group.Details()
+		err = group.SetDetails(domain.Details{Name: "name"}, now)
+		require.NoError(t, err)
+
+		err = fix.repo.UpdateDetails(fix.ctx, group, previous)
+
+		// THEN we get domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+
+	// Tests UpdateDetails fails if the group does not exist.
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// WHEN we update the details of a non existing group
+		group := testhelp.NamedGroup(t, now, "non_existing_group_id", "owner_id", "name")
+		err := fix.repo.UpdateDetails(fix.ctx, group, domain.Details{})
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
+
+// Tests FindByTag returns the groups with the given tag, sorted by id.
+func TestGroup_FindByTag(t *testing.T) {
+	t.Parallel()
+
+	fix := newGroupRepoFixture(t)
+
+	// GIVEN some groups with and without the tag
+	groups := []*domain.Group{
+		testhelp.NamedGroup(t, now, "group_id_2", "owner_id", "two", "tag", "other-tag"),
+		testhelp.NamedGroup(t, now, "group_id_1", "owner_id", "one", "tag"),
+		testhelp.NamedGroup(t, now, "group_id_3", "owner_id", "three", "other-tag"),
+		domain.NewGroup("group_id_4", "owner_id", now),
+	}
+
+	for _, group := range groups {
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+	}
+
+	// WHEN we find the groups by tag
+	got, err := fix.repo.FindByTag(fix.ctx, "tag")
+	require.NoError(t, err)
+
+	// THEN we get the groups with the tag, sorted by id
+	require.Len(t, got, 2)
+	require.Equal(t, groups[1].Snapshot(), got[0].Snapshot())
+	require.Equal(t, groups[0].Snapshot(), got[1].Snapshot())
+
+	// WHEN we find the groups by an unused tag
+	got, err = fix.repo.FindByTag(fix.ctx, "unused")
+	require.NoError(t, err)
+
+	// THEN we get no groups
+	require.Empty(t, got)
+}
+
+func TestGroup_Transfer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("each", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN some groups
+		for _, id := range []string{"b", "a", "c"} {
+			err := fix.repo.Create(fix.ctx, domain.NewGroup(id, "owner_id", now))
+			require.NoError(t, err)
+		}
+
+		// WHEN we iterate over them
+		var got []string
+
+		err := fix.repo.Each(fix.ctx, func(g *domain.Group) error {
+			got = append(got, g.ID())
+			return nil
+		})
+		require.NoError(t, err)
+
+		// THEN we get all of them sorted by id
+		require.Equal(t, []string{"a", "b", "c"}, got)
+	})
+
+	t.Run("put", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("old", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN we put a new version of it and a new group
+		updated := testhelp.NamedGroup(t, now, "old", "owner_id", "name", "tag")
+		err = updated.AddMember("user_id", now)
+		require.NoError(t, err)
+
+		created := domain.NewGroup("new", "owner_id", now)
+
+		err = fix.repo.Put(fix.ctx, []*domain.Group{updated, created})
+		require.NoError(t, err)
+
+		// THEN both exist
+		existing, err := fix.repo.Existing(fix.ctx, []string{"old", "missing", "new"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"new", "old"}, existing)
+
+		// THEN they are stored as they were put
+		for _, want := range []*domain.Group{updated, created} {
+			got, err := fix.repo.Load(fix.ctx, want.ID())
+			require.NoError(t, err)
+			require.Equal(t, want.Snapshot(), got.Snapshot())
+		}
+	})
+
+	t.Run("put keeps the fence", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group written with token 2
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		_, err = fix.repo.LoadFenced(fix.ctx, "group_id", 2)
+		require.NoError(t, err)
+
+		// WHEN we put a new version of it, with a name starting with $
+		updated := testhelp.NamedGroup(t, now, "group_id", "owner_id", "$name")
+		err = fix.repo.Put(fix.ctx, []*domain.Group{updated})
+		require.NoError(t, err)
+
+		// THEN it is stored as it was put
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, updated.Snapshot(), got.Snapshot())
+
+		// THEN it cannot be written with an older token
+		stale := domain.NewGroup("group_id", "owner_id", now)
+		err = fix.repo.UpdateFenced(fix.ctx, stale, 1)
+		require.ErrorIs(t, err, domain.ErrConflict)
+	})
+
+	t.Run("put with duplicated names", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a named group
+		err := fix.repo.Create(fix.ctx, testhelp.NamedGroup(t, now, "a", "owner_id", "name"))
+		require.NoError(t, err)
+
+		// WHEN we put another group of the same owner with the same name
+		err = fix.repo.Put(fix.ctx, []*domain.Group{testhelp.NamedGroup(t, now, "b", "owner_id", "name")})
+
+		// THEN we get domain.ErrAlreadyExists
+		require.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+
+	t.Run("put missing", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group
+		old := domain.NewGroup("old", "owner_id", now)
+		err := fix.repo.Create(fix.ctx, old)
+		require.NoError(t, err)
+
+		// WHEN we put a new version of it and a new group, only if missing
+		updated := testhelp.NamedGroup(t, now, "old", "owner_id", "name", "tag")
+		created := domain.NewGroup("new", "owner_id", now)
+
+		n, err := fix.repo.PutMissing(fix.ctx, []*domain.Group{updated, created})
+		require.NoError(t, err)
+
+		// THEN only the new group is created
+		require.Equal(t, 1, n)
+
+		// THEN the existing group is left as it was
+		for _, want := range []*domain.Group{old, created} {
+			got, err := fix.repo.Load(fix.ctx, want.ID())
+			require.NoError(t, err)
+			require.Equal(t, want.Snapshot(), got.Snapshot())
+		}
+	})
+}
+
+func TestGroup_WithTransactionCancelled(t *testing.T) {
+	t.Parallel()
+
+	t.Run("before starting", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a cancelled context
+		ctx, cancel := context.WithCancel(fix.ctx)
+		cancel()
+
+		// WHEN we run a transaction with it
+		called := false
+		err := fix.repo.WithTransaction(ctx, func(context.Context) error {
+			called = true
+			return nil
+		}, 3)
+
+		// THEN it fails with the error of the context, without running
+		// the callback
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+		require.False(t, called)
+	})
+
+	t.Run("mid-transaction", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group
+		err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+		require.NoError(t, err)
+
+		// WHEN the context is cancelled after the transaction has
+		// updated the group and created another one
+		ctx, cancel := context.WithCancel(fix.ctx)
+		t.Cleanup(cancel)
+
+		err = fix.repo.WithTransaction(ctx, func(txCtx context.Context) error {
+			group, err := fix.repo.Load(txCtx, "group_id")
+			if err != nil {
+				return err
+			}
+
+			if err := group.AddMember("user_id", now); err != nil {
+				return err
+			}
+
+			if err := fix.repo.Update(txCtx, group); err != nil {
+				return err
+			}
+
+			if err := fix.repo.Create(txCtx, domain.NewGroup("other_group_id", "owner_id", now)); err != nil {
+				return err
+			}
+
+			cancel()
+
+			return nil
+		}, 3)
+
+		// THEN it fails with the error of the context, not because of the
+		// retries
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, domain.ErrTooManyTransactionRetries)
+		require.NotErrorIs(t, err, domain.ErrUnavailable)
+
+		// THEN nothing has been written
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner_id"}, got.Members())
+
+		_, err = fix.repo.Load(fix.ctx, "other_group_id")
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
+
+func TestGroup_UpdateFenced(t *testing.T) {
+	t.Parallel()
+
+	// Tests UpdateFenced overwrites the group with the same or greater
+	// tokens, including the groups never written with a token.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db, never written with a token
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		// WHEN we add members and store it with increasing tokens, twice
+		// with the same one
+		for i, token := range []int64{1, 2, 2} {
+			err = group.AddMember(fmt.Sprintf("user_id_%d", i), now)
+			require.NoError(t, err)
+
+			err = fix.repo.UpdateFenced(fix.ctx, group, token)
+			require.NoError(t, err)
+		}
+
+		// THEN loading the group returns the last version
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// Tests UpdateFenced fails if the group has been written with a greater
+	// token, leaving it untouched.
+	t.Run("stale token", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group written with token 2
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		err = fix.repo.UpdateFenced(fix.ctx, group, 2)
+		require.NoError(t, err)
+
+		// WHEN we write it with token 1
+		stale := domain.NewGroup("group_id", "owner_id", now)
+		err = stale.AddMember("user_id", now)
+		require.NoError(t, err)
+
+		err = fix.repo.UpdateFenced(fix.ctx, stale, 1)
+
+		// THEN we get domain.ErrConflict and the group is not changed
+		require.ErrorIs(t, err, domain.ErrConflict)
+
+		got, err := fix.repo.Load(fix.ctx, "group_id")
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+	})
+
+	// Tests UpdateFenced fails if the group does not exist.
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group that is not in the db
+		group := domain.NewGroup("group_id", "owner_id", now)
+
+		// WHEN we write it
+		err := fix.repo.UpdateFenced(fix.ctx, group, 1)
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
+
+func TestGroup_LoadFenced(t *testing.T) {
+	t.Parallel()
+
+	// Tests LoadFenced returns the group and stores the token, so the writes
+	// with smaller tokens fail.
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// GIVEN a group in the db, loaded with token 1
+		group := domain.NewGroup("group_id", "owner_id", now)
+		err := fix.repo.Create(fix.ctx, group)
+		require.NoError(t, err)
+
+		stale, err := fix.repo.LoadFenced(fix.ctx, "group_id", 1)
+		require.NoError(t, err)
+
+		// WHEN we load it with token 2
+		got, err := fix.repo.LoadFenced(fix.ctx, "group_id", 2)
+
+		// THEN we get the group
+		require.NoError(t, err)
+		require.Equal(t, group.Snapshot(), got.Snapshot())
+
+		// THEN it cannot be written nor loaded with token 1 anymore
+		require.NoError(t, stale.AddMember("user_id", now))
+
+		err = fix.repo.UpdateFenced(fix.ctx, stale, 1)
+		require.ErrorIs(t, err, domain.ErrConflict)
+
+		_, err = fix.repo.LoadFenced(fix.ctx, "group_id", 1)
+		require.ErrorIs(t, err, domain.ErrConflict)
+
+		// THEN it can still be written with token 2
+		require.NoError(t, got.AddMember("user_id", now))
+
+		err = fix.repo.UpdateFenced(fix.ctx, got, 2)
+		require.NoError(t, err)
+	})
+
+	// Tests LoadFenced fails if the group does not exist.
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newGroupRepoFixture(t)
+
+		// WHEN we load a group that is not in the db
+		_, err := fix.repo.LoadFenced(fix.ctx, "group_id", 1)
+
+		// THEN we get domain.ErrNotFound
+		require.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
@@ -0,0 +1,170 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultLeaseTTL is how long the leases last by default, see
+	// WithLeaseTTL.
+	defaultLeaseTTL = 10 * time.Second
+	// defaultLockPollInterval is how often a LeaseLocker checks by default if
+	// a lock is free, see WithLockPollInterval.
+	defaultLockPollInterval = 20 * time.Millisecond
+)
+
+// LeaseLocker is a pessimistic lock on the groups, stored in its own
+// collection, with a document per group. It implements application.Locker.
+//
+// The locks are leases: they expire after a while, so if their holder dies,
+// others can take them over. Each lease has a fencing token, greater than the
+// ones of the previous leases of the same group, to be used with
+// GroupRepo.LoadFenced and GroupRepo.UpdateFenced, so a holder whose lease
+// has expired cannot overwrite the changes of the next one.
+//
+// The expiration times are computed by MongoDB, so they do not depend on the
+// clocks of the instances.
+type LeaseLocker struct {
+	coll *mongo.Collection
+	ttl  time.Duration
+	poll time.Duration
+}
+
+type LockOption func(*LeaseLocker)
+
+// WithLeaseTTL sets how long the leases last, it should be much longer than
+// the operations run while holding them.
+//
+// By default, 10 seconds.
+func WithLeaseTTL(ttl time.Duration) LockOption {
+	return func(l *LeaseLocker) {
+		l.ttl = ttl
+	}
+}
+
+// WithLockPollInterval sets how often Lock checks if a lock held by others
+// is free.
+//
+// By default, every 20 milliseconds.
+func WithLockPollInterval(d time.Duration) LockOption {
+	return func(l *LeaseLocker) {
+		l.poll = d
+	}
+}
+
+func NewLeaseLocker(coll *mongo.Collection, options ...LockOption) *LeaseLocker {
+	l := &LeaseLocker{
+		coll: coll,
+		ttl:  defaultLeaseTTL,
+		poll: defaultLockPollInterval,
+	}
+
+	for _, o := range options {
+		o(l)
+	}
+
+	return l
+}
+
+// lockDoc is a Mongo document representing the lock of a group. They are
+// never deleted, so the fencing tokens of a group always increase.
+type lockDoc struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	Fence     int64     `bson:"fence"`
+}
+
+// Lock waits until the lock of the group is free, or its lease has expired,
+// and takes it, until ctx is done.
+//
+// It returns the fencing token of the new lease and a function to release
+// it, which does nothing if the lease has already been taken over.
+func (l *LeaseLocker) Lock(ctx context.Context, groupID string) (int64, func(context.Context) error, error) {
+	owner, err := randomOwner()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for {
+		token, ok, err := l.tryLock(ctx, groupID, owner)
+		if err != nil {
+			return 0, nil, fmt.Errorf("acquiring lock: %w", domainError(err, groupID))
+		}
+
+		if ok {
+			unlock := func(ctx context.Context) error {
+				return l.unlock(ctx, groupID, owner, token)
+			}
+
+			return token, unlock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, fmt.Errorf("waiting for lock: %w", ctx.Err())
+		case <-time.After(l.poll):
+		}
+	}
+}
+
+// tryLock takes the lock if it does not exist or if its lease has expired,
+// and returns its new fencing token.
+func (l *LeaseLocker) tryLock(ctx context.Context, groupID, owner string) (int64, bool, error) {
+	filter := bson.M{
+		"_id":   groupID,
+		"$expr": bson.M{"$lt": bson.A{"$expires_at", "$$NOW"}},
+	}
+
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"owner":      owner,
+			"expires_at": bson.M{"$add": bson.A{"$$NOW", l.ttl.Milliseconds()}},
+			"fence":      bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$fence", int64(0)}}, int64(1)}},
+		}},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var doc lockDoc
+
+	// if the lock is held, the filter does not match and the upsert fails,
+	// as there is already a document with the same id
+	err := l.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return doc.Fence, true, nil
+}
+
+// unlock releases the lease, if it has not been taken over, by expiring it.
+func (l *LeaseLocker) unlock(ctx context.Context, groupID, owner string, token int64) error {
+	filter := bson.M{
+		"_id":   groupID,
+		"owner": owner,
+		"fence": token,
+	}
+
+	update := bson.M{
+		"$set": bson.M{"expires_at": time.Unix(0, 0).UTC()},
+	}
+
+	if _, err := l.coll.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("releasing lock: %w", domainError(err, groupID))
+	}
+
+	return nil
+}
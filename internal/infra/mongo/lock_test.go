@@ -0,0 +1,334 @@
+package mongo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/stretchr/testify/require"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+type leaseLockerFixture struct {
+	*groupRepoFixture
+	locks *mongodriver.Collection
+}
+
+func newLeaseLockerFixture(t *testing.T) *leaseLockerFixture {
+	t.Helper()
+
+	fix := newGroupRepoFixture(t, withTimeout(5*time.Second))
+
+	return &leaseLockerFixture{
+		groupRepoFixture: fix,
+		locks:            fix.db.Collection("group_locks"),
+	}
+}
+
+// locker returns a LeaseLocker polling often, with the given options.
+func (f *leaseLockerFixture) locker(options ...mongo.LockOption) *mongo.LeaseLocker {
+	options = append([]mongo.LockOption{mongo.WithLockPollInterval(time.Millisecond)}, options...)
+
+	return mongo.NewLeaseLocker(f.locks, options...)
+}
+
+// Tests the lock of a group is held by one caller at a time, each with a
+// greater token, and the groups are independent.
+func TestLeaseLocker_MutualExclusion(t *testing.T) {
+	t.Parallel()
+
+	fix := newLeaseLockerFixture(t)
+	locker := fix.locker()
+
+	const callers = 10
+
+	var (
+		holders atomic.Int32
+		mu      sync.Mutex
+		tokens  []int64
+		wg      sync.WaitGroup
+	)
+
+	// GIVEN another group whose lock is held during the whole test
+	_, unlockOther, err := locker.Lock(fix.ctx, "other_group_id")
+	require.NoError(t, err)
+
+	// WHEN several callers take the lock of a group at the same time
+	wg.Add(callers)
+
+	for range callers {
+		go func() {
+			defer wg.Done()
+
+			token, unlock, err := locker.Lock(fix.ctx, "group_id")
+			if !assertNoError(t, err) {
+				return
+			}
+
+			// THEN there is only one holder at a time
+			if n := holders.Add(1); n != 1 {
+				t.Errorf("%d holders", n)
+			}
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			tokens = append(tokens, token)
+			mu.Unlock()
+
+			holders.Add(-1)
+
+			assertNoError(t, unlock(fix.ctx))
+		}()
+	}
+
+	wg.Wait()
+
+	// THEN they all got it, in the order of their tokens
+	require.Len(t, tokens, callers)
+	for i, token := range tokens {
+		require.Equal(t, int64(i+1), token)
+	}
+
+	require.NoError(t, unlockOther(fix.ctx))
+}
+
+// assertNoError reports err, if any, without stopping the test, so it can be
+// called from other goroutines.
+func assertNoError(t *testing.T, err error) bool {
+	t.Helper()
+
+	if err != nil {
+		t.Error(err)
+		return false
+	}
+
+	return true
+}
+
+// Tests Lock waits for the lock to be free until the context is done.
+func TestLeaseLocker_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	fix := newLeaseLockerFixture(t)
+	locker := fix.locker()
+
+	// GIVEN a held lock
+	_, _, err := locker.Lock(fix.ctx, "group_id")
+	require.NoError(t, err)
+
+	// WHEN we try to take it with a short timeout
+	ctx, cancel := context.WithTimeout(fix.ctx, 50*time.Millisecond)
+	t.Cleanup(cancel)
+
+	_, _, err = locker.Lock(ctx, "group_id")
+
+	// THEN we get the error of the context
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// Tests an expired lease is taken over by the next caller, and its holder
+// cannot release the lock of the new holder.
+func TestLeaseLocker_ExpiredLease(t *testing.T) {
+	t.Parallel()
+
+	fix := newLeaseLockerFixture(t)
+
+	const ttl = 500 * time.Millisecond
+	locker := fix.locker(mongo.WithLeaseTTL(ttl))
+
+	// GIVEN a holder that stalls until its lease expires
+	staleToken, staleUnlock, err := locker.Lock(fix.ctx, "group_id")
+	require.NoError(t, err)
+
+	// WHEN another caller takes the lock
+	start := time.Now()
+
+	token, unlock, err := locker.Lock(fix.ctx, "group_id")
+	require.NoError(t, err)
+
+	// THEN it gets it after the lease expires, with a greater token
+	require.GreaterOrEqual(t, time.Since(start), ttl/2)
+	require.Greater(t, token, staleToken)
+
+	// THEN the stale holder cannot release the new lease
+	require.NoError(t, staleUnlock(fix.ctx))
+
+	ctx, cancel := context.WithTimeout(fix.ctx, ttl/10)
+	t.Cleanup(cancel)
+
+	_, _, err = locker.Lock(ctx, "group_id")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// THEN the new holder can
+	require.NoError(t, unlock(fix.ctx))
+
+	_, _, err = locker.Lock(fix.ctx, "group_id")
+	require.NoError(t, err)
+}
+
+// Tests a holder whose lease has expired cannot overwrite the changes of the
+// next holder, whatever the order of their loads and writes, and its changes
+// are not lost when they are accepted.
+func TestLeaseLocker_StaleHolder(t *testing.T) {
+	t.Parallel()
+
+	// step is a load or a write of the stale holder or the new one.
+	type step int
+
+	const (
+		newLoad step = iota
+		newWrite
+		staleWrite
+	)
+
+	tests := map[string]struct {
+		steps []step
+		// staleErr is the error of the write of the stale holder, if any
+		staleErr error
+		// wantMembers are the members of the group at the end
+		wantMembers []string
+	}{
+		"stale write after the new write": {
+			steps:       []step{newLoad, newWrite, staleWrite},
+			staleErr:    domain.ErrConflict,
+			wantMembers: []string{"new_user_id", "owner_id"},
+		},
+		"stale write between the new load and write": {
+			steps:       []step{newLoad, staleWrite, newWrite},
+			staleErr:    domain.ErrConflict,
+			wantMembers: []string{"new_user_id", "owner_id"},
+		},
+		"stale write before the new load": {
+			steps:       []step{staleWrite, newLoad, newWrite},
+			wantMembers: []string{"new_user_id", "owner_id", "stale_user_id"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fix := newLeaseLockerFixture(t)
+			locker := fix.locker(mongo.WithLeaseTTL(100 * time.Millisecond))
+
+			// GIVEN a group
+			err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+			require.NoError(t, err)
+
+			// GIVEN a holder that loads the group and stalls until its
+			// lease expires
+			staleToken, _, err := locker.Lock(fix.ctx, "group_id")
+			require.NoError(t, err)
+
+			stale, err := fix.repo.LoadFenced(fix.ctx, "group_id", staleToken)
+			require.NoError(t, err)
+			require.NoError(t, stale.AddMember("stale_user_id", now))
+
+			// GIVEN another caller that takes the lock after the lease
+			// expires
+			token, _, err := locker.Lock(fix.ctx, "group_id")
+			require.NoError(t, err)
+
+			// WHEN they load and write the group in the given order
+			var group *domain.Group
+
+			for _, step := range test.steps {
+				switch step {
+				case newLoad:
+					group, err = fix.repo.LoadFenced(fix.ctx, "group_id", token)
+					require.NoError(t, err)
+					require.NoError(t, group.AddMember("new_user_id", now))
+				case newWrite:
+					err = fix.repo.UpdateFenced(fix.ctx, group, token)
+					require.NoError(t, err)
+				case staleWrite:
+					err = fix.repo.UpdateFenced(fix.ctx, stale, staleToken)
+					if test.staleErr == nil {
+						require.NoError(t, err)
+					} else {
+						require.ErrorIs(t, err, test.staleErr)
+					}
+				}
+			}
+
+			// THEN the group has the changes of the writes that
+			// succeeded
+			got, err := fix.repo.Load(fix.ctx, "group_id")
+			require.NoError(t, err)
+			require.Equal(t, test.wantMembers, got.Members())
+
+			// THEN the stale holder cannot load it anymore
+			_, err = fix.repo.LoadFenced(fix.ctx, "group_id", staleToken)
+			require.ErrorIs(t, err, domain.ErrConflict)
+		})
+	}
+}
+
+// Tests concurrent load and update cycles of the same group do not lose
+// updates nor break its invariants while holding its lock.
+func TestLeaseLocker_Invariants(t *testing.T) {
+	t.Parallel()
+
+	fix := newLeaseLockerFixture(t)
+	locker := fix.locker()
+
+	// GIVEN a group
+	err := fix.repo.Create(fix.ctx, domain.NewGroup("group_id", "owner_id", now))
+	require.NoError(t, err)
+
+	// WHEN more users than fit in the group are added concurrently, each
+	// while holding the lock
+	const users = 2 * domain.MaxMembers
+
+	var (
+		full atomic.Int32
+		wg   sync.WaitGroup
+	)
+
+	wg.Add(users)
+
+	for i := range users {
+		go func() {
+			defer wg.Done()
+
+			token, unlock, err := locker.Lock(fix.ctx, "group_id")
+			if !assertNoError(t, err) {
+				return
+			}
+
+			defer func() { assertNoError(t, unlock(fix.ctx)) }()
+
+			group, err := fix.repo.LoadFenced(fix.ctx, "group_id", token)
+			if !assertNoError(t, err) {
+				return
+			}
+
+			if err := group.AddMember(fmt.Sprintf("user_id_%d", i), now); err != nil {
+				if !errors.Is(err, domain.ErrGroupFull) {
+					t.Error(err)
+				}
+
+				full.Add(1)
+
+				return
+			}
+
+			assertNoError(t, fix.repo.UpdateFenced(fix.ctx, group, token))
+		}()
+	}
+
+	wg.Wait()
+
+	// THEN the group is full, with no lost updates
+	got, err := fix.repo.Load(fix.ctx, "group_id")
+	require.NoError(t, err)
+	require.Len(t, got.Members(), domain.MaxMembers)
+	require.Equal(t, int32(users-(domain.MaxMembers-1)), full.Load())
+}
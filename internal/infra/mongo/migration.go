@@ -0,0 +1,515 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// migration upgrades group documents from the previous schema version to the
+// next one.
+type migration struct {
+	description string
+	// upgrade modifies a document in the previous version so it becomes a
+	// document in the next version. It must not set the schema_version
+	// field, that is done by the caller.
+	upgrade func(doc bson.M) error
+}
+
+// migrations are the steps to upgrade group documents, the document version
+// after applying migrations[i] is i+1. Documents without a schema_version
+// field are in version 0.
+//
+// Never modify or remove existing migrations, append new ones instead.
+var migrations = []migration{
+	{
+		description: "add schema_version",
+		upgrade:     func(bson.M) error { return nil },
+	},
+	{
+		description: "sort the members",
+		upgrade:     sortMembers,
+	},
+}
+
+// sortMembers sorts the members of the document alphabetically, as they are
+// in the documents written by GroupRepo, so documents can be matched by
+// their members, like Scan does when repairing them. Documents with
+// malformed members are left as they are, they cannot be loaded anyway.
+func sortMembers(doc bson.M) error {
+	members, ok := doc["members"].(bson.A)
+	if !ok {
+		return nil
+	}
+
+	ids := make([]string, len(members))
+	for i, m := range members {
+		id, ok := m.(string)
+		if !ok {
+			return nil
+		}
+
+		ids[i] = id
+	}
+
+	slices.Sort(ids)
+
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	return nil
+}
+
+// currentSchemaVersion is the version of the documents written by this
+// code.
+var currentSchemaVersion = len(migrations)
+
+const (
+	// migrationsCollName is the collection where the applied migrations
+	// and the migration lock are stored.
+	migrationsCollName = "schema_migrations"
+	// defaultMigrationLockTTL is how long the migration lease lasts by
+	// default, see WithMigrationLockTTL.
+	defaultMigrationLockTTL = 30 * time.Second
+	// migrationLockPollInterval is how often an instance waiting for the
+	// migration lock checks if it is free.
+	migrationLockPollInterval = 100 * time.Millisecond
+)
+
+// migrationRecord is a Mongo document recording an applied migration.
+type migrationRecord struct {
+	ID          string    `bson:"_id"`
+	Collection  string    `bson:"collection"`
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// migrationLock is a Mongo document held by the instance running the
+// migrations.
+type migrationLock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// errMigrationLockLost is the cause of the cancellation of the migrations
+// when their lease has been taken over by another instance.
+var errMigrationLockLost = errors.New("migration lock lost")
+
+// MigrateOption configures Migrate.
+type MigrateOption func(*migrator)
+
+// WithMigrationLockTTL sets how long the migration lease lasts if it is not
+// renewed, which happens every third of it while migrating. It is how long
+// other instances wait to take over the migrations if its holder dies.
+//
+// By default, 30 seconds.
+func WithMigrationLockTTL(ttl time.Duration) MigrateOption {
+	return func(m *migrator) {
+		m.lockTTL = ttl
+	}
+}
+
+type migrator struct {
+	lockTTL time.Duration
+}
+
+// Migrate upgrades all the documents in the group collection with the given
+// name to the current schema version, applying the pending migrations in
+// order, and records them in the schema_migrations collection.
+//
+// Only one instance runs the migrations at a time, the others wait for it to
+// finish, until ctx is done. Running Migrate again is a no-op.
+//
+// The instance running the migrations holds a lease, renewed while it
+// migrates. If it loses it anyway, for instance because it could not reach
+// MongoDB for a while, it stops migrating, leaving the rest of the work to
+// the instance that took it over.
+//
+// Documents in older versions are also upgraded when loaded by a GroupRepo,
+// so the application works while the migrations are running.
+//
+// Returns the versions of the migrations applied.
+func Migrate(ctx context.Context, db *mongo.Database, collName string, options ...MigrateOption) ([]int, error) {
+	m := &migrator{
+		lockTTL: defaultMigrationLockTTL,
+	}
+
+	for _, o := range options {
+		o(m)
+	}
+
+	records := db.Collection(migrationsCollName)
+
+	leaseCtx, release, err := acquireMigrationLock(ctx, records, collName, m.lockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := applyMigrations(leaseCtx, db, records, collName)
+	if err != nil && errors.Is(context.Cause(leaseCtx), errMigrationLockLost) {
+		return applied, fmt.Errorf("%w: %v", errMigrationLockLost, err)
+	}
+
+	return applied, err
+}
+
+// applyMigrations applies the pending migrations to the collection and
+// records them, it must be called while holding the migration lock.
+func applyMigrations(
+	ctx context.Context,
+	db *mongo.Database,
+	records *mongo.Collection,
+	collName string,
+) ([]int, error) {
+	var applied []int
+
+	for i, m := range migrations {
+		version := i + 1
+		recordID := fmt.Sprintf("%s/%d", collName, version)
+
+		n, err := records.CountDocuments(ctx, bson.M{"_id": recordID})
+		if err != nil {
+			return applied, fmt.Errorf("checking migration %d: %v", version, err)
+		}
+
+		if n > 0 {
+			continue
+		}
+
+		if err := migrateCollection(ctx, db.Collection(collName), version); err != nil {
+			return applied, fmt.Errorf("applying migration %d (%s): %v", version, m.description, err)
+		}
+
+		record := migrationRecord{
+			ID:          recordID,
+			Collection:  collName,
+			Version:     version,
+			Description: m.description,
+			AppliedAt:   time.Now().UTC(),
+		}
+
+		if _, err := records.InsertOne(ctx, record); err != nil {
+			return applied, fmt.Errorf("recording migration %d: %v", version, err)
+		}
+
+		applied = append(applied, version)
+	}
+
+	return applied, nil
+}
+
+// migrateCollection upgrades all the documents in coll in older versions to
+// the given version.
+//
+// Each document is only replaced if it has not changed since it was read, so
+// it is safe to run it while the application is writing documents.
+func migrateCollection(ctx context.Context, coll *mongo.Collection, version int) error {
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"schema_version": bson.M{"$lt": version}},
+			bson.M{"schema_version": bson.M{"$exists": false}},
+		},
+	}
+
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("finding: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("decoding: %v", err)
+		}
+
+		from, err := schemaVersion(cursor.Current)
+		if err != nil {
+			return fmt.Errorf("document %v: %v", doc["_id"], err)
+		}
+
+		if err := upgrade(doc, from, version); err != nil {
+			return fmt.Errorf("document %v: %v", doc["_id"], err)
+		}
+
+		// skip the document if it has been modified in the meantime, it
+		// is already in the current version
+		sameVersion := bson.M{"schema_version": from}
+		if from == 0 {
+			sameVersion = bson.M{"schema_version": bson.M{"$exists": false}}
+		}
+
+		filter := bson.M{
+			"$and": bson.A{bson.M{"_id": doc["_id"]}, sameVersion},
+		}
+
+		if _, err := coll.ReplaceOne(ctx, filter, doc); err != nil {
+			return fmt.Errorf("replacing document %v: %v", doc["_id"], err)
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("iterating: %v", err)
+	}
+
+	return nil
+}
+
+// schemaVersion returns the schema version of the raw document, 0 if it
+// does not have one.
+//
+// The version can be stored as any type of integral number, as documents
+// edited by hand, for instance from the mongo shell, may have it as a double.
+// Any other type is an error.
+func schemaVersion(raw bson.Raw) (int, error) {
+	v, err := raw.LookupErr("schema_version")
+	if errors.Is(err, bsoncore.ErrElementNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("reading schema_version: %v", err)
+	}
+
+	var version int
+
+	switch v.Type {
+	case bson.TypeInt32:
+		version = int(v.Int32())
+	case bson.TypeInt64:
+		version = int(v.Int64())
+	case bson.TypeDouble:
+		d := v.Double()
+		if d != math.Trunc(d) {
+			return 0, fmt.Errorf("invalid schema_version %v", d)
+		}
+
+		version = int(d)
+	default:
+		return 0, fmt.Errorf("invalid schema_version %v (%s)", v, v.Type)
+	}
+
+	if version < 0 {
+		return 0, fmt.Errorf("invalid schema_version %d", version)
+	}
+
+	return version, nil
+}
+
+// upgrade applies the migrations to the document in memory, from the given
+// version to the target version.
+func upgrade(doc bson.M, from, to int) error {
+	if from > currentSchemaVersion {
+		return fmt.Errorf("unknown schema version %d, the latest one is %d", from, currentSchemaVersion)
+	}
+
+	for version := from + 1; version <= to; version++ {
+		if err := migrations[version-1].upgrade(doc); err != nil {
+			return fmt.Errorf("upgrading to version %d: %v", version, err)
+		}
+
+		doc["schema_version"] = version
+	}
+
+	return nil
+}
+
+// upgradeRaw returns the raw group document upgraded to the current schema
+// version.
+func upgradeRaw(raw bson.Raw) (bson.Raw, error) {
+	from, err := schemaVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == currentSchemaVersion {
+		return raw, nil
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("decoding: %v", err)
+	}
+
+	if err := upgrade(doc, from, currentSchemaVersion); err != nil {
+		return nil, err
+	}
+
+	return bson.Marshal(doc)
+}
+
+// acquireMigrationLock waits until the migration lock of the collection is
+// free and takes it, for the given ttl, renewing it until it is released.
+//
+// The returned context is a child of ctx cancelled, with
+// errMigrationLockLost as its cause, if the lock is taken over by another
+// instance. The returned function releases the lock.
+func acquireMigrationLock(
+	ctx context.Context,
+	records *mongo.Collection,
+	collName string,
+	ttl time.Duration,
+) (context.Context, func(), error) {
+	owner, err := randomOwner()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lockID := "lock/" + collName
+
+	for {
+		ok, err := tryMigrationLock(ctx, records, lockID, owner, ttl)
+		if err != nil {
+			return nil, nil, fmt.Errorf("acquiring migration lock: %v", err)
+		}
+
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for migration lock: %w", ctx.Err())
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+
+	go func() {
+		defer close(renewed)
+		renewMigrationLock(leaseCtx, cancel, done, records, lockID, owner, ttl)
+	}()
+
+	release := func() {
+		close(done)
+		<-renewed
+		cancel(nil)
+
+		const timeout = 5 * time.Second
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		_, _ = records.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	}
+
+	return leaseCtx, release, nil
+}
+
+// renewMigrationLock extends the lease of the migration lock every third of
+// its ttl, until done is closed or ctx is done. If the lock has been taken
+// over by another instance, it cancels ctx with errMigrationLockLost.
+//
+// Failed renewals are retried in the next round, the lease still lasts for a
+// while.
+func renewMigrationLock(
+	ctx context.Context,
+	cancel context.CancelCauseFunc,
+	done <-chan struct{},
+	records *mongo.Collection,
+	lockID, owner string,
+	ttl time.Duration,
+) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		filter := bson.M{
+			"_id":   lockID,
+			"owner": owner,
+		}
+
+		update := bson.M{
+			"$set": bson.M{"expires_at": time.Now().UTC().Add(ttl)},
+		}
+
+		result, err := records.UpdateOne(ctx, filter, update)
+		if err != nil {
+			continue
+		}
+
+		if result.MatchedCount == 0 {
+			cancel(errMigrationLockLost)
+			return
+		}
+	}
+}
+
+// tryMigrationLock takes the lock, for the given ttl, if it does not exist or
+// if it has expired.
+func tryMigrationLock(
+	ctx context.Context,
+	records *mongo.Collection,
+	lockID, owner string,
+	ttl time.Duration,
+) (bool, error) {
+	now := time.Now().UTC()
+
+	lock := migrationLock{
+		ID:        lockID,
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_, err := records.InsertOne(ctx, lock)
+	if err == nil {
+		return true, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	// the lock exists, take it over if it has expired
+	filter := bson.M{
+		"_id":        lockID,
+		"expires_at": bson.M{"$lt": now},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"owner":      owner,
+			"expires_at": lock.ExpiresAt,
+		},
+	}
+
+	result, err := records.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// randomOwner returns a random id to identify the owner of a lock.
+func randomOwner() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating lock owner: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
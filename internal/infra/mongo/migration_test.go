@@ -0,0 +1,330 @@
+package mongo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type migrationFixture struct {
+	*groupRepoFixture
+}
+
+// newMigrationFixture returns a collection, without a schema, with a group
+// document in schema version 0, without the schema_version field and with
+// unsorted members.
+func newMigrationFixture(t *testing.T) *migrationFixture {
+	t.Helper()
+
+	fix := newGroupRepoFixture(t, withoutSchema(), withTimeout(5*time.Second))
+
+	_, err := fix.coll.InsertOne(fix.ctx, bson.M{
+		"_id":      "old_group_id",
+		"owner_id": "owner_id",
+		"members":  bson.A{"user_id", "owner_id"},
+	})
+	require.NoError(t, err)
+
+	return &migrationFixture{fix}
+}
+
+// schemaVersionOf returns the schema_version field of the document, or -1
+// if it does not have one.
+func (f *migrationFixture) schemaVersionOf(t *testing.T, id string) int64 {
+	t.Helper()
+
+	raw, err := f.coll.FindOne(f.ctx, bson.M{"_id": id}).Raw()
+	require.NoError(t, err)
+
+	v, ok := raw.Lookup("schema_version").AsInt64OK()
+	if !ok {
+		return -1
+	}
+
+	return v
+}
+
+// membersOf returns the members field of the document.
+func (f *migrationFixture) membersOf(t *testing.T, id string) []string {
+	t.Helper()
+
+	var doc struct {
+		Members []string `bson:"members"`
+	}
+
+	err := f.coll.FindOne(f.ctx, bson.M{"_id": id}).Decode(&doc)
+	require.NoError(t, err)
+
+	return doc.Members
+}
+
+// Tests Migrate upgrades old documents and records the migrations.
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	fix := newMigrationFixture(t)
+
+	// WHEN we migrate the collection
+	applied, err := mongo.Migrate(fix.ctx, fix.db, "group")
+	require.NoError(t, err)
+
+	// THEN the migrations are applied
+	require.Equal(t, []int{1, 2}, applied)
+
+	// THEN the old document is upgraded, with its members sorted
+	require.EqualValues(t, 2, fix.schemaVersionOf(t, "old_group_id"))
+	require.Equal(t, []string{"owner_id", "user_id"}, fix.membersOf(t, "old_group_id"))
+
+	// THEN the migrations are recorded
+	n, err := fix.db.Collection("schema_migrations").CountDocuments(fix.ctx, bson.M{"collection": "group"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, n)
+
+	// THEN the lock has been released
+	n, err = fix.db.Collection("schema_migrations").CountDocuments(fix.ctx, bson.M{"_id": "lock/group"})
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// WHEN we migrate again
+	applied, err = mongo.Migrate(fix.ctx, fix.db, "group")
+	require.NoError(t, err)
+
+	// THEN nothing is applied
+	require.Empty(t, applied)
+}
+
+// Tests Migrate waits for the lock held by another instance.
+func TestMigrate_Locked(t *testing.T) {
+	t.Parallel()
+
+	t.Run("held lock", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newMigrationFixture(t)
+
+		// GIVEN another instance holding the lock
+		_, err := fix.db.Collection("schema_migrations").InsertOne(fix.ctx, bson.M{
+			"_id":        "lock/group",
+			"owner":      "other",
+			"expires_at": time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		// WHEN we migrate with a short timeout
+		ctx, cancel := context.WithTimeout(fix.ctx, 500*time.Millisecond)
+		defer cancel()
+
+		_, err = mongo.Migrate(ctx, fix.db, "group")
+
+		// THEN we give up waiting for the lock
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// THEN the document has not been upgraded
+		require.EqualValues(t, -1, fix.schemaVersionOf(t, "old_group_id"))
+	})
+
+	t.Run("expired lock", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newMigrationFixture(t)
+
+		// GIVEN an expired lock from a dead instance
+		_, err := fix.db.Collection("schema_migrations").InsertOne(fix.ctx, bson.M{
+			"_id":        "lock/group",
+			"owner":      "dead",
+			"expires_at": time.Now().Add(-time.Minute),
+		})
+		require.NoError(t, err)
+
+		// WHEN we migrate
+		applied, err := mongo.Migrate(fix.ctx, fix.db, "group")
+
+		// THEN we take over the lock and apply the migrations
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 2}, applied)
+	})
+
+	t.Run("renewed lock", func(t *testing.T) {
+		t.Parallel()
+
+		fix := newMigrationFixture(t)
+
+		// GIVEN enough documents for the migrations to take much longer
+		// than the lease ttl
+		const (
+			ttl  = 90 * time.Millisecond
+			docs = 1000
+		)
+
+		batch := make([]any, 0, docs)
+		for i := range docs {
+			batch = append(batch, bson.M{
+				"_id":      fmt.Sprintf("group_id_%d", i),
+				"owner_id": "owner_id",
+				"members":  bson.A{"user_id", "owner_id"},
+			})
+		}
+
+		_, err := fix.coll.InsertMany(fix.ctx, batch)
+		require.NoError(t, err)
+
+		// WHEN several instances migrate concurrently with that ttl
+		const instances = 3
+
+		results := make(chan []int, instances)
+		errs := make(chan error, instances)
+
+		for range instances {
+			go func() {
+				applied, err := mongo.Migrate(fix.ctx, fix.db, "group", mongo.WithMigrationLockTTL(ttl))
+				results <- applied
+				errs <- err
+			}()
+		}
+
+		// THEN the lease is renewed, so nobody takes it over and each
+		// migration is applied by exactly one of them
+		var all []int
+		for range instances {
+			require.NoError(t, <-errs)
+			all = append(all, <-results...)
+		}
+
+		require.Equal(t, []int{1, 2}, all)
+	})
+}
+
+// Tests several instances migrating at the same time apply each migration
+// once.
+func TestMigrate_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	fix := newMigrationFixture(t)
+
+	const instances = 5
+
+	results := make(chan []int, instances)
+	errs := make(chan error, instances)
+
+	// WHEN several instances migrate concurrently
+	for range instances {
+		go func() {
+			applied, err := mongo.Migrate(fix.ctx, fix.db, "group")
+			results <- applied
+			errs <- err
+		}()
+	}
+
+	// THEN each migration is applied by exactly one of them
+	var all []int
+	for range instances {
+		require.NoError(t, <-errs)
+		all = append(all, <-results...)
+	}
+
+	require.Equal(t, []int{1, 2}, all)
+}
+
+// Tests documents in old versions are upgraded when loaded, and written in
+// the current version.
+func TestGroupRepo_LoadOldVersion(t *testing.T) {
+	t.Parallel()
+
+	fix := newMigrationFixture(t)
+
+	// WHEN we load an old document, without migrating the collection
+	group, err := fix.repo.Load(fix.ctx, "old_group_id")
+
+	// THEN we get the group
+	require.NoError(t, err)
+	require.Equal(t, []string{"owner_id", "user_id"}, group.Members())
+
+	// WHEN we update it
+	err = group.AddMember("new_user_id", now)
+	require.NoError(t, err)
+
+	err = fix.repo.Update(fix.ctx, group)
+	require.NoError(t, err)
+
+	// THEN it is written in the current version
+	require.EqualValues(t, 2, fix.schemaVersionOf(t, "old_group_id"))
+	require.Equal(t, []string{"new_user_id", "owner_id", "user_id"}, fix.membersOf(t, "old_group_id"))
+}
+
+// Tests the schema version can be stored as any type of integral number,
+// and that any other value is rejected when loading.
+func TestSchemaVersion_Types(t *testing.T) {
+	t.Parallel()
+
+	subtests := map[string]struct {
+		version      any
+		errorContent string // empty if valid
+	}{
+		"int32":      {version: int32(1)},
+		"int64":      {version: int64(1)},
+		"double":     {version: 1.0},
+		"fractional": {version: 1.5, errorContent: "invalid schema_version"},
+		"negative":   {version: int32(-1), errorContent: "invalid schema_version"},
+		"string":     {version: "1", errorContent: "invalid schema_version"},
+		"null":       {version: nil, errorContent: "invalid schema_version"},
+		"future":     {version: int32(1000), errorContent: "unknown schema version"},
+	}
+
+	for name, test := range subtests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fix := newMigrationFixture(t)
+
+			// GIVEN a document with the schema version
+			_, err := fix.coll.InsertOne(fix.ctx, bson.M{
+				"_id":            "group_id",
+				"owner_id":       "owner_id",
+				"members":        bson.A{"owner_id"},
+				"schema_version": test.version,
+			})
+			require.NoError(t, err)
+
+			// WHEN we load it
+			group, err := fix.repo.Load(fix.ctx, "group_id")
+
+			// THEN we only get it if its version is valid
+			if test.errorContent != "" {
+				require.ErrorContains(t, err, test.errorContent)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"owner_id"}, group.Members())
+		})
+	}
+}
+
+// Tests documents written by a newer version of the code cannot be loaded.
+func TestGroupRepo_LoadNewerVersion(t *testing.T) {
+	t.Parallel()
+
+	fix := newMigrationFixture(t)
+
+	// GIVEN a document in a future schema version
+	_, err := fix.coll.InsertOne(fix.ctx, bson.M{
+		"_id":            "future_group_id",
+		"owner_id":       "owner_id",
+		"members":        bson.A{"owner_id"},
+		"schema_version": 1000,
+	})
+	require.NoError(t, err)
+
+	// WHEN we load it
+	_, err = fix.repo.Load(fix.ctx, "future_group_id")
+
+	// THEN we get an error
+	require.ErrorContains(t, err, "unknown schema version")
+	require.NotErrorIs(t, err, domain.ErrNotFound)
+}
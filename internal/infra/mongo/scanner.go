@@ -0,0 +1,153 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Finding describes a group document that does not represent a valid group.
+type Finding struct {
+	// GroupID is the _id of the document.
+	GroupID string
+	// Reasons describe why the document is invalid.
+	Reasons []string
+	// Repaired is true if the document has been fixed.
+	Repaired bool
+	// RepairErr is why the document could not be fixed, if trying to fix it
+	// failed.
+	RepairErr error
+}
+
+// ScanReport is the result of scanning a collection of groups.
+type ScanReport struct {
+	// Scanned is the number of documents scanned.
+	Scanned int
+	// Findings are the invalid documents found.
+	Findings []Finding
+}
+
+// ScanOption configures a scan.
+type ScanOption func(*scanner)
+
+// WithRepair makes the scan fix the invalid documents that can be fixed
+// without losing members, see domain.GroupSnapshot.Repaired.
+//
+// A document is only fixed if it has not changed since it was read.
+func WithRepair() ScanOption {
+	return func(s *scanner) {
+		s.repair = true
+	}
+}
+
+type scanner struct {
+	coll   *mongo.Collection
+	repair bool
+}
+
+// Scan iterates over all the documents in the collection and reports those
+// that do not represent a valid group, like the ones with no owner or too
+// many members, which GroupRepo.Load would fail to load, or with duplicated
+// members, which GroupRepo.Load merges.
+//
+// The documents are upgraded to the current schema version before checking
+// them, as GroupRepo.Load does, see Migrate.
+//
+// Failing to repair a document does not stop the scan, the error is reported
+// in its finding.
+func Scan(ctx context.Context, coll *mongo.Collection, options ...ScanOption) (*ScanReport, error) {
+	s := &scanner{
+		coll: coll,
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("finding: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	report := &ScanReport{}
+
+	for cursor.Next(ctx) {
+		report.Scanned++
+
+		if finding, ok := s.check(ctx, cursor.Current); ok {
+			report.Findings = append(report.Findings, finding)
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("iterating: %v", err)
+	}
+
+	return report, nil
+}
+
+// check returns the finding for raw, or false if it is a valid group.
+func (s *scanner) check(ctx context.Context, raw bson.Raw) (Finding, bool) {
+	doc, err := decodeGroupDoc(raw)
+	if err != nil {
+		id, ok := raw.Lookup("_id").StringValueOK()
+		if !ok {
+			id = raw.Lookup("_id").String()
+		}
+
+		return Finding{
+			GroupID: id,
+			Reasons: []string{fmt.Sprintf("malformed document: %v", err)},
+		}, true
+	}
+
+	snapshot := doc.snapshot()
+
+	violations := snapshot.Violations()
+	if len(violations) == 0 {
+		return Finding{}, false
+	}
+
+	finding := Finding{
+		GroupID: doc.ID,
+		Reasons: make([]string, 0, len(violations)),
+	}
+
+	for _, v := range violations {
+		finding.Reasons = append(finding.Reasons, v.Error())
+	}
+
+	if !s.repair {
+		return finding, true
+	}
+
+	repaired, ok := snapshot.Repaired()
+	if !ok {
+		return finding, true
+	}
+
+	// only update the document if nobody has changed it in the meantime,
+	// comparing the stored values, as the upgrade may have changed them
+	filter := bson.M{
+		"_id":      doc.ID,
+		"owner_id": raw.Lookup("owner_id"),
+		"members":  raw.Lookup("members"),
+	}
+
+	update := bson.M{
+		"$set": bson.M{"members": repaired.Members},
+	}
+
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		finding.RepairErr = fmt.Errorf("repairing group %s: %v", doc.ID, err)
+		return finding, true
+	}
+
+	finding.Repaired = result.ModifiedCount == 1
+
+	return finding, true
+}
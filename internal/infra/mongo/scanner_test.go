@@ -0,0 +1,174 @@
+package mongo_test
+
+import (
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// newScanFixture returns a collection, without a schema, with some valid and
+// invalid group documents.
+func newScanFixture(t *testing.T) *groupRepoFixture {
+	t.Helper()
+
+	fix := newGroupRepoFixture(t, withoutSchema())
+
+	docs := []any{
+		bson.M{"_id": "valid", "owner_id": "a", "members": bson.A{"a", "b"}},
+		bson.M{"_id": "empty_owner", "owner_id": "", "members": bson.A{"a"}},
+		bson.M{"_id": "owner_not_member", "owner_id": "a", "members": bson.A{"b"}},
+		bson.M{"_id": "duplicated", "owner_id": "a", "members": bson.A{"b", "a", "b"}},
+		bson.M{"_id": "too_many", "owner_id": "a", "members": bson.A{"a", "b", "c", "d", "e", "f"}},
+		bson.M{"_id": "malformed", "owner_id": 42, "members": bson.A{"a"}},
+	}
+
+	_, err := fix.coll.InsertMany(fix.ctx, docs)
+	require.NoError(t, err)
+
+	return fix
+}
+
+// reasons returns the reasons of each finding in the report, by group id.
+func reasons(report *mongo.ScanReport) map[string][]string {
+	result := map[string][]string{}
+	for _, f := range report.Findings {
+		result[f.GroupID] = f.Reasons
+	}
+
+	return result
+}
+
+// Tests the scan reports the invalid documents with their reasons.
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	fix := newScanFixture(t)
+
+	// WHEN we scan the collection
+	report, err := mongo.Scan(fix.ctx, fix.coll)
+	require.NoError(t, err)
+
+	// THEN all the documents have been scanned
+	require.Equal(t, 6, report.Scanned)
+
+	// THEN the invalid documents are reported with their reasons
+	got := reasons(report)
+	require.Len(t, got, 5)
+	require.NotContains(t, got, "valid")
+	require.Equal(t, []string{"empty owner id"}, got["empty_owner"])
+	require.Equal(t, []string{"owner (a) is not member ([b])"}, got["owner_not_member"])
+	require.Equal(t, []string{"duplicated member (b)"}, got["duplicated"])
+	require.Equal(t, []string{"too many members (6)"}, got["too_many"])
+	require.Len(t, got["malformed"], 1)
+	require.Contains(t, got["malformed"][0], "malformed document")
+
+	// THEN nothing has been repaired
+	for _, f := range report.Findings {
+		require.Falsef(t, f.Repaired, "group %s", f.GroupID)
+	}
+
+	// THEN the groups with duplicated members can still be loaded
+	group, err := fix.repo.Load(fix.ctx, "duplicated")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, group.Members())
+}
+
+// Tests the scan repairs the documents that can be fixed.
+func TestScan_WithRepair(t *testing.T) {
+	t.Parallel()
+
+	fix := newScanFixture(t)
+
+	// WHEN we scan the collection repairing the documents
+	report, err := mongo.Scan(fix.ctx, fix.coll, mongo.WithRepair())
+	require.NoError(t, err)
+
+	// THEN the fixable documents have been repaired
+	repaired := map[string]bool{}
+	for _, f := range report.Findings {
+		repaired[f.GroupID] = f.Repaired
+	}
+
+	want := map[string]bool{
+		"empty_owner":      false,
+		"owner_not_member": true,
+		"duplicated":       true,
+		"too_many":         false,
+		"malformed":        false,
+	}
+	require.Equal(t, want, repaired)
+
+	// THEN the repaired groups can be loaded
+	group, err := fix.repo.Load(fix.ctx, "owner_not_member")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, group.Members())
+
+	group, err = fix.repo.Load(fix.ctx, "duplicated")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, group.Members())
+
+	// THEN scanning again only reports the documents that cannot be fixed
+	report, err = mongo.Scan(fix.ctx, fix.coll)
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 3)
+}
+
+// Tests failing to repair a document is reported and does not stop the scan.
+func TestScan_RepairError(t *testing.T) {
+	t.Parallel()
+
+	fix := newScanFixture(t)
+
+	// GIVEN a validator that rejects any change to one of the documents
+	cmd := bson.D{
+		{Key: "collMod", Value: fix.coll.Name()},
+		{Key: "validator", Value: bson.M{"_id": bson.M{"$ne": "duplicated"}}},
+	}
+	err := fix.coll.Database().RunCommand(fix.ctx, cmd).Err()
+	require.NoError(t, err)
+
+	// WHEN we scan the collection repairing the documents
+	report, err := mongo.Scan(fix.ctx, fix.coll, mongo.WithRepair())
+	require.NoError(t, err)
+
+	// THEN the failed repair is reported
+	findings := map[string]mongo.Finding{}
+	for _, f := range report.Findings {
+		findings[f.GroupID] = f
+	}
+
+	require.Equal(t, 6, report.Scanned)
+	require.False(t, findings["duplicated"].Repaired)
+	require.ErrorContains(t, findings["duplicated"].RepairErr, "repairing group duplicated")
+
+	// THEN the rest of the documents are still repaired
+	require.True(t, findings["owner_not_member"].Repaired)
+	require.NoError(t, findings["owner_not_member"].RepairErr)
+}
+
+// Tests the scan checks the documents upgraded to the current schema
+// version, as Load does.
+func TestScan_UpgradesDocuments(t *testing.T) {
+	t.Parallel()
+
+	fix := newScanFixture(t)
+
+	// GIVEN a document with an unknown schema version
+	doc := bson.M{"_id": "future", "schema_version": 1000, "owner_id": "a", "members": bson.A{"a"}}
+	_, err := fix.coll.InsertOne(fix.ctx, doc)
+	require.NoError(t, err)
+
+	// WHEN we scan the collection
+	report, err := mongo.Scan(fix.ctx, fix.coll)
+	require.NoError(t, err)
+
+	// THEN it is reported, as Load cannot load it
+	got := reasons(report)
+	require.Len(t, got["future"], 1)
+	require.Contains(t, got["future"][0], "malformed document")
+
+	_, err = fix.repo.Load(fix.ctx, "future")
+	require.Error(t, err)
+}
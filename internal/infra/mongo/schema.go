@@ -0,0 +1,174 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// groupValidator returns the validator of the group documents: the
+// $jsonSchema checks the fields and the $expr checks the owner is a member.
+//
+// Unknown fields are allowed, so new fields can be added to the documents
+// before updating the validator.
+func groupValidator() bson.M {
+	nonEmptyString := bson.M{
+		"bsonType":  "string",
+		"minLength": 1,
+	}
+
+	schema := bson.M{
+		"bsonType": "object",
+		"required": bson.A{"_id", "owner_id", "members"},
+		"properties": bson.M{
+			"_id":      nonEmptyString,
+			"owner_id": nonEmptyString,
+			"members": bson.M{
+				"bsonType":    "array",
+				"minItems":    1,
+				"maxItems":    domain.MaxMembers,
+				"uniqueItems": true,
+				"items":       nonEmptyString,
+			},
+			"name": bson.M{
+				"bsonType":  "string",
+				"minLength": 1,
+				"maxLength": domain.MaxNameLength,
+			},
+			"description": bson.M{
+				"bsonType":  "string",
+				"maxLength": domain.MaxDescriptionLength,
+			},
+			"tags": bson.M{
+				"bsonType":    "array",
+				"maxItems":    domain.MaxTags,
+				"uniqueItems": true,
+				"items": bson.M{
+					"bsonType":  "string",
+					"minLength": 1,
+					"maxLength": domain.MaxTagLength,
+				},
+			},
+			"joined_at": bson.M{
+				"bsonType": "array",
+				"maxItems": domain.MaxMembers,
+				"items": bson.M{
+					"bsonType": "object",
+					"required": bson.A{"user_id", "at"},
+					"properties": bson.M{
+						"user_id": nonEmptyString,
+						"at":      bson.M{"bsonType": "date"},
+					},
+				},
+			},
+			"created_at": bson.M{"bsonType": "date"},
+			"updated_at": bson.M{"bsonType": "date"},
+			"schema_version": bson.M{
+				"bsonType": bson.A{"int", "long"},
+				"minimum":  0,
+			},
+			"fence": bson.M{
+				"bsonType": bson.A{"int", "long"},
+				"minimum":  1,
+			},
+		},
+	}
+
+	// $in fails if members is not an array, instead of just not matching
+	ownerIsMember := bson.M{
+		"$cond": bson.A{
+			bson.M{"$isArray": "$members"},
+			bson.M{"$in": bson.A{"$owner_id", "$members"}},
+			false,
+		},
+	}
+
+	return bson.M{
+		"$jsonSchema": schema,
+		"$expr":       ownerIsMember,
+	}
+}
+
+// groupIndexes returns the indexes of the group collection:
+//   - a unique index on the owner and the name, so the names of the groups of
+//     the same owner are unique; unnamed groups have no name field, so they
+//     are not indexed.
+//   - an index on the tags, for FindByTag.
+func groupIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().
+				SetName("owner_id_name_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"name": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "tags", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("tags"),
+		},
+	}
+}
+
+// EnsureGroupSchema installs a validator on the collection with the given
+// name, so MongoDB rejects the writes of invalid group documents, no matter
+// where they come from, and creates its indexes. The collection is created if
+// it does not exist.
+//
+// It is meant to be called when bootstrapping the application, before using
+// the collection in a GroupRepo, and it is safe to call it several times.
+func EnsureGroupSchema(ctx context.Context, db *mongo.Database, collName string) error {
+	if err := ensureGroupValidator(ctx, db, collName); err != nil {
+		return err
+	}
+
+	if _, err := db.Collection(collName).Indexes().CreateMany(ctx, groupIndexes()); err != nil {
+		return fmt.Errorf("creating indexes of collection %s: %v", collName, err)
+	}
+
+	return nil
+}
+
+func ensureGroupValidator(ctx context.Context, db *mongo.Database, collName string) error {
+	const (
+		level  = "strict"
+		action = "error"
+		// namespaceExists is the MongoDB error code for creating an
+		// existing collection.
+		namespaceExists = 48
+	)
+
+	opts := options.CreateCollection().
+		SetValidator(groupValidator()).
+		SetValidationLevel(level).
+		SetValidationAction(action)
+
+	err := db.CreateCollection(ctx, collName, opts)
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != namespaceExists {
+		return fmt.Errorf("creating collection %s: %v", collName, err)
+	}
+
+	// the collection already exists, update its validator
+	cmd := bson.D{
+		{Key: "collMod", Value: collName},
+		{Key: "validator", Value: groupValidator()},
+		{Key: "validationLevel", Value: level},
+		{Key: "validationAction", Value: action},
+	}
+
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("updating validator of collection %s: %v", collName, err)
+	}
+
+	return nil
+}
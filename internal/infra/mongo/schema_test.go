@@ -0,0 +1,231 @@
+package mongo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// members returns n member ids, starting with the owner.
+func members(n int) bson.A {
+	result := bson.A{"owner_id"}
+	for i := 1; i < n; i++ {
+		result = append(result, fmt.Sprintf("member_id_%d", i))
+	}
+
+	return result
+}
+
+// Tests invalid group documents inserted directly in the collection are
+// rejected.
+func TestEnsureGroupSchema_RejectsInvalidDocuments(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bson.M{
+		"no owner": {
+			"_id":     "group_id",
+			"members": bson.A{"owner_id"},
+		},
+		"empty owner": {
+			"_id":      "group_id",
+			"owner_id": "",
+			"members":  bson.A{"owner_id"},
+		},
+		"no members": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+		},
+		"empty members": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{},
+		},
+		"members is not an array": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  "owner_id",
+		},
+		"too many members": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  members(domain.MaxMembers + 1),
+		},
+		"duplicated members": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id", "member_id", "member_id"},
+		},
+		"owner is not a member": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"member_id"},
+		},
+		"non string member": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id", 42},
+		},
+		"empty name": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"name":     "",
+		},
+		"name too long": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"name":     strings.Repeat("x", domain.MaxNameLength+1),
+		},
+		"duplicated tags": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"tags":     bson.A{"tag", "tag"},
+		},
+		"tags is not an array": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"tags":     "tag",
+		},
+		"zero fence": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"fence":    int64(0),
+		},
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fix := newGroupRepoFixture(t)
+
+			// WHEN we insert an invalid document
+			_, err := fix.coll.InsertOne(fix.ctx, doc)
+
+			// THEN it is rejected
+			require.Error(t, err)
+			require.Truef(t, isValidationError(err), "unexpected error: %v", err)
+		})
+	}
+}
+
+// Tests valid group documents are accepted, including those with unknown
+// fields.
+func TestEnsureGroupSchema_AcceptsValidDocuments(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bson.M{
+		"only the owner": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+		},
+		"full": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  members(domain.MaxMembers),
+		},
+		"with details": {
+			"_id":         "group_id",
+			"owner_id":    "owner_id",
+			"members":     bson.A{"owner_id"},
+			"name":        "name",
+			"description": "description",
+			"tags":        bson.A{"tag-1", "tag-2"},
+		},
+		"unknown fields": {
+			"_id":      "group_id",
+			"owner_id": "owner_id",
+			"members":  bson.A{"owner_id"},
+			"unknown":  42,
+		},
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fix := newGroupRepoFixture(t)
+
+			// WHEN we insert a valid document
+			_, err := fix.coll.InsertOne(fix.ctx, doc)
+
+			// THEN it is accepted
+			require.NoError(t, err)
+		})
+	}
+}
+
+// Tests updates that would make a document invalid are rejected.
+func TestEnsureGroupSchema_RejectsInvalidUpdates(t *testing.T) {
+	t.Parallel()
+
+	fix := newGroupRepoFixture(t)
+
+	// GIVEN a full group
+	_, err := fix.coll.InsertOne(fix.ctx, bson.M{
+		"_id":      "group_id",
+		"owner_id": "owner_id",
+		"members":  members(domain.MaxMembers),
+	})
+	require.NoError(t, err)
+
+	// WHEN we push another member
+	_, err = fix.coll.UpdateByID(fix.ctx, "group_id", bson.M{
+		"$push": bson.M{"members": "new_member_id"},
+	})
+
+	// THEN it is rejected
+	require.Truef(t, isValidationError(err), "unexpected error: %v", err)
+}
+
+// Tests installing the schema several times, or on an existing collection,
+// works.
+func TestEnsureGroupSchema_Idempotent(t *testing.T) {
+	t.Parallel()
+
+	const timeout = 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+
+	// GIVEN an existing collection without a schema
+	db := testhelp.NewTestDatabase(t, mongoURI)
+	coll := db.Collection("group")
+
+	_, err := coll.InsertOne(ctx, bson.M{"_id": "some_doc"})
+	require.NoError(t, err)
+
+	// WHEN we install the schema twice
+	err = mongo.EnsureGroupSchema(ctx, db, "group")
+	require.NoError(t, err)
+
+	err = mongo.EnsureGroupSchema(ctx, db, "group")
+	require.NoError(t, err)
+
+	// THEN invalid documents are rejected
+	_, err = coll.InsertOne(ctx, bson.M{"_id": "group_id"})
+	require.Truef(t, isValidationError(err), "unexpected error: %v", err)
+}
+
+// isValidationError returns if err is a MongoDB document validation failure.
+func isValidationError(err error) bool {
+	const documentValidationFailure = 121
+
+	var se mongodriver.ServerError
+
+	return errors.As(err, &se) && se.HasErrorCode(documentValidationFailure)
+}
@@ -0,0 +1,118 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer used to trace the repository and the
+// MongoDB commands.
+const tracerName = "github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+
+// startSpan starts a new span with the given name and attributes. The
+// returned function must be called with the error returned by the traced
+// operation once it finishes, to end the span.
+func (r *GroupRepo) startSpan(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, func(errp *error)) {
+	ctx, span := r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+
+	end := func(errp *error) {
+		if err := *errp; err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+
+	return ctx, end
+}
+
+// NewCommandMonitor returns a MongoDB command monitor that creates a span for
+// each command sent to the server (find, update, commitTransaction...), as a
+// child of the span in the context of the operation that sent it.
+//
+// Use it when connecting to MongoDB:
+//
+//	opts := options.Client().
+//		ApplyURI(uri).
+//		SetMonitor(mongo.NewCommandMonitor(tp))
+//
+// The spans do not include the commands themselves, as they may carry
+// sensitive data.
+func NewCommandMonitor(tp trace.TracerProvider) *event.CommandMonitor {
+	m := &commandMonitor{
+		tracer: tp.Tracer(tracerName),
+	}
+
+	return &event.CommandMonitor{
+		Started:   m.started,
+		Succeeded: m.succeeded,
+		Failed:    m.failed,
+	}
+}
+
+type commandMonitor struct {
+	tracer trace.Tracer
+	// spans are the spans of the commands in flight, by command key.
+	spans sync.Map
+}
+
+// commandKey returns a key that identifies a command in flight.
+func commandKey(connectionID string, requestID int64) string {
+	return fmt.Sprintf("%s/%d", connectionID, requestID)
+}
+
+func (m *commandMonitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBName(e.DatabaseName),
+		semconv.DBOperation(e.CommandName),
+	}
+
+	// most commands have the collection name as the value of the command
+	// name field, like {"find": "group", ...}
+	if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		attrs = append(attrs, semconv.DBMongoDBCollection(coll))
+	}
+
+	_, span := m.tracer.Start(ctx, e.CommandName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	m.spans.Store(commandKey(e.ConnectionID, e.RequestID), span)
+}
+
+func (m *commandMonitor) succeeded(_ context.Context, e *event.CommandSucceededEvent) {
+	if span, ok := m.end(e.ConnectionID, e.RequestID); ok {
+		span.End()
+	}
+}
+
+func (m *commandMonitor) failed(_ context.Context, e *event.CommandFailedEvent) {
+	if span, ok := m.end(e.ConnectionID, e.RequestID); ok {
+		span.SetStatus(codes.Error, e.Failure)
+		span.End()
+	}
+}
+
+// end returns the span of a finished command and forgets about it.
+func (m *commandMonitor) end(connectionID string, requestID int64) (trace.Span, bool) {
+	v, ok := m.spans.LoadAndDelete(commandKey(connectionID, requestID))
+	if !ok {
+		return nil, false
+	}
+
+	return v.(trace.Span), true
+}
@@ -0,0 +1,62 @@
+package mongo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/mongo"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/testhelp"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// Tests the repository operations and the MongoDB commands they send are
+// traced.
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	const timeout = 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+
+	// GIVEN a repo and a client that record their spans in memory
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	db := testhelp.NewTestDatabase(t, mongoURI,
+		options.Client().SetMonitor(mongo.NewCommandMonitor(tp)))
+	repo := mongo.NewGroupRepo(db.Collection("group"), mongo.WithTracerProvider(tp))
+
+	// GIVEN a group in the repo
+	err := repo.Create(ctx, domain.NewGroup("group_id", "owner_id", now))
+	require.NoError(t, err)
+
+	exporter.Reset()
+
+	// WHEN we load a group that does not exist
+	_, err = repo.Load(ctx, "unknown_id")
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	// THEN there is a span for the load, with the error
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	find, load := spans[0], spans[1]
+
+	require.Equal(t, "GroupRepo.Load", load.Name)
+	require.Contains(t, load.Attributes, attribute.String("group_id", "unknown_id"))
+	require.Equal(t, codes.Error, load.Status.Code)
+
+	// THEN there is a child span for the find command
+	require.Equal(t, "find", find.Name)
+	require.Equal(t, load.SpanContext.SpanID(), find.Parent.SpanID())
+	require.Contains(t, find.Attributes, semconv.DBMongoDBCollection("group"))
+	require.Contains(t, find.Attributes, semconv.DBOperation("find"))
+}
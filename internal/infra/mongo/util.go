@@ -1,32 +1,94 @@
 package mongo
 
 import (
+	"context"
 	"errors"
-	"fmt"
 
 	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
-// domainError translates between MongoDB errors and domain errors, hiding
-// MongoDB implementation details and returning well known domain errors:
+// MongoDB server error codes translated to domain errors.
+const (
+	codeWriteConflict             = 112
+	codeDocumentValidationFailure = 121
+)
+
+// domainError translates between MongoDB errors and domain errors about the
+// group with the given id, hiding MongoDB implementation details and
+// returning a *domain.Error of a well known kind:
+//
+//   - none, if the operation was interrupted because its context is done:
+//     the error matches context.Canceled or context.DeadlineExceeded with
+//     errors.Is, so the callers can tell it apart from MongoDB failures.
 //
 //   - domain.ErrTransientTransaction: on mongo errors with the
-//     driver.TransientTransactionError label
+//     driver.TransientTransactionError label.
 //
 //   - domain.ErrNotFound: whatever you were looking for, it has not been found.
-func domainError(err error) error {
-	// check for transient transaction errors.
+//
+//   - domain.ErrAlreadyExists: on duplicate key errors.
+//
+//   - domain.ErrConflict: on write conflicts outside transactions.
+//
+//   - domain.ErrInvalidArgument: when the document is rejected by the schema
+//     validator of the collection.
+//
+//   - domain.ErrUnavailable: on network errors, timeouts and when no server
+//     can be selected.
+//
+// Other errors are returned without a kind.
+func domainError(err error, groupID string) error {
+	// hide the MongoDB error, but keep its message
+	cause := errors.New(err.Error())
+
+	var (
+		serverErr    mongo.ServerError
+		selectionErr topology.ServerSelectionError
+	)
+
+	isServerErr := errors.As(err, &serverErr)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return contextError{msg: cause.Error(), err: context.Canceled}
+	case errors.Is(err, context.DeadlineExceeded):
+		return contextError{msg: cause.Error(), err: context.DeadlineExceeded}
+	case hasTransientTransactionLabel(err):
+		return domain.NewError(domain.ErrTransientTransaction, groupID, cause)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return domain.NewError(domain.ErrNotFound, groupID, nil)
+	case mongo.IsDuplicateKeyError(err):
+		return domain.NewError(domain.ErrAlreadyExists, groupID, cause)
+	case isServerErr && serverErr.HasErrorCode(codeWriteConflict):
+		return domain.NewError(domain.ErrConflict, groupID, cause)
+	case isServerErr && serverErr.HasErrorCode(codeDocumentValidationFailure):
+		return domain.NewError(domain.ErrInvalidArgument, groupID, cause)
+	case mongo.IsNetworkError(err), mongo.IsTimeout(err), errors.As(err, &selectionErr):
+		return domain.NewError(domain.ErrUnavailable, groupID, cause)
+	}
+
+	return cause
+}
+
+// contextError is a MongoDB error caused by a done context. It keeps the
+// message of the MongoDB error, but only unwraps to the error of the context.
+type contextError struct {
+	msg string
+	err error
+}
+
+func (e contextError) Error() string { return e.msg }
+func (e contextError) Unwrap() error { return e.err }
+
+func hasTransientTransactionLabel(err error) bool {
 	for current := err; current != nil; current = errors.Unwrap(current) {
 		if le, ok := current.(mongo.LabeledError); ok && le.HasErrorLabel(driver.TransientTransactionError) {
-			return fmt.Errorf("%w: %v", domain.ErrTransientTransaction, err)
+			return true
 		}
 	}
 
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return domain.ErrNotFound
-	}
-
-	return fmt.Errorf("%v", err) // do not expose any mongoDB errors
+	return false
 }
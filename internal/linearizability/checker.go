@@ -0,0 +1,311 @@
+package linearizability
+
+import (
+	"math"
+	"sort"
+)
+
+// Result is the outcome of checking a history.
+type Result struct {
+	Linearizable bool
+	// GroupID is the group whose operations cannot be linearized, if any.
+	GroupID string
+	// Counterexample is a minimal subset of the operations on GroupID that
+	// cannot be linearized, sorted by invocation time.
+	Counterexample []Operation
+	// Partial is the longest linearization of the operations in the
+	// counterexample the checker was able to find, it is useful to see
+	// where things start to go wrong.
+	Partial []Operation
+}
+
+// Check returns if a history of concurrent operations is linearizable with
+// respect to the sequential specification of a group.
+//
+// Operations on different groups are independent from each other, so the
+// history is partitioned by group and each partition is checked on its own,
+// using the Wing & Gong algorithm with Lowe's memoization of already
+// explored states.
+//
+// If the history is not linearizable, the result includes a minimal
+// counterexample: the shortest prefix of the history that cannot be
+// linearized, without the operations with no effects that are not needed to
+// reproduce the problem.
+func Check(history []Operation) Result {
+	partitions, groupIDs := partition(history)
+
+	for _, id := range groupIDs {
+		ops := partitions[id]
+
+		if ok, _ := linearize(ops); ok {
+			continue
+		}
+
+		counterexample := shrink(ops)
+		_, partial := linearize(counterexample)
+
+		return Result{
+			Linearizable:   false,
+			GroupID:        id,
+			Counterexample: counterexample,
+			Partial:        partial,
+		}
+	}
+
+	return Result{Linearizable: true}
+}
+
+// partition groups the operations in the history by group id. It also
+// returns the group ids in a deterministic order.
+func partition(history []Operation) (map[string][]Operation, []string) {
+	result := map[string][]Operation{}
+	ids := []string{}
+
+	for _, op := range history {
+		if _, ok := result[op.GroupID]; !ok {
+			ids = append(ids, op.GroupID)
+		}
+
+		result[op.GroupID] = append(result[op.GroupID], op)
+	}
+
+	sort.Strings(ids)
+
+	for _, ops := range result {
+		sort.SliceStable(ops, func(i, j int) bool {
+			return ops[i].Invoke < ops[j].Invoke
+		})
+	}
+
+	return result, ids
+}
+
+// shrink returns a smaller set of operations from ops that is still not
+// linearizable.
+//
+// Only removals that keep linearizable histories linearizable are
+// attempted, so the counterexample is not linearizable because of the
+// operations in it, not because of the removed ones:
+//   - we look for the shortest non linearizable prefix of the history, where
+//     the operations that had not returned yet become pending.
+//   - we remove, one by one, the operations without effects.
+func shrink(ops []Operation) []Operation {
+	// the return times are the only interesting places to cut the history,
+	// as cutting it anywhere else is equivalent to cutting it at the
+	// previous return.
+	cuts := make([]int64, 0, len(ops))
+	for _, op := range ops {
+		if !op.Pending {
+			cuts = append(cuts, op.Return)
+		}
+	}
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i] < cuts[j] })
+
+	// linearizability is prefix-closed, so we can binary search the shortest
+	// prefix that is not linearizable.
+	if n := sort.Search(len(cuts), func(i int) bool {
+		ok, _ := linearize(prefix(ops, cuts[i]))
+		return !ok
+	}); n < len(cuts) {
+		ops = prefix(ops, cuts[n])
+	}
+
+	for i := 0; i < len(ops); {
+		if hasEffects(ops[i]) {
+			i++
+			continue
+		}
+
+		candidate := append(ops[:i:i], ops[i+1:]...)
+		if ok, _ := linearize(candidate); ok {
+			i++
+			continue
+		}
+
+		ops = candidate
+	}
+
+	return ops
+}
+
+// prefix returns the operations invoked up to time t. Operations that
+// had not returned by then become pending and the reads among them are
+// removed, as they observe nothing.
+func prefix(ops []Operation, t int64) []Operation {
+	result := []Operation{}
+
+	for _, op := range ops {
+		if op.Invoke > t {
+			continue
+		}
+
+		if op.Return > t {
+			if op.Kind == GetGroup {
+				continue
+			}
+
+			op.Pending = true
+		}
+
+		result = append(result, op)
+	}
+
+	return result
+}
+
+// entry is a call or return event in the history, as a node in a doubly
+// linked list.
+type entry struct {
+	op       int
+	isReturn bool
+	time     int64
+	// match is the return entry of a call entry.
+	match      *entry
+	prev, next *entry
+}
+
+// lift removes a call and its return from the list.
+func (e *entry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift undoes the last lift.
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type checker struct {
+	ops  []Operation
+	head *entry
+
+	// linearized is a bitset of the operations linearized so far.
+	linearized []byte
+	// seen are the (linearized, state) pairs already explored.
+	seen map[string]struct{}
+
+	// current and longest are the current and the longest linearizations
+	// found, as indexes in ops.
+	current []int
+	longest []int
+}
+
+// linearize returns if the operations can be linearized, along with the
+// longest linearization found.
+func linearize(ops []Operation) (bool, []Operation) {
+	c := &checker{
+		ops:        ops,
+		head:       buildList(ops),
+		linearized: make([]byte, (len(ops)+7)/8),
+		seen:       map[string]struct{}{},
+	}
+
+	ok := c.search(nil)
+
+	longest := make([]Operation, 0, len(c.longest))
+	for _, i := range c.longest {
+		longest = append(longest, ops[i])
+	}
+
+	return ok, longest
+}
+
+// buildList returns the sentinel head of a list with the call and return
+// events of the operations, sorted by time. Calls go before returns on ties,
+// so operations happening at the same time are considered concurrent.
+func buildList(ops []Operation) *entry {
+	entries := make([]*entry, 0, 2*len(ops))
+
+	for i, op := range ops {
+		ret := op.Return
+		if op.Pending {
+			ret = math.MaxInt64
+		}
+
+		r := &entry{op: i, isReturn: true, time: ret}
+		c := &entry{op: i, time: op.Invoke, match: r}
+		entries = append(entries, c, r)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+
+		return !entries[i].isReturn && entries[j].isReturn
+	})
+
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	return head
+}
+
+// search tries to linearize the remaining operations starting from state s.
+//
+// The candidates to be linearized next are the calls that happen before the
+// first return in the list of remaining events.
+func (c *checker) search(s state) bool {
+	if c.head.next == nil {
+		return true
+	}
+
+	for e := c.head.next; e != nil && !e.isReturn; e = e.next {
+		for _, next := range step(s, c.ops[e.op]) {
+			c.set(e.op, true)
+			key := string(c.linearized) + stateKey(next)
+			if _, ok := c.seen[key]; ok {
+				c.set(e.op, false)
+				continue
+			}
+			c.seen[key] = struct{}{}
+
+			e.lift()
+			c.push(e.op)
+
+			if c.search(next) {
+				return true
+			}
+
+			c.current = c.current[:len(c.current)-1]
+			e.unlift()
+			c.set(e.op, false)
+		}
+	}
+
+	return false
+}
+
+func (c *checker) set(i int, v bool) {
+	if v {
+		c.linearized[i/8] |= 1 << (i % 8)
+	} else {
+		c.linearized[i/8] &^= 1 << (i % 8)
+	}
+}
+
+func (c *checker) push(i int) {
+	c.current = append(c.current, i)
+
+	if len(c.current) > len(c.longest) {
+		c.longest = append(c.longest[:0], c.current...)
+	}
+}
@@ -0,0 +1,448 @@
+package linearizability_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/linearizability"
+	"github.com/stretchr/testify/require"
+)
+
+// helpers to build operations in a compact way, invoke and ret are the
+// invocation and return times of the operation.
+
+func create(groupID, ownerID string, invoke, ret int64) linearizability.Operation {
+	return linearizability.Operation{
+		Kind:    linearizability.CreateGroup,
+		GroupID: groupID,
+		UserID:  ownerID,
+		Invoke:  invoke,
+		Return:  ret,
+	}
+}
+
+// createErr is a creation of a group with a caller-chosen id, with the
+// CreateIfAbsent option if ifAbsent, returning err.
+func createErr(groupID, ownerID string, ifAbsent bool, err error, invoke, ret int64) linearizability.Operation {
+	op := create(groupID, ownerID, invoke, ret)
+	op.IfAbsent = ifAbsent
+	op.Err = err
+
+	return op
+}
+
+func add(groupID, userID string, err error, invoke, ret int64) linearizability.Operation {
+	return linearizability.Operation{
+		Kind:    linearizability.AddUserToGroup,
+		GroupID: groupID,
+		UserID:  userID,
+		Err:     err,
+		Invoke:  invoke,
+		Return:  ret,
+	}
+}
+
+func pendingAdd(groupID, userID string, invoke int64) linearizability.Operation {
+	op := add(groupID, userID, errors.New("connection lost"), invoke, invoke+1)
+	op.Pending = true
+
+	return op
+}
+
+func get(groupID, ownerID string, members []string, invoke, ret int64) linearizability.Operation {
+	return linearizability.Operation{
+		Kind:    linearizability.GetGroup,
+		GroupID: groupID,
+		OwnerID: ownerID,
+		Members: members,
+		Invoke:  invoke,
+		Return:  ret,
+	}
+}
+
+func getNotFound(groupID string, invoke, ret int64) linearizability.Operation {
+	return linearizability.Operation{
+		Kind:    linearizability.GetGroup,
+		GroupID: groupID,
+		Err:     domain.ErrNotFound,
+		Invoke:  invoke,
+		Return:  ret,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	// fullGroupHistory returns the history of filling group "g" sequentially,
+	// the last operation ending at time 10*domain.MaxMembers.
+	fullGroupHistory := func() []linearizability.Operation {
+		result := []linearizability.Operation{create("g", "owner", 0, 1)}
+
+		for i := 1; i < domain.MaxMembers; i++ {
+			result = append(result, add("g", fmt.Sprintf("user_%d", i), nil, int64(10*i), int64(10*i+1)))
+		}
+
+		return result
+	}
+
+	subtests := []struct {
+		name    string
+		history []linearizability.Operation
+		want    bool
+	}{
+		{
+			name:    "empty history",
+			history: nil,
+			want:    true,
+		},
+		{
+			name: "sequential",
+			history: []linearizability.Operation{
+				getNotFound("g", 0, 1),
+				create("g", "owner", 2, 3),
+				add("g", "a", nil, 4, 5),
+				get("g", "owner", []string{"a", "owner"}, 6, 7),
+			},
+			want: true,
+		},
+		{
+			name: "concurrent adds",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", nil, 2, 10),
+				add("g", "b", nil, 3, 9),
+				get("g", "owner", []string{"a", "b", "owner"}, 11, 12),
+			},
+			want: true,
+		},
+		{
+			name: "lost update",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", nil, 2, 10),
+				add("g", "b", nil, 3, 9),
+				get("g", "owner", []string{"a", "owner"}, 11, 12),
+			},
+			want: false,
+		},
+		{
+			name: "read concurrent with add can see it or not",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", nil, 2, 10),
+				get("g", "owner", []string{"owner"}, 3, 4),
+				get("g", "owner", []string{"a", "owner"}, 5, 6),
+			},
+			want: true,
+		},
+		{
+			name: "stale read",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", nil, 2, 3),
+				get("g", "owner", []string{"owner"}, 4, 5),
+			},
+			want: false,
+		},
+		{
+			name: "read going back in time",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", nil, 2, 10),
+				get("g", "owner", []string{"a", "owner"}, 3, 4),
+				get("g", "owner", []string{"owner"}, 5, 6),
+			},
+			want: false,
+		},
+		{
+			name: "pending add not applied",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				pendingAdd("g", "a", 2),
+				get("g", "owner", []string{"owner"}, 5, 6),
+			},
+			want: true,
+		},
+		{
+			name: "pending add applied",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				pendingAdd("g", "a", 2),
+				get("g", "owner", []string{"a", "owner"}, 5, 6),
+			},
+			want: true,
+		},
+		{
+			name: "add to missing group",
+			history: []linearizability.Operation{
+				add("g", "a", domain.ErrNotFound, 0, 1),
+			},
+			want: true,
+		},
+		{
+			name: "group full",
+			history: append(
+				fullGroupHistory(),
+				add("g", "one_too_many", domain.ErrGroupFull, 1000, 1001),
+			),
+			want: true,
+		},
+		{
+			name: "group full too soon",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", domain.ErrGroupFull, 2, 3),
+			},
+			want: false,
+		},
+		{
+			name: "group overflow",
+			history: append(
+				fullGroupHistory(),
+				add("g", "one_too_many", nil, 1000, 1001),
+			),
+			want: false,
+		},
+		{
+			name: "aborted transactions have no effects",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				add("g", "a", domain.ErrTooManyTransactionRetries, 2, 3),
+				get("g", "owner", []string{"owner"}, 4, 5),
+			},
+			want: true,
+		},
+		{
+			name: "create existing group",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				createErr("g", "owner", false, domain.ErrAlreadyExists, 2, 3),
+				createErr("g", "other", true, domain.ErrAlreadyExists, 4, 5),
+				createErr("g", "owner", true, nil, 6, 7),
+				get("g", "owner", []string{"owner"}, 8, 9),
+			},
+			want: true,
+		},
+		{
+			name: "concurrent creations if absent",
+			history: []linearizability.Operation{
+				createErr("g", "owner", true, nil, 0, 5),
+				createErr("g", "owner", true, nil, 1, 4),
+				add("g", "a", nil, 6, 7),
+			},
+			want: true,
+		},
+		{
+			name: "already exists before creation",
+			history: []linearizability.Operation{
+				createErr("g", "owner", false, domain.ErrAlreadyExists, 0, 1),
+				create("g", "owner", 2, 3),
+			},
+			want: false,
+		},
+		{
+			name: "created if absent with another owner",
+			history: []linearizability.Operation{
+				create("g", "owner", 0, 1),
+				createErr("g", "other", true, nil, 2, 3),
+			},
+			want: false,
+		},
+		{
+			name: "independent groups",
+			history: []linearizability.Operation{
+				create("g1", "owner1", 0, 3),
+				create("g2", "owner2", 1, 2),
+				add("g1", "a", nil, 4, 8),
+				add("g2", "a", nil, 5, 7),
+				get("g1", "owner1", []string{"a", "owner1"}, 9, 10),
+				get("g2", "owner2", []string{"a", "owner2"}, 9, 10),
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// WHEN we check the history
+			got := linearizability.Check(test.history)
+
+			// THEN we get the verdict we want
+			require.Equal(t, test.want, got.Linearizable)
+
+			// THEN non linearizable histories come with a counterexample
+			if !test.want {
+				require.NotEmpty(t, got.Counterexample)
+			}
+		})
+	}
+}
+
+// Tests the counterexample only keeps the operations needed to reproduce the
+// problem.
+func TestCheck_Counterexample(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a history with a lost update, surrounded by reads that are
+	// fine and operations happening after the problem
+	history := []linearizability.Operation{
+		create("g", "owner", 0, 1),
+		get("g", "owner", []string{"owner"}, 2, 3),
+		add("g", "a", nil, 4, 10),
+		add("g", "b", nil, 5, 9),
+		get("g", "owner", []string{"owner"}, 6, 7),
+		get("g", "owner", []string{"a", "owner"}, 11, 12),
+		add("g", "c", nil, 13, 14),
+		get("g", "owner", []string{"a", "c", "owner"}, 15, 16),
+		// and an unrelated group
+		create("other", "owner", 0, 1),
+	}
+
+	// WHEN we check the history
+	got := linearizability.Check(history)
+
+	// THEN it is not linearizable
+	require.False(t, got.Linearizable)
+	require.Equal(t, "g", got.GroupID)
+
+	// THEN the counterexample is the creation, the two adds and the read
+	// that missed one of them
+	want := []linearizability.Operation{
+		history[0],
+		history[2],
+		history[3],
+		history[5],
+	}
+	require.Equal(t, want, got.Counterexample)
+
+	// THEN the partial linearization shows everything but the read could be
+	// linearized
+	require.Len(t, got.Partial, 3)
+}
+
+// fakeApp is an application that remembers a single group in memory, and
+// can be told to fail the next call.
+type fakeApp struct {
+	group *domain.Group
+	err   error
+}
+
+func (f *fakeApp) CreateGroup(_ context.Context, ownerID string, _ ...application.Option) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+
+	f.group = domain.NewGroup("group_id", ownerID, time.Now())
+
+	return f.group.ID(), nil
+}
+
+func (f *fakeApp) GetGroup(_ context.Context, _ string) (*domain.Group, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.group, nil
+}
+
+func (f *fakeApp) AddUserToGroup(_ context.Context, userID, _ string, _ ...application.Option) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	return f.group.AddMember(userID, time.Now())
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a recorder wrapping an app
+	app := &fakeApp{}
+	recorder := linearizability.NewRecorder(app)
+	ctx := context.Background()
+
+	// WHEN we make some calls through the recorder, including calls with
+	// unknown outcomes
+	groupID, err := recorder.CreateGroup(ctx, "owner")
+	require.NoError(t, err)
+
+	err = recorder.AddUserToGroup(ctx, "a", groupID)
+	require.NoError(t, err)
+
+	_, err = recorder.GetGroup(ctx, groupID)
+	require.NoError(t, err)
+
+	app.err = errors.New("connection lost")
+
+	err = recorder.AddUserToGroup(ctx, "b", groupID)
+	require.Error(t, err)
+
+	_, err = recorder.GetGroup(ctx, groupID)
+	require.Error(t, err)
+
+	// THEN the history has the calls we made, in order, with their outcome
+	// and without the failed read
+	history := recorder.History()
+	require.Len(t, history, 4)
+
+	require.Equal(t, linearizability.CreateGroup, history[0].Kind)
+	require.Equal(t, "owner", history[0].UserID)
+
+	require.Equal(t, linearizability.AddUserToGroup, history[1].Kind)
+	require.False(t, history[1].Pending)
+
+	require.Equal(t, linearizability.GetGroup, history[2].Kind)
+	require.Equal(t, "owner", history[2].OwnerID)
+	require.Equal(t, []string{"a", "owner"}, history[2].Members)
+
+	require.Equal(t, linearizability.AddUserToGroup, history[3].Kind)
+	require.True(t, history[3].Pending)
+
+	// THEN the timestamps are consistent
+	for i, op := range history {
+		require.LessOrEqualf(t, op.Invoke, op.Return, "operation #%d", i)
+
+		if i > 0 {
+			require.LessOrEqualf(t, history[i-1].Return, op.Invoke, "operation #%d", i)
+		}
+	}
+
+	// THEN the history is linearizable
+	require.True(t, linearizability.Check(history).Linearizable)
+}
+
+// Tests failed creations are recorded when the caller knows the id of the
+// group, with their error and options.
+func TestRecorder_CreateGroup(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a recorder wrapping an app where the group already exists
+	app := &fakeApp{err: domain.NewError(domain.ErrAlreadyExists, "group_id", nil)}
+	recorder := linearizability.NewRecorder(app)
+	ctx := context.Background()
+
+	// WHEN we create it again, with and without a caller-chosen id
+	_, err := recorder.CreateGroup(ctx, "owner")
+	require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+	_, err = recorder.CreateGroup(ctx, "owner", application.UseGroupID("group_id"), application.CreateIfAbsent{})
+	require.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+	// THEN only the creation with a caller-chosen id is recorded, as a known
+	// failure
+	history := recorder.History()
+	require.Len(t, history, 1)
+
+	require.Equal(t, linearizability.CreateGroup, history[0].Kind)
+	require.Equal(t, "group_id", history[0].GroupID)
+	require.True(t, history[0].IfAbsent)
+	require.ErrorIs(t, history[0].Err, domain.ErrAlreadyExists)
+	require.False(t, history[0].Pending)
+}
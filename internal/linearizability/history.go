@@ -0,0 +1,231 @@
+package linearizability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// Kind identifies the use case an operation represents.
+type Kind int
+
+const (
+	CreateGroup Kind = iota + 1
+	GetGroup
+	AddUserToGroup
+)
+
+func (k Kind) String() string {
+	switch k {
+	case CreateGroup:
+		return "CreateGroup"
+	case GetGroup:
+		return "GetGroup"
+	case AddUserToGroup:
+		return "AddUserToGroup"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Operation is a single call to the application, as seen by its caller.
+type Operation struct {
+	Kind    Kind
+	GroupID string
+	// UserID is the owner for CreateGroup and the new member for
+	// AddUserToGroup.
+	UserID string
+	// IfAbsent is true for the CreateGroup calls with the
+	// application.CreateIfAbsent option.
+	IfAbsent bool
+
+	// OwnerID and Members are the group returned by a successful GetGroup.
+	OwnerID string
+	Members []string
+	// Err is the error returned by the call.
+	Err error
+
+	// Invoke and Return are the times when the call started and finished,
+	// in nanoseconds since the recorder was created.
+	Invoke int64
+	Return int64
+	// Pending is true if the outcome of the call is unknown: the call may
+	// or may not have taken effect. Pending operations can be linearized at
+	// any point after their invocation.
+	Pending bool
+}
+
+func (o Operation) String() string {
+	var output string
+
+	switch {
+	case o.Pending:
+		output = fmt.Sprintf("pending (%v)", o.Err)
+	case o.Err != nil:
+		output = o.Err.Error()
+	case o.Kind == GetGroup:
+		output = fmt.Sprintf("owner=%s members=%v", o.OwnerID, o.Members)
+	default:
+		output = "ok"
+	}
+
+	return fmt.Sprintf("[%d, %d] %s(group=%s, user=%s) -> %s",
+		o.Invoke, o.Return, o.Kind, o.GroupID, o.UserID, output)
+}
+
+// App is the subset of application.App whose calls can be recorded.
+type App interface {
+	CreateGroup(ctx context.Context, ownerID string, options ...application.Option) (string, error)
+	GetGroup(ctx context.Context, groupID string) (*domain.Group, error)
+	AddUserToGroup(ctx context.Context, userID, groupID string, options ...application.Option) error
+}
+
+// Recorder wraps an App, recording the invocation and return of every call
+// into a history that can later be verified with Check.
+//
+// Recorder is safe for concurrent use and implements App itself, so it can
+// be used as a drop-in replacement of the wrapped application.
+type Recorder struct {
+	app   App
+	start time.Time
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+func NewRecorder(app App) *Recorder {
+	return &Recorder{
+		app:   app,
+		start: time.Now(),
+	}
+}
+
+func (r *Recorder) CreateGroup(ctx context.Context, ownerID string, options ...application.Option) (string, error) {
+	invoke := r.now()
+	groupID, err := r.app.CreateGroup(ctx, ownerID, options...)
+	ret := r.now()
+
+	op := Operation{
+		Kind:    CreateGroup,
+		GroupID: groupID,
+		UserID:  ownerID,
+		Err:     err,
+		Invoke:  invoke,
+		Return:  ret,
+		Pending: !isKnownOutcome(err),
+	}
+
+	for _, o := range options {
+		switch o := o.(type) {
+		case application.UseGroupID:
+			op.GroupID = string(o)
+		case application.CreateIfAbsent:
+			op.IfAbsent = true
+		}
+	}
+
+	// a failed creation of a group with a new id never returns the id, so
+	// no other recorded operation can observe it, but the caller knows the
+	// id of the group when using application.UseGroupID.
+	if err == nil || op.GroupID != "" {
+		r.add(op)
+	}
+
+	return groupID, err
+}
+
+func (r *Recorder) GetGroup(ctx context.Context, groupID string) (*domain.Group, error) {
+	invoke := r.now()
+	group, err := r.app.GetGroup(ctx, groupID)
+	ret := r.now()
+
+	op := Operation{
+		Kind:    GetGroup,
+		GroupID: groupID,
+		Err:     err,
+		Invoke:  invoke,
+		Return:  ret,
+	}
+
+	switch {
+	case err == nil:
+		op.OwnerID = group.OwnerID()
+		op.Members = group.Members()
+		r.add(op)
+	case errors.Is(err, domain.ErrNotFound):
+		r.add(op)
+	default:
+		// a failed read has no effects and observes nothing.
+	}
+
+	return group, err
+}
+
+func (r *Recorder) AddUserToGroup(
+	ctx context.Context,
+	userID, groupID string,
+	options ...application.Option,
+) error {
+	invoke := r.now()
+	err := r.app.AddUserToGroup(ctx, userID, groupID, options...)
+	ret := r.now()
+
+	r.add(Operation{
+		Kind:    AddUserToGroup,
+		GroupID: groupID,
+		UserID:  userID,
+		Err:     err,
+		Invoke:  invoke,
+		Return:  ret,
+		Pending: !isKnownOutcome(err),
+	})
+
+	return err
+}
+
+// History returns a copy of the operations recorded so far.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Operation, len(r.ops))
+	copy(result, r.ops)
+
+	return result
+}
+
+func (r *Recorder) now() int64 {
+	return time.Since(r.start).Nanoseconds()
+}
+
+func (r *Recorder) add(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ops = append(r.ops, op)
+}
+
+// isKnownOutcome returns if we know for sure whether a mutation returning err
+// has taken effect or not.
+func isKnownOutcome(err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, domain.ErrGroupFull):
+		return true
+	case errors.Is(err, domain.ErrNotFound):
+		return true
+	case errors.Is(err, domain.ErrAlreadyExists):
+		return true
+	case errors.Is(err, domain.ErrTooManyTransactionRetries):
+		// all the attempts were aborted.
+		return true
+	default:
+		return false
+	}
+}
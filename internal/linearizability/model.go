@@ -0,0 +1,157 @@
+package linearizability
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// state is the sequential specification of a single group: the snapshot of
+// the group, or nil if the group has not been created yet.
+type state = *domain.GroupSnapshot
+
+// stateKey returns a string that uniquely identifies s, which can be used to
+// memoize the states already explored by the checker.
+func stateKey(s state) string {
+	if s == nil {
+		return "-"
+	}
+
+	return s.OwnerID + "\x00" + strings.Join(s.Members, "\x00")
+}
+
+// step returns the states the group can be in after applying op to s.
+//
+// An empty result means op cannot be linearized when the group is in state
+// s. Pending operations can return more than one state, as they may or may
+// not have taken effect.
+//
+// The model reuses domain.Group, so it follows the same rules the
+// application is supposed to follow. Times are not observed by the
+// operations, so the model ignores them.
+func step(s state, op Operation) []state {
+	switch op.Kind {
+	case CreateGroup:
+		return stepCreateGroup(s, op)
+	case GetGroup:
+		if s == nil {
+			if errors.Is(op.Err, domain.ErrNotFound) {
+				return []state{s}
+			}
+
+			return nil
+		}
+
+		if op.Err == nil && op.OwnerID == s.OwnerID && slices.Equal(op.Members, s.Members) {
+			return []state{s}
+		}
+
+		return nil
+	case AddUserToGroup:
+		return stepAddUserToGroup(s, op)
+	default:
+		return nil
+	}
+}
+
+// stepCreateGroup returns the states after a creation: it creates the group
+// if it does not exist yet, otherwise it fails with domain.ErrAlreadyExists
+// or, with application.CreateIfAbsent, succeeds without effects if the group
+// has the same owner.
+func stepCreateGroup(s state, op Operation) []state {
+	if s == nil {
+		created := domain.NewGroup(op.GroupID, op.UserID, time.Time{}).Snapshot()
+
+		switch {
+		case op.Pending:
+			return []state{s, created}
+		case op.Err == nil:
+			return []state{created}
+		case errors.Is(op.Err, domain.ErrTooManyTransactionRetries):
+			return []state{s}
+		default:
+			return nil
+		}
+	}
+
+	found := op.IfAbsent && s.OwnerID == op.UserID
+
+	switch {
+	case op.Pending:
+		return []state{s}
+	case op.Err == nil && found:
+		return []state{s}
+	case errors.Is(op.Err, domain.ErrAlreadyExists) && !found:
+		return []state{s}
+	case errors.Is(op.Err, domain.ErrTooManyTransactionRetries):
+		return []state{s}
+	default:
+		return nil
+	}
+}
+
+func stepAddUserToGroup(s state, op Operation) []state {
+	if op.Pending {
+		// it may have not taken effect...
+		result := []state{s}
+
+		// ... or it may have succeeded
+		if next, err := addMember(s, op.UserID); err == nil {
+			result = append(result, next)
+		}
+
+		return result
+	}
+
+	if errors.Is(op.Err, domain.ErrTooManyTransactionRetries) {
+		return []state{s}
+	}
+
+	next, err := addMember(s, op.UserID)
+	switch {
+	case err == nil && op.Err == nil:
+		return []state{next}
+	case errors.Is(err, domain.ErrGroupFull) && errors.Is(op.Err, domain.ErrGroupFull):
+		return []state{s}
+	case errors.Is(err, domain.ErrNotFound) && errors.Is(op.Err, domain.ErrNotFound):
+		return []state{s}
+	default:
+		return nil
+	}
+}
+
+// addMember returns the state of the group after adding userID as a member.
+func addMember(s state, userID string) (state, error) {
+	if s == nil {
+		return nil, domain.ErrNotFound
+	}
+
+	group, err := s.Regenerate()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := group.AddMember(userID, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return group.Snapshot(), nil
+}
+
+// hasEffects returns if op can change the state of the group.
+//
+// Removing operations without effects from a linearizable history always
+// leads to another linearizable history.
+func hasEffects(op Operation) bool {
+	switch {
+	case op.Kind == GetGroup:
+		return false
+	case !op.Pending && op.Err != nil:
+		return false
+	default:
+		return true
+	}
+}
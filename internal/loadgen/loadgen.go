@@ -0,0 +1,380 @@
+// Package loadgen stresses the application with a configurable workload of
+// concurrent calls, and summarizes how it went: latencies, errors and
+// whether the resulting groups are consistent.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// App is the subset of application.App driven by the load generator.
+type App interface {
+	CreateGroup(ctx context.Context, ownerID string, options ...application.Option) (string, error)
+	GetGroup(ctx context.Context, groupID string) (*domain.Group, error)
+	AddUserToGroup(ctx context.Context, userID, groupID string, options ...application.Option) error
+}
+
+// Op is a kind of call to the application.
+type Op string
+
+const (
+	OpCreate Op = "create"
+	OpGet    Op = "get"
+	OpAdd    Op = "add"
+)
+
+// ops are all the kinds of calls, in the order they are reported.
+var ops = []Op{OpCreate, OpGet, OpAdd}
+
+// Mix is the relative weight of each kind of call in the workload, for
+// instance, {create: 1, add: 9} makes 10% of the calls create groups and
+// 90% of them add users to groups.
+type Mix map[Op]int
+
+// ParseMix parses a mix in the "create=1,get=5,add=10" format.
+func ParseMix(s string) (Mix, error) {
+	mix := Mix{}
+
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q, want op=weight", part)
+		}
+
+		op := Op(name)
+		if op != OpCreate && op != OpGet && op != OpAdd {
+			return nil, fmt.Errorf("unknown op %q", name)
+		}
+
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q for op %s", weight, name)
+		}
+
+		mix[op] = w
+	}
+
+	return mix, nil
+}
+
+// Distribution is how the calls choose the group they operate on.
+type Distribution string
+
+const (
+	// Uniform makes all the groups equally likely.
+	Uniform Distribution = "uniform"
+	// Zipf makes a few groups very hot and most of them cold, the older
+	// groups being the hottest.
+	Zipf Distribution = "zipf"
+)
+
+// Config describes a workload.
+type Config struct {
+	// Mix is the proportion of each kind of call.
+	Mix Mix
+	// Concurrency is the number of goroutines making calls.
+	Concurrency int
+	// Rate is the target number of calls per second, between all the
+	// goroutines, or 0 to make calls as fast as possible.
+	Rate float64
+	// Duration is how long to run the workload, 0 means until ctx is done
+	// or Operations calls have been made.
+	Duration time.Duration
+	// Operations is the number of calls to make, 0 means no limit.
+	Operations int
+	// Groups is the number of groups created before starting the workload.
+	Groups int
+	// Distribution chooses the group of each call.
+	Distribution Distribution
+	// ZipfS is the s parameter of the Zipf distribution, it must be greater
+	// than 1, the bigger the hotter the hot groups are.
+	ZipfS float64
+	// Seed makes the choices of the workload reproducible.
+	Seed int64
+	// Options are passed to every AddUserToGroup call.
+	Options []application.Option
+}
+
+func (c Config) validate() error {
+	total := 0
+	for _, w := range c.Mix {
+		total += w
+	}
+
+	switch {
+	case total == 0:
+		return errors.New("the mix has no operations")
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.Rate < 0:
+		return errors.New("rate must not be negative")
+	case c.Duration == 0 && c.Operations == 0:
+		return errors.New("either duration or operations must be set")
+	case c.Groups < 1 && c.Mix[OpCreate] == 0:
+		return errors.New("there must be some initial groups if the mix does not create them")
+	case c.Distribution != Uniform && c.Distribution != Zipf:
+		return fmt.Errorf("unknown distribution %q", c.Distribution)
+	case c.Distribution == Zipf && c.ZipfS <= 1:
+		return errors.New("the Zipf s parameter must be greater than 1")
+	}
+
+	return nil
+}
+
+// Run runs the workload against the app and returns its summary.
+//
+// Returns an error if the configuration is wrong or if the initial groups
+// cannot be created. Errors during the workload are reported in the summary.
+func Run(ctx context.Context, app App, cfg Config) (*Summary, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	g := &generator{
+		app:      app,
+		cfg:      cfg,
+		stats:    map[Op]*opStats{},
+		expected: map[string][]string{},
+	}
+
+	for _, op := range ops {
+		g.stats[op] = &opStats{errors: map[string]int{}}
+	}
+
+	for i := range cfg.Groups {
+		id, err := app.CreateGroup(ctx, fmt.Sprintf("owner_%d", i))
+		if err != nil {
+			return nil, fmt.Errorf("creating initial group %d: %v", i, err)
+		}
+
+		g.groupIDs = append(g.groupIDs, id)
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	g.run(ctx)
+	elapsed := time.Since(start)
+
+	// the workload context may be done, but the invariants must be checked
+	checkCtx := context.WithoutCancel(ctx)
+
+	return g.summary(checkCtx, elapsed), nil
+}
+
+// generator is the state of a running workload.
+type generator struct {
+	app App
+	cfg Config
+
+	mu       sync.Mutex
+	groupIDs []string
+	stats    map[Op]*opStats
+	// expected are the users successfully added to each group.
+	expected map[string][]string
+	// issued is the number of calls made so far.
+	issued int
+}
+
+// run runs the workers until ctx is done or all the calls have been made.
+func (g *generator) run(ctx context.Context) {
+	var tokens <-chan time.Time
+	if g.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / g.cfg.Rate))
+		defer ticker.Stop()
+
+		tokens = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(g.cfg.Concurrency)
+
+	for w := range g.cfg.Concurrency {
+		go func() {
+			defer wg.Done()
+
+			//nolint:gosec // weak random generation is ok here
+			rnd := rand.New(rand.NewSource(g.cfg.Seed + int64(w)))
+			worker := &worker{generator: g, id: w, rnd: rnd}
+
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+
+				if ctx.Err() != nil || !g.take() {
+					return
+				}
+
+				worker.call(ctx)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// take reserves a call, returns false if all the calls have been made.
+func (g *generator) take() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cfg.Operations > 0 && g.issued >= g.cfg.Operations {
+		return false
+	}
+
+	g.issued++
+
+	return true
+}
+
+func (g *generator) record(op Op, elapsed time.Duration, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s := g.stats[op]
+	s.latencies = append(s.latencies, elapsed)
+
+	if err != nil {
+		s.errors[errorKind(err)]++
+	}
+}
+
+// worker makes calls with its own random generator.
+type worker struct {
+	*generator
+	id  int
+	rnd *rand.Rand
+	n   int
+	// zipf is the generator for the current number of groups.
+	zipf       *rand.Zipf
+	zipfGroups int
+}
+
+func (w *worker) call(ctx context.Context) {
+	w.n++
+
+	op := w.chooseOp()
+
+	groupID, ok := w.chooseGroup()
+	if !ok {
+		// no groups yet, create one instead
+		op = OpCreate
+	}
+
+	start := time.Now()
+
+	switch op {
+	case OpCreate:
+		id, err := w.app.CreateGroup(ctx, fmt.Sprintf("owner_%d_%d", w.id, w.n))
+		w.record(op, time.Since(start), err)
+
+		if err == nil {
+			w.mu.Lock()
+			w.groupIDs = append(w.groupIDs, id)
+			w.mu.Unlock()
+		}
+	case OpGet:
+		_, err := w.app.GetGroup(ctx, groupID)
+		w.record(op, time.Since(start), err)
+	case OpAdd:
+		userID := fmt.Sprintf("user_%d_%d", w.id, w.n)
+		err := w.app.AddUserToGroup(ctx, userID, groupID, w.cfg.Options...)
+		w.record(op, time.Since(start), err)
+
+		if err == nil {
+			w.mu.Lock()
+			w.expected[groupID] = append(w.expected[groupID], userID)
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *worker) chooseOp() Op {
+	total := 0
+	for _, op := range ops {
+		total += w.cfg.Mix[op]
+	}
+
+	n := w.rnd.Intn(total)
+	for _, op := range ops {
+		if n < w.cfg.Mix[op] {
+			return op
+		}
+
+		n -= w.cfg.Mix[op]
+	}
+
+	panic("unreachable")
+}
+
+// chooseGroup returns a group id, or false if there are no groups yet.
+func (w *worker) chooseGroup() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(w.groupIDs)
+	if n == 0 {
+		return "", false
+	}
+
+	if w.cfg.Distribution == Uniform {
+		return w.groupIDs[w.rnd.Intn(n)], true
+	}
+
+	if w.zipf == nil || w.zipfGroups != n {
+		w.zipf = rand.NewZipf(w.rnd, w.cfg.ZipfS, 1, uint64(n-1))
+		w.zipfGroups = n
+	}
+
+	return w.groupIDs[w.zipf.Uint64()], true
+}
+
+// errorKind returns the name of the kind of the error, for the error
+// breakdown of the summary.
+func errorKind(err error) string {
+	switch domain.KindOf(err) {
+	case domain.ErrGroupFull:
+		return "group_full"
+	case domain.ErrNotFound:
+		return "not_found"
+	case domain.ErrAlreadyExists:
+		return "already_exists"
+	case domain.ErrInvalidArgument:
+		return "invalid_argument"
+	case domain.ErrFailedPrecondition:
+		return "failed_precondition"
+	case domain.ErrConflict:
+		return "conflict"
+	case domain.ErrUnavailable:
+		return "unavailable"
+	case domain.ErrTooManyTransactionRetries:
+		return "too_many_transaction_retries"
+	case domain.ErrTransientTransaction:
+		return "transient_transaction"
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "canceled"
+	}
+
+	return "other"
+}
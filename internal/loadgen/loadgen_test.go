@@ -0,0 +1,200 @@
+package loadgen_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/loadgen"
+	"github.com/stretchr/testify/require"
+)
+
+// sequentialUuider returns predictable ids, it is safe for concurrent use.
+type sequentialUuider struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (u *sequentialUuider) NewString() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.n++
+
+	return fmt.Sprintf("group_%d", u.n)
+}
+
+func newApp() *application.App {
+	return application.New(&sequentialUuider{}, memory.NewGroupRepo())
+}
+
+func validConfig() loadgen.Config {
+	return loadgen.Config{
+		Mix:          loadgen.Mix{loadgen.OpCreate: 1, loadgen.OpGet: 2, loadgen.OpAdd: 7},
+		Concurrency:  8,
+		Operations:   500,
+		Groups:       5,
+		Distribution: loadgen.Zipf,
+		ZipfS:        1.5,
+		Seed:         42,
+		Options:      []application.Option{application.EnableTransactions{}},
+	}
+}
+
+// Tests a workload with transactions makes the requested calls and keeps
+// the groups consistent.
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	for _, distribution := range []loadgen.Distribution{loadgen.Uniform, loadgen.Zipf} {
+		t.Run(string(distribution), func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN a workload
+			cfg := validConfig()
+			cfg.Distribution = distribution
+
+			// WHEN we run it
+			summary, err := loadgen.Run(context.Background(), newApp(), cfg)
+			require.NoError(t, err)
+
+			// THEN all the calls have been made
+			require.Equal(t, cfg.Operations, summary.Total())
+			for op, s := range summary.Ops {
+				require.Positivef(t, s.Count, "op %s", op)
+				require.LessOrEqualf(t, s.P50, s.P99, "op %s", op)
+			}
+
+			// THEN the groups are consistent
+			require.Empty(t, summary.Violations)
+			require.GreaterOrEqual(t, summary.Groups, cfg.Groups)
+
+			// THEN the adds to full groups have failed
+			require.Positive(t, summary.Ops[loadgen.OpAdd].Errors["group_full"])
+		})
+	}
+}
+
+// Tests a workload stops after its duration, respecting its rate.
+func TestRun_DurationAndRate(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a slow workload limited by time
+	cfg := validConfig()
+	cfg.Operations = 0
+	cfg.Duration = 200 * time.Millisecond
+	cfg.Rate = 100
+
+	// WHEN we run it
+	summary, err := loadgen.Run(context.Background(), newApp(), cfg)
+	require.NoError(t, err)
+
+	// THEN it makes roughly rate*duration calls
+	require.Positive(t, summary.Total())
+	require.LessOrEqual(t, summary.Total(), 25)
+}
+
+// Tests the summary is written in a human readable way.
+func TestSummary_Write(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN the summary of a workload
+	summary, err := loadgen.Run(context.Background(), newApp(), validConfig())
+	require.NoError(t, err)
+
+	// WHEN we write it
+	var buf bytes.Buffer
+	err = summary.Write(&buf)
+	require.NoError(t, err)
+
+	// THEN it has the number of calls, the latencies and the errors
+	require.Contains(t, buf.String(), "500 calls")
+	require.Contains(t, buf.String(), "p99")
+	require.Contains(t, buf.String(), "group_full")
+	require.Contains(t, buf.String(), "0 invariant violations")
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(*loadgen.Config){
+		"empty mix":            func(c *loadgen.Config) { c.Mix = loadgen.Mix{} },
+		"zero weights":         func(c *loadgen.Config) { c.Mix = loadgen.Mix{loadgen.OpAdd: 0} },
+		"no concurrency":       func(c *loadgen.Config) { c.Concurrency = 0 },
+		"negative rate":        func(c *loadgen.Config) { c.Rate = -1 },
+		"no limit":             func(c *loadgen.Config) { c.Operations = 0; c.Duration = 0 },
+		"unknown distribution": func(c *loadgen.Config) { c.Distribution = "normal" },
+		"small zipf s":         func(c *loadgen.Config) { c.ZipfS = 1 },
+		"no groups to work on": func(c *loadgen.Config) { c.Groups = 0; c.Mix = loadgen.Mix{loadgen.OpAdd: 1} },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN an invalid config
+			cfg := validConfig()
+			modify(&cfg)
+
+			// WHEN we run it
+			_, err := loadgen.Run(context.Background(), newApp(), cfg)
+
+			// THEN we get an error
+			require.ErrorContains(t, err, "invalid config")
+		})
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+
+		got, err := loadgen.ParseMix("create=1, get=5,add=10")
+		require.NoError(t, err)
+
+		want := loadgen.Mix{loadgen.OpCreate: 1, loadgen.OpGet: 5, loadgen.OpAdd: 10}
+		require.Equal(t, want, got)
+	})
+
+	for _, s := range []string{"", "create", "delete=1", "add=-1", "add=x"} {
+		t.Run(fmt.Sprintf("invalid %q", s), func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadgen.ParseMix(s)
+			require.Error(t, err)
+		})
+	}
+}
+
+// Tests the summary detects lost updates.
+func TestRun_LostUpdates(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN an app that loses updates: it says it has added users but it
+	// does not
+	app := &lossyApp{App: newApp()}
+
+	// WHEN we run a workload against it
+	summary, err := loadgen.Run(context.Background(), app, validConfig())
+	require.NoError(t, err)
+
+	// THEN the lost updates are reported
+	require.NotEmpty(t, summary.Violations)
+	require.Contains(t, summary.Violations[0], "lost update")
+}
+
+type lossyApp struct {
+	*application.App
+}
+
+func (a *lossyApp) AddUserToGroup(ctx context.Context, _, groupID string, _ ...application.Option) error {
+	_, err := a.App.GetGroup(ctx, groupID)
+	return err
+}
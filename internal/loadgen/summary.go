@@ -0,0 +1,169 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+)
+
+// Summary describes how a workload went.
+type Summary struct {
+	// Elapsed is how long the workload took.
+	Elapsed time.Duration
+	// Ops has the statistics of each kind of call.
+	Ops map[Op]OpSummary
+	// Groups is the number of groups checked after the workload.
+	Groups int
+	// Violations describe the broken invariants found in the groups after
+	// the workload, like lost updates or groups with too many members.
+	Violations []string
+}
+
+// OpSummary has the statistics of a kind of call.
+type OpSummary struct {
+	Count int
+	// Errors is the number of failed calls by kind of error, like
+	// "group_full" or "too_many_transaction_retries".
+	Errors map[string]int
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// Total returns the number of calls made.
+func (s *Summary) Total() int {
+	total := 0
+	for _, op := range s.Ops {
+		total += op.Count
+	}
+
+	return total
+}
+
+// Write writes a human readable version of the summary to w.
+func (s *Summary) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	rate := float64(s.Total()) / s.Elapsed.Seconds()
+	fmt.Fprintf(tw, "%d calls in %v (%.1f calls/s)\n\n", s.Total(), s.Elapsed.Round(time.Millisecond), rate)
+
+	fmt.Fprintln(tw, "op\tcalls\terrors\tp50\tp90\tp99\tmax\t")
+
+	for _, op := range ops {
+		o := s.Ops[op]
+
+		errs := 0
+		for _, n := range o.Errors {
+			errs += n
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%v\t%v\t%v\t\n", op, o.Count, errs,
+			o.P50.Round(time.Microsecond), o.P90.Round(time.Microsecond),
+			o.P99.Round(time.Microsecond), o.Max.Round(time.Microsecond))
+	}
+
+	fmt.Fprintln(tw)
+
+	for _, op := range ops {
+		kinds := make([]string, 0, len(s.Ops[op].Errors))
+		for kind := range s.Ops[op].Errors {
+			kinds = append(kinds, kind)
+		}
+		slices.Sort(kinds)
+
+		for _, kind := range kinds {
+			fmt.Fprintf(tw, "%s errors\t%s\t%d\t\n", op, kind, s.Ops[op].Errors[kind])
+		}
+	}
+
+	fmt.Fprintf(tw, "\nchecked %d groups: %d invariant violations\n", s.Groups, len(s.Violations))
+
+	for _, v := range s.Violations {
+		fmt.Fprintf(tw, "  %s\n", v)
+	}
+
+	return tw.Flush()
+}
+
+// opStats are the raw statistics of a kind of call.
+type opStats struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func (s *opStats) summary() OpSummary {
+	sorted := slices.Clone(s.latencies)
+	slices.Sort(sorted)
+
+	percentile := func(p int) time.Duration {
+		if len(sorted) == 0 {
+			return 0
+		}
+
+		i := (len(sorted)*p+99)/100 - 1
+
+		return sorted[max(i, 0)]
+	}
+
+	return OpSummary{
+		Count:  len(sorted),
+		Errors: s.errors,
+		P50:    percentile(50),
+		P90:    percentile(90),
+		P99:    percentile(99),
+		Max:    percentile(100),
+	}
+}
+
+// summary checks the invariants of the groups and returns the summary.
+func (g *generator) summary(ctx context.Context, elapsed time.Duration) *Summary {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s := &Summary{
+		Elapsed: elapsed,
+		Ops:     map[Op]OpSummary{},
+		Groups:  len(g.groupIDs),
+	}
+
+	for _, op := range ops {
+		s.Ops[op] = g.stats[op].summary()
+	}
+
+	for _, id := range g.groupIDs {
+		s.Violations = append(s.Violations, g.check(ctx, id)...)
+	}
+
+	return s
+}
+
+// check returns the broken invariants of a group:
+//   - it can be loaded, which means it is a valid domain group.
+//   - all the users successfully added to it are members, that is, no
+//     update has been lost.
+func (g *generator) check(ctx context.Context, groupID string) []string {
+	group, err := g.app.GetGroup(ctx, groupID)
+	if err != nil {
+		return []string{fmt.Sprintf("group %s: cannot be loaded: %v", groupID, err)}
+	}
+
+	var result []string
+
+	if group.NumMembers() > domain.MaxMembers {
+		result = append(result, fmt.Sprintf("group %s: too many members (%d)", groupID, group.NumMembers()))
+	}
+
+	for _, userID := range g.expected[groupID] {
+		if !group.HasMember(userID) {
+			result = append(result, fmt.Sprintf("group %s: lost update, %s was added but it is not a member", groupID, userID))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,81 @@
+// Package logging helps carrying structured logging attributes through
+// contexts, so every log line related to a request includes the request
+// details, no matter which layer emits it.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// attrsKey is the context key for the logging attributes.
+type attrsKey struct{}
+
+// WithAttrs returns a copy of ctx that carries attrs, in addition to the
+// attributes already carried by ctx.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	current := Attrs(ctx)
+
+	all := make([]slog.Attr, 0, len(current)+len(attrs))
+	all = append(all, current...)
+	all = append(all, attrs...)
+
+	return context.WithValue(ctx, attrsKey{}, all)
+}
+
+// Attrs returns the logging attributes carried by ctx.
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// NewLogger returns a logger that adds the attributes carried by the context
+// to every record logged by l, or a logger that discards everything if l is
+// nil.
+func NewLogger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.New(discardHandler{})
+	}
+
+	if _, ok := l.Handler().(contextHandler); ok {
+		return l
+	}
+
+	return slog.New(contextHandler{Handler: l.Handler()})
+}
+
+// Discard returns a logger that discards everything.
+func Discard() *slog.Logger {
+	return NewLogger(nil)
+}
+
+// contextHandler is a slog.Handler that adds the attributes in the context to
+// every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// discardHandler is a slog.Handler that discards everything.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
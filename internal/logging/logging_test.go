@@ -0,0 +1,96 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/logging"
+	"github.com/stretchr/testify/require"
+)
+
+// newJSONLogger returns a logger that writes JSON records to buf.
+func newJSONLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// lastRecord decodes the last JSON record written to buf.
+func lastRecord(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+
+	var record map[string]any
+	err := json.Unmarshal(lines[len(lines)-1], &record)
+	require.NoError(t, err)
+
+	return record
+}
+
+func TestWithAttrs(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a context with some attributes
+	ctx := logging.WithAttrs(context.Background(), slog.String("a", "1"))
+
+	// WHEN we add more attributes to it
+	ctx2 := logging.WithAttrs(ctx, slog.String("b", "2"))
+
+	// THEN the new context has all the attributes
+	require.Equal(t, []slog.Attr{slog.String("a", "1"), slog.String("b", "2")}, logging.Attrs(ctx2))
+
+	// THEN the original context is not modified
+	require.Equal(t, []slog.Attr{slog.String("a", "1")}, logging.Attrs(ctx))
+}
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("adds context attributes", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a logger
+		var buf bytes.Buffer
+		logger := logging.NewLogger(newJSONLogger(&buf))
+
+		// GIVEN a context with attributes
+		ctx := logging.WithAttrs(context.Background(), slog.String("group_id", "some_group_id"))
+
+		// WHEN we log with the context
+		logger.InfoContext(ctx, "some message", slog.Int("n", 42))
+
+		// THEN the record has the attributes from the context and the
+		// ones in the call
+		record := lastRecord(t, &buf)
+		require.Equal(t, "some message", record["msg"])
+		require.Equal(t, "some_group_id", record["group_id"])
+		require.EqualValues(t, 42, record["n"])
+	})
+
+	t.Run("wrapping twice does not duplicate attributes", func(t *testing.T) {
+		t.Parallel()
+
+		// GIVEN a logger wrapped twice
+		var buf bytes.Buffer
+		logger := logging.NewLogger(logging.NewLogger(newJSONLogger(&buf)))
+
+		// WHEN we log with a context with attributes
+		ctx := logging.WithAttrs(context.Background(), slog.String("group_id", "some_group_id"))
+		logger.InfoContext(ctx, "some message")
+
+		// THEN the attribute appears only once
+		require.Equal(t, 1, bytes.Count(buf.Bytes(), []byte(`"group_id":`)))
+	})
+
+	t.Run("nil logger discards everything", func(t *testing.T) {
+		t.Parallel()
+
+		// WHEN we create a logger from nil
+		logger := logging.NewLogger(nil)
+
+		// THEN it is not enabled at any level
+		require.False(t, logger.Enabled(context.Background(), slog.LevelError))
+	})
+}
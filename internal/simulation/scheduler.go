@@ -0,0 +1,123 @@
+package simulation
+
+import (
+	"context"
+	"sort"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+)
+
+// chooser decides which of the n runnable clients runs next.
+type chooser interface {
+	choose(n int) int
+}
+
+// event is sent by a virtual client to the scheduler when it is about to
+// take a step or when it has finished.
+type event struct {
+	client int
+	step   memory.Step
+	done   bool
+	err    error
+}
+
+// scheduler runs the virtual clients one at a time, choosing at every step
+// which client goes next.
+//
+// Clients only run between steps, the rest of the time they are parked
+// waiting for the scheduler to resume them, so the execution only depends
+// on the choices made by the scheduler.
+type scheduler struct {
+	chooser chooser
+	events  chan event
+	resume  []chan struct{}
+
+	// parked are the steps the parked clients are waiting to take.
+	parked map[int]memory.Step
+	// choices made so far, along with the number of options available
+	// for each choice.
+	choices []int
+	options []int
+}
+
+func newScheduler(c chooser, clients int) *scheduler {
+	s := &scheduler{
+		chooser: c,
+		events:  make(chan event),
+		resume:  make([]chan struct{}, clients),
+		parked:  map[int]memory.Step{},
+	}
+
+	for i := range s.resume {
+		s.resume[i] = make(chan struct{})
+	}
+
+	return s
+}
+
+// clientKey is the context key for the id of a virtual client.
+type clientKey struct{}
+
+func withClient(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, clientKey{}, id)
+}
+
+// yield parks the client calling it until the scheduler decides it can take
+// its next step. It is a no-op outside of virtual clients.
+func (s *scheduler) yield(ctx context.Context, step memory.Step) {
+	id, ok := ctx.Value(clientKey{}).(int)
+	if !ok {
+		return
+	}
+
+	s.events <- event{client: id, step: step}
+	<-s.resume[id]
+}
+
+// start runs fn as a virtual client, which is parked until the scheduler
+// picks it for the first time.
+func (s *scheduler) start(ctx context.Context, id int, fn func(context.Context) error) {
+	ctx = withClient(ctx, id)
+
+	go func() {
+		s.yield(ctx, "start")
+		err := fn(ctx)
+		s.events <- event{client: id, done: true, err: err}
+	}()
+
+	e := <-s.events
+	s.parked[e.client] = e.step
+}
+
+// live returns if there are clients that have not finished yet.
+func (s *scheduler) live() bool {
+	return len(s.parked) > 0
+}
+
+// next chooses a parked client and lets it take its step, returning the
+// client, the step it took, and the event it sent afterwards.
+func (s *scheduler) next() (int, memory.Step, event) {
+	ids := make([]int, 0, len(s.parked))
+	for id := range s.parked {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	choice := s.chooser.choose(len(ids))
+	s.choices = append(s.choices, choice)
+	s.options = append(s.options, len(ids))
+
+	id := ids[choice]
+	step := s.parked[id]
+	delete(s.parked, id)
+
+	s.resume[id] <- struct{}{}
+
+	// only the resumed client is running, so the next event is its
+	e := <-s.events
+	if !e.done {
+		s.parked[id] = e.step
+	}
+
+	return id, step, e
+}
@@ -0,0 +1,274 @@
+// Package simulation explores the interleavings of concurrent calls to the
+// application layer in a deterministic way.
+//
+// Each simulation runs several virtual clients, each of them adding a user to
+// a group, against an in-process store. A scheduler decides which client
+// takes the next step (a load, an update, a commit...), so the whole
+// execution is determined by the sequence of choices made by the scheduler,
+// which can come from a seeded random generator or from an exhaustive
+// exploration of all the possible choices. Any execution can be replayed
+// from its seed or its choices.
+//
+// After every step, the simulation checks the invariants of the groups and
+// that no successful call has been lost.
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+)
+
+// Config describes the workload of a simulation.
+type Config struct {
+	// Clients is the number of virtual clients, client i adds user
+	// "user_<i>" to group i%Groups.
+	Clients int
+	// Groups is the number of groups the clients add users to.
+	Groups int
+	// Options are passed to every AddUserToGroup call. DelayBeforeUpdating
+	// must not be used, as the scheduler takes care of interleaving the
+	// clients.
+	Options []application.Option
+}
+
+// Report describes a finished simulation.
+type Report struct {
+	// Choices made by the scheduler, they can be used to replay the
+	// simulation.
+	Choices []int
+	// Trace has a description of every step taken.
+	Trace []string
+	// Results are the errors returned to each client.
+	Results []error
+
+	// options has the number of options available for each choice.
+	options []int
+}
+
+// Violation is returned when a simulation breaks an invariant.
+type Violation struct {
+	// Reason describes the broken invariant.
+	Reason string
+	// Choices made by the scheduler up to the violation.
+	Choices []int
+	// Trace has a description of every step taken up to the violation.
+	Trace []string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s, after %d steps:\n\t%s\nreplay with choices %v",
+		v.Reason, len(v.Trace), strings.Join(v.Trace, "\n\t"), v.Choices)
+}
+
+// Run runs a simulation where the scheduler makes random choices using the
+// given seed. Running it again with the same seed leads to the same
+// execution.
+//
+// Returns a *Violation error if an invariant is broken.
+func Run(cfg Config, seed int64) (*Report, error) {
+	//nolint:gosec // weak random generation is ok here
+	return simulate(cfg, &randomChooser{rnd: rand.New(rand.NewSource(seed))})
+}
+
+// Replay runs a simulation where the scheduler makes the given choices, and
+// chooses the first runnable client once they run out.
+//
+// Returns a *Violation error if an invariant is broken.
+func Replay(cfg Config, choices []int) (*Report, error) {
+	return simulate(cfg, &replayChooser{choices: choices})
+}
+
+// Explore runs simulations for all the possible interleavings of the
+// clients, in depth first order, stopping after maxRuns simulations.
+//
+// Returns the number of simulations run, whether all the interleavings were
+// explored, and a *Violation error as soon as an invariant is broken.
+func Explore(cfg Config, maxRuns int) (int, bool, error) {
+	var prefix []int
+
+	for runs := 1; runs <= maxRuns; runs++ {
+		report, err := Replay(cfg, prefix)
+		if err != nil {
+			return runs, false, err
+		}
+
+		// backtrack to the last choice that still has unexplored options
+		// and take the next one
+		last := len(report.Choices) - 1
+		for last >= 0 && report.Choices[last]+1 >= report.options[last] {
+			last--
+		}
+
+		if last < 0 {
+			return runs, true, nil
+		}
+
+		prefix = append(report.Choices[:last:last], report.Choices[last]+1)
+	}
+
+	return maxRuns, false, nil
+}
+
+func simulate(cfg Config, c chooser) (*Report, error) {
+	sched := newScheduler(c, cfg.Clients)
+	store := memory.NewGroupRepo(memory.WithStepHook(sched.yield))
+	app := application.New(&sequentialUuider{}, store)
+
+	ctx := context.Background()
+
+	groupIDs := make([]string, 0, cfg.Groups)
+	for i := range cfg.Groups {
+		id, err := app.CreateGroup(ctx, fmt.Sprintf("owner_%d", i))
+		if err != nil {
+			return nil, fmt.Errorf("creating group: %v", err)
+		}
+
+		groupIDs = append(groupIDs, id)
+	}
+
+	s := &simulation{
+		store:    store,
+		groupIDs: groupIDs,
+		results:  make([]error, cfg.Clients),
+		done:     make([]bool, cfg.Clients),
+	}
+
+	for i := range cfg.Clients {
+		userID, groupID := s.userID(i), s.groupID(i)
+
+		sched.start(ctx, i, func(ctx context.Context) error {
+			return app.AddUserToGroup(ctx, userID, groupID, cfg.Options...)
+		})
+	}
+
+	var violation *Violation
+
+	for sched.live() {
+		id, step, e := sched.next()
+		s.trace = append(s.trace, fmt.Sprintf("client %d: %s", id, step))
+
+		if e.done {
+			s.results[id] = e.err
+			s.done[id] = true
+			s.trace = append(s.trace, fmt.Sprintf("client %d: returns %v", id, e.err))
+		}
+
+		// keep running the clients after a violation, so they all finish,
+		// but only report the first one
+		if violation != nil {
+			continue
+		}
+
+		if reason, ok := s.check(ctx); !ok {
+			violation = &Violation{
+				Reason:  reason,
+				Choices: sched.choices,
+				Trace:   s.trace,
+			}
+		}
+	}
+
+	if violation != nil {
+		return nil, violation
+	}
+
+	return &Report{
+		Choices: sched.choices,
+		Trace:   s.trace,
+		Results: s.results,
+		options: sched.options,
+	}, nil
+}
+
+// simulation is the state of a running simulation.
+type simulation struct {
+	store    *memory.GroupRepo
+	groupIDs []string
+	trace    []string
+	results  []error
+	done     []bool
+}
+
+func (s *simulation) userID(client int) string {
+	return fmt.Sprintf("user_%d", client)
+}
+
+func (s *simulation) groupID(client int) string {
+	return s.groupIDs[client%len(s.groupIDs)]
+}
+
+// check returns if the invariants hold, or a description of the broken one.
+//
+// The invariants are:
+//   - all the groups are valid domain groups.
+//   - all the clients that have returned successfully have their users as
+//     members of their groups.
+func (s *simulation) check(ctx context.Context) (string, bool) {
+	groups := map[string]*domain.Group{}
+
+	for _, id := range s.groupIDs {
+		group, err := s.store.Load(ctx, id)
+		if err != nil {
+			return fmt.Sprintf("invalid group %s: %v", id, err), false
+		}
+
+		if group.NumMembers() > domain.MaxMembers {
+			return fmt.Sprintf("group %s has too many members: %v", id, group.Members()), false
+		}
+
+		groups[id] = group
+	}
+
+	for client, err := range s.results {
+		if !s.done[client] || err != nil {
+			continue
+		}
+
+		group := groups[s.groupID(client)]
+		if !group.HasMember(s.userID(client)) {
+			return fmt.Sprintf("lost update: client %d returned successfully but %s is not a member of %s: %v",
+				client, s.userID(client), group.ID(), group.Members()), false
+		}
+	}
+
+	return "", true
+}
+
+type randomChooser struct {
+	rnd *rand.Rand
+}
+
+func (c *randomChooser) choose(n int) int {
+	return c.rnd.Intn(n)
+}
+
+type replayChooser struct {
+	choices []int
+	next    int
+}
+
+func (c *replayChooser) choose(n int) int {
+	defer func() { c.next++ }()
+
+	if c.next < len(c.choices) && c.choices[c.next] < n {
+		return c.choices[c.next]
+	}
+
+	return 0
+}
+
+// sequentialUuider returns predictable ids, so simulations are deterministic.
+type sequentialUuider struct {
+	n int
+}
+
+func (u *sequentialUuider) NewString() string {
+	u.n++
+	return fmt.Sprintf("group_%d", u.n)
+}
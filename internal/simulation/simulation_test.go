@@ -0,0 +1,151 @@
+package simulation_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/application"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/simulation"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	withTransactions = simulation.Config{
+		Clients: 2 * domain.MaxMembers,
+		Groups:  1,
+		Options: []application.Option{application.EnableTransactions{}},
+	}
+
+	withoutTransactions = simulation.Config{
+		Clients: 2 * domain.MaxMembers,
+		Groups:  1,
+	}
+)
+
+// Tests running a simulation twice with the same seed leads to the same
+// execution.
+func TestRun_Deterministic(t *testing.T) {
+	t.Parallel()
+
+	const seed = 42
+
+	// GIVEN a simulation with a seed
+	first, err := simulation.Run(withTransactions, seed)
+	require.NoError(t, err)
+
+	// WHEN we run it again with the same seed
+	second, err := simulation.Run(withTransactions, seed)
+	require.NoError(t, err)
+
+	// THEN we get the same execution
+	require.Equal(t, first, second)
+
+	// THEN replaying its choices also leads to the same execution
+	replayed, err := simulation.Replay(withTransactions, first.Choices)
+	require.NoError(t, err)
+	require.Equal(t, first, replayed)
+}
+
+// Tests the invariants hold for many random interleavings when transactions
+// are enabled.
+func TestRun_WithTransactions(t *testing.T) {
+	t.Parallel()
+
+	const seeds = 200
+
+	for seed := range int64(seeds) {
+		// WHEN we run a simulation
+		report, err := simulation.Run(withTransactions, seed)
+
+		// THEN no invariant is broken
+		require.NoErrorf(t, err, "seed %d", seed)
+
+		// THEN only MaxMembers-1 clients succeed, the rest get ErrGroupFull
+		// or run out of retries
+		successCount := 0
+		for i, err := range report.Results {
+			switch {
+			case err == nil:
+				successCount++
+			case errors.Is(err, domain.ErrGroupFull):
+			case errors.Is(err, domain.ErrTooManyTransactionRetries):
+			default:
+				t.Fatalf("seed %d: client %d: unexpected error: %v", seed, i, err)
+			}
+		}
+
+		require.LessOrEqualf(t, successCount, domain.MaxMembers-1, "seed %d", seed)
+	}
+}
+
+// Tests some random interleavings lose updates when transactions are
+// disabled.
+func TestRun_WithoutTransactions(t *testing.T) {
+	t.Parallel()
+
+	const maxSeeds = 200
+
+	// WHEN we run simulations until one breaks an invariant
+	var violation *simulation.Violation
+	for seed := range int64(maxSeeds) {
+		_, err := simulation.Run(withoutTransactions, seed)
+		if errors.As(err, &violation) {
+			t.Logf("seed %d: %v", seed, err)
+			break
+		}
+
+		require.NoError(t, err)
+	}
+
+	// THEN we found a lost update
+	require.NotNil(t, violation, "no violation found")
+	require.Contains(t, violation.Reason, "lost update")
+}
+
+// Tests all the interleavings of a small workload.
+func TestExplore(t *testing.T) {
+	t.Parallel()
+
+	const maxRuns = 100_000
+
+	t.Run("with transactions", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := simulation.Config{
+			Clients: 2,
+			Groups:  1,
+			Options: []application.Option{application.EnableTransactions{}},
+		}
+
+		// WHEN we explore all the interleavings
+		runs, exhausted, err := simulation.Explore(cfg, maxRuns)
+
+		// THEN no invariant is broken in any of them
+		require.NoError(t, err)
+		require.True(t, exhausted)
+		require.Greater(t, runs, 1)
+		t.Logf("explored %d interleavings", runs)
+	})
+
+	t.Run("without transactions", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := simulation.Config{
+			Clients: 2,
+			Groups:  1,
+		}
+
+		// WHEN we explore the interleavings
+		_, _, err := simulation.Explore(cfg, maxRuns)
+
+		// THEN we find a lost update
+		var violation *simulation.Violation
+		require.ErrorAs(t, err, &violation)
+		require.Contains(t, violation.Reason, "lost update")
+
+		// THEN replaying its choices leads to the same violation
+		_, err = simulation.Replay(cfg, violation.Choices)
+		require.Equal(t, violation, err)
+	})
+}
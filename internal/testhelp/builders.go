@@ -0,0 +1,36 @@
+package testhelp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// NamedGroup returns a new group created at now, with the given name and
+// tags.
+func NamedGroup(t testing.TB, now time.Time, id, ownerID, name string, tags ...string) *domain.Group {
+	t.Helper()
+
+	group := domain.NewGroup(id, ownerID, now)
+	err := group.SetDetails(domain.Details{Name: name, Tags: tags}, now)
+	require.NoError(t, err)
+
+	return group
+}
+
+// AuditEntry returns an audit entry of a user added to the group with the
+// given id, at the given time, in milliseconds.
+func AuditEntry(groupID, id string, millis int64) domain.AuditEntry {
+	return domain.AuditEntry{
+		ID:            id,
+		GroupID:       groupID,
+		Actor:         "actor",
+		Action:        domain.AuditMemberAdded,
+		UserID:        "user_id",
+		Time:          time.UnixMilli(millis).UTC(),
+		MembersBefore: 1,
+		MembersAfter:  2,
+	}
+}
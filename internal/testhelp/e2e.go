@@ -18,13 +18,15 @@ import (
 //   - it connects to a Mongo instance at localhost:27017
 //   - it uses a unique database name, based on the test name, thus allowing for safe concurrent tests
 //   - it drops the database content during test cleanup.
-func NewTestDatabase(t *testing.T, uri string) *mongo.Database {
+//
+// Extra client options, like a command monitor, are applied after the uri.
+func NewTestDatabase(t testing.TB, uri string, opts ...*options.ClientOptions) *mongo.Database {
 	t.Helper()
 
-	dbName := databaseName(t)
+	dbName := DatabaseName(t)
 	t.Logf("using MongoDB database name %s", dbName)
 
-	client := newTestClient(t, uri)
+	client := newTestClient(t, uri, opts...)
 	db := client.Database(dbName)
 	t.Cleanup(func() {
 		t.Helper()
@@ -42,15 +44,15 @@ func NewTestDatabase(t *testing.T, uri string) *mongo.Database {
 }
 
 // newTestClient is a test helper that returns a MongoDB client connected to a
-// server at uri.
-func newTestClient(t *testing.T, uri string) *mongo.Client {
+// server at uri, with the given extra options.
+func newTestClient(t testing.TB, uri string, extra ...*options.ClientOptions) *mongo.Client {
 	timeout := 2 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	opts := options.Client().ApplyURI(uri)
+	opts := append([]*options.ClientOptions{options.Client().ApplyURI(uri)}, extra...)
 
-	client, err := mongo.Connect(ctx, opts)
+	client, err := mongo.Connect(ctx, opts...)
 	if err != nil {
 		t.Fatalf("connecting to MongoDB at %q: %s", uri, err)
 	}
@@ -73,7 +75,7 @@ func newTestClient(t *testing.T, uri string) *mongo.Client {
 	return client
 }
 
-// databaseName is a test helper that generates a MongoDB database name for the
+// DatabaseName is a test helper that generates a MongoDB database name for the
 // given test using the following criteria:
 //   - trim the test name to 50 bytes (mongo db names cannot be longer than 63 bytes)
 //   - while trimming the test name, avoid splitting unicode symbols at the end.
@@ -89,7 +91,7 @@ func newTestClient(t *testing.T, uri string) *mongo.Client {
 //   - TestFoo_x3la8aXkI2fi
 //   - TestSuperVeryLongTestName_scenarioOnALeapYear_scen_t0p8UoMDIafi
 //   - TestSuperVeryLongTestName_scenarioOnALeapYear_scen_L1ljD7cT7Wmn
-func databaseName(t *testing.T) string {
+func DatabaseName(t testing.TB) string {
 	t.Helper()
 
 	const (
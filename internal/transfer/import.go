@@ -0,0 +1,261 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec"
+)
+
+// DefaultBatchSize is how many groups are stored at once by default.
+const DefaultBatchSize = 100
+
+// maxLineSize is the size of the longest line accepted by Import, way
+// bigger than any valid group.
+const maxLineSize = 1 << 20
+
+// ConflictPolicy is what Import does with the groups that already exist in
+// the destination.
+type ConflictPolicy string
+
+const (
+	// Skip keeps the existing groups as they are.
+	Skip ConflictPolicy = "skip"
+	// Overwrite replaces the existing groups by the imported ones.
+	Overwrite ConflictPolicy = "overwrite"
+	// Fail stops the import with domain.ErrAlreadyExists.
+	Fail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy returns the policy with the given name.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case Skip, Overwrite, Fail:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q, want skip, overwrite or fail", s)
+	}
+}
+
+// ImportReport is the result of an import.
+type ImportReport struct {
+	// Read is the number of groups read and handled, that is, created,
+	// overwritten or skipped.
+	Read int
+	// Created is the number of groups that did not exist.
+	Created int
+	// Overwritten is the number of existing groups replaced.
+	Overwritten int
+	// Skipped is the number of existing groups left as they were.
+	Skipped int
+}
+
+// ImportOption configures an import.
+type ImportOption func(*importer)
+
+// WithDryRun makes the import validate the groups and report what it would
+// do, without storing anything.
+//
+// Imports are not atomic, the batches stored before a failure are kept, so
+// a dry run is a cheap way to find invalid records and conflicts before the
+// real import.
+func WithDryRun() ImportOption {
+	return func(i *importer) {
+		i.dryRun = true
+	}
+}
+
+// WithConflictPolicy sets what to do with the groups that already exist.
+//
+// It defaults to Fail.
+func WithConflictPolicy(p ConflictPolicy) ImportOption {
+	return func(i *importer) {
+		i.policy = p
+	}
+}
+
+// WithBatchSize sets how many groups are stored at once.
+//
+// It defaults to DefaultBatchSize.
+func WithBatchSize(n int) ImportOption {
+	return func(i *importer) {
+		i.batchSize = n
+	}
+}
+
+type importer struct {
+	dst       Destination
+	dryRun    bool
+	policy    ConflictPolicy
+	batchSize int
+
+	report *ImportReport
+	// seen are the ids of the groups read so far.
+	seen  map[string]struct{}
+	batch []*domain.Group
+}
+
+// Import reads groups from r, in the format written by Export, and stores
+// them in the destination in batches.
+//
+// Every group is validated as it is read, see domain.GroupSnapshot.Regenerate,
+// and the existing groups are handled according to the conflict policy.
+// Groups created concurrently by someone else, after checking if they exist,
+// are overwritten with the Overwrite and Fail policies, and skipped with the
+// Skip policy.
+//
+// The report counts the groups of the batches stored before the error, if
+// any, the groups of the failed batch are not counted, not even as read.
+//
+// Errors:
+//   - domain.ErrInvalidArgument if a line does not have a valid group or has
+//     a group already read.
+//   - domain.ErrAlreadyExists if a group already exists, with the Fail
+//     conflict policy.
+//   - whatever errors the destination returns.
+func Import(ctx context.Context, dst Destination, r io.Reader, options ...ImportOption) (*ImportReport, error) {
+	i := &importer{
+		dst:       dst,
+		policy:    Fail,
+		batchSize: DefaultBatchSize,
+		report:    &ImportReport{},
+		seen:      map[string]struct{}{},
+	}
+
+	for _, o := range options {
+		o(i)
+	}
+
+	if _, err := ParseConflictPolicy(string(i.policy)); err != nil {
+		return nil, err
+	}
+
+	if i.batchSize < 1 {
+		return nil, fmt.Errorf("invalid batch size %d", i.batchSize)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		if err := i.read(scanner.Bytes()); err != nil {
+			return i.report, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if len(i.batch) == i.batchSize {
+			if err := i.flush(ctx); err != nil {
+				return i.report, err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return i.report, fmt.Errorf("reading: %v", err)
+	}
+
+	if err := i.flush(ctx); err != nil {
+		return i.report, err
+	}
+
+	return i.report, nil
+}
+
+// read adds the group in the line to the current batch.
+func (i *importer) read(line []byte) error {
+	group, err := codec.UnmarshalJSON(line)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := i.seen[group.ID()]; ok {
+		return domain.Errorf(domain.ErrInvalidArgument, group.ID(), "duplicated group")
+	}
+
+	i.seen[group.ID()] = struct{}{}
+	i.batch = append(i.batch, group)
+
+	return nil
+}
+
+// flush stores the current batch, according to the conflict policy.
+func (i *importer) flush(ctx context.Context) error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(i.batch))
+	for n, g := range i.batch {
+		ids[n] = g.ID()
+	}
+
+	existing, err := i.dst.Existing(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("finding existing groups: %w", err)
+	}
+
+	exists := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		exists[id] = true
+	}
+
+	var (
+		put    = make([]*domain.Group, 0, len(i.batch))
+		report ImportReport
+	)
+
+	for _, g := range i.batch {
+		switch {
+		case !exists[g.ID()]:
+			report.Created++
+		case i.policy == Fail:
+			return domain.NewError(domain.ErrAlreadyExists, g.ID(), nil)
+		case i.policy == Skip:
+			report.Skipped++
+			continue
+		default:
+			report.Overwritten++
+		}
+
+		put = append(put, g)
+	}
+
+	if !i.dryRun && len(put) > 0 {
+		if err := i.put(ctx, put, &report); err != nil {
+			return fmt.Errorf("storing groups: %w", err)
+		}
+	}
+
+	i.report.Read += len(i.batch)
+	i.report.Created += report.Created
+	i.report.Overwritten += report.Overwritten
+	i.report.Skipped += report.Skipped
+	i.batch = i.batch[:0]
+
+	return nil
+}
+
+// put stores the groups, correcting the report with the groups created
+// concurrently when they are skipped.
+func (i *importer) put(ctx context.Context, groups []*domain.Group, report *ImportReport) error {
+	if i.policy != Skip {
+		return i.dst.Put(ctx, groups)
+	}
+
+	created, err := i.dst.PutMissing(ctx, groups)
+	if err != nil {
+		return err
+	}
+
+	report.Skipped += report.Created - created
+	report.Created = created
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package transfer exports groups to newline-delimited JSON and imports them
+// back, to back them up or to seed other environments.
+//
+// Each line has a group in the JSON format of the codec package.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/codec"
+)
+
+// Source is where the groups are exported from.
+type Source interface {
+	// Each calls fn with every group, sorted by id, stopping at the first
+	// error it returns.
+	Each(ctx context.Context, fn func(*domain.Group) error) error
+}
+
+// Destination is where the groups are imported to.
+type Destination interface {
+	// Existing returns the ids, among the given ones, of the groups that
+	// exist.
+	Existing(ctx context.Context, ids []string) ([]string, error)
+	// Put stores the groups, creating the missing ones and overwriting
+	// the existing ones.
+	Put(ctx context.Context, groups []*domain.Group) error
+	// PutMissing stores the groups that do not exist, leaving the
+	// existing ones as they are, and returns how many it has created.
+	PutMissing(ctx context.Context, groups []*domain.Group) (int, error)
+}
+
+// Export writes all the groups in the source to w, one per line, and returns
+// how many it has written.
+func Export(ctx context.Context, src Source, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	n := 0
+
+	err := src.Each(ctx, func(group *domain.Group) error {
+		data, err := codec.MarshalJSON(group)
+		if err != nil {
+			return err
+		}
+
+		if _, err := bw.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("writing group %s: %v", group.ID(), err)
+		}
+
+		n++
+
+		return nil
+	})
+	if err != nil {
+		return n, fmt.Errorf("exporting: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return n, fmt.Errorf("writing: %v", err)
+	}
+
+	return n, nil
+}
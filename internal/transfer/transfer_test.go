@@ -0,0 +1,353 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alcortesm/demo-mongodb-transactions/internal/domain"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/infra/memory"
+	"github.com/alcortesm/demo-mongodb-transactions/internal/transfer"
+	"github.com/stretchr/testify/require"
+)
+
+// now is the time used by the tests that do not care about it.
+var now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newRepo returns a repository with a group for each id, owned by "owner_id".
+func newRepo(t *testing.T, ids ...string) *memory.GroupRepo {
+	t.Helper()
+
+	repo := memory.NewGroupRepo()
+
+	for _, id := range ids {
+		err := repo.Create(context.Background(), domain.NewGroup(id, "owner_id", now))
+		require.NoError(t, err)
+	}
+
+	return repo
+}
+
+// snapshots returns the snapshots of all the groups in the repository.
+func snapshots(t *testing.T, repo *memory.GroupRepo) []*domain.GroupSnapshot {
+	t.Helper()
+
+	var result []*domain.GroupSnapshot
+
+	err := repo.Each(context.Background(), func(g *domain.Group) error {
+		result = append(result, g.Snapshot())
+		return nil
+	})
+	require.NoError(t, err)
+
+	return result
+}
+
+// Tests that the groups exported from a repository can be imported into
+// another one.
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// GIVEN a repository with some groups
+	src := newRepo(t, "b", "a", "c")
+
+	named := domain.NewGroup("d", "owner_id", now)
+	require.NoError(t, named.AddMember("user_id", now.Add(time.Second)))
+	require.NoError(t, named.SetDetails(domain.Details{Name: "name", Tags: []string{"tag"}}, now))
+	require.NoError(t, src.Create(ctx, named))
+
+	// WHEN we export them
+	var buf bytes.Buffer
+	n, err := transfer.Export(ctx, src, &buf)
+	require.NoError(t, err)
+
+	// THEN we get one line per group, sorted by id
+	require.Equal(t, 4, n)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[0], `"id":"a"`)
+	require.Contains(t, lines[3], `"id":"d"`)
+
+	// WHEN we import them into an empty repository
+	dst := memory.NewGroupRepo()
+	report, err := transfer.Import(ctx, dst, &buf)
+	require.NoError(t, err)
+
+	// THEN it has the same groups
+	require.Equal(t, &transfer.ImportReport{Read: 4, Created: 4}, report)
+	require.Equal(t, snapshots(t, src), snapshots(t, dst))
+}
+
+func TestExport_Error(t *testing.T) {
+	t.Parallel()
+
+	// GIVEN a writer that always fails
+	w := failingWriter{}
+
+	// WHEN we export to it
+	_, err := transfer.Export(context.Background(), newRepo(t, "a"), w)
+
+	// THEN we get an error
+	require.ErrorContains(t, err, "writing")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken")
+}
+
+// export returns the groups with the given ids in the export format.
+func export(t *testing.T, ids ...string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	_, err := transfer.Export(context.Background(), newRepo(t, ids...), &buf)
+	require.NoError(t, err)
+
+	return buf.String()
+}
+
+func TestImport_ConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name   string
+		policy transfer.ConflictPolicy
+		dryRun bool
+		want   *transfer.ImportReport
+		// wantErr is the kind of error we want, nil for success
+		wantErr error
+		// wantMembers is the number of members of the existing group
+		// after the import.
+		wantMembers int
+		// wantIDs are the ids of the groups after the import.
+		wantIDs []string
+	}{
+		{
+			name:        "skip",
+			policy:      transfer.Skip,
+			want:        &transfer.ImportReport{Read: 2, Created: 1, Skipped: 1},
+			wantMembers: 2,
+			wantIDs:     []string{"a", "b"},
+		},
+		{
+			name:        "overwrite",
+			policy:      transfer.Overwrite,
+			want:        &transfer.ImportReport{Read: 2, Created: 1, Overwritten: 1},
+			wantMembers: 1,
+			wantIDs:     []string{"a", "b"},
+		},
+		{
+			name:        "fail",
+			policy:      transfer.Fail,
+			want:        &transfer.ImportReport{},
+			wantErr:     domain.ErrAlreadyExists,
+			wantMembers: 2,
+			wantIDs:     []string{"a"},
+		},
+		{
+			name:        "dry run",
+			policy:      transfer.Overwrite,
+			dryRun:      true,
+			want:        &transfer.ImportReport{Read: 2, Created: 1, Overwritten: 1},
+			wantMembers: 2,
+			wantIDs:     []string{"a"},
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			// GIVEN a repository with group "a" with two members
+			repo := newRepo(t)
+			existing := domain.NewGroup("a", "owner_id", now)
+			require.NoError(t, existing.AddMember("user_id", now))
+			require.NoError(t, repo.Create(ctx, existing))
+
+			// GIVEN an export with groups "a", with one member, and "b"
+			data := export(t, "a", "b")
+
+			// WHEN we import it with the conflict policy
+			options := []transfer.ImportOption{transfer.WithConflictPolicy(test.policy)}
+			if test.dryRun {
+				options = append(options, transfer.WithDryRun())
+			}
+
+			report, err := transfer.Import(ctx, repo, strings.NewReader(data), options...)
+
+			// THEN we get the report and error we want
+			if test.wantErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, test.wantErr)
+			}
+
+			require.Equal(t, test.want, report)
+
+			// THEN the repository has the groups we want
+			require.Equal(t, test.wantIDs, repo.GroupIDs())
+
+			got, err := repo.Load(ctx, "a")
+			require.NoError(t, err)
+			require.Len(t, got.Members(), test.wantMembers)
+		})
+	}
+}
+
+// Tests the groups are stored in batches, and the batches stored before a
+// failure are kept.
+func TestImport_Batches(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// GIVEN a repository with group "d"
+	repo := newRepo(t, "d")
+
+	// GIVEN an export with groups "a" to "e"
+	data := export(t, "a", "b", "c", "d", "e")
+
+	// WHEN we import it in batches of two, failing on conflicts
+	report, err := transfer.Import(ctx, repo, strings.NewReader(data),
+		transfer.WithBatchSize(2),
+		transfer.WithConflictPolicy(transfer.Fail),
+	)
+
+	// THEN it fails on the second batch, keeping and reporting only the
+	// first one
+	require.ErrorIs(t, err, domain.ErrAlreadyExists)
+	require.Equal(t, &transfer.ImportReport{Read: 2, Created: 2}, report)
+	require.Equal(t, []string{"a", "b", "d"}, repo.GroupIDs())
+}
+
+// lateDestination is a destination that does not see the existing groups,
+// as if they were created concurrently, after checking if they exist.
+type lateDestination struct {
+	*memory.GroupRepo
+}
+
+func (lateDestination) Existing(context.Context, []string) ([]string, error) {
+	return nil, nil
+}
+
+// Tests the groups created concurrently are left as they are with the Skip
+// conflict policy.
+func TestImport_SkipConcurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// GIVEN a repository with group "a" with two members, created after
+	// checking if it exists
+	repo := newRepo(t)
+	existing := domain.NewGroup("a", "owner_id", now)
+	require.NoError(t, existing.AddMember("user_id", now))
+	require.NoError(t, repo.Create(ctx, existing))
+
+	// WHEN we import groups "a", with one member, and "b", skipping the
+	// existing ones
+	report, err := transfer.Import(ctx, lateDestination{repo}, strings.NewReader(export(t, "a", "b")),
+		transfer.WithConflictPolicy(transfer.Skip))
+	require.NoError(t, err)
+
+	// THEN group "a" is skipped and kept as it was
+	require.Equal(t, &transfer.ImportReport{Read: 2, Created: 1, Skipped: 1}, report)
+	require.Equal(t, []string{"a", "b"}, repo.GroupIDs())
+
+	got, err := repo.Load(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, existing.Snapshot(), got.Snapshot())
+}
+
+func TestImport_Invalid(t *testing.T) {
+	t.Parallel()
+
+	valid := strings.TrimSuffix(export(t, "a"), "\n")
+
+	subtests := []struct {
+		name    string
+		data    string
+		options []transfer.ImportOption
+		// errorContent is the content of the error we want
+		errorContent string
+		// wantInvalidArgument is true if we want domain.ErrInvalidArgument
+		wantInvalidArgument bool
+	}{
+		{
+			name:                "invalid json",
+			data:                valid + "\n{\n",
+			errorContent:        "line 2",
+			wantInvalidArgument: true,
+		},
+		{
+			name:                "invalid group",
+			data:                `{"version":1,"id":"b","owner_id":"o","members":[{"id":"x"}]}`,
+			errorContent:        "line 1",
+			wantInvalidArgument: true,
+		},
+		{
+			name:                "duplicated group",
+			data:                valid + "\n\n" + valid + "\n",
+			errorContent:        "duplicated group",
+			wantInvalidArgument: true,
+		},
+		{
+			name:         "unknown conflict policy",
+			data:         valid,
+			options:      []transfer.ImportOption{transfer.WithConflictPolicy("ignore")},
+			errorContent: "unknown conflict policy",
+		},
+		{
+			name:         "invalid batch size",
+			data:         valid,
+			options:      []transfer.ImportOption{transfer.WithBatchSize(0)},
+			errorContent: "invalid batch size",
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// GIVEN an empty repository
+			repo := memory.NewGroupRepo()
+
+			// WHEN we import invalid data, even in a dry run
+			options := append([]transfer.ImportOption{transfer.WithDryRun()}, test.options...)
+			_, err := transfer.Import(context.Background(), repo, strings.NewReader(test.data), options...)
+
+			// THEN we get the error we want
+			require.ErrorContains(t, err, test.errorContent)
+
+			if test.wantInvalidArgument {
+				require.ErrorIs(t, err, domain.ErrInvalidArgument)
+			}
+
+			// THEN nothing is stored
+			require.Empty(t, repo.GroupIDs())
+		})
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []transfer.ConflictPolicy{transfer.Skip, transfer.Overwrite, transfer.Fail} {
+		got, err := transfer.ParseConflictPolicy(string(p))
+		require.NoError(t, err)
+		require.Equal(t, p, got)
+	}
+
+	_, err := transfer.ParseConflictPolicy("ignore")
+	require.Error(t, err)
+}